@@ -0,0 +1,512 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+//-------------------------------------Customer accounts
+
+// Customers log in without a password: they give their email address and
+// are sent a link that logs them in, which shows that the address is theirs.
+// A logged-in customer sees every order placed with that address. Links and
+// sessions are kept in memory only, so a restart logs everyone out.
+
+// Mail is the SMTP server clerk sends login links through.
+type Mail struct {
+	Server   string `json:"server"` // host:port; empty = no logins
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from"`
+	LinkBase string `json:"linkBase,omitempty"` // URL of the account page as customers reach it, required with a server
+}
+
+// Lifetimes of login links and sessions.
+const (
+	linkTTL    = 15 * time.Minute
+	sessionTTL = 24 * time.Hour
+)
+
+// sessionCookie is the name of the cookie holding a customer's session.
+const sessionCookie = "clerk_session"
+
+// accounts holds the login links sent and the sessions open, by the hash of
+// their token, and limits how often customers may look up orders and ask
+// for links.
+type accounts struct {
+	mu       sync.Mutex
+	links    map[string]login // unused login links
+	sessions map[string]login // open sessions
+	now      func() time.Time
+
+	lookups     *rateLimiter // order lookups, by client address
+	linksByAddr *rateLimiter // login links, by client address
+	linksByMail *rateLimiter // login links, by email address
+}
+
+// login is a verified, or to be verified, email address and when that
+// stops counting.
+type login struct {
+	email   string
+	expires time.Time
+}
+
+// newAccounts returns accounts with no one logged in, on the given clock.
+func newAccounts(now func() time.Time) *accounts {
+	return &accounts{
+		links:       make(map[string]login),
+		sessions:    make(map[string]login),
+		now:         now,
+		lookups:     newRateLimiter(10, 30*time.Second, now),
+		linksByAddr: newRateLimiter(5, 2*time.Minute, now),
+		linksByMail: newRateLimiter(3, 10*time.Minute, now),
+	}
+}
+
+// newToken returns a random token for a link or a session, and the hash it
+// is kept under, so that what is in memory cannot be used to log in.
+func newToken() (token, key string) {
+	b := make([]byte, 32)
+	rand.Read(b) //nolint:errcheck // crypto/rand.Read does not fail
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, tokenKey(token)
+}
+
+// tokenKey is the hash a token is kept under.
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newLink records a login link for an email address and returns its token.
+func (a *accounts) newLink(email string) string {
+	token, key := newToken()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.prune()
+	a.links[key] = login{email: email, expires: a.now().Add(linkTTL)}
+	return token
+}
+
+// useLink opens a session for the email address of an unexpired login link,
+// which then stops working, and returns the session's token.
+func (a *accounts) useLink(token string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l, ok := a.links[tokenKey(token)]
+	delete(a.links, tokenKey(token))
+	if !ok || !a.now().Before(l.expires) {
+		return "", false
+	}
+	session, key := newToken()
+	a.sessions[key] = login{email: l.email, expires: a.now().Add(sessionTTL)}
+	return session, true
+}
+
+// customer returns the email address of an open session.
+func (a *accounts) customer(session string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[tokenKey(session)]
+	if !ok || !a.now().Before(s.expires) {
+		return "", false
+	}
+	return s.email, true
+}
+
+// logout closes a session.
+func (a *accounts) logout(session string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, tokenKey(session))
+}
+
+// prune forgets expired links and sessions. The caller holds the lock.
+func (a *accounts) prune() {
+	now := a.now()
+	for _, m := range []map[string]login{a.links, a.sessions} {
+		for key, l := range m {
+			if !now.Before(l.expires) {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+//-------------------------------------Rate limiting
+
+// rateLimiter allows each key a burst of requests, and one more every
+// interval after that (a token bucket per key).
+type rateLimiter struct {
+	mu       sync.Mutex
+	burst    float64
+	interval time.Duration
+	buckets  map[string]*bucket
+	now      func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+func newRateLimiter(burst int, interval time.Duration, now func() time.Time) *rateLimiter {
+	return &rateLimiter{burst: float64(burst), interval: interval, buckets: make(map[string]*bucket), now: now}
+}
+
+// allow takes a request from the key's bucket, or tells how long until
+// there is one to take.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= 10000 {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+float64(now.Sub(b.at))/float64(l.interval))
+	b.at = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.interval))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune forgets the buckets that have filled up again, which are as good as
+// new. The caller holds the lock.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+float64(now.Sub(b.at))/float64(l.interval) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// limited answers 429 when the limiter has nothing left for the key, and
+// tells the handler whether to stop.
+func limited(w http.ResponseWriter, l *rateLimiter, key string) bool {
+	ok, wait := l.allow(key)
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "too many requests, try again later", http.StatusTooManyRequests)
+	return true
+}
+
+// clientAddr is the address requests are limited by: the peer's IP address.
+// Behind a reverse proxy, that is the proxy's.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//-------------------------------------Login links by email
+
+// send mails a plain-text message.
+func (m *Mail) send(to, subject, body string) error {
+	host, _, err := net.SplitHostPort(m.Server)
+	if err != nil {
+		return fmt.Errorf("mail server %q: %w", m.Server, err)
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+	msg := "From: " + m.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+	return smtp.SendMail(m.Server, auth, m.From, []string{to}, []byte(msg))
+}
+
+// check verifies that a mail server comes with the address of the account
+// page. Login links are never built from the request, whose Host header the
+// client chooses: a forged one would send a customer's login to another site.
+func (m *Mail) check() error {
+	if m.Server == "" {
+		return nil
+	}
+	u, err := url.Parse(m.LinkBase)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("mail.linkBase must be the absolute URL of the account page, as customers reach it (got %q)", m.LinkBase)
+	}
+	return nil
+}
+
+// emailAddress reads a single bare email address, lower-cased, or refuses it.
+func emailAddress(s string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(s))
+	if err != nil || addr.Name != "" || strings.ContainsAny(addr.Address, "\r\n") {
+		return "", errors.New("a valid email address is required")
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+//-------------------------------------Service handler
+
+// accountPageData is what the account page is rendered from.
+type accountPageData struct {
+	Email   string // of the customer logged in
+	Orders  []ProductOrder_v1
+	Sent    string // address a login link was just sent to
+	Problem string
+}
+
+// accountHandler serves the account page (GET): the customer's orders when
+// logged in, a login form otherwise. GET ?token=T follows a login link; POST
+// asks for a link (email=E) or logs out (action=logout).
+func (t *Traits) accountHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if token := r.URL.Query().Get("token"); token != "" {
+			t.followLink(w, r, token)
+			return
+		}
+		email, ok := t.session(r)
+		if !ok {
+			t.accountPage(w, http.StatusOK, accountPageData{})
+			return
+		}
+		orders, err := t.customerOrders(email)
+		if err != nil {
+			log.Printf("clerk: listing a customer's orders: %v\n", err)
+			t.accountPage(w, http.StatusBadGateway, accountPageData{Email: email, Problem: "Your orders cannot be listed right now."})
+			return
+		}
+		t.accountPage(w, http.StatusOK, accountPageData{Email: email, Orders: orders})
+
+	case http.MethodPost:
+		if r.FormValue("action") == "logout" {
+			if c, err := r.Cookie(sessionCookie); err == nil {
+				t.accounts.logout(c.Value)
+			}
+			http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+		t.sendLink(w, r)
+
+	default:
+		http.Error(w, "Method is not supported.", http.StatusMethodNotAllowed)
+	}
+}
+
+// sendLink mails a login link to the address in the form. Whether or not the
+// address has orders, the answer is the same.
+func (t *Traits) sendLink(w http.ResponseWriter, r *http.Request) {
+	if t.Mail.Server == "" || t.Mail.check() != nil {
+		http.Error(w, "customer logins are not configured", http.StatusServiceUnavailable)
+		return
+	}
+	email, err := emailAddress(r.FormValue("email"))
+	if err != nil {
+		t.accountPage(w, http.StatusBadRequest, accountPageData{Problem: err.Error()})
+		return
+	}
+	if limited(w, t.accounts.linksByAddr, clientAddr(r)) || limited(w, t.accounts.linksByMail, email) {
+		return
+	}
+	link := t.Mail.LinkBase + "?token=" + url.QueryEscape(t.accounts.newLink(email))
+	body := "Follow this link to see your orders:\n\n" + link + "\n\n" +
+		"The link works once, for " + strconv.Itoa(int(linkTTL.Minutes())) + " minutes. " +
+		"If you did not ask for it, ignore this message.\n"
+	if err := t.Mail.send(email, "Your order login link", body); err != nil {
+		log.Printf("clerk: could not send a login link: %v\n", err)
+	}
+	t.accountPage(w, http.StatusOK, accountPageData{Sent: email})
+}
+
+// followLink logs the customer in with a login link and sends them on to the
+// account page without the token.
+func (t *Traits) followLink(w http.ResponseWriter, r *http.Request, token string) {
+	session, ok := t.accounts.useLink(token)
+	if !ok {
+		t.accountPage(w, http.StatusBadRequest, accountPageData{Problem: "This login link has expired or has been used. Ask for a new one."})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: sessionCookie, Value: session, Path: "/",
+		MaxAge: int(sessionTTL.Seconds()), HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+// session returns the email address of the customer logged in, if any.
+func (t *Traits) session(r *http.Request) (string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	return t.accounts.customer(c.Value)
+}
+
+// customerOrders lists the orders placed with an email address, newest
+// first, from the tracker's operator orders service. That service searches
+// names and addresses by part, so the orders are narrowed to the address.
+func (t *Traits) customerOrders(email string) ([]ProductOrder_v1, error) {
+	if t.TrackerToken == "" {
+		return nil, errors.New("no trackerToken is configured")
+	}
+	cer := t.cervices["orders"]
+	baseURL, err := t.serviceURL(cer)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 10 * time.Second, Transport: http.DefaultClient.Transport}
+	var orders []ProductOrder_v1
+	for page := 1; ; page++ {
+		req, err := http.NewRequest(http.MethodGet, baseURL+"?customer="+url.QueryEscape(email)+"&per_page=500&page="+strconv.Itoa(page), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+t.TrackerToken)
+		req.Header.Set("Accept", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			cer.Nodes = make(map[string][]components.NodeInfo)
+			return nil, err
+		}
+		var list struct {
+			Orders []ProductOrder_v1 `json:"orders"`
+			Total  int               `json:"total"`
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("tracker answered %s", resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading tracker's orders: %w", err)
+		}
+		for _, o := range list.Orders {
+			if strings.EqualFold(o.Email, email) {
+				orders = append(orders, o)
+			}
+		}
+		if len(list.Orders) == 0 || page*500 >= list.Total {
+			break
+		}
+	}
+	sort.SliceStable(orders, func(i, j int) bool { return orders[i].OrderNumber > orders[j].OrderNumber })
+	return orders, nil
+}
+
+//-------------------------------------Account page
+
+// accountPage renders the account page.
+func (t *Traits) accountPage(w http.ResponseWriter, status int, data accountPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := accountPageTemplate.Execute(w, data); err != nil {
+		log.Printf("clerk: rendering the account page: %v\n", err)
+	}
+}
+
+const accountPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <meta name="referrer" content="no-referrer">
+  <title>My Orders</title>
+  {{template "style"}}
+</head>
+<body>
+  <h1>My Orders</h1>
+
+  {{if .Email}}
+  <div class="card">
+    <h2>Orders placed with {{.Email}}</h2>
+    {{if .Problem}}<div class="alert alert-error">{{.Problem}}</div>
+    {{else if not .Orders}}<p>You have no orders yet. <a href="orders">Place one.</a></p>
+    {{else}}
+    {{range .Orders}}
+    <dl class="detail" style="margin-bottom:1rem">
+      <div><dt>Order</dt><dd><span class="tag">#{{.OrderNumber}}</span></dd></div>
+      <div><dt>Product</dt><dd>{{if .Description}}{{.Description}}{{else}}{{.ProductID}}{{end}}</dd></div>
+      {{if .Price}}<div><dt>Price</dt><dd>{{printf "%.2f" .Price}} {{.Currency}} excl. VAT</dd></div>{{end}}
+      <div><dt>Status</dt><dd>{{with .Status}}{{.}}{{else}}—{{end}}</dd></div>
+      <div><dt>Ordered</dt><dd>{{.OrderedTimestamp.Format "2006-01-02 15:04"}}</dd></div>
+      <div><dt>Completed</dt><dd>{{if .CompletedTimestamp.IsZero}}—{{else}}{{.CompletedTimestamp.Format "2006-01-02 15:04"}}{{end}}</dd></div>
+    </dl>
+    {{end}}
+    {{end}}
+    <form method="post" class="btn-row">
+      <input type="hidden" name="action" value="logout">
+      <button type="submit" class="btn-secondary">Log Out</button>
+    </form>
+  </div>
+
+  {{else}}
+  <div class="card">
+    <h2>Log In</h2>
+    {{if .Sent}}
+    <div class="alert alert-success">A login link is on its way to {{.Sent}}. It works once, within a quarter of an hour.</div>
+    {{else}}
+    <form method="post" novalidate>
+      <div class="field">
+        <label for="email">Email <span class="note">the address you ordered with</span></label>
+        <input type="email" id="email" name="email" placeholder="you@example.com" autocomplete="email">
+      </div>
+      {{with .Problem}}<div class="alert alert-error">{{.}}</div>{{end}}
+      <div class="btn-row">
+        <button type="submit" class="btn-primary">Email Me a Login Link</button>
+      </div>
+    </form>
+    {{end}}
+  </div>
+  {{end}}
+</body>
+</html>`
+
+var accountPageTemplate = template.Must(template.New("account").Parse(pageStyle + accountPageHTML))
@@ -0,0 +1,347 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// fakeSMTP is a local SMTP server that keeps the messages it is given.
+type fakeSMTP struct {
+	addr string
+	mu   sync.Mutex
+	sent []string // recipient, then the message
+}
+
+// newFakeSMTP starts a fake SMTP server for the test.
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSMTP{addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// serve speaks just enough SMTP for net/smtp.SendMail.
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	in := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) } //nolint:errcheck
+	reply("220 fake ESMTP")
+	var rcpt string
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpt = strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>")
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				l, err := in.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			s.mu.Lock()
+			s.sent = append(s.sent, rcpt, msg.String())
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+// messages returns the recipients and messages received so far.
+func (s *fakeSMTP) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.sent...)
+}
+
+var linkToken = regexp.MustCompile(`\?token=([A-Za-z0-9_%-]+)`)
+
+// accountTraits returns clerk traits sending mail through a fake server and
+// listing orders from a fake tracker, on a clock the test moves.
+func accountTraits(t *testing.T) (*Traits, *fakeSMTP, *time.Time) {
+	t.Helper()
+	now := time.Date(2026, 5, 4, 9, 0, 0, 0, time.UTC)
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testTrackerToken {
+			http.Error(w, "an operator token is required", http.StatusUnauthorized)
+			return
+		}
+		// The tracker's customer search matches part of an address.
+		orders := []ProductOrder_v1{
+			{OrderNumber: 3, ProductID: "penholder", Description: "Pen Holder, height 15 mm", Email: "ada@example.com", Status: "shipped"},
+			{OrderNumber: 4, ProductID: "penholder", Email: "grada@example.com"},
+			{OrderNumber: 7, ProductID: "coaster", Email: "Ada@Example.com", Price: 55, Currency: "SEK"},
+		}
+		var found []ProductOrder_v1
+		for _, o := range orders {
+			if strings.Contains(strings.ToLower(o.Email), strings.ToLower(r.URL.Query().Get("customer"))) {
+				found = append(found, o)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"orders": found, "total": len(found), "version": "OrderList_v1"}) //nolint:errcheck
+	}))
+	t.Cleanup(tracker.Close)
+
+	smtpServer := newFakeSMTP(t)
+	tr := &Traits{
+		Mail:         Mail{Server: smtpServer.addr, From: "orders@example.com", LinkBase: testLinkBase},
+		TrackerToken: testTrackerToken,
+		accounts:     newAccounts(func() time.Time { return now }),
+		cervices: components.Cervices{
+			"orders": {Definition: "orders", Nodes: map[string][]components.NodeInfo{"tracker": {{URL: tracker.URL}}}},
+		},
+	}
+	return tr, smtpServer, &now
+}
+
+const (
+	testTrackerToken = "clerk-token-0123456789"
+	testLinkBase     = "https://shop.example.com/clerk/product/account"
+)
+
+// askForLink posts an email address to the account page from a client.
+func askForLink(tr *Traits, email, client string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/clerk/product/account", strings.NewReader(url.Values{"email": {email}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = client + ":40000"
+	w := httptest.NewRecorder()
+	tr.accountHandler(w, req)
+	return w
+}
+
+// followLink follows the login link of the last message sent, and returns
+// the response.
+func followLink(t *testing.T, tr *Traits, mails *fakeSMTP) *httptest.ResponseRecorder {
+	t.Helper()
+	sent := mails.messages()
+	if len(sent) == 0 {
+		t.Fatal("no mail sent")
+	}
+	m := linkToken.FindStringSubmatch(sent[len(sent)-1])
+	if m == nil {
+		t.Fatalf("no login link in %q", sent[len(sent)-1])
+	}
+	token, _ := url.QueryUnescape(m[1])
+	w := httptest.NewRecorder()
+	tr.accountHandler(w, httptest.NewRequest(http.MethodGet, "/clerk/product/account?token="+url.QueryEscape(token), nil))
+	return w
+}
+
+// TestMagicLinkLogin logs a customer in by the link mailed to them and lists
+// the orders placed with their address, and no one else's.
+func TestMagicLinkLogin(t *testing.T) {
+	tr, mails, _ := accountTraits(t)
+	if w := askForLink(tr, "Ada@Example.com", "192.0.2.1"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "on its way to ada@example.com") {
+		t.Fatalf("asking for a link: %d %s", w.Code, w.Body)
+	}
+	sent := mails.messages()
+	if len(sent) != 2 || sent[0] != "ada@example.com" || !strings.Contains(sent[1], testLinkBase+"?token=") {
+		t.Fatalf("mail %q", sent)
+	}
+
+	w := followLink(t, tr, mails)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/clerk/product/account" {
+		t.Fatalf("following the link: %d %v", w.Code, w.Header())
+	}
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != sessionCookie || !cookie.HttpOnly {
+		t.Errorf("session cookie %+v", cookie)
+	}
+	if again := followLink(t, tr, mails); again.Code != http.StatusBadRequest {
+		t.Errorf("a login link worked twice: %d", again.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/clerk/product/account", nil)
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	tr.accountHandler(w, req)
+	page := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(page, ">#7</span>") || !strings.Contains(page, ">#3</span>") || !strings.Contains(page, "55.00 SEK") {
+		t.Fatalf("my orders: %d %s", w.Code, page)
+	}
+	if strings.Contains(page, ">#4</span>") {
+		t.Error("another customer's order is listed")
+	}
+	if strings.Index(page, ">#7</span>") > strings.Index(page, ">#3</span>") {
+		t.Error("orders are not newest first")
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/clerk/product/account", strings.NewReader("action=logout"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	tr.accountHandler(httptest.NewRecorder(), req)
+	if _, ok := tr.accounts.customer(cookie.Value); ok {
+		t.Error("the session is open after logging out")
+	}
+}
+
+// TestLoginExpiry refuses links and sessions past their time.
+func TestLoginExpiry(t *testing.T) {
+	tr, mails, now := accountTraits(t)
+	askForLink(tr, "ada@example.com", "192.0.2.1")
+	*now = now.Add(linkTTL)
+	if w := followLink(t, tr, mails); w.Code != http.StatusBadRequest {
+		t.Errorf("an expired link worked: %d", w.Code)
+	}
+
+	askForLink(tr, "ada@example.com", "192.0.2.1")
+	cookie := followLink(t, tr, mails).Result().Cookies()[0]
+	*now = now.Add(sessionTTL)
+	req := httptest.NewRequest(http.MethodGet, "/clerk/product/account", nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	tr.accountHandler(w, req)
+	if strings.Contains(w.Body.String(), "Orders placed with") {
+		t.Error("an expired session is still logged in")
+	}
+}
+
+// TestLoginLinksLimited limits the links sent to an address, and refuses
+// addresses that are not.
+func TestLoginLinksLimited(t *testing.T) {
+	tr, mails, now := accountTraits(t)
+	for i, client := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4"} {
+		w := askForLink(tr, "ada@example.com", client)
+		if want := i < 3; (w.Code == http.StatusOK) != want {
+			t.Errorf("link %d: %d", i+1, w.Code)
+		}
+	}
+	if w := askForLink(tr, "ada@example.com", "192.0.2.5"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over the limit: %d %v", w.Code, w.Header())
+	}
+	*now = now.Add(10 * time.Minute)
+	if w := askForLink(tr, "ada@example.com", "192.0.2.5"); w.Code != http.StatusOK {
+		t.Errorf("after waiting: %d", w.Code)
+	}
+	if n := len(mails.messages()) / 2; n != 4 {
+		t.Errorf("%d links sent, want 4", n)
+	}
+
+	for _, bad := range []string{"", "not an address", "Ada <ada@example.com>", "ada@example.com, eve@example.com"} {
+		if w := askForLink(tr, bad, "198.51.100.1"); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: %d", bad, w.Code)
+		}
+	}
+	tr.Mail.Server = ""
+	if w := askForLink(tr, "ada@example.com", "198.51.100.2"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a mail server: %d", w.Code)
+	}
+}
+
+// TestLoginLinkIgnoresHost asks for a link with a forged Host header, which
+// must not change where the mailed link points, and checks that a mail server
+// without the account page's address is refused.
+func TestLoginLinkIgnoresHost(t *testing.T) {
+	tr, mails, _ := accountTraits(t)
+	req := httptest.NewRequest(http.MethodPost, "/clerk/product/account", strings.NewReader(url.Values{"email": {"ada@example.com"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Host = "evil.example"
+	w := httptest.NewRecorder()
+	tr.accountHandler(w, req)
+	sent := mails.messages()
+	if w.Code != http.StatusOK || len(sent) != 2 {
+		t.Fatalf("asking for a link: %d, mail %q", w.Code, sent)
+	}
+	if !strings.Contains(sent[1], testLinkBase+"?token=") || strings.Contains(sent[1], "evil.example") {
+		t.Errorf("link follows the Host header: %q", sent[1])
+	}
+
+	for _, base := range []string{"", "/clerk/product/account", "shop.example.com/account", "https://shop.example.com/account?next=x"} {
+		m := Mail{Server: "smtp.example.com:25", LinkBase: base}
+		if m.check() == nil {
+			t.Errorf("link base %q accepted", base)
+		}
+	}
+	if err := (&Mail{}).check(); err != nil {
+		t.Errorf("no mail server: %v", err)
+	}
+	tr.Mail.LinkBase = ""
+	if w := askForLink(tr, "ada@example.com", "198.51.100.3"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a link base: %d", w.Code)
+	}
+}
+
+// TestLookupRateLimited stops a client trying order numbers one by one,
+// without holding up others.
+func TestLookupRateLimited(t *testing.T) {
+	tracker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no order found with that number and email", http.StatusNotFound)
+	}))
+	defer tracker.Close()
+	tr := newTestTraits()
+	tr.cervices = components.Cervices{
+		"order": {Definition: "order", Nodes: map[string][]components.NodeInfo{"tracker": {{URL: tracker.URL}}}},
+	}
+	lookup := func(id int, client string) int {
+		req := httptest.NewRequest(http.MethodGet, "/clerk/product/orders?email=eve%40example.com&id="+strings.Repeat("1", id), nil)
+		req.RemoteAddr = client + ":50000"
+		w := httptest.NewRecorder()
+		tr.ordersHandler(w, req)
+		return w.Code
+	}
+	codes := make(map[int]int)
+	for id := 1; id <= 15; id++ {
+		codes[lookup(id, "203.0.113.9")]++
+	}
+	if codes[http.StatusNotFound] != 10 || codes[http.StatusTooManyRequests] != 5 {
+		t.Errorf("answers %v, want 10 lookups and 5 refusals", codes)
+	}
+	if code := lookup(1, "203.0.113.10"); code != http.StatusNotFound {
+		t.Errorf("another client was refused: %d", code)
+	}
+}
@@ -0,0 +1,422 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+//-------------------------------------Product catalogue
+
+// The catalogue describes each product clerk takes orders for in a JSON file
+// of its own: the product's parameters, with their types, ranges and units,
+// and the rule that prices it. The order form and the validation of orders
+// are derived from it, so that a new product is a new file rather than new
+// code. The built-in products are in catalogue/; files in the directory
+// configured as the asset's catalogue add to them or, under the same file
+// name, replace them.
+//
+//go:embed catalogue/*.json
+var embeddedCatalogue embed.FS
+
+// Product is a product of the catalogue.
+type Product struct {
+	ID          string      `json:"id"` // as in ProductOrder_v1.product_id
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  []Parameter `json:"parameters"`
+	Price       PriceRule   `json:"price"`
+}
+
+// Parameter types.
+const (
+	paramNumber  = "number"
+	paramInteger = "integer"
+	paramChoice  = "choice"
+	paramText    = "text"
+)
+
+// Parameter is a value the customer chooses when ordering a product.
+type Parameter struct {
+	Name         string   `json:"name"` // key in the order's parameters and id of the form field
+	Label        string   `json:"label"`
+	Type         string   `json:"type"` // number, integer, choice or text
+	Unit         string   `json:"unit,omitempty"`
+	Min          *float64 `json:"min,omitempty"` // for text, the shortest length
+	Max          *float64 `json:"max,omitempty"` // for text, the longest length
+	Step         float64  `json:"step,omitempty"`
+	MinParameter string   `json:"minParameter,omitempty"` // a number parameter this one may not be below
+	MaxParameter string   `json:"maxParameter,omitempty"` // a number parameter this one may not exceed
+	Choices      []Choice `json:"choices,omitempty"`
+	Default      any      `json:"default,omitempty"`
+	Optional     bool     `json:"optional,omitempty"`
+	Placeholder  string   `json:"placeholder,omitempty"`
+}
+
+// Choice is one of the values of a choice parameter.
+type Choice struct {
+	Value any    `json:"value"` // a number or a string
+	Label string `json:"label"`
+}
+
+// PriceRule prices an order, VAT excluded: the base price, plus a rate per
+// unit of number parameters, plus a surcharge (or, if negative, a discount)
+// for some values of choice parameters. A product with no rule is priced on
+// request.
+type PriceRule struct {
+	Currency string                        `json:"currency,omitempty"` // ISO 4217
+	Base     float64                       `json:"base,omitempty"`
+	Per      map[string]float64            `json:"per,omitempty"`     // by parameter
+	Choices  map[string]map[string]float64 `json:"choices,omitempty"` // by parameter and value
+}
+
+// formFieldIDs are the ids the order page gives its own fields, which no
+// parameter may take.
+var formFieldIDs = []string{"product", "name", "email", "line", "peppol", "price", "lookupId", "lookupEmail"}
+
+// catalogue is the set of products an asset takes orders for.
+type catalogue struct {
+	products []*Product // by name
+}
+
+// defaultProduct is the product the order page offers first, if the catalogue
+// has it.
+const defaultProduct = "penholder"
+
+// builtinCatalogue is the catalogue of the assets configured without one.
+var builtinCatalogue = mustLoadCatalogue()
+
+// mustLoadCatalogue loads the built-in catalogue, which is part of the
+// program and so cannot be wrong at run time.
+func mustLoadCatalogue() *catalogue {
+	c, err := loadCatalogue("")
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// loadCatalogue reads the built-in products and those in dir, if set, and
+// checks them all.
+func loadCatalogue(dir string) (*catalogue, error) {
+	files := make(map[string][]byte)
+	embedded, _ := fs.Glob(embeddedCatalogue, "catalogue/*.json")
+	for _, name := range embedded {
+		data, err := embeddedCatalogue.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Base(name)] = data
+	}
+	if dir != "" {
+		own, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		if len(own) == 0 {
+			if _, err := os.Stat(dir); err != nil {
+				return nil, fmt.Errorf("product catalogue: %w", err)
+			}
+		}
+		for _, name := range own {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return nil, fmt.Errorf("product catalogue: %w", err)
+			}
+			files[filepath.Base(name)] = data
+		}
+	}
+
+	c := &catalogue{}
+	seen := make(map[string]string)
+	for file, data := range files {
+		var p Product
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("product catalogue %s: %w", file, err)
+		}
+		if err := p.check(); err != nil {
+			return nil, fmt.Errorf("product catalogue %s: %w", file, err)
+		}
+		if other, dup := seen[p.ID]; dup {
+			return nil, fmt.Errorf("product catalogue: %s and %s both describe product %q", other, file, p.ID)
+		}
+		seen[p.ID] = file
+		c.products = append(c.products, &p)
+	}
+	if len(c.products) == 0 {
+		return nil, errors.New("product catalogue: no products")
+	}
+	sort.Slice(c.products, func(i, j int) bool { return c.products[i].Name < c.products[j].Name })
+	return c, nil
+}
+
+// product finds a product by ID.
+func (c *catalogue) product(id string) (*Product, bool) {
+	for _, p := range c.products {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// first returns the product the order page offers when none is asked for.
+func (c *catalogue) first() *Product {
+	if p, ok := c.product(defaultProduct); ok {
+		return p
+	}
+	return c.products[0]
+}
+
+//-------------------------------------Checking the catalogue
+
+// check tells what is wrong with a product's description.
+func (p *Product) check() error {
+	if p.ID == "" || p.Name == "" {
+		return errors.New("a product needs an id and a name")
+	}
+	params := make(map[string]*Parameter)
+	for i := range p.Parameters {
+		q := &p.Parameters[i]
+		if q.Name == "" || q.Label == "" {
+			return fmt.Errorf("product %s: parameter %d needs a name and a label", p.ID, i+1)
+		}
+		if slices.Contains(formFieldIDs, q.Name) {
+			return fmt.Errorf("product %s: %q is the name of a field of the order form", p.ID, q.Name)
+		}
+		if params[q.Name] != nil {
+			return fmt.Errorf("product %s: parameter %s is described twice", p.ID, q.Name)
+		}
+		params[q.Name] = q
+		switch q.Type {
+		case paramNumber, paramInteger, paramText:
+		case paramChoice:
+			if len(q.Choices) == 0 {
+				return fmt.Errorf("product %s: choice parameter %s has no choices", p.ID, q.Name)
+			}
+			for _, ch := range q.Choices {
+				switch ch.Value.(type) {
+				case float64, string:
+				default:
+					return fmt.Errorf("product %s: the values of %s must be numbers or strings", p.ID, q.Name)
+				}
+			}
+		default:
+			return fmt.Errorf("product %s: parameter %s has unknown type %q", p.ID, q.Name, q.Type)
+		}
+		if q.Min != nil && q.Max != nil && *q.Min > *q.Max {
+			return fmt.Errorf("product %s: parameter %s has min above max", p.ID, q.Name)
+		}
+	}
+	for _, q := range p.Parameters {
+		for _, other := range []string{q.MinParameter, q.MaxParameter} {
+			if other == "" {
+				continue
+			}
+			if o := params[other]; o == nil || o == params[q.Name] || !o.numeric() || !q.numeric() {
+				return fmt.Errorf("product %s: parameter %s is bounded by %q, which is not another number parameter", p.ID, q.Name, other)
+			}
+		}
+		if q.Default != nil {
+			if _, err := q.value(q.Default); err != nil {
+				return fmt.Errorf("product %s: default: %w", p.ID, err)
+			}
+		}
+	}
+
+	r := p.Price
+	if r.priced() && len(r.Currency) != 3 {
+		return fmt.Errorf("product %s: a price needs a three-letter currency", p.ID)
+	}
+	for name := range r.Per {
+		if q := params[name]; q == nil || !q.numeric() {
+			return fmt.Errorf("product %s: price per %s, which is not a number parameter", p.ID, name)
+		}
+	}
+	for name, values := range r.Choices {
+		q := params[name]
+		if q == nil || q.Type != paramChoice {
+			return fmt.Errorf("product %s: price by %s, which is not a choice parameter", p.ID, name)
+		}
+		for v := range values {
+			if !slices.ContainsFunc(q.Choices, func(ch Choice) bool { return fmt.Sprint(ch.Value) == v }) {
+				return fmt.Errorf("product %s: price for %s %s, which is not one of its choices", p.ID, name, v)
+			}
+		}
+	}
+	return nil
+}
+
+// numeric tells if a parameter takes numbers.
+func (q *Parameter) numeric() bool {
+	return q.Type == paramNumber || q.Type == paramInteger
+}
+
+// priced tells if the rule prices anything.
+func (r *PriceRule) priced() bool {
+	return r.Base != 0 || len(r.Per) > 0 || len(r.Choices) > 0
+}
+
+//-------------------------------------Checking orders
+
+// Validate checks the parameters of an order for the product and returns
+// them as they are to be filed: with the defaults of those not given, and
+// the values of choices as the catalogue has them.
+func (p *Product) Validate(params map[string]any) (map[string]any, error) {
+	for name := range params {
+		if !slices.ContainsFunc(p.Parameters, func(q Parameter) bool { return q.Name == name }) {
+			return nil, fmt.Errorf("%s has no parameter %s", p.Name, name)
+		}
+	}
+	valid := make(map[string]any, len(p.Parameters))
+	for _, q := range p.Parameters {
+		v, given := params[q.Name]
+		if !given || v == nil || v == "" {
+			if q.Default != nil {
+				v = q.Default
+			} else if q.Optional {
+				continue
+			} else {
+				return nil, fmt.Errorf("%s is required", q.Name)
+			}
+		}
+		v, err := q.value(v)
+		if err != nil {
+			return nil, err
+		}
+		valid[q.Name] = v
+	}
+	for _, q := range p.Parameters {
+		v, ok := valid[q.Name].(float64)
+		if !ok {
+			continue
+		}
+		if bound, ok := valid[q.MinParameter].(float64); ok && v < bound {
+			return nil, fmt.Errorf("%s must not be below %s", q.Name, q.MinParameter)
+		}
+		if bound, ok := valid[q.MaxParameter].(float64); ok && v > bound {
+			return nil, fmt.Errorf("%s must not exceed %s", q.Name, q.MaxParameter)
+		}
+	}
+	return valid, nil
+}
+
+// value checks a value of the parameter on its own, and returns it in the
+// catalogue's terms.
+func (q *Parameter) value(v any) (any, error) {
+	switch q.Type {
+	case paramChoice:
+		for _, ch := range q.Choices {
+			if fmt.Sprint(ch.Value) == fmt.Sprint(v) {
+				return ch.Value, nil
+			}
+		}
+		return nil, fmt.Errorf("%s must be one of %s", q.Name, q.choiceValues())
+	case paramText:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be text", q.Name)
+		}
+		n := float64(len([]rune(s)))
+		if (q.Min != nil && n < *q.Min) || (q.Max != nil && n > *q.Max) {
+			return nil, fmt.Errorf("%s must be %s characters long", q.Name, q.bounds())
+		}
+		return s, nil
+	}
+	n, ok := v.(float64)
+	if !ok || math.IsNaN(n) || math.IsInf(n, 0) {
+		return nil, fmt.Errorf("%s must be a number", q.Name)
+	}
+	if q.Type == paramInteger && n != math.Trunc(n) {
+		return nil, fmt.Errorf("%s must be a whole number", q.Name)
+	}
+	if (q.Min != nil && n < *q.Min) || (q.Max != nil && n > *q.Max) {
+		return nil, fmt.Errorf("%s must be %s", q.Name, strings.TrimSpace(q.bounds()+" "+q.Unit))
+	}
+	return n, nil
+}
+
+// bounds puts the range of a parameter into words.
+func (q *Parameter) bounds() string {
+	switch {
+	case q.Min != nil && q.Max != nil:
+		return fmt.Sprintf("between %g and %g", *q.Min, *q.Max)
+	case q.Min != nil:
+		return fmt.Sprintf("at least %g", *q.Min)
+	case q.Max != nil:
+		return fmt.Sprintf("at most %g", *q.Max)
+	}
+	return ""
+}
+
+// choiceValues lists the values of a choice parameter.
+func (q *Parameter) choiceValues() string {
+	values := make([]string, len(q.Choices))
+	for i, ch := range q.Choices {
+		values[i] = fmt.Sprint(ch.Value)
+	}
+	return strings.Join(values, ", ")
+}
+
+// Quote prices validated parameters, in cents rounded, and tells if the
+// product has a price at all.
+func (p *Product) Quote(params map[string]any) (float64, bool) {
+	r := p.Price
+	if !r.priced() {
+		return 0, false
+	}
+	price := r.Base
+	for name, rate := range r.Per {
+		if v, ok := params[name].(float64); ok {
+			price += rate * v
+		}
+	}
+	for name, values := range r.Choices {
+		if v, ok := params[name]; ok {
+			price += values[fmt.Sprint(v)]
+		}
+	}
+	return math.Round(price*100) / 100, true
+}
+
+// Describe puts the product and validated parameters into words, for the
+// order record and the invoice.
+func (p *Product) Describe(params map[string]any) string {
+	parts := []string{p.Name}
+	for _, q := range p.Parameters {
+		v, ok := params[q.Name]
+		if !ok {
+			continue
+		}
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("%s %v %s", strings.ToLower(q.Label), v, q.Unit)))
+	}
+	return strings.Join(parts, ", ")
+}
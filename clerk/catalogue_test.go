@@ -0,0 +1,212 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// coaster is a second product, written to a catalogue directory by the tests.
+const coaster = `{
+  "id": "coaster",
+  "name": "Coaster",
+  "parameters": [
+    {"name": "diameter", "label": "Diameter", "type": "integer", "unit": "mm", "min": 70, "max": 110},
+    {"name": "wood", "label": "Wood", "type": "choice",
+     "choices": [{"value": "birch", "label": "Birch"}, {"value": "oak", "label": "Oak"}]},
+    {"name": "engraving", "label": "Engraving", "type": "text", "max": 12, "optional": true}
+  ],
+  "price": {"currency": "SEK", "base": 40, "choices": {"wood": {"oak": 15}}}
+}`
+
+// catalogueDir writes product files to a directory and returns it.
+func catalogueDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestBuiltinCatalogue verifies the pen holder is built in and offered first.
+func TestBuiltinCatalogue(t *testing.T) {
+	p := builtinCatalogue.first()
+	if p.ID != "penholder" || len(p.Parameters) != 3 {
+		t.Fatalf("first product %+v", p)
+	}
+	if _, ok := builtinCatalogue.product("coaster"); ok {
+		t.Error("coaster is built in")
+	}
+}
+
+// TestValidatePenHolder checks pen holder parameters as the single-product
+// form did, with the roughness defaulted.
+func TestValidatePenHolder(t *testing.T) {
+	p, _ := builtinCatalogue.product("penholder")
+	got, err := p.Validate(map[string]any{"height": 15.0, "depth": 5.0})
+	if err != nil {
+		t.Fatalf("valid order refused: %v", err)
+	}
+	if got["roughness"] != 63.0 {
+		t.Errorf("roughness defaulted to %v", got["roughness"])
+	}
+	for _, bad := range []map[string]any{
+		{"height": 25.0, "depth": 3.0},
+		{"height": 0.0, "depth": 0.0},
+		{"height": 10.0, "depth": 15.0},
+		{"height": 10.0},
+		{"height": "10", "depth": 5.0},
+		{"height": 10.0, "depth": 5.0, "roughness": 50.0},
+		{"height": 10.0, "depth": 5.0, "colour": "red"},
+	} {
+		if _, err := p.Validate(bad); err == nil {
+			t.Errorf("%v accepted", bad)
+		}
+	}
+	if got := p.Describe(map[string]any{"height": 15.0, "depth": 5.0, "roughness": 63.0}); got != "Pen Holder, height 15 mm, depth 5 mm, surface roughness 63" {
+		t.Errorf("description %q", got)
+	}
+}
+
+// TestQuote prices pen holders by height and finish.
+func TestQuote(t *testing.T) {
+	p, _ := builtinCatalogue.product("penholder")
+	for _, c := range []struct {
+		roughness float64
+		want      float64
+	}{{63, 250}, {32, 290}, {125, 230}} {
+		price, priced := p.Quote(map[string]any{"height": 15.0, "depth": 5.0, "roughness": c.roughness})
+		if !priced || price != c.want {
+			t.Errorf("roughness %v: price %v (%v), want %v", c.roughness, price, priced, c.want)
+		}
+	}
+}
+
+// TestCatalogueDirectory adds a product from a directory and checks its
+// integer, choice and optional text parameters.
+func TestCatalogueDirectory(t *testing.T) {
+	c, err := loadCatalogue(catalogueDir(t, map[string]string{"coaster.json": coaster}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := c.product("coaster")
+	if !ok || c.first().ID != "penholder" {
+		t.Fatalf("catalogue %v", c.products)
+	}
+	got, err := p.Validate(map[string]any{"diameter": 90.0, "wood": "oak"})
+	if err != nil {
+		t.Fatalf("valid order refused: %v", err)
+	}
+	if _, has := got["engraving"]; has {
+		t.Error("an optional parameter without a default was filled in")
+	}
+	if price, _ := p.Quote(got); price != 55 {
+		t.Errorf("price %v, want 55", price)
+	}
+	for _, bad := range []map[string]any{
+		{"diameter": 90.5, "wood": "oak"},
+		{"diameter": 90.0, "wood": "pine"},
+		{"diameter": 90.0, "wood": "oak", "engraving": "far too long to fit"},
+	} {
+		if _, err := p.Validate(bad); err == nil {
+			t.Errorf("%v accepted", bad)
+		}
+	}
+}
+
+// TestCatalogueRefused verifies that mistakes in product files stop loading.
+func TestCatalogueRefused(t *testing.T) {
+	for name, text := range map[string]string{
+		"unknown field":     `{"id": "x", "name": "X", "parameters": [], "colour": "red"}`,
+		"form field":        `{"id": "x", "name": "X", "parameters": [{"name": "email", "label": "E", "type": "text"}]}`,
+		"unknown type":      `{"id": "x", "name": "X", "parameters": [{"name": "a", "label": "A", "type": "date"}]}`,
+		"bad bound":         `{"id": "x", "name": "X", "parameters": [{"name": "a", "label": "A", "type": "number", "maxParameter": "b"}]}`,
+		"bad default":       `{"id": "x", "name": "X", "parameters": [{"name": "a", "label": "A", "type": "number", "max": 5, "default": 9}]}`,
+		"price by text":     `{"id": "x", "name": "X", "parameters": [{"name": "a", "label": "A", "type": "text"}], "price": {"currency": "SEK", "per": {"a": 1}}}`,
+		"no currency":       `{"id": "x", "name": "X", "parameters": [], "price": {"base": 10}}`,
+		"duplicate product": coaster,
+	} {
+		files := map[string]string{"x.json": text}
+		if name == "duplicate product" {
+			files = map[string]string{"a.json": coaster, "b.json": text}
+		}
+		if _, err := loadCatalogue(catalogueDir(t, files)); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+	if _, err := loadCatalogue(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("a missing directory was taken for an empty one")
+	}
+}
+
+// TestProductPages verifies the order page offers every product and renders
+// the one asked for, and that the products service lists them.
+func TestProductPages(t *testing.T) {
+	c, err := loadCatalogue(catalogueDir(t, map[string]string{"coaster.json": coaster}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &Traits{catalogue: c}
+
+	w := httptest.NewRecorder()
+	tr.ordersHandler(w, httptest.NewRequest(http.MethodGet, "/clerk/product/orders?product=coaster", nil))
+	page := w.Body.String()
+	for _, want := range []string{`id="product"`, `<option value="coaster" selected>`, `id="diameter"`, `id="wood"`, `maxlength="12"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("coaster page missing %q", want)
+		}
+	}
+	w = httptest.NewRecorder()
+	tr.ordersHandler(w, httptest.NewRequest(http.MethodGet, "/clerk/product/orders?product=vase", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown product page: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	tr.productsHandler(w, httptest.NewRequest(http.MethodGet, "/clerk/product/products", nil))
+	var products []Product
+	if err := json.Unmarshal(w.Body.Bytes(), &products); err != nil || len(products) != 2 {
+		t.Errorf("products %s", w.Body)
+	}
+	w = httptest.NewRecorder()
+	tr.productsHandler(w, httptest.NewRequest(http.MethodGet, "/clerk/product/products?id=vase", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown product: %d", w.Code)
+	}
+}
+
+// TestSubmitOrder_UnknownProduct verifies that orders are checked against
+// the catalogue.
+func TestSubmitOrder_UnknownProduct(t *testing.T) {
+	order := &ProductOrder_v1{ProductID: "vase", Parameters: map[string]any{}, Name: "Test", Email: "t@t.com"}
+	order.NewForm()
+	body, _ := json.Marshal(order)
+	w := httptest.NewRecorder()
+	newTestTraits().submitOrder(w, httptest.NewRequest(http.MethodPost, "/clerk/product/orders", strings.NewReader(string(body))))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "vase") {
+		t.Errorf("unknown product: %d %s", w.Code, w.Body)
+	}
+}
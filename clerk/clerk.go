@@ -38,7 +38,7 @@ func main() {
 	usecases.WatchShutdown(&sys, cancel)
 
 	sys.Husk = &components.Husk{
-		Description: "browser-based order entry form for pen holder orders",
+		Description: "browser-based order entry form for the products of a catalogue",
 		Details:     map[string][]string{"Developer": {"Synecdoque"}},
 		Host:        components.NewDevice(),
 		ProtoPort:   map[string]int{"https": 0, "http": 20190, "coap": 0},
@@ -87,6 +87,10 @@ func serving(t *Traits, w http.ResponseWriter, r *http.Request, servicePath stri
 	switch servicePath {
 	case "orders":
 		t.ordersHandler(w, r)
+	case "products":
+		t.productsHandler(w, r)
+	case "account":
+		t.accountHandler(w, r)
 	default:
 		http.Error(w, "Invalid service request [Do not modify the services subpath in the configuration file]", http.StatusBadRequest)
 	}
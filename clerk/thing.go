@@ -19,11 +19,15 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sdoque/mbaigo/components"
@@ -31,9 +35,39 @@ import (
 	"github.com/sdoque/mbaigo/usecases"
 )
 
-//-------------------------------------Order form
+//-------------------------------------Order forms
+
+// ProductOrder_v1 is the exchanged form for an order of any product of the
+// catalogue: the product's ID and its parameters by name. Description, price
+// and currency are clerk's, from the catalogue.
+type ProductOrder_v1 struct {
+	OrderNumber        int            `json:"order_number"`
+	ProductID          string         `json:"product_id"`
+	Parameters         map[string]any `json:"parameters"`
+	Description        string         `json:"description,omitempty"`
+	Price              float64        `json:"price,omitempty"` // VAT excluded
+	Currency           string         `json:"currency,omitempty"`
+	Name               string         `json:"name"`
+	Email              string         `json:"email"`
+	OrderedTimestamp   time.Time      `json:"timestamp"`
+	CompletedTimestamp time.Time      `json:"completed_timestamp"`
+	ProductionLine     string         `json:"production_line"`
+	PeppolID           string         `json:"peppol_id"`
+	Status             string         `json:"status,omitempty"`
+	Version            string         `json:"version"`
+}
+
+func (f *ProductOrder_v1) NewForm() forms.Form {
+	f.Version = "ProductOrder_v1"
+	return f
+}
 
-// PenHolderOrder_v1 is the exchanged form for pen holder orders.
+func (f *ProductOrder_v1) FormVersion() string {
+	return f.Version
+}
+
+// PenHolderOrder_v1 is the exchanged form for pen holder orders, from before
+// the catalogue. Clerk still takes it, as an order for the penholder product.
 type PenHolderOrder_v1 struct {
 	OrderNumber        int       `json:"order_number"`
 	Name               string    `json:"name"`
@@ -58,15 +92,65 @@ func (f *PenHolderOrder_v1) FormVersion() string {
 }
 
 func init() {
+	forms.FormTypeMap["ProductOrder_v1"] = reflect.TypeOf(ProductOrder_v1{})
 	forms.FormTypeMap["PenHolderOrder_v1"] = reflect.TypeOf(PenHolderOrder_v1{})
 }
 
+// productOrder maps a pen holder order onto the generic form.
+func (f *PenHolderOrder_v1) productOrder() *ProductOrder_v1 {
+	p := &ProductOrder_v1{
+		OrderNumber: f.OrderNumber,
+		ProductID:   defaultProduct,
+		Parameters: map[string]any{
+			"height":    f.Height,
+			"depth":     f.Depth,
+			"roughness": float64(f.Roughness),
+		},
+		Name: f.Name, Email: f.Email,
+		OrderedTimestamp: f.OrderedTimestamp, CompletedTimestamp: f.CompletedTimestamp,
+		ProductionLine: f.ProductionLine, PeppolID: f.PeppolID,
+	}
+	p.NewForm()
+	return p
+}
+
+// penHolderOrder maps a pen holder order in the generic form back onto
+// PenHolderOrder_v1.
+func (f *ProductOrder_v1) penHolderOrder() *PenHolderOrder_v1 {
+	num := func(name string) float64 {
+		v, _ := f.Parameters[name].(float64)
+		return v
+	}
+	o := &PenHolderOrder_v1{
+		OrderNumber: f.OrderNumber,
+		Name:        f.Name, Email: f.Email,
+		Height: num("height"), Depth: num("depth"), Roughness: int(math.Round(num("roughness"))),
+		OrderedTimestamp: f.OrderedTimestamp, CompletedTimestamp: f.CompletedTimestamp,
+		ProductionLine: f.ProductionLine, PeppolID: f.PeppolID,
+	}
+	o.NewForm()
+	return o
+}
+
 //-------------------------------------Define the unit asset
 
 // Traits holds the runtime state for the clerk unit asset.
 type Traits struct {
-	owner    *components.System  `json:"-"`
-	cervices components.Cervices `json:"-"`
+	Catalogue    string              `json:"catalogue"`    // directory of product files adding to the built-in ones; empty = built-in only
+	Mail         Mail                `json:"mail"`         // SMTP server for customers' login links; no server = no logins
+	TrackerToken string              `json:"trackerToken"` // operator token for the tracker's orders service, to list a customer's orders
+	catalogue    *catalogue          `json:"-"`
+	accounts     *accounts           `json:"-"`
+	owner        *components.System  `json:"-"`
+	cervices     components.Cervices `json:"-"`
+}
+
+// products returns the asset's catalogue.
+func (t *Traits) products() *catalogue {
+	if t.catalogue == nil {
+		return builtinCatalogue
+	}
+	return t.catalogue
 }
 
 //-------------------------------------Instantiate a unit asset template
@@ -76,9 +160,23 @@ func initTemplate() *components.UnitAsset {
 	ordersService := components.Service{
 		Definition:  "orders",
 		SubPath:     "orders",
-		Details:     map[string][]string{"Forms": {"PenHolderOrder_v1"}},
+		Details:     map[string][]string{"Forms": {"ProductOrder_v1", "PenHolderOrder_v1"}},
+		RegPeriod:   60,
+		Description: "browser order form (GET ?product=P), submit new order (POST), look up order (GET ?id=N)",
+	}
+	productsService := components.Service{
+		Definition:  "products",
+		SubPath:     "products",
+		Details:     map[string][]string{"Forms": {"application/json"}},
 		RegPeriod:   60,
-		Description: "browser order form (GET), submit new order (POST), look up order (GET ?id=N)",
+		Description: "the product catalogue (GET) or one product of it (GET ?id=P)",
+	}
+	accountService := components.Service{
+		Definition:  "account",
+		SubPath:     "account",
+		Details:     map[string][]string{"Forms": {"text/html"}},
+		RegPeriod:   60,
+		Description: "customer login by emailed link and the customer's orders (GET), ask for a link or log out (POST)",
 	}
 
 	return &components.UnitAsset{
@@ -86,9 +184,11 @@ func initTemplate() *components.UnitAsset {
 		Mission: "take_orders",
 		Details: map[string][]string{"Collection": {"PenHolder"}},
 		ServicesMap: components.Services{
-			ordersService.SubPath: &ordersService,
+			ordersService.SubPath:   &ordersService,
+			productsService.SubPath: &productsService,
+			accountService.SubPath:  &accountService,
 		},
-		Traits: &Traits{},
+		Traits: &Traits{Mail: Mail{From: "orders@example.com"}},
 	}
 }
 
@@ -105,12 +205,41 @@ func newResource(uac usecases.ConfigurableAsset, sys *components.System) (*compo
 		Nodes:      make(map[string][]components.NodeInfo),
 	}
 
+	// Cervice: the tracker's operator orders service, listing a customer's
+	// orders. The form tells it from clerk's own orders service.
+	ordersCer := &components.Cervice{
+		Definition: "orders",
+		Details:    map[string][]string{"Forms": {"OrderList_v1"}},
+		Protos:     sProtocols,
+		Nodes:      make(map[string][]components.NodeInfo),
+	}
+
 	t := &Traits{
-		owner: sys,
+		accounts: newAccounts(time.Now),
+		owner:    sys,
 		cervices: components.Cervices{
-			orderCer.Definition: orderCer,
+			orderCer.Definition:  orderCer,
+			ordersCer.Definition: ordersCer,
 		},
 	}
+	if len(uac.Traits) > 0 {
+		if err := json.Unmarshal(uac.Traits[0], t); err != nil {
+			log.Println("Warning: could not unmarshal traits:", err)
+		}
+	}
+	if err := t.Mail.check(); err != nil {
+		log.Fatalf("clerk: %v", err)
+	}
+	if t.Mail.Server != "" && t.TrackerToken == "" {
+		log.Println("clerk: no trackerToken is configured; logged-in customers cannot see their orders")
+	}
+	if t.Catalogue != "" {
+		c, err := loadCatalogue(t.Catalogue)
+		if err != nil {
+			log.Fatalf("clerk: %v", err)
+		}
+		t.catalogue = c
+	}
 
 	ua := &components.UnitAsset{
 		Name:        uac.Name,
@@ -130,19 +259,22 @@ func newResource(uac usecases.ConfigurableAsset, sys *components.System) (*compo
 
 //-------------------------------------Service handlers
 
-// ordersHandler routes GET (page or lookup) and POST (new order).
+// ordersHandler routes GET (page or lookup) and POST (new order). Lookups are
+// rate limited by client, so that order numbers cannot be tried one by one.
 func (t *Traits) ordersHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		id := r.URL.Query().Get("id")
 		email := r.URL.Query().Get("email")
 		if id != "" && email != "" {
+			if limited(w, t.accounts.lookups, clientAddr(r)) {
+				return
+			}
 			t.lookupFromTracker(w, id, email)
 		} else if id != "" || email != "" {
 			http.Error(w, "both id and email are required for order lookup", http.StatusBadRequest)
 		} else {
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			fmt.Fprint(w, orderPage)
+			t.orderPage(w, r.URL.Query().Get("product"))
 		}
 	case http.MethodPost:
 		t.submitOrder(w, r)
@@ -151,8 +283,10 @@ func (t *Traits) ordersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// submitOrder unpacks the incoming JSON order, forwards it to the tracker, and
-// returns the confirmed record (including the assigned order number) as JSON.
+// submitOrder unpacks the incoming JSON order, checks it against the
+// catalogue, prices it, forwards it to the tracker as ProductOrder_v1, and
+// returns the confirmed record (including the assigned order number) as JSON,
+// in the form the order came in.
 func (t *Traits) submitOrder(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -166,20 +300,34 @@ func (t *Traits) submitOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unpacking order: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	order, ok := unpacked.(*PenHolderOrder_v1)
-	if !ok {
-		http.Error(w, "expected PenHolderOrder_v1 body", http.StatusBadRequest)
+	var order *ProductOrder_v1
+	_, isLegacy := unpacked.(*PenHolderOrder_v1)
+	switch f := unpacked.(type) {
+	case *ProductOrder_v1:
+		order = f
+	case *PenHolderOrder_v1:
+		order = f.productOrder()
+	default:
+		http.Error(w, "expected ProductOrder_v1 or PenHolderOrder_v1 body", http.StatusBadRequest)
 		return
 	}
 
-	if order.Height <= 0 || order.Height > 21 {
-		http.Error(w, "height must be between 0 and 21 mm", http.StatusBadRequest)
+	product, ok := t.products().product(order.ProductID)
+	if !ok {
+		http.Error(w, "unknown product "+strconv.Quote(order.ProductID), http.StatusBadRequest)
 		return
 	}
-	if order.Depth < 0 || order.Depth > order.Height {
-		http.Error(w, "depth must be ≥ 0 and not exceed height", http.StatusBadRequest)
+	params, err := product.Validate(order.Parameters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	order.Parameters = params
+	order.Description = product.Describe(params)
+	order.Price, order.Currency = 0, ""
+	if price, priced := product.Quote(params); priced {
+		order.Price, order.Currency = price, product.Price.Currency
+	}
 
 	packed, err := usecases.Pack(order, "application/json")
 	if err != nil {
@@ -194,41 +342,50 @@ func (t *Traits) submitOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	confirmed, ok := f.(*PenHolderOrder_v1)
+	confirmed, ok := f.(*ProductOrder_v1)
 	if !ok {
 		http.Error(w, "unexpected response from tracker", http.StatusBadGateway)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if isLegacy {
+		json.NewEncoder(w).Encode(confirmed.penHolderOrder()) //nolint:errcheck
+		return
+	}
 	json.NewEncoder(w).Encode(confirmed) //nolint:errcheck
 }
 
-// lookupFromTracker discovers the tracker's order service URL, appends ?id=N&email=E,
-// and proxies the response back to the browser.
-func (t *Traits) lookupFromTracker(w http.ResponseWriter, id, email string) {
-	cer := t.cervices["order"]
-
-	if len(cer.Nodes) == 0 {
-		if err := usecases.Search4Services(cer, t.owner); err != nil {
-			http.Error(w, "could not discover order service: "+err.Error(), http.StatusBadGateway)
+// productsHandler serves the catalogue (GET), or one product of it (GET ?id=P).
+func (t *Traits) productsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method is not supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	var reply any = t.products().products
+	if id := r.URL.Query().Get("id"); id != "" {
+		p, ok := t.products().product(id)
+		if !ok {
+			http.Error(w, "unknown product "+strconv.Quote(id), http.StatusNotFound)
 			return
 		}
+		reply = p
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply) //nolint:errcheck
+}
 
-	var baseURL string
-	for _, nodes := range cer.Nodes {
-		if len(nodes) > 0 {
-			baseURL = nodes[0].URL
-			break
-		}
-	}
-	if baseURL == "" {
-		http.Error(w, "order service not found", http.StatusBadGateway)
+// lookupFromTracker discovers the tracker's order service URL, appends ?id=N&email=E,
+// and proxies the response, as ProductOrder_v1, back to the browser.
+func (t *Traits) lookupFromTracker(w http.ResponseWriter, id, email string) {
+	cer := t.cervices["order"]
+	baseURL, err := t.serviceURL(cer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
 
-	targetURL := baseURL + "?id=" + url.QueryEscape(id) + "&email=" + url.QueryEscape(email)
+	targetURL := baseURL + "?id=" + url.QueryEscape(id) + "&email=" + url.QueryEscape(email) + "&form=ProductOrder_v1"
 	// Preserve framework-installed TLS so this works against an HTTPS-only tracker.
 	resp, err := (&http.Client{Timeout: 10 * time.Second, Transport: http.DefaultClient.Transport}).Get(targetURL)
 	if err != nil {
@@ -249,15 +406,53 @@ func (t *Traits) lookupFromTracker(w http.ResponseWriter, id, email string) {
 	w.Write(body) //nolint:errcheck
 }
 
-//-------------------------------------Embedded page
+// serviceURL returns the URL of a provider of a cervice, discovering the
+// providers if none is known yet.
+func (t *Traits) serviceURL(cer *components.Cervice) (string, error) {
+	if len(cer.Nodes) == 0 {
+		if err := usecases.Search4Services(cer, t.owner); err != nil {
+			return "", fmt.Errorf("could not discover %s service: %w", cer.Definition, err)
+		}
+	}
+	for _, nodes := range cer.Nodes {
+		if len(nodes) > 0 {
+			return nodes[0].URL, nil
+		}
+	}
+	return "", fmt.Errorf("%s service not found", cer.Definition)
+}
 
-// orderPage is the complete single-page UI served to browsers.
-const orderPage = `<!DOCTYPE html>
-<html lang="en">
-<head>
-  <meta charset="UTF-8">
-  <meta name="viewport" content="width=device-width, initial-scale=1.0">
-  <title>Pen Holder Orders</title>
+//-------------------------------------Order page
+
+// orderPageData is what the order page is rendered from.
+type orderPageData struct {
+	Products []*Product
+	Product  *Product // the one being ordered
+}
+
+// orderPage renders the order page for a product of the catalogue, the
+// default one if none is asked for.
+func (t *Traits) orderPage(w http.ResponseWriter, productID string) {
+	c := t.products()
+	data := orderPageData{Products: c.products, Product: c.first()}
+	if productID != "" {
+		p, ok := c.product(productID)
+		if !ok {
+			http.Error(w, "unknown product "+strconv.Quote(productID), http.StatusNotFound)
+			return
+		}
+		data.Product = p
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := orderPageTemplate.Execute(w, data); err != nil {
+		log.Printf("clerk: rendering the order page: %v\n", err)
+	}
+}
+
+//-------------------------------------Embedded pages
+
+// pageStyle is the style sheet of the pages served to browsers.
+const pageStyle = `{{define "style"}}
   <style>
     *, *::before, *::after { box-sizing: border-box; margin: 0; padding: 0; }
     body {
@@ -365,6 +560,11 @@ const orderPage = `<!DOCTYPE html>
       color: #c62828;
       border: 1px solid #ef9a9a;
     }
+    .price {
+      font-size: 0.95rem;
+      color: #333;
+      margin-top: 0.5rem;
+    }
     .hidden { display: none; }
     dl.detail { font-size: 0.9rem; }
     dl.detail div { display: flex; gap: 0.5rem; padding: 0.2rem 0; }
@@ -380,15 +580,39 @@ const orderPage = `<!DOCTYPE html>
       font-weight: 700;
     }
   </style>
+{{end}}`
+
+// orderPageHTML is the html/template of the single-page UI served to
+// browsers. The fields of the product's parameters, and their validation in
+// the browser, follow the catalogue; clerk checks the order again when it is
+// placed.
+const orderPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <title>{{.Product.Name}} Orders</title>
+  {{template "style"}}
 </head>
 <body>
-  <h1>Pen Holder Orders</h1>
+  <h1>{{.Product.Name}} Orders</h1>
 
   <!-- ── New order ───────────────────────────────────────── -->
   <div class="card">
     <h2>New Order</h2>
     <form id="orderForm" novalidate>
 
+      {{if gt (len .Products) 1}}
+      <div class="field">
+        <label for="product">Product</label>
+        <select id="product" onchange="window.location.search = '?product=' + encodeURIComponent(this.value)">
+          {{range .Products}}<option value="{{.ID}}"{{if eq .ID $.Product.ID}} selected{{end}}>{{.Name}}</option>
+          {{end}}
+        </select>
+      </div>
+      {{end}}
+      {{with .Product.Description}}<p class="field">{{.}}</p>{{end}}
+
       <div class="row">
         <div class="field">
           <label for="name">Name</label>
@@ -402,27 +626,22 @@ const orderPage = `<!DOCTYPE html>
         </div>
       </div>
 
-      <div class="row">
-        <div class="field">
-          <label for="height">Height <span class="note">mm, max 21</span></label>
-          <input type="number" id="height" min="0.1" max="21" step="0.1" placeholder="e.g. 15">
-          <div class="err" id="err-height"></div>
-        </div>
-        <div class="field">
-          <label for="depth">Depth <span class="note">mm, ≤ height</span></label>
-          <input type="number" id="depth" min="0" step="0.1" placeholder="e.g. 5">
-          <div class="err" id="err-depth"></div>
-        </div>
-      </div>
-
+      {{range .Product.Parameters}}
       <div class="field">
-        <label for="roughness">Surface Roughness</label>
-        <select id="roughness">
-          <option value="32">Ra max. 32 µm — Fine (grinding / finish turning)</option>
-          <option value="63" selected>Ra max. 63 µm — Standard (general turning)</option>
-          <option value="125">Ra max. 125 µm — Rough (heavy turning / milling)</option>
+        <label for="{{.Name}}">{{.Label}}{{with note .}} <span class="note">{{.}}</span>{{end}}</label>
+        {{if eq .Type "choice"}}
+        <select id="{{.Name}}">
+          {{$default := print .Default}}{{range .Choices}}<option value="{{.Value}}"{{if eq (print .Value) $default}} selected{{end}}>{{.Label}}</option>
+          {{end}}
         </select>
+        {{else if eq .Type "text"}}
+        <input type="text" id="{{.Name}}"{{with .Max}} maxlength="{{num .}}"{{end}}{{with .Default}} value="{{.}}"{{end}}{{with .Placeholder}} placeholder="{{.}}"{{end}}>
+        {{else}}
+        <input type="number" id="{{.Name}}"{{with .Min}} min="{{num .}}"{{end}}{{with .Max}} max="{{num .}}"{{end}}{{with .Step}} step="{{.}}"{{end}}{{with .Default}} value="{{.}}"{{end}}{{with .Placeholder}} placeholder="{{.}}"{{end}}>
+        {{end}}
+        <div class="err" id="err-{{.Name}}"></div>
       </div>
+      {{end}}
 
       <div class="field">
         <label for="line">Production Line <span class="note">optional</span></label>
@@ -434,6 +653,8 @@ const orderPage = `<!DOCTYPE html>
         <input type="text" id="peppol" placeholder="e.g. 0192:987654321">
       </div>
 
+      <div id="price" class="price"></div>
+
       <div class="btn-row">
         <button type="submit" class="btn-primary">Place Order</button>
         <button type="button" class="btn-secondary" onclick="resetForm()">Clear</button>
@@ -459,9 +680,85 @@ const orderPage = `<!DOCTYPE html>
       <button class="btn-primary" onclick="lookupOrder()">Look Up Order</button>
     </div>
     <div id="lookupResult" class="hidden" style="margin-top:1rem"></div>
+    <p class="field" style="margin-top:1rem"><a href="account">Log in by email</a> to see all your orders.</p>
   </div>
 
   <script>
+    // The product being ordered, as the catalogue describes it.
+    const product = {{.Product}};
+    const params  = product.parameters || [];
+
+    // ── Parameters ─────────────────────────────────────────
+    // value reads a parameter's field: a number for number and integer
+    // parameters (NaN if not one), the option's value for choices, text
+    // otherwise; undefined when left blank.
+    function value(p) {
+      const raw = document.getElementById(p.name).value.trim();
+      if (raw === '') return undefined;
+      if (p.type === 'number' || p.type === 'integer') return Number(raw);
+      if (p.type === 'choice') {
+        const c = p.choices.find(function(c) { return String(c.value) === raw; });
+        return c ? c.value : raw;
+      }
+      return raw;
+    }
+
+    function values() {
+      const v = {};
+      params.forEach(function(p) {
+        const x = value(p);
+        if (x !== undefined) v[p.name] = x;
+      });
+      return v;
+    }
+
+    // check mirrors the catalogue checks clerk makes when the order is placed.
+    function check(p, v) {
+      const x = v[p.name];
+      if (x === undefined) return p.optional ? '' : p.label + ' is required.';
+      if (p.type === 'text') {
+        if (p.min !== undefined && x.length < p.min) return p.label + ' must be at least ' + p.min + ' characters.';
+        if (p.max !== undefined && x.length > p.max) return p.label + ' must be at most ' + p.max + ' characters.';
+        return '';
+      }
+      if (p.type === 'choice') return '';
+      const unit = p.unit ? ' ' + p.unit : '';
+      if (isNaN(x)) return p.label + ' must be a number.';
+      if (p.type === 'integer' && !Number.isInteger(x)) return p.label + ' must be a whole number.';
+      if (p.min !== undefined && x < p.min) return p.label + ' must be at least ' + p.min + unit + '.';
+      if (p.max !== undefined && x > p.max) return p.label + ' must be at most ' + p.max + unit + '.';
+      if (p.minParameter && x < v[p.minParameter]) return p.label + ' must not be below ' + labelOf(p.minParameter) + '.';
+      if (p.maxParameter && x > v[p.maxParameter]) return p.label + ' must not exceed ' + labelOf(p.maxParameter) + '.';
+      return '';
+    }
+
+    function labelOf(name) {
+      const p = params.find(function(p) { return p.name === name; });
+      return p ? p.label.toLowerCase() : name;
+    }
+
+    // quote mirrors the catalogue's price rule.
+    function quote(v) {
+      const r = product.price || {};
+      if (!r.base && !r.per && !r.choices) return null;
+      let price = r.base || 0;
+      Object.keys(r.per || {}).forEach(function(name) {
+        if (typeof v[name] === 'number' && !isNaN(v[name])) price += r.per[name] * v[name];
+      });
+      Object.keys(r.choices || {}).forEach(function(name) {
+        if (v[name] !== undefined) price += r.choices[name][String(v[name])] || 0;
+      });
+      return Math.round(price * 100) / 100;
+    }
+
+    function showPrice() {
+      const v = values();
+      const complete = params.every(function(p) { return check(p, v) === ''; });
+      const price = complete ? quote(v) : null;
+      document.getElementById('price').textContent = price === null ? '' :
+        'Price: ' + price.toFixed(2) + ' ' + product.price.currency + ' excl. VAT';
+    }
+
     // ── Submit new order ────────────────────────────────────
     document.getElementById('orderForm').addEventListener('submit', async function(e) {
       e.preventDefault();
@@ -469,16 +766,15 @@ const orderPage = `<!DOCTYPE html>
 
       const order = {
         order_number:        0,
+        product_id:          product.id,
+        parameters:          values(),
         name:                document.getElementById('name').value.trim(),
         email:               document.getElementById('email').value.trim(),
-        height:              parseFloat(document.getElementById('height').value),
-        depth:               parseFloat(document.getElementById('depth').value),
-        roughness:           parseInt(document.getElementById('roughness').value, 10),
         production_line:     document.getElementById('line').value.trim(),
         peppol_id:           document.getElementById('peppol').value.trim(),
         timestamp:           new Date().toISOString(),
         completed_timestamp: '0001-01-01T00:00:00Z',
-        version:             'PenHolderOrder_v1'
+        version:             'ProductOrder_v1'
       };
 
       const fb = document.getElementById('feedback');
@@ -492,12 +788,14 @@ const orderPage = `<!DOCTYPE html>
         });
         if (resp.ok) {
           const r = await resp.json();
+          const price = r.price ? '<br>Price: ' + r.price.toFixed(2) + ' ' + esc(r.currency) + ' excl. VAT.' : '';
           fb.className = 'alert alert-success';
           fb.innerHTML =
             '<strong>Order <span class="tag">#' + r.order_number + '</span> confirmed.</strong><br>' +
-            'Thank you, ' + esc(r.name) + '. A confirmation will be sent to ' + esc(r.email) + '.';
+            'Thank you, ' + esc(r.name) + '. A confirmation will be sent to ' + esc(r.email) + '.' + price;
           document.getElementById('orderForm').reset();
           clearErrors();
+          showPrice();
         } else {
           const msg = await resp.text();
           fb.className = 'alert alert-error';
@@ -510,6 +808,10 @@ const orderPage = `<!DOCTYPE html>
     });
 
     // ── Client-side validation ──────────────────────────────
+    function fieldIds() {
+      return ['name', 'email'].concat(params.map(function(p) { return p.name; }));
+    }
+
     function validate() {
       let ok = true;
       function set(id, msg) {
@@ -521,33 +823,36 @@ const orderPage = `<!DOCTYPE html>
       }
       const name  = document.getElementById('name').value.trim();
       const email = document.getElementById('email').value.trim();
-      const h     = parseFloat(document.getElementById('height').value);
-      const d     = parseFloat(document.getElementById('depth').value);
 
       set('name',   name ? '' : 'Name is required.');
       set('email',  /^[^\s@]+@[^\s@]+\.[^\s@]+$/.test(email) ? '' : 'A valid email address is required.');
-      set('height', !isNaN(h) && h > 0 && h <= 21 ? '' : 'Height must be between 0 and 21 mm.');
-      set('depth',  !isNaN(d) && d >= 0 && d <= h  ? '' : 'Depth must be \u2265 0 mm and not exceed height.');
+      const v = values();
+      params.forEach(function(p) { set(p.name, check(p, v)); });
       return ok;
     }
 
-    // Clear field error on input.
-    ['name','email','height','depth'].forEach(function(id) {
+    // Clear field error on input, and requote.
+    fieldIds().forEach(function(id) {
       document.getElementById(id).addEventListener('input', function() {
         document.getElementById('err-' + id).textContent = '';
         this.classList.remove('invalid');
+        showPrice();
       });
     });
 
-    // Tighten depth max when height changes.
-    document.getElementById('height').addEventListener('input', function() {
-      const h = parseFloat(this.value);
-      const dep = document.getElementById('depth');
-      if (!isNaN(h)) dep.max = h;
+    // Keep bounds set by other parameters in step with them.
+    params.forEach(function(p) {
+      [['minParameter', 'min'], ['maxParameter', 'max']].forEach(function(b) {
+        if (!p[b[0]]) return;
+        document.getElementById(p[b[0]]).addEventListener('input', function() {
+          const x = parseFloat(this.value);
+          if (!isNaN(x)) document.getElementById(p.name)[b[1]] = x;
+        });
+      });
     });
 
     function clearErrors() {
-      ['name','email','height','depth'].forEach(function(id) {
+      fieldIds().forEach(function(id) {
         document.getElementById('err-' + id).textContent = '';
         document.getElementById(id).classList.remove('invalid');
       });
@@ -556,12 +861,15 @@ const orderPage = `<!DOCTYPE html>
     function resetForm() {
       document.getElementById('orderForm').reset();
       clearErrors();
+      showPrice();
       document.getElementById('peppol').value = '';
       const fb = document.getElementById('feedback');
       fb.className = 'hidden';
       fb.textContent = '';
     }
 
+    showPrice();
+
     // ── Look up order ───────────────────────────────────────
     async function lookupOrder() {
       const id    = document.getElementById('lookupId').value.trim();
@@ -581,16 +889,25 @@ const orderPage = `<!DOCTYPE html>
           const completed = o.completed_timestamp && !o.completed_timestamp.startsWith('0001')
             ? fmtDate(o.completed_timestamp) : '\u2014';
           const peppolRow = o.peppol_id ? row('Peppol ID', esc(o.peppol_id)) : '';
+          const priceRow  = o.price ? row('Price', o.price.toFixed(2) + ' ' + esc(o.currency) + ' excl. VAT') : '';
+          const known = o.product_id === product.id;
+          const paramRows = Object.keys(o.parameters || {}).map(function(name) {
+            const p = known ? params.find(function(p) { return p.name === name; }) : null;
+            const unit = p && p.unit ? ' ' + esc(p.unit) : '';
+            return row(p ? esc(p.label) : esc(name), esc(o.parameters[name]) + unit);
+          }).join('');
           out.className = 'alert alert-success';
           out.innerHTML =
             '<dl class="detail">' +
             row('Order',           '<span class="tag">#' + o.order_number + '</span>') +
             row('Name',            esc(o.name)) +
             row('Email',           esc(o.email)) +
-            row('Dimensions',      o.height + ' \u00d7 ' + o.depth + ' mm') +
-            row('Roughness',       'Ra max. ' + o.roughness + ' \u00b5m') +
+            row('Product',         esc(known ? product.name : o.product_id)) +
+            paramRows +
+            priceRow +
             row('Production line', esc(o.production_line) || '\u2014') +
             peppolRow +
+            row('Status',          esc(o.status) || '\u2014') +
             row('Ordered',         fmtDate(o.timestamp)) +
             row('Completed',       completed) +
             '</dl>';
@@ -608,7 +925,7 @@ const orderPage = `<!DOCTYPE html>
       return '<div><dt>' + label + '</dt><dd>' + value + '</dd></div>';
     }
     function esc(s) {
-      return String(s || '').replace(/[&<>"']/g, function(c) {
+      return String(s === undefined || s === null ? '' : s).replace(/[&<>"']/g, function(c) {
         return {'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;',"'":'&#39;'}[c];
       });
     }
@@ -618,3 +935,18 @@ const orderPage = `<!DOCTYPE html>
   </script>
 </body>
 </html>`
+
+var orderPageTemplate = template.Must(template.New("orders").Funcs(template.FuncMap{
+	"num": func(f *float64) string { return strconv.FormatFloat(*f, 'f', -1, 64) },
+	"note": func(p Parameter) string {
+		switch {
+		case p.Unit != "" && p.Max != nil && p.Type != paramText:
+			return p.Unit + ", max " + strconv.FormatFloat(*p.Max, 'f', -1, 64)
+		case p.Unit != "" && p.MaxParameter != "":
+			return p.Unit + ", ≤ " + p.MaxParameter
+		case p.Optional:
+			return strings.TrimPrefix(p.Unit+", optional", ", ")
+		}
+		return p.Unit
+	},
+}).Parse(pageStyle + orderPageHTML))
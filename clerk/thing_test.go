@@ -28,7 +28,7 @@ import (
 
 // newTestTraits returns a Traits with nil owner (no Orchestrator needed).
 func newTestTraits() *Traits {
-	return &Traits{}
+	return &Traits{accounts: newAccounts(time.Now)}
 }
 
 // TestOrdersHandler_GET_ServesPage verifies that GET without ?id returns the HTML page.
@@ -132,16 +132,21 @@ func TestPenHolderOrder_v1_FormVersion(t *testing.T) {
 	}
 }
 
-// TestOrderPage_ContainsFormElements verifies the embedded HTML has the key form fields.
+// TestOrderPage_ContainsFormElements verifies the rendered page has the key
+// form fields, those of the pen holder's parameters included.
 func TestOrderPage_ContainsFormElements(t *testing.T) {
+	w := httptest.NewRecorder()
+	newTestTraits().orderPage(w, "")
+	page := w.Body.String()
 	for _, want := range []string{
 		`id="name"`, `id="email"`, `id="height"`, `id="depth"`,
 		`id="roughness"`, `id="line"`, `id="peppol"`,
 		`id="lookupId"`, `id="lookupEmail"`,
+		`max="21"`, `<option value="63" selected>`,
 		`Place Order`, `Look Up Order`,
 	} {
-		if !strings.Contains(orderPage, want) {
-			t.Errorf("orderPage missing expected element: %q", want)
+		if !strings.Contains(page, want) {
+			t.Errorf("order page missing expected element: %q", want)
 		}
 	}
 }
@@ -0,0 +1,99 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// -------------------------------------Define a data point
+
+// point is a single reading ready to be stored, independent of the backend.
+// Fields hold float64 or bool values.
+type point struct {
+	Measurement string            `json:"measurement"`
+	Tags        map[string]string `json:"tags"`
+	Fields      map[string]any    `json:"fields"`
+	Time        time.Time         `json:"time"`
+}
+
+// floatField returns a field value as a number, with booleans mapped to 0 and 1
+// for the backends that only store numbers.
+func floatField(v any) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// -------------------------------------Define the storage backend
+
+// backend is the time-series database the collector writes into.
+type backend interface {
+	// write stores the points, returning an error if they could not be stored.
+	write(ctx context.Context, points []point) error
+	// measurements lists the measurement names present in the store.
+	measurements(ctx context.Context) ([]string, error)
+	// query returns the stored readings selected by a historical query.
+	query(ctx context.Context, q historyQuery) ([]series, error)
+	// close flushes and releases the connection to the store.
+	close() error
+}
+
+// Names of the supported backends, as used in the traits
+const (
+	backendInfluxDB    = "influxdb"
+	backendLocal       = "local"
+	backendPrometheus  = "prometheus"
+	backendTimescaleDB = "timescaledb"
+)
+
+// newBackend opens the backend selected in the traits (InfluxDB by default).
+func newBackend(t *Traits) (backend, error) {
+	switch t.Backend {
+	case "", backendInfluxDB:
+		if t.FluxURL == "" || t.Token == "" || t.Org == "" || t.Bucket == "" {
+			return nil, fmt.Errorf("invalid InfluxDB configuration: missing required parameters")
+		}
+		return newInfluxBackend(t.FluxURL, t.Token, t.Org, t.Bucket), nil
+	case backendLocal:
+		if t.LocalPath == "" {
+			return nil, fmt.Errorf("invalid local store configuration: missing localPath")
+		}
+		return newLocalBackend(t.LocalPath)
+	case backendPrometheus:
+		if t.PromURL == "" {
+			return nil, fmt.Errorf("invalid Prometheus configuration: missing promURL")
+		}
+		return newPromBackend(t.PromURL), nil
+	case backendTimescaleDB:
+		if t.PgConn == "" {
+			return nil, fmt.Errorf("invalid TimescaleDB configuration: missing pgConn")
+		}
+		return newTimescaleBackend(t.PgConn)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", t.Backend)
+	}
+}
@@ -0,0 +1,212 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// testPoints returns two readings of the same measurement from two sources.
+func testPoints() []point {
+	ts := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	return []point{
+		{"temperature", map[string]string{"source": "ds1", "FunctionalLocation": "Kitchen"}, map[string]any{"value": 21.5}, ts},
+		{"temperature", map[string]string{"source": "ds2"}, map[string]any{"value": 19.0}, ts.Add(time.Second)},
+	}
+}
+
+// ── backend selection ─────────────────────────────────────────────────────────
+
+func TestNewBackend(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		traits  Traits
+		wantErr bool
+	}{
+		{"influxdb is the default", Traits{FluxURL: "http://localhost:8086", Token: "tok", Org: "o", Bucket: "b"}, false},
+		{"influxdb without token", Traits{Backend: "influxdb", FluxURL: "http://localhost:8086", Org: "o", Bucket: "b"}, true},
+		{"local store", Traits{Backend: "local", LocalPath: filepath.Join(dir, "test.db")}, false},
+		{"local store without path", Traits{Backend: "local"}, true},
+		{"prometheus", Traits{Backend: "prometheus", PromURL: "http://localhost:9090"}, false},
+		{"prometheus without url", Traits{Backend: "prometheus"}, true},
+		{"timescaledb without connection", Traits{Backend: "timescaledb"}, true},
+		{"unknown backend", Traits{Backend: "mongodb"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := newBackend(&tc.traits)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tc.wantErr)
+			}
+			if store != nil {
+				store.close()
+			}
+		})
+	}
+}
+
+// ── embedded local store ──────────────────────────────────────────────────────
+
+func TestLocalBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collector.db")
+	store, err := newLocalBackend(path)
+	if err != nil {
+		t.Fatalf("newLocalBackend: %v", err)
+	}
+	ctx := context.Background()
+	pts := append(testPoints(), point{"on_off", map[string]string{"source": "plug"}, map[string]any{"value": true}, time.Now()})
+	if err := store.write(ctx, pts); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	store.close()
+
+	// The readings survive reopening the file
+	store, err = newLocalBackend(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.close()
+	names, err := store.measurements(ctx)
+	if err != nil {
+		t.Fatalf("measurements: %v", err)
+	}
+	if strings.Join(names, ",") != "on_off,temperature" {
+		t.Errorf("measurements = %v, want [on_off temperature]", names)
+	}
+
+	var count int
+	var sum float64
+	row := store.db.QueryRow(`SELECT COUNT(*), SUM(value) FROM readings WHERE json_extract(tags, '$.source') = 'ds1'`)
+	if err := row.Scan(&count, &sum); err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if count != 1 || sum != 21.5 {
+		t.Errorf("ds1 readings = %d (sum %v), want 1 (sum 21.5)", count, sum)
+	}
+}
+
+// ── InfluxDB ──────────────────────────────────────────────────────────────────
+
+func TestInfluxBackendWrite(t *testing.T) {
+	var body string
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	store := newInfluxBackend(srv.URL, "tok", "org", "bucket")
+	defer store.close()
+	if err := store.write(context.Background(), testPoints()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if !strings.Contains(body, "temperature,FunctionalLocation=Kitchen,source=ds1 value=21.5") {
+		t.Errorf("unexpected line protocol:\n%s", body)
+	}
+
+	// Write failures are reported to the caller
+	status = http.StatusServiceUnavailable
+	if err := store.write(context.Background(), testPoints()); err == nil {
+		t.Error("expected an error when the database is unavailable")
+	}
+}
+
+// ── Prometheus remote write ───────────────────────────────────────────────────
+
+func TestPromBackendWrite(t *testing.T) {
+	var payload []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/write" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("Content-Encoding = %q, want snappy", r.Header.Get("Content-Encoding"))
+		}
+		compressed, _ := io.ReadAll(r.Body)
+		var err error
+		if payload, err = snappy.Decode(nil, compressed); err != nil {
+			t.Errorf("snappy: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	store := newPromBackend(srv.URL + "/")
+	defer store.close()
+	if err := store.write(context.Background(), testPoints()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// The first time series of the WriteRequest, with its labels sorted by name
+	series := encodeTimeSeries(map[string]string{
+		"__name__": "temperature", "source": "ds1", "FunctionalLocation": "Kitchen",
+	}, 21.5, testPoints()[0].Time.UnixMilli())
+	want := appendMessage(nil, 1, series)
+	if !bytes.HasPrefix(payload, want) {
+		t.Errorf("unexpected WriteRequest %x", payload)
+	}
+	if !bytes.Contains(payload, []byte("FunctionalLocation")) || !bytes.Contains(payload, []byte("ds2")) {
+		t.Error("expected labels of both points in the WriteRequest")
+	}
+}
+
+func TestPromBackendMeasurements(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/label/__name__/values" {
+			http.Error(w, `{"status":"error","error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":["humidity","temperature"]}`))
+	}))
+	defer srv.Close()
+
+	names, err := newPromBackend(srv.URL).measurements(context.Background())
+	if err != nil {
+		t.Fatalf("measurements: %v", err)
+	}
+	if strings.Join(names, ",") != "humidity,temperature" {
+		t.Errorf("measurements = %v", names)
+	}
+}
+
+func TestPromName(t *testing.T) {
+	for in, want := range map[string]string{
+		"temperature":        "temperature",
+		"FunctionalLocation": "FunctionalLocation",
+		"9lives":             "_lives",
+		"flow-rate.m3/h":     "flow_rate_m3_h",
+	} {
+		if got := promName(in); got != want {
+			t.Errorf("promName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
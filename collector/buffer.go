@@ -0,0 +1,382 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store-and-forward tuning
+const (
+	replayPeriod   = 10 * time.Second // how often the database is retried while points are buffered
+	replayBatch    = 500              // points forwarded per write while replaying
+	maxSegmentSize = 1 << 20          // largest segment file in bytes
+	offsetFile     = "offset"         // how far the oldest segment has been forwarded
+)
+
+// segment is one append-only file of the buffer, holding one JSON encoded point per line.
+type segment struct {
+	path   string
+	size   int64
+	count  int
+	newest time.Time
+}
+
+// forwarder is a backend that stores points on disk while the database behind
+// it is unreachable and replays them, in order and with their original
+// timestamps, once the database is back. The buffer is bounded by size and by
+// age; the oldest points are dropped first when a bound is exceeded.
+type forwarder struct {
+	inner    backend
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu       sync.Mutex
+	segments []*segment // oldest first
+	active   *os.File   // file of the last segment while it is appended to
+	offset   int        // points of the oldest segment already forwarded, saved in the offset file
+	depth    int        // points waiting in the buffer
+	dropped  int        // points dropped because of the size or age bound
+}
+
+// newForwarder opens the buffer directory, picking up the points a previous run
+// could not forward from where its replay stopped.
+func newForwarder(inner backend, dir string, maxBytes int64, maxAge time.Duration) (*forwarder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating buffer directory: %w", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths) // segment names are zero padded creation times
+	f := &forwarder{inner: inner, dir: dir, maxBytes: maxBytes, maxAge: maxAge}
+	for _, path := range paths {
+		seg, err := scanSegment(path)
+		if err != nil {
+			return nil, err
+		}
+		if seg.count == 0 {
+			os.Remove(path)
+			continue
+		}
+		f.segments = append(f.segments, seg)
+		f.depth += seg.count
+	}
+	f.loadOffset()
+	if f.depth > 0 {
+		log.Printf("store-and-forward buffer holds %d point(s) from a previous run\n", f.depth)
+	}
+	return f, nil
+}
+
+// scanSegment counts the points of an existing segment file.
+func scanSegment(path string) (*segment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{path: path, size: int64(len(data))}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var p point
+		if json.Unmarshal(line, &p) != nil {
+			continue // empty or torn line
+		}
+		seg.count++
+		if p.Time.After(seg.newest) {
+			seg.newest = p.Time
+		}
+	}
+	return seg, nil
+}
+
+// loadOffset takes up the replay of the oldest segment where the previous run
+// left it. The offset file names the segment, so that an offset outlived by
+// its segment is ignored.
+func (f *forwarder) loadOffset() {
+	data, err := os.ReadFile(filepath.Join(f.dir, offsetFile))
+	if err != nil || len(f.segments) == 0 {
+		return
+	}
+	var name string
+	var n int
+	if _, err := fmt.Sscan(string(data), &name, &n); err != nil || name != filepath.Base(f.segments[0].path) {
+		return
+	}
+	if n > 0 && n <= f.segments[0].count {
+		f.offset = n
+		f.depth -= n
+	}
+}
+
+// saveOffset records how far the oldest segment has been forwarded, through a
+// temporary file. A crash between a write and its offset replays that batch
+// again. The caller must hold the lock.
+func (f *forwarder) saveOffset() {
+	path := filepath.Join(f.dir, offsetFile)
+	if f.offset == 0 || len(f.segments) == 0 {
+		os.Remove(path)
+		return
+	}
+	data := fmt.Sprintf("%s %d\n", filepath.Base(f.segments[0].path), f.offset)
+	err := os.WriteFile(path+".tmp", []byte(data), 0o644)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		log.Printf("unable to save the store-and-forward offset: %v\n", err)
+	}
+}
+
+// write forwards the points directly while the buffer is empty. Otherwise, or if
+// the database refuses them, the points are appended to the buffer so that they
+// are stored after the ones already waiting. The lock is not held while the
+// database is written, so a slow database does not hold up the other writers.
+func (f *forwarder) write(ctx context.Context, points []point) error {
+	f.mu.Lock()
+	direct := f.depth == 0
+	f.mu.Unlock()
+	if direct {
+		err := f.inner.write(ctx, points)
+		if err == nil {
+			return nil
+		}
+		log.Printf("database unreachable, buffering readings: %v\n", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.appendPoints(points)
+}
+
+// appendPoints adds points to the active segment and enforces the size bound.
+// The caller must hold the lock.
+func (f *forwarder) appendPoints(points []point) error {
+	var buf bytes.Buffer
+	newest := time.Time{}
+	for _, p := range points {
+		line, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		if p.Time.After(newest) {
+			newest = p.Time
+		}
+	}
+
+	segSize := min(int64(maxSegmentSize), f.maxBytes/4)
+	if f.active != nil && f.segments[len(f.segments)-1].size+int64(buf.Len()) > segSize {
+		f.rotate()
+	}
+	if f.active == nil {
+		path := filepath.Join(f.dir, fmt.Sprintf("%020d.jsonl", time.Now().UnixNano()))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("creating buffer segment: %w", err)
+		}
+		f.active = file
+		f.segments = append(f.segments, &segment{path: path})
+	}
+	if _, err := f.active.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("writing buffer segment: %w", err)
+	}
+	seg := f.segments[len(f.segments)-1]
+	seg.size += int64(buf.Len())
+	seg.count += len(points)
+	if newest.After(seg.newest) {
+		seg.newest = newest
+	}
+	f.depth += len(points)
+
+	// Drop the oldest segments to stay within the size bound
+	for f.size() > f.maxBytes && len(f.segments) > 1 {
+		f.dropOldest("size")
+	}
+	return nil
+}
+
+// rotate closes the active segment so that the next points start a new one.
+func (f *forwarder) rotate() {
+	if f.active != nil {
+		f.active.Close()
+		f.active = nil
+	}
+}
+
+// size returns the number of bytes held in the buffer.
+func (f *forwarder) size() (total int64) {
+	for _, seg := range f.segments {
+		total += seg.size
+	}
+	return total
+}
+
+// dropOldest discards the oldest segment and counts its remaining points as dropped.
+func (f *forwarder) dropOldest(reason string) {
+	seg := f.segments[0]
+	if len(f.segments) == 1 {
+		f.rotate()
+	}
+	lost := seg.count - f.offset
+	f.dropped += lost
+	f.depth -= lost
+	f.offset = 0
+	f.segments = f.segments[1:]
+	os.Remove(seg.path)
+	f.saveOffset()
+	log.Printf("store-and-forward buffer dropped %d point(s) (%s bound exceeded)\n", lost, reason)
+}
+
+// run retries the database periodically until the context is cancelled.
+func (f *forwarder) run(ctx context.Context) {
+	ticker := time.NewTicker(replayPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.replay(ctx); err != nil {
+				log.Printf("database still unreachable, %d point(s) buffered: %v\n", f.stats().depth, err)
+			}
+		}
+	}
+}
+
+// replay forwards the buffered points, oldest first, until the buffer is empty
+// or the database fails again.
+func (f *forwarder) replay(ctx context.Context) error {
+	replayed := 0
+	for {
+		f.mu.Lock()
+		f.expire()
+		if f.depth == 0 {
+			f.mu.Unlock()
+			if replayed > 0 {
+				log.Printf("database reachable again, replayed %d buffered point(s)\n", replayed)
+			}
+			return nil
+		}
+		if len(f.segments) == 1 {
+			f.rotate() // new points go to a fresh segment while this one is replayed
+		}
+		seg := f.segments[0]
+		offset := f.offset
+		f.mu.Unlock()
+
+		// Only the last segment is ever appended to, so the oldest one can be read without the lock.
+		batch, lines, err := readSegment(seg.path, offset, replayBatch)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			lines = seg.count - offset // nothing left to read, the segment is done
+		} else if err := f.inner.write(ctx, batch); err != nil {
+			return err
+		}
+		replayed += len(batch)
+
+		f.mu.Lock()
+		// The segment may have been dropped by the size bound in the meantime
+		if len(f.segments) > 0 && f.segments[0] == seg {
+			f.offset += lines
+			f.depth -= lines
+			if f.offset >= seg.count {
+				f.segments = f.segments[1:]
+				f.offset = 0
+				os.Remove(seg.path)
+			}
+			f.saveOffset()
+		}
+		f.mu.Unlock()
+	}
+}
+
+// expire drops the segments whose newest point is older than the age bound.
+// The caller must hold the lock.
+func (f *forwarder) expire() {
+	cutoff := time.Now().Add(-f.maxAge)
+	for len(f.segments) > 0 && f.segments[0].newest.Before(cutoff) {
+		f.dropOldest("age")
+	}
+}
+
+// readSegment returns up to max points of a segment file, skipping the first
+// offset ones, and the number of lines consumed (torn lines are skipped).
+func readSegment(path string, offset, max int) (points []point, lines int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxSegmentSize)
+	n := 0
+	for scanner.Scan() && len(points) < max {
+		var p point
+		if json.Unmarshal(scanner.Bytes(), &p) != nil {
+			continue
+		}
+		n++
+		if n <= offset {
+			continue
+		}
+		points = append(points, p)
+		lines++
+	}
+	return points, lines, scanner.Err()
+}
+
+// bufferStats is a snapshot of the buffer's health.
+type bufferStats struct {
+	depth   int
+	dropped int
+}
+
+func (f *forwarder) stats() bufferStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return bufferStats{depth: f.depth, dropped: f.dropped}
+}
+
+func (f *forwarder) measurements(ctx context.Context) ([]string, error) {
+	return f.inner.measurements(ctx)
+}
+
+// query only sees the points that already reached the database.
+func (f *forwarder) query(ctx context.Context, q historyQuery) ([]series, error) {
+	return f.inner.query(ctx, q)
+}
+
+// close keeps the buffered points on disk for the next run.
+func (f *forwarder) close() error {
+	f.mu.Lock()
+	f.rotate()
+	f.mu.Unlock()
+	return f.inner.close()
+}
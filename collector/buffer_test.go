@@ -0,0 +1,349 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+// flakyStore is a mockStore that refuses writes while down is set.
+type flakyStore struct {
+	mockStore
+	down bool
+}
+
+func (f *flakyStore) write(ctx context.Context, points []point) error {
+	if f.down {
+		return errors.New("connection refused")
+	}
+	return f.mockStore.write(ctx, points)
+}
+
+// numberedPoints returns n readings one second apart, starting at start, whose
+// values count from first.
+func numberedPoints(n int, first float64, start time.Time) []point {
+	pts := make([]point, n)
+	for i := range pts {
+		pts[i] = point{
+			Measurement: "temperature",
+			Tags:        map[string]string{"source": "ds1"},
+			Fields:      map[string]any{"value": first + float64(i)},
+			Time:        start.Add(time.Duration(i) * time.Second),
+		}
+	}
+	return pts
+}
+
+// ── buffering and in-order replay ─────────────────────────────────────────────
+
+func TestForwarderBuffersAndReplays(t *testing.T) {
+	ctx := context.Background()
+	db := &flakyStore{down: true}
+	fwd, err := newForwarder(db, t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatalf("newForwarder: %v", err)
+	}
+	defer fwd.close()
+
+	start := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	for _, p := range numberedPoints(3, 0, start) {
+		if err := fwd.write(ctx, []point{p}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if got := fwd.stats().depth; got != 3 {
+		t.Fatalf("depth = %d, want 3", got)
+	}
+	if err := fwd.replay(ctx); err == nil {
+		t.Error("expected replay to fail while the database is down")
+	}
+
+	// Once the database is back, new readings still queue behind the buffered ones
+	db.down = false
+	if err := fwd.write(ctx, numberedPoints(1, 3, start.Add(3*time.Second))); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if len(db.captured()) != 0 {
+		t.Fatal("expected no direct write while readings are buffered")
+	}
+	if err := fwd.replay(ctx); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+
+	got := db.captured()
+	if len(got) != 4 {
+		t.Fatalf("replayed %d points, want 4", len(got))
+	}
+	for i, p := range got {
+		if p.Fields["value"] != float64(i) {
+			t.Errorf("point %d has value %v, out of order", i, p.Fields["value"])
+		}
+		if want := start.Add(time.Duration(i) * time.Second); !p.Time.Equal(want) {
+			t.Errorf("point %d time = %v, want original %v", i, p.Time, want)
+		}
+		if p.Tags["source"] != "ds1" {
+			t.Errorf("point %d lost its tags: %v", i, p.Tags)
+		}
+	}
+	if s := fwd.stats(); s.depth != 0 || s.dropped != 0 {
+		t.Errorf("stats = %+v, want empty buffer and no drops", s)
+	}
+
+	// With an empty buffer, readings go straight to the database again
+	fwd.write(ctx, numberedPoints(1, 4, time.Now()))
+	if len(db.captured()) != 5 {
+		t.Errorf("expected a direct write, got %d points", len(db.captured()))
+	}
+}
+
+// ── persistence ───────────────────────────────────────────────────────────────
+
+func TestForwarderSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fwd, err := newForwarder(&flakyStore{down: true}, dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatalf("newForwarder: %v", err)
+	}
+	fwd.write(ctx, numberedPoints(5, 0, time.Now()))
+	fwd.close()
+
+	db := &flakyStore{}
+	fwd, err = newForwarder(db, dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer fwd.close()
+	if got := fwd.stats().depth; got != 5 {
+		t.Fatalf("depth after restart = %d, want 5", got)
+	}
+	if err := fwd.replay(ctx); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if got := len(db.captured()); got != 5 {
+		t.Errorf("replayed %d points, want 5", got)
+	}
+}
+
+// partialStore accepts the first `accept` writes and refuses the others.
+type partialStore struct {
+	mockStore
+	accept int
+}
+
+func (s *partialStore) write(ctx context.Context, points []point) error {
+	s.mu.Lock()
+	if s.accept == 0 {
+		s.mu.Unlock()
+		return errors.New("connection reset")
+	}
+	s.accept--
+	s.mu.Unlock()
+	return s.mockStore.write(ctx, points)
+}
+
+func TestForwarderResumesReplayAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fwd, err := newForwarder(&flakyStore{down: true}, dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatalf("newForwarder: %v", err)
+	}
+	fwd.write(ctx, numberedPoints(replayBatch+200, 0, time.Now()))
+
+	// The database takes the first batch and fails again
+	fwd.inner = &partialStore{accept: 1}
+	if err := fwd.replay(ctx); err == nil {
+		t.Fatal("expected replay to fail on the second batch")
+	}
+	fwd.close()
+
+	db := &flakyStore{}
+	fwd, err = newForwarder(db, dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer fwd.close()
+	if got := fwd.stats().depth; got != 200 {
+		t.Fatalf("depth after restart = %d, want 200", got)
+	}
+	if err := fwd.replay(ctx); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	got := db.captured()
+	if len(got) != 200 || got[0].Fields["value"] != float64(replayBatch) {
+		t.Fatalf("replayed %d points from %v, want 200 from %d", len(got), got[0].Fields["value"], replayBatch)
+	}
+	if _, err := os.Stat(filepath.Join(dir, offsetFile)); !os.IsNotExist(err) {
+		t.Errorf("offset file left behind an empty buffer: %v", err)
+	}
+}
+
+// blockingStore holds every write until released.
+type blockingStore struct {
+	mockStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) write(ctx context.Context, points []point) error {
+	s.entered <- struct{}{}
+	<-s.release
+	return s.mockStore.write(ctx, points)
+}
+
+func TestForwarderWriteDoesNotHoldLock(t *testing.T) {
+	db := &blockingStore{entered: make(chan struct{}), release: make(chan struct{})}
+	fwd, err := newForwarder(db, t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatalf("newForwarder: %v", err)
+	}
+	defer fwd.close()
+
+	done := make(chan error)
+	go func() { done <- fwd.write(context.Background(), numberedPoints(1, 0, time.Now())) }()
+	<-db.entered
+
+	// The health services answer while the database is slow
+	stats := make(chan bufferStats)
+	go func() { stats <- fwd.stats() }()
+	select {
+	case s := <-stats:
+		if s.depth != 0 {
+			t.Errorf("depth = %d during a direct write, want 0", s.depth)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stats blocked behind the database write")
+	}
+	close(db.release)
+	if err := <-done; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// ── bounds ────────────────────────────────────────────────────────────────────
+
+func TestForwarderSizeBound(t *testing.T) {
+	ctx := context.Background()
+	db := &flakyStore{down: true}
+	fwd, err := newForwarder(db, t.TempDir(), 4096, time.Hour)
+	if err != nil {
+		t.Fatalf("newForwarder: %v", err)
+	}
+	defer fwd.close()
+
+	for i := range 200 {
+		fwd.write(ctx, numberedPoints(1, float64(i), time.Now()))
+	}
+	s := fwd.stats()
+	if s.dropped == 0 {
+		t.Fatal("expected readings to be dropped by the size bound")
+	}
+	if s.depth+s.dropped != 200 {
+		t.Errorf("depth %d + dropped %d != 200", s.depth, s.dropped)
+	}
+	if size := fwd.size(); size > 4096 {
+		t.Errorf("buffer holds %d bytes, bound is 4096", size)
+	}
+
+	// The oldest readings are the ones that were dropped
+	db.down = false
+	if err := fwd.replay(ctx); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	got := db.captured()
+	if len(got) != s.depth {
+		t.Fatalf("replayed %d points, want %d", len(got), s.depth)
+	}
+	if last := got[len(got)-1].Fields["value"]; last != 199.0 {
+		t.Errorf("last replayed value = %v, want 199", last)
+	}
+}
+
+func TestForwarderAgeBound(t *testing.T) {
+	ctx := context.Background()
+	db := &flakyStore{down: true}
+	fwd, err := newForwarder(db, t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatalf("newForwarder: %v", err)
+	}
+	defer fwd.close()
+
+	fwd.write(ctx, numberedPoints(3, 0, time.Now().Add(-2*time.Hour)))
+	db.down = false
+	if err := fwd.replay(ctx); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(db.captured()) != 0 {
+		t.Errorf("expected expired readings not to be replayed, got %d", len(db.captured()))
+	}
+	if s := fwd.stats(); s.depth != 0 || s.dropped != 3 {
+		t.Errorf("stats = %+v, want depth 0 and 3 dropped", s)
+	}
+}
+
+// ── health services ───────────────────────────────────────────────────────────
+
+func TestBufferHealthServices(t *testing.T) {
+	fwd, err := newForwarder(&flakyStore{down: true}, t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatalf("newForwarder: %v", err)
+	}
+	defer fwd.close()
+	fwd.write(context.Background(), numberedPoints(7, 0, time.Now()))
+
+	for _, tc := range []struct {
+		traits *Traits
+		path   string
+		want   float64
+	}{
+		{&Traits{buffer: fwd}, "buffered", 7},
+		{&Traits{buffer: fwd}, "dropped", 0},
+		{&Traits{}, "buffered", 0}, // buffering disabled
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/collector/demo/"+tc.path, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		serving(tc.traits, w, req, tc.path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", tc.path, w.Code)
+		}
+		var f forms.SignalA_v1a
+		if err := json.Unmarshal(w.Body.Bytes(), &f); err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		if f.Value != tc.want || f.Unit != "points" {
+			t.Errorf("%s = %v %s, want %v points", tc.path, f.Value, f.Unit, tc.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/collector/demo/buffered", nil)
+	w := httptest.NewRecorder()
+	serving(&Traits{}, w, req, "buffered")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("PUT status = %d, want 404", w.Code)
+	}
+}
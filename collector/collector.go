@@ -41,7 +41,7 @@ func main() {
 
 	// Instantiate the husk
 	sys.Husk = &components.Husk{
-		Description: " is a system that ingests time signals into an Influx database",
+		Description: " is a system that ingests time signals into a time-series database",
 		Details:     map[string][]string{"Developer": {"Synecdoque"}},
 		Host:        components.NewDevice(),
 		ProtoPort:   map[string]int{"https": 0, "http": 20180, "coap": 0},
@@ -98,6 +98,10 @@ func serving(t *Traits, w http.ResponseWriter, r *http.Request, servicePath stri
 	switch servicePath {
 	case "mquery":
 		t.measQuery(w, r)
+	case "buffered", "dropped":
+		t.bufferHealth(w, r, servicePath)
+	case "history":
+		t.history(w, r)
 	default:
 		http.Error(w, "Invalid service request [Do not modify the services subpath in the configuration file]", http.StatusBadRequest)
 	}
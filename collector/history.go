@@ -0,0 +1,317 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+//-------------------------------------Historical query
+
+// historyQuery selects stored readings of one measurement.
+type historyQuery struct {
+	Measurement string
+	Tags        map[string]string // tags the readings must carry (e.g. source, FunctionalLocation)
+	Start       time.Time
+	Stop        time.Time
+	Aggregate   string        // "" for the raw readings, or "mean", "min", "max" or "last"
+	Window      time.Duration // aggregation window; the whole time range if zero
+}
+
+// series is the readings of one field of one tagged stream, oldest first.
+type series struct {
+	Tags    map[string]string
+	Field   string
+	Samples []sample
+}
+
+type sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// The supported aggregations
+var aggregates = map[string]bool{"mean": true, "min": true, "max": true, "last": true}
+
+// Query parameters that are not tag filters
+var historyParams = map[string]bool{"measurement": true, "start": true, "stop": true, "aggregate": true, "window": true}
+
+// Tags the history can be filtered on
+var historyTags = map[string]bool{"source": true, "FunctionalLocation": true}
+
+// parseHistoryQuery reads a query from the URL parameters:
+//
+//	?measurement=temperature&FunctionalLocation=Kitchen&start=-24h&stop=now&aggregate=mean&window=1h
+//
+// start and stop are RFC 3339 times or durations relative to now (default the
+// last 24 hours); source and FunctionalLocation are tag filters, and any other
+// parameter is refused.
+func parseHistoryQuery(params url.Values, now time.Time) (q historyQuery, err error) {
+	q.Measurement = params.Get("measurement")
+	if q.Measurement == "" {
+		return q, fmt.Errorf("missing measurement")
+	}
+	if q.Start, err = parseTime(params.Get("start"), now.Add(-24*time.Hour), now); err != nil {
+		return q, fmt.Errorf("bad start: %w", err)
+	}
+	if q.Stop, err = parseTime(params.Get("stop"), now, now); err != nil {
+		return q, fmt.Errorf("bad stop: %w", err)
+	}
+	if !q.Start.Before(q.Stop) {
+		return q, fmt.Errorf("start must be before stop")
+	}
+	q.Aggregate = params.Get("aggregate")
+	if q.Aggregate != "" && !aggregates[q.Aggregate] {
+		return q, fmt.Errorf("unknown aggregate %q (mean, min, max or last)", q.Aggregate)
+	}
+	if w := params.Get("window"); w != "" {
+		if q.Window, err = time.ParseDuration(w); err != nil || q.Window <= 0 {
+			return q, fmt.Errorf("bad window %q", w)
+		}
+	}
+	q.Tags = make(map[string]string)
+	for key, values := range params {
+		switch {
+		case historyParams[key] || len(values) == 0:
+		case historyTags[key]:
+			q.Tags[key] = values[0]
+		default:
+			return q, fmt.Errorf("unknown parameter %q (the tag filters are source and FunctionalLocation)", key)
+		}
+	}
+	return q, nil
+}
+
+// parseTime accepts an RFC 3339 time, a duration relative to now (e.g. -24h) or "now".
+func parseTime(s string, def, now time.Time) (time.Time, error) {
+	switch s {
+	case "":
+		return def, nil
+	case "now":
+		return now, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// window returns the aggregation window, the whole time range by default.
+func (q historyQuery) window() time.Duration {
+	if q.Window > 0 {
+		return q.Window
+	}
+	return q.Stop.Sub(q.Start)
+}
+
+// aggregate reduces the samples of a series, oldest first, to one sample per
+// window, stamped with the start of the window. Raw samples are returned as is.
+func (q historyQuery) aggregate(samples []sample) []sample {
+	if q.Aggregate == "" || len(samples) == 0 {
+		return samples
+	}
+	window := q.window()
+	var out []sample
+	var sum float64
+	var count int
+	for i, s := range samples {
+		start := q.Start.Add(s.Time.Sub(q.Start) / window * window)
+		if count == 0 {
+			out = append(out, sample{Time: start, Value: s.Value})
+		}
+		agg := &out[len(out)-1]
+		switch q.Aggregate {
+		case "min":
+			agg.Value = min(agg.Value, s.Value)
+		case "max":
+			agg.Value = max(agg.Value, s.Value)
+		case "last":
+			agg.Value = s.Value
+		case "mean":
+			sum += s.Value
+			agg.Value = sum / float64(count+1)
+		}
+		count++
+		// Close the window before a sample of the next one
+		if i+1 < len(samples) && !samples[i+1].Time.Before(start.Add(window)) {
+			sum, count = 0, 0
+		}
+	}
+	return out
+}
+
+// seriesKey identifies a series by its field and tags.
+func seriesKey(field string, tags map[string]string) string {
+	var sb strings.Builder
+	sb.WriteString(field)
+	for _, k := range sortedKeys(tags) {
+		sb.WriteString("\x00" + k + "=" + tags[k])
+	}
+	return sb.String()
+}
+
+// groupSeries collects samples into series, in the order they were first seen.
+type groupSeries struct {
+	index map[string]int
+	list  []series
+}
+
+func (g *groupSeries) add(field string, tags map[string]string, s sample) {
+	if g.index == nil {
+		g.index = make(map[string]int)
+	}
+	key := seriesKey(field, tags)
+	i, ok := g.index[key]
+	if !ok {
+		i = len(g.list)
+		g.index[key] = i
+		g.list = append(g.list, series{Tags: tags, Field: field})
+	}
+	g.list[i].Samples = append(g.list[i].Samples, s)
+}
+
+//-------------------------------------Time-series form
+
+// TimeSeries_v1 is the exchanged form for stored readings.
+type TimeSeries_v1 struct {
+	Measurement string    `json:"measurement" xml:"measurement"`
+	Start       time.Time `json:"start" xml:"start"`
+	Stop        time.Time `json:"stop" xml:"stop"`
+	Aggregate   string    `json:"aggregate,omitempty" xml:"aggregate,omitempty"`
+	Window      string    `json:"window,omitempty" xml:"window,omitempty"`
+	Series      []SeriesT `json:"series" xml:"series"`
+	Version     string    `json:"version" xml:"version"`
+}
+
+// SeriesT is one tagged stream of a TimeSeries_v1 form.
+type SeriesT struct {
+	Tags   map[string]string `json:"tags" xml:"-"`
+	Field  string            `json:"field" xml:"field"`
+	Points []PointT          `json:"points" xml:"point"`
+}
+
+// PointT is a time stamped value of a series.
+type PointT struct {
+	Time  time.Time `json:"time" xml:"time"`
+	Value float64   `json:"value" xml:"value"`
+}
+
+func (f *TimeSeries_v1) NewForm() forms.Form {
+	f.Version = "TimeSeries_v1"
+	return f
+}
+
+func (f *TimeSeries_v1) FormVersion() string {
+	return f.Version
+}
+
+func init() {
+	forms.FormTypeMap["TimeSeries_v1"] = reflect.TypeOf(TimeSeries_v1{})
+}
+
+// newTimeSeriesForm fills out a time-series form with the result of a query.
+func newTimeSeriesForm(q historyQuery, result []series) (f TimeSeries_v1) {
+	f.NewForm()
+	f.Measurement = q.Measurement
+	f.Start = q.Start
+	f.Stop = q.Stop
+	f.Aggregate = q.Aggregate
+	if q.Aggregate != "" {
+		f.Window = q.window().String()
+	}
+	f.Series = make([]SeriesT, 0, len(result))
+	for _, s := range result {
+		st := SeriesT{Tags: s.Tags, Field: s.Field, Points: make([]PointT, 0, len(s.Samples))}
+		for _, smp := range s.Samples {
+			st.Points = append(st.Points, PointT{Time: smp.Time, Value: smp.Value})
+		}
+		f.Series = append(f.Series, st)
+	}
+	return f
+}
+
+// writeCSV writes a time-series form as CSV, one row per point with a column per tag.
+func writeCSV(w http.ResponseWriter, f TimeSeries_v1) error {
+	tagSet := make(map[string]bool)
+	for _, s := range f.Series {
+		for k := range s.Tags {
+			tagSet[k] = true
+		}
+	}
+	tagKeys := sortedKeys(tagSet)
+
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	cw.Write(append(append([]string{"time", "measurement"}, tagKeys...), "field", "value"))
+	for _, s := range f.Series {
+		for _, p := range s.Points {
+			row := []string{p.Time.UTC().Format(time.RFC3339Nano), f.Measurement}
+			for _, k := range tagKeys {
+				row = append(row, s.Tags[k])
+			}
+			row = append(row, s.Field, strconv.FormatFloat(p.Value, 'g', -1, 64))
+			cw.Write(row)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//-------------------------------------Service handler
+
+// history handles GET requests for stored readings. The response is a
+// TimeSeries_v1 form, or CSV if the client accepts text/csv.
+func (t *Traits) history(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		q, err := parseHistoryQuery(r.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, "Invalid history query: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := t.store.query(r.Context(), q)
+		if err != nil {
+			log.Printf("unable to query %s: %v\n", q.Measurement, err)
+			http.Error(w, "Unable to query the database", http.StatusInternalServerError)
+			return
+		}
+		sort.SliceStable(result, func(i, j int) bool {
+			return seriesKey(result[i].Field, result[i].Tags) < seriesKey(result[j].Field, result[j].Tags)
+		})
+		f := newTimeSeriesForm(q, result)
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			if err := writeCSV(w, f); err != nil {
+				log.Printf("error writing CSV: %v\n", err)
+			}
+			return
+		}
+		usecases.HTTPProcessGetRequest(w, r, &f)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
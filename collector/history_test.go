@@ -0,0 +1,296 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var historyStart = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// historyPoints returns one reading per minute for an hour from each of two
+// kitchen sensors; ds1 counts from 0 and ds2 from 100.
+func historyPoints() (pts []point) {
+	for i := range 60 {
+		ts := historyStart.Add(time.Duration(i) * time.Minute)
+		pts = append(pts,
+			point{"temperature", map[string]string{"source": "ds1", "FunctionalLocation": "Kitchen"}, map[string]any{"value": float64(i)}, ts},
+			point{"temperature", map[string]string{"source": "ds2", "FunctionalLocation": "Kitchen"}, map[string]any{"value": 100 + float64(i)}, ts},
+		)
+	}
+	return pts
+}
+
+// ── query parsing ─────────────────────────────────────────────────────────────
+
+func TestParseHistoryQuery(t *testing.T) {
+	now := historyStart.Add(time.Hour)
+	q, err := parseHistoryQuery(url.Values{
+		"measurement": {"temperature"}, "source": {"ds1"},
+		"start": {"-30m"}, "aggregate": {"max"}, "window": {"10m"},
+	}, now)
+	if err != nil {
+		t.Fatalf("parseHistoryQuery: %v", err)
+	}
+	if !q.Start.Equal(now.Add(-30*time.Minute)) || !q.Stop.Equal(now) {
+		t.Errorf("range = %v..%v", q.Start, q.Stop)
+	}
+	if q.Aggregate != "max" || q.Window != 10*time.Minute {
+		t.Errorf("aggregation = %s per %v", q.Aggregate, q.Window)
+	}
+	if len(q.Tags) != 1 || q.Tags["source"] != "ds1" {
+		t.Errorf("tags = %v, want only source=ds1", q.Tags)
+	}
+
+	for name, params := range map[string]url.Values{
+		"missing measurement": {},
+		"bad start":           {"measurement": {"t"}, "start": {"yesterday"}},
+		"reversed range":      {"measurement": {"t"}, "start": {"now"}, "stop": {"-1h"}},
+		"unknown aggregate":   {"measurement": {"t"}, "aggregate": {"median"}},
+		"bad window":          {"measurement": {"t"}, "aggregate": {"mean"}, "window": {"-5m"}},
+		"unknown tag":         {"measurement": {"t"}, `x"]) |> drop(`: {"1"}},
+	} {
+		if _, err := parseHistoryQuery(params, now); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// ── aggregation ───────────────────────────────────────────────────────────────
+
+func TestAggregate(t *testing.T) {
+	var samples []sample
+	for i, v := range []float64{4, 2, 6, 1, 9} {
+		samples = append(samples, sample{historyStart.Add(time.Duration(i) * 20 * time.Second), v})
+	}
+	q := historyQuery{Start: historyStart, Stop: historyStart.Add(2 * time.Minute), Window: time.Minute}
+	for agg, want := range map[string][]float64{
+		"":     {4, 2, 6, 1, 9},
+		"mean": {4, 5},
+		"min":  {2, 1},
+		"max":  {6, 9},
+		"last": {6, 9},
+	} {
+		q.Aggregate = agg
+		got := q.aggregate(samples)
+		if len(got) != len(want) {
+			t.Errorf("%q: %d samples, want %d", agg, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if got[i].Value != want[i] {
+				t.Errorf("%q: sample %d = %v, want %v", agg, i, got[i].Value, want[i])
+			}
+		}
+		if agg != "" && !got[1].Time.Equal(historyStart.Add(time.Minute)) {
+			t.Errorf("%q: second window stamped %v, want its start", agg, got[1].Time)
+		}
+	}
+}
+
+// ── history service over the local store ──────────────────────────────────────
+
+func newHistoryStore(t *testing.T) *sqlBackend {
+	t.Helper()
+	store, err := newLocalBackend(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("newLocalBackend: %v", err)
+	}
+	t.Cleanup(func() { store.close() })
+	if err := store.write(context.Background(), historyPoints()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return store
+}
+
+func getHistory(t *testing.T, tr *Traits, query, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/collector/demo/history?"+query, nil)
+	req.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	serving(tr, w, req, "history")
+	return w
+}
+
+func TestHistoryServiceJSON(t *testing.T) {
+	tr := &Traits{store: newHistoryStore(t)}
+	rng := "&start=" + historyStart.Format(time.RFC3339) + "&stop=" + historyStart.Add(time.Hour).Format(time.RFC3339)
+
+	w := getHistory(t, tr, "measurement=temperature&source=ds1&aggregate=mean&window=30m"+rng, "application/json")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var f TimeSeries_v1
+	if err := json.Unmarshal(w.Body.Bytes(), &f); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body.String(), err)
+	}
+	if f.Version != "TimeSeries_v1" || f.Window != "30m0s" {
+		t.Errorf("form = %s, window %q", f.Version, f.Window)
+	}
+	if len(f.Series) != 1 || f.Series[0].Tags["source"] != "ds1" || f.Series[0].Field != "value" {
+		t.Fatalf("series = %+v, want the ds1 values only", f.Series)
+	}
+	pts := f.Series[0].Points
+	if len(pts) != 2 || pts[0].Value != 14.5 || pts[1].Value != 44.5 {
+		t.Errorf("half-hour means = %+v, want 14.5 and 44.5", pts)
+	}
+	if !pts[1].Time.Equal(historyStart.Add(30 * time.Minute)) {
+		t.Errorf("second window stamped %v", pts[1].Time)
+	}
+
+	// Raw readings of both sensors of a location
+	w = getHistory(t, tr, "measurement=temperature&FunctionalLocation=Kitchen"+rng, "application/json")
+	f = TimeSeries_v1{}
+	json.Unmarshal(w.Body.Bytes(), &f)
+	if len(f.Series) != 2 || len(f.Series[0].Points) != 60 || len(f.Series[1].Points) != 60 {
+		t.Errorf("expected two series of 60 readings, got %d series", len(f.Series))
+	}
+
+	// No match is an empty list, not an error
+	w = getHistory(t, tr, "measurement=temperature&FunctionalLocation=Garage"+rng, "application/json")
+	f = TimeSeries_v1{}
+	json.Unmarshal(w.Body.Bytes(), &f)
+	if w.Code != http.StatusOK || len(f.Series) != 0 {
+		t.Errorf("status %d with %d series, want 200 and none", w.Code, len(f.Series))
+	}
+}
+
+func TestHistoryServiceCSV(t *testing.T) {
+	tr := &Traits{store: newHistoryStore(t)}
+	w := getHistory(t, tr, "measurement=temperature&aggregate=max&window=1h&start="+historyStart.Format(time.RFC3339)+
+		"&stop="+historyStart.Add(time.Hour).Format(time.RFC3339), "text/csv")
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	want := [][]string{
+		{"time", "measurement", "FunctionalLocation", "source", "field", "value"},
+		{"2025-06-01T12:00:00Z", "temperature", "Kitchen", "ds1", "value", "59"},
+		{"2025-06-01T12:00:00Z", "temperature", "Kitchen", "ds2", "value", "159"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %v", rows)
+	}
+	for i := range want {
+		if strings.Join(rows[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("row %d = %v, want %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestHistoryServiceErrors(t *testing.T) {
+	tr := &Traits{store: &mockStore{}}
+	if w := getHistory(t, tr, "aggregate=mean", "application/json"); w.Code != http.StatusBadRequest {
+		t.Errorf("query without measurement: status = %d, want 400", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/collector/demo/history", nil)
+	w := httptest.NewRecorder()
+	serving(tr, w, req, "history")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("POST status = %d, want 404", w.Code)
+	}
+}
+
+// ── InfluxDB and Prometheus queries ───────────────────────────────────────────
+
+func TestFluxQuery(t *testing.T) {
+	q := historyQuery{
+		Measurement: "temperature",
+		Tags:        map[string]string{"source": `ds"1`},
+		Start:       historyStart,
+		Stop:        historyStart.Add(time.Hour),
+		Aggregate:   "mean",
+		Window:      15 * time.Minute,
+	}
+	want := `from(bucket: "b")
+  |> range(start: 2025-06-01T12:00:00Z, stop: 2025-06-01T13:00:00Z)
+  |> filter(fn: (r) => r._measurement == "temperature")
+  |> filter(fn: (r) => r["source"] == "ds\"1")
+  |> aggregateWindow(every: 900s, fn: mean, createEmpty: false, timeSrc: "_start")
+`
+	if got := fluxQuery("b", q); got != want {
+		t.Errorf("fluxQuery =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFluxString(t *testing.T) {
+	for in, want := range map[string]string{
+		`ds1`:         `"ds1"`,
+		`a"b\c`:       `"a\"b\\c"`,
+		"${now()}":    `"\${now()}"`,
+		"$5 ${x} $":   `"$5 \${x} $"`,
+		"tab\tline\n": `"tab\tline\n"`,
+		"\a\x00é€":    "\"\a\x00é€\"",
+		"bad\xffutf8": "\"bad\uFFFDutf8\"",
+	} {
+		if got := fluxString(in); got != want {
+			t.Errorf("fluxString(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestPromBackendQuery(t *testing.T) {
+	var got url.Values
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, got = r.URL.Path, r.URL.Query()
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"__name__":"temperature","source":"ds1"},"values":[[1748779200,"20.5"],[1748780100,"21"]]}]}}`))
+	}))
+	defer srv.Close()
+
+	q := historyQuery{
+		Measurement: "temperature",
+		Tags:        map[string]string{"source": "ds1"},
+		Start:       historyStart.Add(-15 * time.Minute),
+		Stop:        historyStart.Add(15 * time.Minute),
+		Aggregate:   "mean",
+		Window:      15 * time.Minute,
+	}
+	result, err := newPromBackend(srv.URL).query(context.Background(), q)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if path != "/api/v1/query_range" || got.Get("query") != `avg_over_time(temperature{source="ds1"}[900s])` || got.Get("step") != "900s" {
+		t.Errorf("request %s %v", path, got)
+	}
+	if len(result) != 1 || result[0].Tags["source"] != "ds1" || len(result[0].Samples) != 2 {
+		t.Fatalf("result = %+v", result)
+	}
+	// Samples are stamped with the start of their window
+	if s := result[0].Samples[0]; !s.Time.Equal(historyStart.Add(-15*time.Minute)) || s.Value != 20.5 {
+		t.Errorf("first sample = %+v", s)
+	}
+
+	// Raw readings use a range selector
+	q.Aggregate = ""
+	newPromBackend(srv.URL).query(context.Background(), q)
+	if path != "/api/v1/query" || got.Get("query") != `temperature{source="ds1"}[1800s]` {
+		t.Errorf("raw request %s %v", path, got)
+	}
+}
@@ -0,0 +1,174 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// influxBackend stores the points in an InfluxDB v2 bucket.
+type influxBackend struct {
+	client   influxdb2.Client
+	writeAPI api.WriteAPIBlocking
+	org      string
+	bucket   string
+}
+
+// newInfluxBackend creates the InfluxDB client. Writes are blocking so that
+// failures are reported back to the collector instead of being lost.
+func newInfluxBackend(url, token, org, bucket string) *influxBackend {
+	client := influxdb2.NewClient(url, token)
+	return &influxBackend{
+		client:   client,
+		writeAPI: client.WriteAPIBlocking(org, bucket),
+		org:      org,
+		bucket:   bucket,
+	}
+}
+
+func (b *influxBackend) write(ctx context.Context, points []point) error {
+	wps := make([]*write.Point, 0, len(points))
+	for _, p := range points {
+		wps = append(wps, write.NewPoint(p.Measurement, p.Tags, p.Fields, p.Time))
+	}
+	return b.writeAPI.WritePoint(ctx, wps...)
+}
+
+// measurements queries the bucket's schema for its list of measurements.
+func (b *influxBackend) measurements(ctx context.Context) ([]string, error) {
+	query := fmt.Sprintf(`
+		 import "influxdata/influxdb/schema"
+		 schema.measurements(bucket: "%s")
+	 `, b.bucket)
+
+	results, err := b.client.QueryAPI(b.org).Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for results.Next() {
+		names = append(names, fmt.Sprintf("%v", results.Record().Value()))
+	}
+	return names, results.Err()
+}
+
+// query runs the Flux query of a historical query and groups the records into series.
+func (b *influxBackend) query(ctx context.Context, q historyQuery) ([]series, error) {
+	results, err := b.client.QueryAPI(b.org).Query(ctx, fluxQuery(b.bucket, q))
+	if err != nil {
+		return nil, err
+	}
+	var group groupSeries
+	for results.Next() {
+		rec := results.Record()
+		value, ok := floatField(rec.Value())
+		if !ok {
+			if v, isInt := rec.Value().(int64); isInt {
+				value, ok = float64(v), true
+			}
+		}
+		if !ok {
+			continue
+		}
+		tags := make(map[string]string)
+		for k, v := range rec.Values() {
+			if fluxColumns[k] {
+				continue
+			}
+			if s, isString := v.(string); isString {
+				tags[k] = s
+			}
+		}
+		group.add(rec.Field(), tags, sample{Time: rec.Time().UTC(), Value: value})
+	}
+	return group.list, results.Err()
+}
+
+// Columns of a Flux record that are not tags
+var fluxColumns = map[string]bool{
+	"result": true, "table": true, "_start": true, "_stop": true,
+	"_time": true, "_value": true, "_field": true, "_measurement": true,
+}
+
+// Flux functions of the supported aggregations
+var fluxAggregates = map[string]string{"mean": "mean", "min": "min", "max": "max", "last": "last"}
+
+// fluxQuery builds the Flux query of a historical query. Aggregated windows are
+// stamped with their start time, as with the other backends.
+func fluxQuery(bucket string, q historyQuery) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "from(bucket: %s)\n", fluxString(bucket))
+	fmt.Fprintf(&sb, "  |> range(start: %s, stop: %s)\n",
+		q.Start.UTC().Format(time.RFC3339Nano), q.Stop.UTC().Format(time.RFC3339Nano))
+	fmt.Fprintf(&sb, "  |> filter(fn: (r) => r._measurement == %s)\n", fluxString(q.Measurement))
+	for _, key := range sortedKeys(q.Tags) {
+		fmt.Fprintf(&sb, "  |> filter(fn: (r) => r[%s] == %s)\n", fluxString(key), fluxString(q.Tags[key]))
+	}
+	if fn, ok := fluxAggregates[q.Aggregate]; ok {
+		fmt.Fprintf(&sb, "  |> aggregateWindow(every: %s, fn: %s, createEmpty: false, timeSrc: \"_start\")\n",
+			fluxDuration(q.window()), fn)
+	}
+	return sb.String()
+}
+
+// fluxString quotes a string literal for Flux. Backslashes, quotes and the
+// ${ of string interpolation are escaped; other characters are kept as they
+// are, since Flux knows none of Go's other escapes.
+func fluxString(s string) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '$' && i+1 < len(s) && s[i+1] == '{':
+			sb.WriteString(`\$`)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// fluxDuration writes a duration as a Flux duration literal (e.g. 90s).
+func fluxDuration(d time.Duration) string {
+	if d%time.Second == 0 {
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+	return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms"
+}
+
+func (b *influxBackend) close() error {
+	b.client.Close()
+	return nil
+}
@@ -0,0 +1,258 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// promBackend pushes the points to a Prometheus compatible server (Prometheus with
+// the remote-write receiver enabled, Mimir, VictoriaMetrics, ...) using the
+// remote-write protocol, and reads back through the Prometheus HTTP API.
+type promBackend struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newPromBackend(baseURL string) *promBackend {
+	return &promBackend{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{},
+	}
+}
+
+// write sends one snappy compressed protobuf WriteRequest with a time series per
+// point field. The metric is named after the measurement (and the field if it is
+// not "value") and the tags become labels.
+func (b *promBackend) write(ctx context.Context, points []point) error {
+	var req []byte
+	for _, p := range points {
+		for _, field := range sortedKeys(p.Fields) {
+			value, ok := floatField(p.Fields[field])
+			if !ok {
+				continue
+			}
+			name := p.Measurement
+			if field != "value" {
+				name += "_" + field
+			}
+			labels := map[string]string{"__name__": promName(name)}
+			for k, v := range p.Tags {
+				labels[promName(k)] = v
+			}
+			req = appendMessage(req, 1, encodeTimeSeries(labels, value, p.Time.UnixMilli()))
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/api/v1/write",
+		bytes.NewReader(snappy.Encode(nil, req)))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// measurements lists the metric names known to the server.
+func (b *promBackend) measurements(ctx context.Context) ([]string, error) {
+	var names []string
+	err := b.apiGet(ctx, "/api/v1/label/__name__/values", &names)
+	return names, err
+}
+
+// Range functions of the supported aggregations
+var promAggregates = map[string]string{
+	"mean": "avg_over_time", "min": "min_over_time", "max": "max_over_time", "last": "last_over_time",
+}
+
+// query reads back the series of a measurement. Raw readings are fetched with a
+// range selector evaluated at the stop time; aggregations are evaluated over
+// each window with query_range and stamped with the start of the window.
+func (b *promBackend) query(ctx context.Context, q historyQuery) ([]series, error) {
+	selector := promSelector(q.Measurement, q.Tags)
+	params := url.Values{}
+	path := "/api/v1/query"
+	shift := time.Duration(0)
+	if fn, ok := promAggregates[q.Aggregate]; ok {
+		window := q.window()
+		path = "/api/v1/query_range"
+		shift = window
+		params.Set("query", fmt.Sprintf("%s(%s[%s])", fn, selector, promDuration(window)))
+		params.Set("start", promTime(q.Start.Add(window)))
+		params.Set("end", promTime(q.Stop))
+		params.Set("step", promDuration(window))
+	} else {
+		params.Set("query", fmt.Sprintf("%s[%s]", selector, promDuration(q.Stop.Sub(q.Start))))
+		params.Set("time", promTime(q.Stop))
+	}
+
+	var data struct {
+		Result []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][2]any          `json:"values"`
+		} `json:"result"`
+	}
+	if err := b.apiGet(ctx, path+"?"+params.Encode(), &data); err != nil {
+		return nil, err
+	}
+	var group groupSeries
+	for _, r := range data.Result {
+		tags := make(map[string]string)
+		for k, v := range r.Metric {
+			if k != "__name__" {
+				tags[k] = v
+			}
+		}
+		for _, pair := range r.Values {
+			ts, tok := pair[0].(float64)
+			vs, vok := pair[1].(string)
+			if !tok || !vok {
+				return nil, fmt.Errorf("prometheus api: malformed sample %v", pair)
+			}
+			value, err := strconv.ParseFloat(vs, 64)
+			if err != nil {
+				return nil, fmt.Errorf("prometheus api: %w", err)
+			}
+			stamp := time.UnixMilli(int64(math.Round(ts * 1000))).UTC().Add(-shift)
+			group.add("value", tags, sample{Time: stamp, Value: value})
+		}
+	}
+	return group.list, nil
+}
+
+// promSelector builds the instant vector selector of a measurement with tag filters.
+func promSelector(measurement string, tags map[string]string) string {
+	matchers := make([]string, 0, len(tags))
+	for _, k := range sortedKeys(tags) {
+		matchers = append(matchers, promName(k)+"="+strconv.Quote(tags[k]))
+	}
+	return promName(measurement) + "{" + strings.Join(matchers, ",") + "}"
+}
+
+// promDuration writes a duration in the Prometheus notation (e.g. 90s or 1500ms).
+func promDuration(d time.Duration) string {
+	if d%time.Second == 0 {
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+	return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms"
+}
+
+// promTime writes a timestamp as Unix seconds.
+func promTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1000, 'f', 3, 64)
+}
+
+// apiGet calls the Prometheus HTTP API and decodes the data of a successful response.
+func (b *promBackend) apiGet(ctx context.Context, path string, data any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+		Error  string          `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("prometheus api: %s: %w", resp.Status, err)
+	}
+	if body.Status != "success" {
+		return fmt.Errorf("prometheus api: %s: %s", resp.Status, body.Error)
+	}
+	return json.Unmarshal(body.Data, data)
+}
+
+func (b *promBackend) close() error {
+	b.client.CloseIdleConnections()
+	return nil
+}
+
+// promName replaces the characters that are not allowed in metric and label names.
+func promName(s string) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9' && i > 0:
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// -------------------------------------Protobuf encoding of the remote-write messages
+
+// encodeTimeSeries encodes a prometheus.TimeSeries with a single sample:
+//
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label      { string name = 1; string value = 2; }
+//	message Sample     { double value = 1; int64 timestamp = 2; }
+//
+// Labels must be sorted by name.
+func encodeTimeSeries(labels map[string]string, value float64, timestampMs int64) []byte {
+	var ts []byte
+	for _, name := range sortedKeys(labels) {
+		var label []byte
+		label = appendMessage(label, 1, []byte(name))
+		label = appendMessage(label, 2, []byte(labels[name]))
+		ts = appendMessage(ts, 1, label)
+	}
+	var sample []byte
+	sample = binary.AppendUvarint(sample, 1<<3|1) // field 1, 64-bit
+	sample = binary.LittleEndian.AppendUint64(sample, math.Float64bits(value))
+	sample = binary.AppendUvarint(sample, 2<<3|0) // field 2, varint
+	sample = binary.AppendUvarint(sample, uint64(timestampMs))
+	return appendMessage(ts, 2, sample)
+}
+
+// appendMessage appends a length-delimited field (string, bytes or message).
+func appendMessage(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
@@ -0,0 +1,239 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// sqlBackend stores the points as rows of a "readings" table, one row per field:
+// (time, measurement, tags, field, value). The tags are kept as a JSON object.
+// It backs both the embedded SQLite store and TimescaleDB/PostgreSQL.
+type sqlBackend struct {
+	db *sql.DB
+	// placeholder returns the n-th (1-based) query parameter of the SQL dialect
+	placeholder func(n int) string
+	// timeValue converts a timestamp into the type of the time column
+	timeValue func(t time.Time) any
+	// scanTime converts a value of the time column back into a timestamp
+	scanTime func(v any) (time.Time, error)
+	// tagExpr returns the SQL expression extracting a tag from the tags column
+	tagExpr func(key string) string
+}
+
+// newLocalBackend opens (or creates) the embedded SQLite store, which needs no
+// database server. Time is stored as Unix nanoseconds.
+func newLocalBackend(path string) (*sqlBackend, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening local store: %w", err)
+	}
+	db.SetMaxOpenConns(1) // SQLite allows a single writer
+	schema := []string{
+		`PRAGMA journal_mode=WAL`,
+		`CREATE TABLE IF NOT EXISTS readings (
+			time        INTEGER NOT NULL,
+			measurement TEXT    NOT NULL,
+			tags        TEXT    NOT NULL,
+			field       TEXT    NOT NULL,
+			value       REAL    NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS readings_measurement_time ON readings (measurement, time)`,
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("creating local store schema: %w", err)
+		}
+	}
+	return &sqlBackend{
+		db:          db,
+		placeholder: func(int) string { return "?" },
+		timeValue:   func(t time.Time) any { return t.UnixNano() },
+		scanTime: func(v any) (time.Time, error) {
+			ns, ok := v.(int64)
+			if !ok {
+				return time.Time{}, fmt.Errorf("unexpected time value %T", v)
+			}
+			return time.Unix(0, ns).UTC(), nil
+		},
+		tagExpr: func(key string) string { return "json_extract(tags, " + sqlString(`$."`+key+`"`) + ")" },
+	}, nil
+}
+
+// newTimescaleBackend connects to PostgreSQL and creates the readings table.
+// If the TimescaleDB extension is installed, the table is turned into a hypertable.
+func newTimescaleBackend(connStr string) (*sqlBackend, error) {
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("opening TimescaleDB connection: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS readings (
+		time        TIMESTAMPTZ      NOT NULL,
+		measurement TEXT             NOT NULL,
+		tags        JSONB            NOT NULL,
+		field       TEXT             NOT NULL,
+		value       DOUBLE PRECISION NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating readings table: %w", err)
+	}
+	if _, err := db.Exec(`SELECT create_hypertable('readings', 'time', if_not_exists => TRUE)`); err != nil {
+		log.Printf("readings is a plain PostgreSQL table (no TimescaleDB): %v\n", err)
+	}
+	return &sqlBackend{
+		db:          db,
+		placeholder: func(n int) string { return "$" + strconv.Itoa(n) },
+		timeValue:   func(t time.Time) any { return t.UTC() },
+		scanTime: func(v any) (time.Time, error) {
+			t, ok := v.(time.Time)
+			if !ok {
+				return time.Time{}, fmt.Errorf("unexpected time value %T", v)
+			}
+			return t.UTC(), nil
+		},
+		tagExpr: func(key string) string { return "tags->>" + sqlString(key) },
+	}, nil
+}
+
+// write inserts all fields of all points in a single transaction.
+func (b *sqlBackend) write(ctx context.Context, points []point) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert := fmt.Sprintf("INSERT INTO readings (time, measurement, tags, field, value) VALUES (%s, %s, %s, %s, %s)",
+		b.placeholder(1), b.placeholder(2), b.placeholder(3), b.placeholder(4), b.placeholder(5))
+	stmt, err := tx.PrepareContext(ctx, insert)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, p := range points {
+		tags, err := json.Marshal(p.Tags)
+		if err != nil {
+			return err
+		}
+		for _, field := range sortedKeys(p.Fields) {
+			value, ok := floatField(p.Fields[field])
+			if !ok {
+				continue
+			}
+			if _, err := stmt.ExecContext(ctx, b.timeValue(p.Time), p.Measurement, string(tags), field, value); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (b *sqlBackend) measurements(ctx context.Context) ([]string, error) {
+	rows, err := b.db.QueryContext(ctx, "SELECT DISTINCT measurement FROM readings ORDER BY measurement")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// query selects the readings of the measurement within the time range whose
+// tags match the filters, and aggregates each series per window.
+func (b *sqlBackend) query(ctx context.Context, q historyQuery) ([]series, error) {
+	stmt := fmt.Sprintf("SELECT time, tags, field, value FROM readings WHERE measurement = %s AND time >= %s AND time < %s",
+		b.placeholder(1), b.placeholder(2), b.placeholder(3))
+	args := []any{q.Measurement, b.timeValue(q.Start), b.timeValue(q.Stop)}
+	for _, key := range sortedKeys(q.Tags) {
+		args = append(args, q.Tags[key])
+		stmt += fmt.Sprintf(" AND %s = %s", b.tagExpr(key), b.placeholder(len(args)))
+	}
+	stmt += " ORDER BY time"
+
+	rows, err := b.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var group groupSeries
+	for rows.Next() {
+		var (
+			tv    any
+			tags  []byte
+			field string
+			value float64
+		)
+		if err := rows.Scan(&tv, &tags, &field, &value); err != nil {
+			return nil, err
+		}
+		ts, err := b.scanTime(tv)
+		if err != nil {
+			return nil, err
+		}
+		tagMap := make(map[string]string)
+		if err := json.Unmarshal(tags, &tagMap); err != nil {
+			return nil, fmt.Errorf("tags of a reading: %w", err)
+		}
+		group.add(field, tagMap, sample{Time: ts, Value: value})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range group.list {
+		group.list[i].Samples = q.aggregate(group.list[i].Samples)
+	}
+	return group.list, nil
+}
+
+// sqlString quotes a string literal for SQL.
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (b *sqlBackend) close() error {
+	return b.db.Close()
+}
+
+// sortedKeys returns the keys of a map in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
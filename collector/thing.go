@@ -22,14 +22,11 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api"
-	"github.com/influxdata/influxdb-client-go/v2/api/write"
-
 	"github.com/sdoque/mbaigo/components"
 	"github.com/sdoque/mbaigo/forms"
 	"github.com/sdoque/mbaigo/usecases"
@@ -37,21 +34,36 @@ import (
 
 // -------------------------------------Define a measurement (or signal)
 type MeasurementT struct {
-	Name    string              `json:"serviceDefinition"`
-	Details map[string][]string `json:"mdetails"`
-	Period  time.Duration       `json:"samplingPeriod"`
+	Name      string              `json:"serviceDefinition"`
+	Details   map[string][]string `json:"mdetails"`
+	Period    time.Duration       `json:"samplingPeriod"`
+	Timestamp string              `json:"timestamp,omitempty"` // "provider" (default) or "collection" time
 }
 
+// Sources of a point's timestamp
+const (
+	providerTime   = "provider"   // the time the provider measured the value (falls back to collection time)
+	collectionTime = "collection" // the time the collector read the value
+)
+
 //-------------------------------------Define the unit asset
 
 // Traits are Asset-specific configurable parameters
 type Traits struct {
-	FluxURL      string         `json:"db_url"`
-	Token        string         `json:"token"`
-	Org          string         `json:"organization"`
-	Bucket       string         `json:"bucket"`
+	Backend      string         `json:"backend"`      // "influxdb" (default), "local", "prometheus" or "timescaledb"
+	FluxURL      string         `json:"db_url"`       // InfluxDB v2 URL
+	Token        string         `json:"token"`        // InfluxDB v2 API token
+	Org          string         `json:"organization"` // InfluxDB v2 organization
+	Bucket       string         `json:"bucket"`       // InfluxDB v2 bucket
+	LocalPath    string         `json:"localPath"`    // SQLite file of the embedded local store
+	PromURL      string         `json:"promURL"`      // base URL of a Prometheus remote-write receiver
+	PgConn       string         `json:"pgConn"`       // TimescaleDB/PostgreSQL connection string
+	BufferDir    string         `json:"bufferDir"`    // directory of the store-and-forward buffer (empty disables it)
+	BufferMaxMB  int            `json:"bufferMaxMB"`  // size bound of the buffer in megabytes
+	BufferMaxAge int            `json:"bufferMaxAge"` // age bound of the buffered readings in hours
 	Measurements []MeasurementT `json:"measurements"`
-	client       influxdb2.Client
+	store        backend
+	buffer       *forwarder
 	owner        *components.System  `json:"-"`
 	cervices     components.Cervices `json:"-"`
 	name         string              `json:"-"`
@@ -69,24 +81,53 @@ func initTemplate() *components.UnitAsset {
 		CUnit:       "",
 		Description: "provides the list of measurements in the bucket (GET)",
 	}
+	bufferedService := components.Service{
+		Definition:  "buffered",
+		SubPath:     "buffered",
+		Details:     map[string][]string{"Unit": {"points"}, "Forms": {"SignalA_v1a"}},
+		RegPeriod:   60,
+		Description: "provides the number of readings waiting in the store-and-forward buffer (GET)",
+	}
+	droppedService := components.Service{
+		Definition:  "dropped",
+		SubPath:     "dropped",
+		Details:     map[string][]string{"Unit": {"points"}, "Forms": {"SignalA_v1a"}},
+		RegPeriod:   60,
+		Description: "provides the number of buffered readings dropped because of the size or age bound (GET)",
+	}
+	historyService := components.Service{
+		Definition:  "history",
+		SubPath:     "history",
+		Details:     map[string][]string{"Forms": {"TimeSeries_v1", "text/csv"}},
+		RegPeriod:   60,
+		Description: "provides the stored readings of a measurement, filtered by tags and time range and optionally aggregated (GET)",
+	}
 
 	return &components.UnitAsset{
 		Name:    "demo",
 		Mission: "handle_timeseries",
-		Details: map[string][]string{"Database": {"InfluxDB"}},
+		Details: map[string][]string{"Database": {"SQLite"}},
 		ServicesMap: components.Services{
-			mqueryService.SubPath: &mqueryService,
+			mqueryService.SubPath:   &mqueryService,
+			bufferedService.SubPath: &bufferedService,
+			droppedService.SubPath:  &droppedService,
+			historyService.SubPath:  &historyService,
 		},
 		Traits: &Traits{
-			FluxURL: "http://10.0.0.33:8086",
-			Token:   "K1NTWNlToyUNXdii7IwNJ1W-kMsagUr8w1r4cRVYqK-N-R9vVT1MCJwHFBxOgiW85iKiMSsUpbrxQsQZJA8IzA==",
-			Org:     "mbaigo",
-			Bucket:  "demo",
+			Backend:      backendLocal,
+			FluxURL:      "http://localhost:8086",
+			Org:          "mbaigo",
+			Bucket:       "demo",
+			LocalPath:    "demo.db",
+			BufferDir:    "buffer",
+			BufferMaxMB:  64,
+			BufferMaxAge: 72,
 			Measurements: []MeasurementT{
 				{
-					Name:    "temperature",
-					Details: map[string][]string{"FunctionalLocation": {"Kitchen"}},
-					Period:  3,
+					Name:      "temperature",
+					Details:   map[string][]string{"FunctionalLocation": {"Kitchen"}},
+					Period:    3,
+					Timestamp: providerTime,
 				},
 			},
 		},
@@ -108,15 +149,28 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 		}
 	}
 
-	if t.FluxURL == "" || t.Token == "" || t.Org == "" || t.Bucket == "" {
-		log.Fatal("Invalid InfluxDB configuration: missing required parameters")
+	// Connect to the time-series database selected in the traits
+	store, err := newBackend(t)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Create a new client for InfluxDB
-	t.client = influxdb2.NewClient(t.FluxURL, t.Token)
-
-	// Create a non-blocking write API
-	writeAPI := t.client.WriteAPI(t.Org, t.Bucket)
+	// Queue the readings on disk while the database is unreachable
+	if t.BufferDir != "" {
+		if t.BufferMaxMB <= 0 {
+			t.BufferMaxMB = 64
+		}
+		if t.BufferMaxAge <= 0 {
+			t.BufferMaxAge = 72
+		}
+		t.buffer, err = newForwarder(store, t.BufferDir, int64(t.BufferMaxMB)<<20, time.Duration(t.BufferMaxAge)*time.Hour)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = t.buffer
+		go t.buffer.run(sys.Ctx)
+	}
+	t.store = store
 
 	// Build cervices map from measurements
 	sProtocols := components.SProtocols(sys.Husk.ProtoPort)
@@ -152,7 +206,7 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 		wg.Add(1)
 		go func(name string, period time.Duration) {
 			defer wg.Done()
-			if err := t.collectIngest(name, period, writeAPI); err != nil {
+			if err := t.collectIngest(name, period, store); err != nil {
 				log.Printf("Error in collectIngest for measurement: %v", err)
 			}
 		}(measurement.Name, measurement.Period*time.Second)
@@ -161,13 +215,26 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 	return ua, func() {
 		log.Println("Waiting for all goroutines to finish...")
 		wg.Wait()
-		log.Println("Disconnecting from InfluxDB")
-		t.client.Close()
+		log.Println("Disconnecting from the database")
+		if err := store.close(); err != nil {
+			log.Printf("Error closing the database: %v", err)
+		}
 	}
 }
 
 //-------------------------------------Service handlers
 
+// bufferHealth handles GET requests for the buffer depth and drop count.
+func (t *Traits) bufferHealth(w http.ResponseWriter, r *http.Request, servicePath string) {
+	switch r.Method {
+	case "GET":
+		f := t.getBufferStat(servicePath)
+		usecases.HTTPProcessGetRequest(w, r, &f)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
 // measQuery handles GET requests for the list of measurements in the bucket.
 func (t *Traits) measQuery(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -180,13 +247,36 @@ func (t *Traits) measQuery(w http.ResponseWriter, r *http.Request) {
 
 //-------------------------------------Unit asset's functionalities
 
+// getBufferStat fills out a signal form with the number of buffered ("buffered")
+// or dropped ("dropped") readings; both are zero when buffering is disabled.
+func (t *Traits) getBufferStat(stat string) (f forms.SignalA_v1a) {
+	f.NewForm()
+	if t.buffer != nil {
+		s := t.buffer.stats()
+		switch stat {
+		case "buffered":
+			f.Value = float64(s.depth)
+		case "dropped":
+			f.Value = float64(s.dropped)
+		}
+	}
+	f.Unit = "points"
+	f.Timestamp = time.Now()
+	return f
+}
+
 // collectIngest discovers all providers of a measurement type and ingests a reading
-// from each one into InfluxDB on every tick. Each point is tagged with the source
-// node name so readings from different assets remain distinguishable in the bucket.
-func (t *Traits) collectIngest(name string, period time.Duration, writeAPI api.WriteAPI) error {
+// from each one into the database on every tick. Each point is tagged with the source
+// node name so readings from different assets remain distinguishable in the store.
+// A reading a provider serves again unchanged, with the same timestamp, is not re-written
+// once it has been stored.
+func (t *Traits) collectIngest(name string, period time.Duration, store backend) error {
 	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 
+	useCollectionTime := t.timeSource(name) == collectionTime
+	lastSeen := make(map[string]point) // last stored reading of each provider, keyed by node and index
+
 	for {
 		select {
 		case <-t.owner.Ctx.Done():
@@ -209,7 +299,7 @@ func (t *Traits) collectIngest(name string, period time.Duration, writeAPI api.W
 			// Query each provider individually so we can tag the point with its node name
 			// and the provider's registered details (unit, location, etc.).
 			for node, nodeInfos := range cer.Nodes {
-				for _, ni := range nodeInfos {
+				for i, ni := range nodeInfos {
 					// Build a temporary single-entry cervice; pre-populated Nodes skip re-discovery.
 					tmp := &components.Cervice{
 						Definition: cer.Definition,
@@ -223,56 +313,125 @@ func (t *Traits) collectIngest(name string, period time.Duration, writeAPI api.W
 						cer.Nodes = make(map[string][]components.NodeInfo) // reset so next tick re-discovers
 						break
 					}
-					tup, ok := tf.(*forms.SignalA_v1a)
-					if !ok {
-						log.Printf("unexpected form from %s for %s\n", node, name)
+					fields, stamp, unit, err := formFields(tf)
+					if err != nil {
+						log.Printf("unexpected form from %s for %s: %v\n", node, name, err)
 						continue
 					}
 
+					// Skip a cached reading the provider serves again unchanged
+					provider := node + "#" + strconv.Itoa(i)
+					if prev, ok := lastSeen[provider]; ok && !stamp.IsZero() && stamp.Equal(prev.Time) && sameFields(fields, prev.Fields) {
+						continue
+					}
+					seen := point{Fields: fields, Time: stamp}
+
 					// Tag with the node name plus all details the provider registered
-					// (e.g. Unit, Location) so streams are distinguishable in InfluxDB.
+					// (e.g. Unit, Location) so streams are distinguishable in the database.
 					tags := map[string]string{"source": node}
 					for key, values := range ni.Details {
 						tags[key] = strings.Join(values, ",")
 					}
+					if _, ok := tags["Unit"]; !ok && unit != "" {
+						tags["Unit"] = unit
+					}
 
-					point := write.NewPoint(
-						name,
-						tags,
-						map[string]interface{}{"value": tup.Value},
-						time.Now(),
-					)
-					writeAPI.WritePoint(point)
-					log.Printf("collected %s from %-20s  value=%.4f\n", name, node, tup.Value)
+					if useCollectionTime || stamp.IsZero() {
+						stamp = time.Now()
+					}
+					p := point{
+						Measurement: name,
+						Tags:        tags,
+						Fields:      fields,
+						Time:        stamp,
+					}
+					if err := store.write(t.owner.Ctx, []point{p}); err != nil {
+						log.Printf("unable to store %s from %s: %v\n", name, node, err)
+						continue
+					}
+					lastSeen[provider] = seen
+					log.Printf("collected %s from %-20s  %v\n", name, node, fields)
 				}
 			}
 		}
 	}
 }
 
-// q4measurements queries the bucket for the list of measurements
-func (t *Traits) q4measurements(w http.ResponseWriter) {
-	text := "The list of measurements in the " + t.name + " bucket is:\n"
-	queryAPI := t.client.QueryAPI(t.Org)
+// timeSource returns where the timestamps of a measurement come from.
+func (t *Traits) timeSource(name string) string {
+	for _, m := range t.Measurements {
+		if m.Name == name && m.Timestamp == collectionTime {
+			return collectionTime
+		}
+	}
+	return providerTime
+}
 
-	query := fmt.Sprintf(`
-		 import "influxdata/influxdb/schema"
-		 schema.measurements(bucket: "%s")
-	 `, t.name)
+// formFields extracts the values, the provider's timestamp and the unit (if any)
+// from a form. SignalA and SignalB forms give a single "value" field; any other
+// form the framework can unpack contributes its top-level numbers and booleans,
+// named after their JSON keys.
+func formFields(f forms.Form) (fields map[string]any, stamp time.Time, unit string, err error) {
+	switch sig := f.(type) {
+	case *forms.SignalA_v1a:
+		return map[string]any{"value": sig.Value}, sig.Timestamp, sig.Unit, nil
+	case *forms.SignalB_v1a:
+		return map[string]any{"value": sig.Value}, sig.Timestamp, "", nil
+	}
 
-	results, err := queryAPI.Query(context.Background(), query)
+	data, err := json.Marshal(f)
 	if err != nil {
-		log.Fatal(err)
+		return nil, stamp, "", err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, stamp, "", fmt.Errorf("%s is not a JSON object", f.FormVersion())
 	}
+	fields = make(map[string]any)
+	for key, value := range raw {
+		switch v := value.(type) {
+		case float64, bool:
+			fields[key] = v
+		case string:
+			switch strings.ToLower(key) {
+			case "timestamp":
+				stamp, _ = time.Parse(time.RFC3339Nano, v)
+			case "unit":
+				unit = v
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return nil, stamp, "", fmt.Errorf("%s carries no numeric or boolean value", f.FormVersion())
+	}
+	return fields, stamp, unit, nil
+}
 
-	for results.Next() {
-		measurement := fmt.Sprintf("%v", results.Record().Value())
-		text += "- " + measurement + "\n"
+// sameFields reports whether two readings carry the same values.
+func sameFields(a, b map[string]any) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
 	}
+	return true
+}
 
-	if err := results.Err(); err != nil {
-		log.Fatal(err)
+// q4measurements queries the database for the list of measurements
+func (t *Traits) q4measurements(w http.ResponseWriter) {
+	names, err := t.store.measurements(context.Background())
+	if err != nil {
+		log.Printf("unable to list measurements: %v\n", err)
+		http.Error(w, "Unable to query the database", http.StatusInternalServerError)
+		return
 	}
 
+	text := "The list of measurements in the " + t.name + " store is:\n"
+	for _, measurement := range names {
+		text += "- " + measurement + "\n"
+	}
 	w.Write([]byte(text))
 }
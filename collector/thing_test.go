@@ -18,67 +18,46 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
 
-	"github.com/influxdata/influxdb-client-go/v2/api"
-	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	"github.com/sdoque/mbaigo/components"
 	"github.com/sdoque/mbaigo/forms"
 )
 
-// ── mock WriteAPI ──────────────────────────────────────────────────────────────
+// ── mock backend ──────────────────────────────────────────────────────────────
 
-// mockWriteAPI implements api.WriteAPI, capturing every point written.
-type mockWriteAPI struct {
+// mockStore implements backend, capturing every point written.
+type mockStore struct {
 	mu     sync.Mutex
-	points []*write.Point
+	points []point
 }
 
-func (m *mockWriteAPI) WriteRecord(_ string)                             {}
-func (m *mockWriteAPI) Flush()                                           {}
-func (m *mockWriteAPI) Errors() <-chan error                             { return make(chan error) }
-func (m *mockWriteAPI) SetWriteFailedCallback(_ api.WriteFailedCallback) {}
-func (m *mockWriteAPI) WritePoint(p *write.Point) {
+func (m *mockStore) measurements(_ context.Context) ([]string, error) { return nil, nil }
+func (m *mockStore) close() error                                     { return nil }
+func (m *mockStore) query(_ context.Context, _ historyQuery) ([]series, error) {
+	return nil, nil
+}
+func (m *mockStore) write(_ context.Context, points []point) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.points = append(m.points, p)
+	m.points = append(m.points, points...)
+	return nil
 }
 
 // captured returns a snapshot of all written points.
-func (m *mockWriteAPI) captured() []*write.Point {
+func (m *mockStore) captured() []point {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	out := make([]*write.Point, len(m.points))
+	out := make([]point, len(m.points))
 	copy(out, m.points)
 	return out
 }
 
-// ── point inspection helpers ───────────────────────────────────────────────────
-
-// tagMap extracts all tags from a point as a plain map.
-// The lp.Tag type (from influxdata/line-protocol) need not be named explicitly.
-func tagMap(p *write.Point) map[string]string {
-	result := make(map[string]string)
-	for _, tag := range p.TagList() {
-		result[tag.Key] = tag.Value
-	}
-	return result
-}
-
-// fieldValue returns the value of the named field, or nil if absent.
-func fieldValue(p *write.Point, key string) interface{} {
-	for _, f := range p.FieldList() {
-		if f.Key == key {
-			return f.Value
-		}
-	}
-	return nil
-}
-
 // ── helpers ───────────────────────────────────────────────────────────────────
 
 // newTestSystem returns a System with a non-nil Husk (empty CoreS slice) so that
@@ -112,11 +91,11 @@ func newSignalServer(t *testing.T, value float64, unit string) *httptest.Server
 // duration to allow ticks to fire, then cancels the context and waits for the
 // goroutine to exit. Returns the cancellation function and a channel that
 // receives the function's return value.
-func runCollectIngest(t *testing.T, tr *Traits, name string, period, wait time.Duration, mwa *mockWriteAPI) {
+func runCollectIngest(t *testing.T, tr *Traits, name string, period, wait time.Duration, ms *mockStore) {
 	t.Helper()
 	done := make(chan struct{})
 	go func() {
-		tr.collectIngest(name, period, mwa) //nolint:errcheck
+		tr.collectIngest(name, period, ms) //nolint:errcheck
 		close(done)
 	}()
 	time.Sleep(wait)
@@ -205,27 +184,27 @@ func TestCollectIngestSingleProvider(t *testing.T) {
 			"sensor1": {{URL: srv.URL, Details: map[string][]string{"Unit": {"kPa"}}}},
 		},
 	}
-	mwa := &mockWriteAPI{}
+	ms := &mockStore{}
 	tr := &Traits{
 		owner:    &sys,
 		cervices: components.Cervices{"pressure": cer},
 	}
 
 	done := make(chan struct{})
-	go func() { tr.collectIngest("pressure", 50*time.Millisecond, mwa); close(done) }()
+	go func() { tr.collectIngest("pressure", 50*time.Millisecond, ms); close(done) }()
 	time.Sleep(150 * time.Millisecond)
 	cancel()
 	<-done
 
-	pts := mwa.captured()
+	pts := ms.captured()
 	if len(pts) == 0 {
 		t.Fatal("expected at least one point written")
 	}
 	p := pts[0]
-	if p.Name() != "pressure" {
-		t.Errorf("measurement name = %q, want pressure", p.Name())
+	if p.Measurement != "pressure" {
+		t.Errorf("measurement name = %q, want pressure", p.Measurement)
 	}
-	tags := tagMap(p)
+	tags := p.Tags
 	if tags["source"] != "sensor1" {
 		t.Errorf("source tag = %q, want sensor1", tags["source"])
 	}
@@ -233,7 +212,7 @@ func TestCollectIngestSingleProvider(t *testing.T) {
 	if tags["Unit"] != "kPa" {
 		t.Errorf("Unit tag = %q, want kPa", tags["Unit"])
 	}
-	if v := fieldValue(p, "value"); v != 42.5 {
+	if v := p.Fields["value"]; v != 42.5 {
 		t.Errorf("value field = %v, want 42.5", v)
 	}
 }
@@ -255,19 +234,19 @@ func TestCollectIngestMultipleProviders(t *testing.T) {
 			"sensor2": {{URL: srv2.URL, Details: map[string][]string{"Unit": {"bar"}}}},
 		},
 	}
-	mwa := &mockWriteAPI{}
+	ms := &mockStore{}
 	tr := &Traits{
 		owner:    &sys,
 		cervices: components.Cervices{"pressure": cer},
 	}
 
 	done := make(chan struct{})
-	go func() { tr.collectIngest("pressure", 50*time.Millisecond, mwa); close(done) }()
+	go func() { tr.collectIngest("pressure", 50*time.Millisecond, ms); close(done) }()
 	time.Sleep(200 * time.Millisecond) // allow several ticks so both providers are polled
 	cancel()
 	<-done
 
-	pts := mwa.captured()
+	pts := ms.captured()
 	if len(pts) < 2 {
 		t.Fatalf("expected at least 2 points (one per provider), got %d", len(pts))
 	}
@@ -279,12 +258,12 @@ func TestCollectIngestMultipleProviders(t *testing.T) {
 	}
 	bySource := make(map[string]*providerStats)
 	for _, p := range pts {
-		tags := tagMap(p)
+		tags := p.Tags
 		src := tags["source"]
 		if bySource[src] == nil {
 			bySource[src] = &providerStats{unit: tags["Unit"]}
 		}
-		if v, ok := fieldValue(p, "value").(float64); ok {
+		if v, ok := p.Fields["value"].(float64); ok {
 			bySource[src].values = append(bySource[src].values, v)
 		}
 	}
@@ -337,7 +316,7 @@ func TestCollectIngestProviderFailure(t *testing.T) {
 	}
 
 	done := make(chan struct{})
-	go func() { tr.collectIngest("pressure", 50*time.Millisecond, &mockWriteAPI{}); close(done) }()
+	go func() { tr.collectIngest("pressure", 50*time.Millisecond, &mockStore{}); close(done) }()
 	time.Sleep(150 * time.Millisecond)
 	cancel()
 	<-done
@@ -349,14 +328,166 @@ func TestCollectIngestProviderFailure(t *testing.T) {
 	}
 }
 
+// ── collectIngest: binary signals ─────────────────────────────────────────────
+
+func TestCollectIngestSignalB(t *testing.T) {
+	stamp := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	value := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(forms.SignalB_v1a{Value: value, Timestamp: stamp, Version: "SignalB_v1.0"})
+		// The next reading is a new one
+		value = !value
+		stamp = stamp.Add(time.Second)
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sys := newTestSystem(ctx)
+
+	cer := &components.Cervice{
+		Definition: "on_off",
+		Protos:     []string{"http"},
+		Nodes: map[string][]components.NodeInfo{
+			"plug1": {{URL: srv.URL}},
+		},
+	}
+	ms := &mockStore{}
+	tr := &Traits{
+		owner:    &sys,
+		cervices: components.Cervices{"on_off": cer},
+	}
+	first := stamp
+
+	done := make(chan struct{})
+	go func() { tr.collectIngest("on_off", 50*time.Millisecond, ms); close(done) }()
+	time.Sleep(150 * time.Millisecond)
+	cancel()
+	<-done
+
+	pts := ms.captured()
+	if len(pts) == 0 {
+		t.Fatal("expected SignalB readings to be stored")
+	}
+	if v := pts[0].Fields["value"]; v != true {
+		t.Errorf("value field = %v, want true", v)
+	}
+	// The provider's measurement time is kept
+	if !pts[0].Time.Equal(first) {
+		t.Errorf("time = %v, want provider timestamp %v", pts[0].Time, first)
+	}
+}
+
+// ── collectIngest: timestamps and duplicates ──────────────────────────────────
+
+// newCachedSignalServer always serves the same cached reading.
+func newCachedSignalServer(t *testing.T, stamp time.Time) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(forms.SignalA_v1a{Value: 3.5, Unit: "m/s", Timestamp: stamp, Version: "SignalA_v1.0"})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCollectIngestTimestamps(t *testing.T) {
+	stamp := time.Now().Add(-10 * time.Minute).Truncate(time.Millisecond)
+
+	for _, tc := range []struct {
+		mode           string
+		wantProviderTS bool
+	}{
+		{"", true},
+		{providerTime, true},
+		{collectionTime, false},
+	} {
+		srv := newCachedSignalServer(t, stamp)
+		ctx, cancel := context.WithCancel(context.Background())
+		sys := newTestSystem(ctx)
+		cer := &components.Cervice{
+			Definition: "wind",
+			Protos:     []string{"http"},
+			Nodes:      map[string][]components.NodeInfo{"netatmo": {{URL: srv.URL}}},
+		}
+		ms := &mockStore{}
+		tr := &Traits{
+			owner:        &sys,
+			cervices:     components.Cervices{"wind": cer},
+			Measurements: []MeasurementT{{Name: "wind", Timestamp: tc.mode}},
+		}
+
+		done := make(chan struct{})
+		go func() { tr.collectIngest("wind", 30*time.Millisecond, ms); close(done) }()
+		time.Sleep(200 * time.Millisecond) // several ticks
+		cancel()
+		<-done
+
+		pts := ms.captured()
+		// The unchanged cached reading is stored only once
+		if len(pts) != 1 {
+			t.Fatalf("mode %q: expected 1 point for an unchanged reading, got %d", tc.mode, len(pts))
+		}
+		if got := pts[0].Time.Equal(stamp); got != tc.wantProviderTS {
+			t.Errorf("mode %q: time = %v, provider timestamp %v", tc.mode, pts[0].Time, stamp)
+		}
+		// Without a registered Unit detail, the form's unit is used
+		if pts[0].Tags["Unit"] != "m/s" {
+			t.Errorf("mode %q: Unit tag = %q, want m/s", tc.mode, pts[0].Tags["Unit"])
+		}
+	}
+}
+
+// failingStore fails the first `failures` writes.
+type failingStore struct {
+	mockStore
+	failures int
+}
+
+func (f *failingStore) write(ctx context.Context, points []point) error {
+	f.mu.Lock()
+	if f.failures > 0 {
+		f.failures--
+		f.mu.Unlock()
+		return errors.New("database unavailable")
+	}
+	f.mu.Unlock()
+	return f.mockStore.write(ctx, points)
+}
+
+func TestCollectIngestFailedWriteIsRetried(t *testing.T) {
+	stamp := time.Now().Add(-10 * time.Minute).Truncate(time.Millisecond)
+	srv := newCachedSignalServer(t, stamp)
+	ctx, cancel := context.WithCancel(context.Background())
+	sys := newTestSystem(ctx)
+	cer := &components.Cervice{
+		Definition: "wind",
+		Protos:     []string{"http"},
+		// The same node offers the reading twice: each is a provider of its own
+		Nodes: map[string][]components.NodeInfo{"netatmo": {{URL: srv.URL}, {URL: srv.URL}}},
+	}
+	fs := &failingStore{failures: 1}
+	tr := &Traits{owner: &sys, cervices: components.Cervices{"wind": cer}}
+
+	done := make(chan struct{})
+	go func() { tr.collectIngest("wind", 30*time.Millisecond, fs); close(done) }()
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-done
+
+	// The reading whose write failed is stored at the next tick, once per provider
+	if pts := fs.captured(); len(pts) != 2 {
+		t.Fatalf("expected the cached reading stored once per provider, got %d points", len(pts))
+	}
+}
+
 // ── collectIngest: unexpected response form is skipped ────────────────────────
 
 func TestCollectIngestBadForm(t *testing.T) {
-	// Server returns a valid 200 but with a form the collector cannot use.
+	// Server returns a valid 200 but with a form that carries no value to store.
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		// SignalB is not a SignalA_v1a — type assertion in collectIngest will fail.
-		json.NewEncoder(w).Encode(forms.SignalB_v1a{Value: true, Version: "SignalB_v1.0"})
+		json.NewEncoder(w).Encode(forms.NewMessengerRegistration_v1("http://localhost"))
 	}))
 	t.Cleanup(srv.Close)
 
@@ -370,21 +501,50 @@ func TestCollectIngestBadForm(t *testing.T) {
 			"device1": {{URL: srv.URL}},
 		},
 	}
-	mwa := &mockWriteAPI{}
+	ms := &mockStore{}
 	tr := &Traits{
 		owner:    &sys,
 		cervices: components.Cervices{"switch": cer},
 	}
 
 	done := make(chan struct{})
-	go func() { tr.collectIngest("switch", 50*time.Millisecond, mwa); close(done) }()
+	go func() { tr.collectIngest("switch", 50*time.Millisecond, ms); close(done) }()
 	time.Sleep(150 * time.Millisecond)
 	cancel()
 	<-done
 
-	// No points should be written because the form type assertion failed every time.
-	if len(mwa.captured()) != 0 {
-		t.Errorf("expected 0 points for unsupported form, got %d", len(mwa.captured()))
+	// No points should be written because the form has nothing to store.
+	if len(ms.captured()) != 0 {
+		t.Errorf("expected 0 points for unsupported form, got %d", len(ms.captured()))
+	}
+}
+
+// ── formFields ────────────────────────────────────────────────────────────────
+
+func TestFormFields(t *testing.T) {
+	stamp := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	sigA := &forms.SignalA_v1a{Value: 1.5, Unit: "kPa", Timestamp: stamp}
+	fields, ts, unit, err := formFields(sigA)
+	if err != nil || fields["value"] != 1.5 || !ts.Equal(stamp) || unit != "kPa" {
+		t.Errorf("SignalA: %v %v %q %v", fields, ts, unit, err)
+	}
+
+	sigB := &forms.SignalB_v1a{Value: false, Timestamp: stamp}
+	fields, ts, _, err = formFields(sigB)
+	if err != nil || fields["value"] != false || !ts.Equal(stamp) {
+		t.Errorf("SignalB: %v %v %v", fields, ts, err)
+	}
+
+	// Other forms contribute their top-level numbers and booleans
+	msg := forms.NewSystemMessage_v1(forms.LevelWarn, "hot", "thermostat")
+	fields, ts, _, err = formFields(&msg)
+	if err != nil || fields["level"] != float64(forms.LevelWarn) || len(fields) != 1 || !ts.IsZero() {
+		t.Errorf("SystemMessage: %v %v %v", fields, ts, err)
+	}
+
+	reg := forms.NewMessengerRegistration_v1("http://localhost")
+	if _, _, _, err := formFields(&reg); err == nil {
+		t.Error("expected an error for a form without values")
 	}
 }
 
@@ -95,10 +95,37 @@ func main() {
 
 // serving handles the resources services. NOTE: it expects those names from the request URL path
 func serving(t *Traits, w http.ResponseWriter, r *http.Request, servicePath string) {
-	switch servicePath {
-	case "access":
-		t.readSignal(w, r)
+	switch t.Mode {
+	case modeRecord:
+		// A recording unit asset offers no services
+	case modePlant:
+		if t.Plant == nil {
+			break
+		}
+		switch servicePath {
+		case serviceName(t.Plant.Actuator):
+			t.actuator(w, r)
+			return
+		case serviceName(t.Plant.Output):
+			t.plantOutput(w, r)
+			return
+		}
 	default:
-		http.Error(w, "Invalid service request [Do not modify the services subpath in the configuration file]", http.StatusBadRequest)
+		switch servicePath {
+		case "access":
+			t.readSignal(w, r)
+			return
+		case "playback":
+			t.playback(w, r)
+			return
+		case "fault":
+			t.faultService(w, r)
+			return
+		}
+		if col, ok := t.columns[servicePath]; ok {
+			t.readColumn(w, r, col)
+			return
+		}
 	}
+	http.Error(w, "Invalid service request [Do not modify the services subpath in the configuration file]", http.StatusBadRequest)
 }
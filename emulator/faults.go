@@ -0,0 +1,375 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"mime"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// Fault modes
+const (
+	faultNoise   = "noise"   // additive Gaussian noise, magnitude is the standard deviation
+	faultDrift   = "drift"   // bias growing by magnitude per minute
+	faultStuck   = "stuck"   // value frozen at the one when the fault started, or at value
+	faultSpike   = "spike"   // magnitude added to a reading with the given probability
+	faultDropout = "dropout" // readings fail with the given probability; the service stays registered
+	faultLatency = "latency" // readings answered magnitude seconds late
+)
+
+var faultModes = map[string]bool{
+	faultNoise: true, faultDrift: true, faultStuck: true, faultSpike: true, faultDropout: true, faultLatency: true,
+}
+
+// Default probabilities per reading
+const (
+	defaultSpikeProbability   = 0.1
+	defaultDropoutProbability = 1
+)
+
+// errDropout is returned for a reading lost to a dropout fault. The signal
+// service answers it with 503 Service Unavailable: the service itself stays
+// registered, since the framework keeps re-registering it.
+var errDropout = errors.New("signal dropout")
+
+// FaultT describes a fault to inject into the replayed signals. A scheduled
+// fault starts at the wall time At, or After seconds from the system start; a
+// fault injected through the fault service starts at once unless At is given.
+type FaultT struct {
+	ID          string     `json:"id,omitempty" xml:"id,omitempty"`
+	Mode        string     `json:"mode" xml:"mode"`
+	Signal      string     `json:"signal,omitempty" xml:"signal,omitempty"` // service sub-path; all signals if empty
+	Magnitude   float64    `json:"magnitude,omitempty" xml:"magnitude,omitempty"`
+	Value       *float64   `json:"value,omitempty" xml:"value,omitempty"`             // stuck-at value
+	Probability float64    `json:"probability,omitempty" xml:"probability,omitempty"` // per reading, for spikes and dropouts
+	At          *time.Time `json:"at,omitempty" xml:"at,omitempty"`
+	After       float64    `json:"after,omitempty" xml:"after,omitempty"`       // seconds from the system start
+	Duration    float64    `json:"duration,omitempty" xml:"duration,omitempty"` // seconds; until cleared if zero
+	Active      bool       `json:"active" xml:"active"`
+}
+
+// fault is an injected fault with its resolved schedule.
+type fault struct {
+	FaultT
+	column int // signal column, or -1 for all
+	start  time.Time
+	end    time.Time // zero if the fault lasts until cleared
+	stuck  []float64 // values when a stuck fault started
+}
+
+// faultSet holds the faults of a unit asset. It is only used by the emulation loop.
+type faultSet struct {
+	faults []*fault
+	nextID int
+	rng    *rand.Rand
+	logTo  io.Writer // fault log, one JSON object per line; nil if not kept
+	name   string    // input file, for the log
+}
+
+// faultEvent is a line of the fault log.
+type faultEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"` // scheduled, started, ended or cleared
+	File  string    `json:"file"`
+	Fault FaultT    `json:"fault"`
+}
+
+// newFault validates a fault description and resolves its schedule. The
+// signals map service sub-paths to columns; origin is the system start.
+func newFault(ft FaultT, signals map[string]int, origin, now time.Time) (*fault, error) {
+	if !faultModes[ft.Mode] {
+		return nil, fmt.Errorf("unknown fault mode %q", ft.Mode)
+	}
+	if ft.Magnitude < 0 || ft.Probability < 0 || ft.Probability > 1 || ft.Duration < 0 {
+		return nil, fmt.Errorf("fault %s: magnitude, probability and duration must not be negative (probability at most 1)", ft.Mode)
+	}
+	f := &fault{FaultT: ft, column: -1}
+	if ft.Signal != "" {
+		col, ok := signals[ft.Signal]
+		if !ok {
+			return nil, fmt.Errorf("unknown signal %q", ft.Signal)
+		}
+		f.column = col
+	}
+	switch {
+	case ft.At != nil:
+		f.start = *ft.At
+	case ft.After > 0:
+		f.start = origin.Add(seconds(ft.After))
+	default:
+		f.start = now
+	}
+	if ft.Duration > 0 {
+		f.end = f.start.Add(seconds(ft.Duration))
+	}
+	f.Active = false
+	return f, nil
+}
+
+// add schedules a fault.
+func (fs *faultSet) add(f *fault, now time.Time) {
+	if f.ID == "" {
+		fs.nextID++
+		f.ID = fmt.Sprintf("f%d", fs.nextID)
+	}
+	fs.faults = append(fs.faults, f)
+	fs.record(now, "scheduled", f)
+}
+
+// clear ends the faults with the given ID, or all faults if id is empty.
+func (fs *faultSet) clear(id string, now time.Time) error {
+	found := false
+	kept := fs.faults[:0]
+	for _, f := range fs.faults {
+		if id == "" || f.ID == id {
+			found = true
+			f.Active = false
+			fs.record(now, "cleared", f)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	fs.faults = kept
+	if id != "" && !found {
+		return fmt.Errorf("no fault %q", id)
+	}
+	return nil
+}
+
+// update starts and ends faults according to their schedule. The current
+// signal values are those frozen by a stuck fault.
+func (fs *faultSet) update(now time.Time, current []float64) {
+	kept := fs.faults[:0]
+	for _, f := range fs.faults {
+		if !f.Active && !now.Before(f.start) && (f.end.IsZero() || now.Before(f.end)) {
+			f.Active = true
+			f.stuck = append([]float64(nil), current...)
+			fs.record(now, "started", f)
+		}
+		if !f.end.IsZero() && !now.Before(f.end) {
+			f.Active = false
+			fs.record(now, "ended", f)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	fs.faults = kept
+}
+
+// apply corrupts a reading of a signal column. It returns the reading, how
+// late it is to be answered, or errDropout if it is lost.
+func (fs *faultSet) apply(col int, v float64, now time.Time) (float64, time.Duration, error) {
+	var delay time.Duration
+	for _, f := range fs.faults {
+		if !f.Active || (f.column >= 0 && f.column != col) {
+			continue
+		}
+		switch f.Mode {
+		case faultNoise:
+			v += fs.rng.NormFloat64() * f.Magnitude
+		case faultDrift:
+			v += f.Magnitude * now.Sub(f.start).Minutes()
+		case faultStuck:
+			v = f.stuck[col]
+			if f.Value != nil {
+				v = *f.Value
+			}
+		case faultSpike:
+			if fs.rng.Float64() < probability(f.Probability, defaultSpikeProbability) {
+				v += f.Magnitude
+			}
+		case faultDropout:
+			if fs.rng.Float64() < probability(f.Probability, defaultDropoutProbability) {
+				return v, delay, errDropout
+			}
+		case faultLatency:
+			delay += seconds(f.Magnitude)
+		}
+	}
+	return v, delay, nil
+}
+
+// list returns the faults that are scheduled or active.
+func (fs *faultSet) list() []FaultT {
+	out := make([]FaultT, 0, len(fs.faults))
+	for _, f := range fs.faults {
+		out = append(out, f.FaultT)
+	}
+	return out
+}
+
+// record logs a fault event, and appends it to the fault log if one is kept.
+func (fs *faultSet) record(now time.Time, event string, f *fault) {
+	target := "all signals"
+	if f.Signal != "" {
+		target = f.Signal
+	}
+	log.Printf("fault %s %s: %s on %s of %s (magnitude %g)\n", f.ID, event, f.Mode, target, fs.name, f.Magnitude)
+	if fs.logTo == nil {
+		return
+	}
+	line, err := json.Marshal(faultEvent{Time: now, Event: event, File: fs.name, Fault: f.FaultT})
+	if err != nil {
+		return
+	}
+	if _, err := fs.logTo.Write(append(line, '\n')); err != nil {
+		log.Printf("unable to write the fault log: %v\n", err)
+	}
+}
+
+// openFaultLog opens the fault log for appending.
+func openFaultLog(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+}
+
+func probability(p, def float64) float64 {
+	if p == 0 {
+		return def
+	}
+	return p
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+//-------------------------------------Fault form
+
+// Faults_v1 is the exchanged form of the fault service. A PUT carries an
+// action: "inject" the listed faults, or "clear" the fault with the given ID
+// (all faults if no ID is given).
+type Faults_v1 struct {
+	Action  string   `json:"action,omitempty" xml:"action,omitempty"`
+	ID      string   `json:"id,omitempty" xml:"id,omitempty"`
+	Faults  []FaultT `json:"faults" xml:"fault"`
+	Version string   `json:"version" xml:"version"`
+}
+
+func (f *Faults_v1) NewForm() forms.Form {
+	f.Version = "Faults_v1"
+	return f
+}
+
+func (f *Faults_v1) FormVersion() string {
+	return f.Version
+}
+
+func init() {
+	forms.FormTypeMap["Faults_v1"] = reflect.TypeOf(Faults_v1{})
+}
+
+//-------------------------------------Service handler
+
+// faultService lists (GET) or injects and clears (PUT) faults.
+func (t *Traits) faultService(w http.ResponseWriter, r *http.Request) {
+	order := STray{
+		Action:  "fault",
+		FaultsP: make(chan Faults_v1, 1),
+		Error:   make(chan error, 1),
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, "could not parse Content-Type: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		unpacked, err := usecases.Unpack(body, mediaType)
+		if err != nil {
+			http.Error(w, "unpacking request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cmd, ok := unpacked.(*Faults_v1)
+		if !ok {
+			http.Error(w, "expected Faults_v1 body", http.StatusBadRequest)
+			return
+		}
+		if cmd.Action != "inject" && cmd.Action != "clear" {
+			http.Error(w, "action must be inject or clear", http.StatusBadRequest)
+			return
+		}
+		order.Faults = *cmd
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+		return
+	}
+
+	select {
+	case t.trayChan <- order:
+	case <-r.Context().Done():
+		http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+		return
+	case <-time.After(1 * time.Second):
+		http.Error(w, "Asset busy", http.StatusGatewayTimeout)
+		return
+	}
+	select {
+	case err := <-order.Error:
+		http.Error(w, "Invalid fault request: "+err.Error(), http.StatusBadRequest)
+	case list := <-order.FaultsP:
+		usecases.HTTPProcessGetRequest(w, r, &list)
+	case <-time.After(5 * time.Second):
+		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+	}
+}
+
+// handle applies a fault request in the emulation loop. Either all the
+// faults of an inject request are scheduled, or none.
+func (fs *faultSet) handle(cmd Faults_v1, signals map[string]int, origin, now time.Time) error {
+	switch cmd.Action {
+	case "inject":
+		var added []*fault
+		for _, ft := range cmd.Faults {
+			f, err := newFault(ft, signals, origin, now)
+			if err != nil {
+				return err
+			}
+			added = append(added, f)
+		}
+		for _, f := range added {
+			fs.add(f, now)
+		}
+	case "clear":
+		return fs.clear(cmd.ID, now)
+	}
+	return nil
+}
+
+// form returns the fault list in a fault form.
+func (fs *faultSet) form() (f Faults_v1) {
+	f.NewForm()
+	f.Faults = fs.list()
+	return f
+}
@@ -0,0 +1,213 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSignals = map[string]int{"access": 0, "pressure": 0, "level": 1}
+
+// inject schedules a fault at wall0, failing the test if it is invalid.
+func inject(t *testing.T, fs *faultSet, ft FaultT) {
+	t.Helper()
+	f, err := newFault(ft, testSignals, wall0, wall0)
+	if err != nil {
+		t.Fatalf("newFault: %v", err)
+	}
+	fs.add(f, wall0)
+}
+
+func newTestFaults() *faultSet {
+	return &faultSet{rng: rand.New(rand.NewSource(1)), name: "test.csv"}
+}
+
+// ── fault modes ───────────────────────────────────────────────────────────────
+
+func TestFaultModes(t *testing.T) {
+	stuckAt := -1.0
+	for _, tc := range []struct {
+		fault FaultT
+		col   int
+		want  float64
+	}{
+		{FaultT{Mode: faultDrift, Magnitude: 0.5}, 0, 10 + 0.5*2}, // two minutes in
+		{FaultT{Mode: faultStuck}, 0, 7},                          // value when the fault started
+		{FaultT{Mode: faultStuck, Value: &stuckAt}, 0, -1},
+		{FaultT{Mode: faultSpike, Magnitude: 100, Probability: 1}, 0, 110},
+		{FaultT{Mode: faultSpike, Magnitude: 100, Probability: 1, Signal: "level"}, 0, 10}, // other signal
+	} {
+		fs := newTestFaults()
+		inject(t, fs, tc.fault)
+		fs.update(wall0, []float64{7, 70})
+		got, _, err := fs.apply(tc.col, 10, wall0.Add(2*time.Minute))
+		if err != nil || got != tc.want {
+			t.Errorf("%+v: reading = %v (%v), want %v", tc.fault, got, err, tc.want)
+		}
+	}
+
+	// Noise has the configured spread
+	fs := newTestFaults()
+	inject(t, fs, FaultT{Mode: faultNoise, Magnitude: 2})
+	fs.update(wall0, []float64{0, 0})
+	var sum, sumSq float64
+	for range 5000 {
+		v, _, _ := fs.apply(0, 10, wall0)
+		sum += v
+		sumSq += v * v
+	}
+	mean := sum / 5000
+	std := math.Sqrt(sumSq/5000 - mean*mean)
+	if math.Abs(mean-10) > 0.2 || math.Abs(std-2) > 0.2 {
+		t.Errorf("noise mean %.2f std %.2f, want 10 and 2", mean, std)
+	}
+}
+
+func TestFaultDropoutAndLatency(t *testing.T) {
+	fs := newTestFaults()
+	inject(t, fs, FaultT{Mode: faultDropout, Signal: "pressure"})
+	inject(t, fs, FaultT{Mode: faultLatency, Magnitude: 1.5})
+	fs.update(wall0, []float64{0, 0})
+	if _, _, err := fs.apply(0, 1, wall0); !errors.Is(err, errDropout) {
+		t.Errorf("pressure reading: err = %v, want a dropout", err)
+	}
+	if _, delay, err := fs.apply(1, 1, wall0); err != nil || delay != 1500*time.Millisecond {
+		t.Errorf("level reading: delay %v, err %v", delay, err)
+	}
+}
+
+// ── schedule and log ──────────────────────────────────────────────────────────
+
+func TestFaultSchedule(t *testing.T) {
+	var logBuf bytes.Buffer
+	fs := newTestFaults()
+	fs.logTo = &logBuf
+	inject(t, fs, FaultT{ID: "bias", Mode: faultDrift, Magnitude: 1, After: 60, Duration: 120})
+
+	fs.update(wall0.Add(59*time.Second), []float64{0, 0})
+	if v, _, _ := fs.apply(0, 5, wall0.Add(59*time.Second)); v != 5 {
+		t.Errorf("reading before the fault = %v, want 5", v)
+	}
+	fs.update(wall0.Add(2*time.Minute), []float64{0, 0})
+	if v, _, _ := fs.apply(0, 5, wall0.Add(2*time.Minute)); v != 6 {
+		t.Errorf("reading one minute into the drift = %v, want 6", v)
+	}
+	fs.update(wall0.Add(3*time.Minute), []float64{0, 0})
+	if v, _, _ := fs.apply(0, 5, wall0.Add(3*time.Minute)); v != 5 || len(fs.list()) != 0 {
+		t.Errorf("after the fault: reading %v with %d faults left", v, len(fs.list()))
+	}
+
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(logBuf.String()), "\n") {
+		var ev faultEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("fault log line %q: %v", line, err)
+		}
+		events = append(events, ev.Event+"@"+ev.Time.Sub(wall0).String())
+	}
+	if got := strings.Join(events, " "); got != "scheduled@0s started@2m0s ended@3m0s" {
+		t.Errorf("fault log = %s", got)
+	}
+}
+
+func TestNewFaultValidation(t *testing.T) {
+	for _, ft := range []FaultT{
+		{Mode: "gremlins"},
+		{Mode: faultNoise, Magnitude: -1},
+		{Mode: faultSpike, Probability: 2},
+		{Mode: faultStuck, Signal: "flow"},
+	} {
+		if _, err := newFault(ft, testSignals, wall0, wall0); err == nil {
+			t.Errorf("%+v: expected an error", ft)
+		}
+	}
+}
+
+// ── fault service ─────────────────────────────────────────────────────────────
+
+func putFaults(t *testing.T, tr *Traits, body string) (*httptest.ResponseRecorder, Faults_v1) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, "/emulator/signal/fault", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	serving(tr, w, req, "fault")
+	var list Faults_v1
+	json.Unmarshal(w.Body.Bytes(), &list)
+	return w, list
+}
+
+func TestFaultService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logPath := filepath.Join(t.TempDir(), "faults.jsonl")
+	tr := &Traits{
+		InputFile:     writeFile(t, "data.csv", sampleCSV),
+		PlaybackSpeed: 1,
+		FaultLog:      logPath,
+		trayChan:      make(chan STray),
+	}
+	go tr.emulateAsset(ctx, nil)
+
+	read := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/emulator/signal/access", nil)
+		w := httptest.NewRecorder()
+		serving(tr, w, req, "access")
+		return w.Code
+	}
+
+	w, list := putFaults(t, tr, `{"action":"inject","faults":[{"id":"lost","mode":"dropout","signal":"access"}],"version":"Faults_v1"}`)
+	if w.Code != http.StatusOK || len(list.Faults) != 1 || !list.Faults[0].Active {
+		t.Fatalf("inject: %d %+v", w.Code, list)
+	}
+	if code := read(); code != http.StatusServiceUnavailable {
+		t.Errorf("reading during a dropout: status = %d, want 503", code)
+	}
+
+	// An invalid fault rejects the whole request
+	w, _ = putFaults(t, tr, `{"action":"inject","faults":[{"mode":"noise","magnitude":1},{"mode":"gremlins"}],"version":"Faults_v1"}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid fault: status = %d, want 400", w.Code)
+	}
+
+	w, list = putFaults(t, tr, `{"action":"clear","id":"lost","version":"Faults_v1"}`)
+	if w.Code != http.StatusOK || len(list.Faults) != 0 {
+		t.Errorf("clear: %d %+v", w.Code, list)
+	}
+	if code := read(); code != http.StatusOK {
+		t.Errorf("reading after clearing: status = %d, want 200", code)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("fault log: %v", err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 3 {
+		t.Errorf("fault log has %d events, want scheduled, started and cleared:\n%s", n, data)
+	}
+}
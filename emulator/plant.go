@@ -0,0 +1,277 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// PlantT configures an emulated actuator driving a first-order-plus-dead-time
+// plant: after the dead time, the output moves towards bias + gain × actuator
+// with the time constant.
+type PlantT struct {
+	Actuator     string   `json:"actuator"`     // service definition of the actuator, e.g. rotation, pumpSpeed or OnOff
+	ActuatorUnit string   `json:"actuatorUnit"` // e.g. Percent
+	Switch       bool     `json:"switch"`       // the actuator is switched on (1) or off (0) with SignalB_v1a forms
+	Output       string   `json:"output"`       // service definition of the plant output, e.g. temperature or level
+	OutputUnit   string   `json:"outputUnit"`   // e.g. Celsius
+	Gain         float64  `json:"gain"`         // steady-state output change per actuator unit
+	TimeConstant float64  `json:"timeConstant"` // seconds
+	DeadTime     float64  `json:"deadTime"`     // seconds
+	Bias         float64  `json:"bias"`         // output with the actuator at 0, e.g. the ambient temperature
+	Initial      *float64 `json:"initial,omitempty"`
+	Min          *float64 `json:"min,omitempty"` // output limits, e.g. an empty or full tank
+	Max          *float64 `json:"max,omitempty"`
+	Speed        float64  `json:"speed"` // simulated seconds per second: 1 = real-time
+}
+
+// inputChange is an actuator setting made at a simulated time.
+type inputChange struct {
+	at float64 // simulated seconds
+	u  float64
+}
+
+// plant integrates the model exactly, the delayed actuator setting being
+// constant between changes. It is only used by the simulation loop.
+type plant struct {
+	cfg    PlantT
+	y      float64
+	now    float64       // simulated seconds since the start
+	inputs []inputChange // settings in time order; the first is in effect at now - dead time
+}
+
+func newPlant(cfg PlantT) *plant {
+	p := &plant{cfg: cfg, y: cfg.Bias, inputs: []inputChange{{at: math.Inf(-1)}}}
+	if cfg.Initial != nil {
+		p.y = *cfg.Initial
+	}
+	p.y = p.clamp(p.y)
+	return p
+}
+
+// input returns the latest actuator setting.
+func (p *plant) input() float64 {
+	return p.inputs[len(p.inputs)-1].u
+}
+
+// set changes the actuator setting now.
+func (p *plant) set(u float64) {
+	p.inputs = append(p.inputs, inputChange{at: p.now, u: u})
+}
+
+// advance moves the simulation d simulated seconds forward.
+func (p *plant) advance(d float64) {
+	for d > 0 {
+		// The setting acting on the plant, and when the next one takes over
+		i := 0
+		for i+1 < len(p.inputs) && p.inputs[i+1].at+p.cfg.DeadTime <= p.now {
+			i++
+		}
+		h := d
+		if i+1 < len(p.inputs) {
+			h = math.Min(d, p.inputs[i+1].at+p.cfg.DeadTime-p.now)
+		}
+		target := p.cfg.Bias + p.cfg.Gain*p.inputs[i].u
+		if p.cfg.TimeConstant > 0 {
+			p.y = target + (p.y-target)*math.Exp(-h/p.cfg.TimeConstant)
+		} else {
+			p.y = target
+		}
+		p.y = p.clamp(p.y)
+		p.now += h
+		d -= h
+		p.inputs = p.inputs[i:]
+	}
+}
+
+func (p *plant) clamp(y float64) float64 {
+	if p.cfg.Min != nil && y < *p.cfg.Min {
+		return *p.cfg.Min
+	}
+	if p.cfg.Max != nil && y > *p.cfg.Max {
+		return *p.cfg.Max
+	}
+	return y
+}
+
+//-------------------------------------Plant services
+
+// addPlantServices adds the actuator and output services, with the unit
+// asset's details so that consumers find them as they would the real ones.
+func (t *Traits) addPlantServices(services components.Services, details map[string][]string) {
+	actuatorForm := "SignalA_v1a"
+	if t.Plant.Switch {
+		actuatorForm = "SignalB_v1a"
+	}
+	services[serviceName(t.Plant.Actuator)] = &components.Service{
+		Definition:  t.Plant.Actuator,
+		SubPath:     serviceName(t.Plant.Actuator),
+		Details:     components.MergeDetails(details, map[string][]string{"Unit": {t.Plant.ActuatorUnit}, "Forms": {actuatorForm}}),
+		RegPeriod:   30,
+		Description: "provides (GET) or sets (PUT) the emulated actuator driving the plant model",
+	}
+	services[serviceName(t.Plant.Output)] = &components.Service{
+		Definition:  t.Plant.Output,
+		SubPath:     serviceName(t.Plant.Output),
+		Details:     components.MergeDetails(details, map[string][]string{"Unit": {t.Plant.OutputUnit}, "Forms": {"SignalA_v1a"}}),
+		RegPeriod:   30,
+		Description: "provides the output of the plant model",
+	}
+}
+
+// simulatePlant runs the plant model, advancing it to the present whenever
+// the actuator or the output is requested.
+func (t *Traits) simulatePlant(ctx context.Context) {
+	p := newPlant(*t.Plant)
+	speed := t.Plant.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case order := <-t.trayChan:
+			now := time.Now()
+			p.advance(now.Sub(last).Seconds() * speed)
+			last = now
+			switch order.Action {
+			case "actuator":
+				if order.Input != nil {
+					p.set(*order.Input)
+					log.Printf("%s set to %g %s\n", t.Plant.Actuator, *order.Input, t.Plant.ActuatorUnit)
+				}
+				order.FormP <- t.actuatorForm(p.input(), now)
+			case "output":
+				var f forms.SignalA_v1a
+				f.NewForm()
+				f.Value = p.y
+				f.Unit = t.Plant.OutputUnit
+				f.Timestamp = now
+				order.FormP <- &f
+			default:
+				order.Error <- fmt.Errorf("the plant has no %s service", order.Action)
+			}
+		}
+	}
+}
+
+// actuatorForm returns the actuator setting as a signal, or a switch state.
+func (t *Traits) actuatorForm(u float64, now time.Time) forms.Form {
+	if t.Plant.Switch {
+		var f forms.SignalB_v1a
+		f.NewForm()
+		f.Value = u != 0
+		f.Timestamp = now
+		return &f
+	}
+	var f forms.SignalA_v1a
+	f.NewForm()
+	f.Value = u
+	f.Unit = t.Plant.ActuatorUnit
+	f.Timestamp = now
+	return &f
+}
+
+// actuator reads (GET) or sets (PUT) the emulated actuator. A setting is a
+// SignalA_v1a value, or a SignalB_v1a state for on (1) and off (0).
+func (t *Traits) actuator(w http.ResponseWriter, r *http.Request) {
+	order := STray{Action: "actuator"}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, "could not parse Content-Type: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		unpacked, err := usecases.Unpack(body, mediaType)
+		if err != nil {
+			http.Error(w, "unpacking request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var u float64
+		switch sig := unpacked.(type) {
+		case *forms.SignalA_v1a:
+			u = sig.Value
+		case *forms.SignalB_v1a:
+			if sig.Value {
+				u = 1
+			}
+		default:
+			http.Error(w, "expected SignalA_v1a or SignalB_v1a body", http.StatusBadRequest)
+			return
+		}
+		if t.Plant.Switch && u != 0 {
+			u = 1
+		}
+		order.Input = &u
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+		return
+	}
+	t.plantOrder(w, r, order)
+}
+
+// plantOutput provides the output of the plant model.
+func (t *Traits) plantOutput(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+		return
+	}
+	t.plantOrder(w, r, STray{Action: "output"})
+}
+
+// plantOrder hands an order to the simulation loop and answers with its form.
+func (t *Traits) plantOrder(w http.ResponseWriter, r *http.Request, order STray) {
+	order.FormP = make(chan forms.Form, 1)
+	order.Error = make(chan error, 1)
+	select {
+	case t.trayChan <- order:
+	case <-r.Context().Done():
+		http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+		return
+	case <-time.After(1 * time.Second):
+		http.Error(w, "Asset busy", http.StatusGatewayTimeout)
+		return
+	}
+	select {
+	case err := <-order.Error:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case f := <-order.FormP:
+		usecases.HTTPProcessGetRequest(w, r, f)
+	case <-time.After(5 * time.Second):
+		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+	}
+}
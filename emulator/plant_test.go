@@ -0,0 +1,153 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var heater = PlantT{
+	Actuator: "rotation", ActuatorUnit: "Percent",
+	Output: "temperature", OutputUnit: "Celsius",
+	Gain: 0.2, TimeConstant: 600, DeadTime: 30, Bias: 20,
+}
+
+// ── plant model ───────────────────────────────────────────────────────────────
+
+func TestPlantStepResponse(t *testing.T) {
+	p := newPlant(heater)
+	p.set(50) // steady state 20 + 0.2 × 50 = 30 °C
+	p.advance(29)
+	if p.y != 20 {
+		t.Errorf("output within the dead time = %v, want 20", p.y)
+	}
+	p.advance(1 + 600) // one time constant after the dead time
+	if want := 20 + 10*(1-math.Exp(-1)); math.Abs(p.y-want) > 1e-9 {
+		t.Errorf("output one time constant in = %v, want %v", p.y, want)
+	}
+	p.advance(10 * 600)
+	if math.Abs(p.y-30) > 1e-3 {
+		t.Errorf("steady state = %v, want 30", p.y)
+	}
+}
+
+func TestPlantDelayedSettings(t *testing.T) {
+	// Advancing in one go or in small steps gives the same output
+	one, many := newPlant(heater), newPlant(heater)
+	for _, p := range []*plant{one, many} {
+		p.set(100)
+	}
+	one.advance(10)
+	many.advance(10)
+	for _, p := range []*plant{one, many} {
+		p.set(0) // switched off before the first setting reached the plant
+	}
+	one.advance(900)
+	for range 900 {
+		many.advance(1)
+	}
+	want := 20 + 20*(1-math.Exp(-10.0/600))*math.Exp(-(910-40.0)/600)
+	if math.Abs(one.y-want) > 1e-9 || math.Abs(many.y-want) > 1e-9 {
+		t.Errorf("outputs %v and %v, want %v", one.y, many.y, want)
+	}
+	if len(many.inputs) != 1 {
+		t.Errorf("%d settings kept, want only the one in effect", len(many.inputs))
+	}
+}
+
+func TestPlantLimits(t *testing.T) {
+	full, empty := 100.0, 0.0
+	p := newPlant(PlantT{Gain: 2, TimeConstant: 10, Bias: 50, Min: &empty, Max: &full})
+	p.set(100)
+	p.advance(1000)
+	if p.y != 100 {
+		t.Errorf("output = %v, want it held at the maximum", p.y)
+	}
+}
+
+// ── closed loop through the services ──────────────────────────────────────────
+
+func plantRequest(t *testing.T, tr *Traits, method, path, body string) map[string]any {
+	t.Helper()
+	req := httptest.NewRequest(method, "/emulator/heater/"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	serving(tr, w, req, path)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s %s: status %d: %s", method, path, w.Code, w.Body.String())
+	}
+	var f map[string]any
+	json.Unmarshal(w.Body.Bytes(), &f)
+	return f
+}
+
+func TestPlantClosedLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := heater
+	cfg.Speed = 36000 // ten simulated hours per second
+	tr := &Traits{Mode: modePlant, Plant: &cfg, trayChan: make(chan STray)}
+	go tr.simulatePlant(ctx)
+
+	// A proportional controller, as the thermostat's, holds 25 °C with an offset
+	const setpoint, kp = 25.0, 20.0
+	var temp float64
+	for range 200 {
+		temp = plantRequest(t, tr, http.MethodGet, "temperature", "")["value"].(float64)
+		rot := math.Max(0, math.Min(100, kp*(setpoint-temp)))
+		plantRequest(t, tr, http.MethodPut, "rotation",
+			fmt.Sprintf(`{"value":%g,"unit":"Percent","version":"SignalA_v1.0"}`, rot))
+		time.Sleep(2 * time.Millisecond)
+	}
+	// steady state: 20 + 0.2 × 20 × (25 − T) = T, so T = 24.0
+	if math.Abs(temp-24) > 0.5 {
+		t.Errorf("closed-loop temperature = %.2f, want about 24", temp)
+	}
+	if rot := plantRequest(t, tr, http.MethodGet, "rotation", "")["value"].(float64); rot <= 0 {
+		t.Errorf("rotation = %v", rot)
+	}
+}
+
+func TestPlantSwitch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := heater
+	cfg.Actuator, cfg.Switch, cfg.Gain = "OnOff", true, 5
+	tr := &Traits{Mode: modePlant, Plant: &cfg, trayChan: make(chan STray)}
+	go tr.simulatePlant(ctx)
+
+	f := plantRequest(t, tr, http.MethodPut, "OnOff", `{"value":true,"version":"SignalB_v1.0"}`)
+	if f["value"] != true {
+		t.Errorf("switch state = %v, want on", f["value"])
+	}
+
+	// The replay services are not offered by a plant
+	req := httptest.NewRequest(http.MethodGet, "/emulator/heater/access", nil)
+	w := httptest.NewRecorder()
+	serving(tr, w, req, "access")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("replay service of a plant: status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,333 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// Interpolation and end-of-file options, as used in the traits
+const (
+	interpStep   = "step"   // hold each recorded value until the next one
+	interpLinear = "linear" // interpolate between recorded values
+	endLoop      = "loop"   // start over at the end of the recording
+	endStop      = "stop"   // hold the last recorded values at the end
+)
+
+// Playback states
+const (
+	statePlaying = "playing"
+	statePaused  = "paused"
+	stateEnded   = "ended"
+)
+
+//-------------------------------------Playback head
+
+// playhead tracks the replay position in recorded time. The position advances
+// with the wall clock, scaled by the speed, from an anchor that is reset by
+// every pause, resume, seek or speed change. Offsets are measured from the
+// first recorded timestamp.
+type playhead struct {
+	times  []time.Duration // recorded offset of each row, ascending
+	cycle  time.Duration   // length of one pass through the recording when looping
+	loop   bool
+	speed  float64
+	paused bool
+
+	anchorPos  time.Duration // unwrapped position at anchorWall
+	anchorWall time.Time
+	since      time.Time // last start or seek, before which no row became current
+}
+
+// newPlayhead prepares the replay of rows with the given timestamps. Unparsable
+// timestamps are replaced by a regular one-second spacing. When looping, the
+// last row is held for the hold interval before starting over. The recording
+// starts playing at now.
+func newPlayhead(stamps []string, hold time.Duration, speed float64, loop bool, now time.Time) (*playhead, time.Time) {
+	first := time.Time{}
+	p := &playhead{times: make([]time.Duration, len(stamps)), loop: loop, speed: speed}
+	for i, s := range stamps {
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			first = time.Time{}
+			for j := range p.times {
+				p.times[j] = time.Duration(j) * time.Second
+			}
+			break
+		}
+		if i == 0 {
+			first = ts
+		}
+		p.times[i] = ts.Sub(first)
+	}
+	if !sort.SliceIsSorted(p.times, func(i, j int) bool { return p.times[i] < p.times[j] }) {
+		log.Printf("timestamps are not in chronological order; replaying in file order")
+		for j := range p.times {
+			p.times[j] = time.Duration(j) * time.Second
+		}
+	}
+
+	p.cycle = p.times[len(p.times)-1] + hold
+	if p.speed <= 0 {
+		p.speed = 1
+	}
+	p.anchorWall, p.since = now, now
+	return p, first
+}
+
+// unwrapped returns the position at now, counting the passes already looped.
+func (p *playhead) unwrapped(now time.Time) time.Duration {
+	if p.paused {
+		return p.anchorPos
+	}
+	return p.anchorPos + time.Duration(float64(now.Sub(p.anchorWall))*p.speed)
+}
+
+// position returns the recorded offset being replayed at now, the number of
+// completed passes and whether a non-looping replay has reached the end.
+func (p *playhead) position(now time.Time) (pos time.Duration, pass int64, ended bool) {
+	pos = p.unwrapped(now)
+	end := p.times[len(p.times)-1]
+	switch {
+	case p.loop:
+		pass = int64(pos / p.cycle)
+		pos -= time.Duration(pass) * p.cycle
+	case pos >= end:
+		pos, ended = end, true
+	}
+	return pos, pass, ended
+}
+
+// index returns the row current at a recorded offset.
+func (p *playhead) index(pos time.Duration) int {
+	return max(sort.Search(len(p.times), func(i int) bool { return p.times[i] > pos })-1, 0)
+}
+
+// reanchor freezes the current position as the new anchor.
+func (p *playhead) reanchor(now time.Time) {
+	p.anchorPos = p.unwrapped(now)
+	p.anchorWall = now
+}
+
+func (p *playhead) pause(now time.Time) {
+	p.reanchor(now)
+	p.paused = true
+}
+
+func (p *playhead) resume(now time.Time) {
+	p.reanchor(now)
+	p.paused = false
+}
+
+func (p *playhead) setSpeed(speed float64, now time.Time) {
+	p.reanchor(now)
+	p.speed = speed
+}
+
+// seek moves to a recorded offset, clamped to the recording.
+func (p *playhead) seek(pos time.Duration, now time.Time) {
+	p.anchorPos = min(max(pos, 0), p.times[len(p.times)-1])
+	p.anchorWall, p.since = now, now
+}
+
+// rowStamp returns the wall time at which a row of the given pass became current.
+func (p *playhead) rowStamp(row int, pass int64) time.Time {
+	recorded := p.times[row] + time.Duration(pass)*p.cycle
+	stamp := p.anchorWall.Add(time.Duration(float64(recorded-p.anchorPos) / p.speed))
+	if stamp.Before(p.since) {
+		return p.since
+	}
+	return stamp
+}
+
+// values returns the signal values of the recording at now, with their time stamp.
+func (p *playhead) values(rows []Row, interpolation string, now time.Time) ([]float64, time.Time) {
+	pos, pass, ended := p.position(now)
+	i := p.index(pos)
+	if interpolation != interpLinear || ended || i+1 >= len(rows) || p.times[i+1] == p.times[i] {
+		return rows[i].Values, p.rowStamp(i, pass)
+	}
+	w := float64(pos-p.times[i]) / float64(p.times[i+1]-p.times[i])
+	values := make([]float64, len(rows[i].Values))
+	for c := range values {
+		values[c] = rows[i].Values[c] + w*(rows[i+1].Values[c]-rows[i].Values[c])
+	}
+	if p.paused {
+		return values, p.anchorWall
+	}
+	return values, now
+}
+
+//-------------------------------------Playback form
+
+// Playback_v1 is the exchanged form of the playback control service. A PUT
+// carries an action: "pause", "resume", "seek" (to position) or "speed".
+type Playback_v1 struct {
+	Action        string    `json:"action,omitempty" xml:"action,omitempty"`
+	State         string    `json:"state" xml:"state"`
+	Position      time.Time `json:"position" xml:"position"` // recorded time being replayed
+	Start         time.Time `json:"start" xml:"start"`       // first recorded time
+	End           time.Time `json:"end" xml:"end"`           // last recorded time
+	Speed         float64   `json:"speed" xml:"speed"`
+	Interpolation string    `json:"interpolation" xml:"interpolation"`
+	AtEnd         string    `json:"atEnd" xml:"atEnd"`
+	Version       string    `json:"version" xml:"version"`
+}
+
+func (f *Playback_v1) NewForm() forms.Form {
+	f.Version = "Playback_v1"
+	return f
+}
+
+func (f *Playback_v1) FormVersion() string {
+	return f.Version
+}
+
+func init() {
+	forms.FormTypeMap["Playback_v1"] = reflect.TypeOf(Playback_v1{})
+}
+
+// playbackState fills out the playback form.
+func (t *Traits) playbackState(p *playhead, first time.Time, now time.Time) (f Playback_v1) {
+	f.NewForm()
+	pos, _, ended := p.position(now)
+	switch {
+	case ended:
+		f.State = stateEnded
+	case p.paused:
+		f.State = statePaused
+	default:
+		f.State = statePlaying
+	}
+	f.Position = first.Add(pos)
+	f.Start = first
+	f.End = first.Add(p.times[len(p.times)-1])
+	f.Speed = p.speed
+	f.Interpolation = t.interpolation()
+	f.AtEnd = t.atEnd()
+	return f
+}
+
+// control applies a playback command.
+func (t *Traits) control(p *playhead, first time.Time, cmd Playback_v1, now time.Time) error {
+	switch cmd.Action {
+	case "":
+	case "pause":
+		p.pause(now)
+	case "resume":
+		p.resume(now)
+	case "seek":
+		if cmd.Position.IsZero() {
+			return fmt.Errorf("seek needs a position")
+		}
+		p.seek(cmd.Position.Sub(first), now)
+	case "speed":
+		if cmd.Speed <= 0 {
+			return fmt.Errorf("speed must be positive")
+		}
+		p.setSpeed(cmd.Speed, now)
+	default:
+		return fmt.Errorf("unknown action %q (pause, resume, seek or speed)", cmd.Action)
+	}
+	if cmd.Action != "" {
+		log.Printf("playback of %s: %s\n", t.InputFile, cmd.Action)
+	}
+	return nil
+}
+
+// interpolation returns the configured interpolation, step by default.
+func (t *Traits) interpolation() string {
+	if t.Interpolation == interpLinear {
+		return interpLinear
+	}
+	return interpStep
+}
+
+// atEnd returns the configured end-of-file behaviour, loop by default.
+func (t *Traits) atEnd() string {
+	if t.AtEnd == endStop {
+		return endStop
+	}
+	return endLoop
+}
+
+//-------------------------------------Service handler
+
+// playback reports (GET) or controls (PUT) the replay of the input file.
+func (t *Traits) playback(w http.ResponseWriter, r *http.Request) {
+	order := STray{
+		Action: "playback",
+		StateP: make(chan Playback_v1, 1),
+		Error:  make(chan error, 1),
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, "could not parse Content-Type: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		unpacked, err := usecases.Unpack(body, mediaType)
+		if err != nil {
+			http.Error(w, "unpacking request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		cmd, ok := unpacked.(*Playback_v1)
+		if !ok {
+			http.Error(w, "expected Playback_v1 body", http.StatusBadRequest)
+			return
+		}
+		order.Command = *cmd
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+		return
+	}
+
+	select {
+	case t.trayChan <- order:
+	case <-r.Context().Done():
+		http.Error(w, "Request cancelled", http.StatusRequestTimeout)
+		return
+	case <-time.After(1 * time.Second):
+		http.Error(w, "Asset busy", http.StatusGatewayTimeout)
+		return
+	}
+	select {
+	case err := <-order.Error:
+		http.Error(w, "Invalid playback command: "+err.Error(), http.StatusBadRequest)
+	case state := <-order.StateP:
+		usecases.HTTPProcessGetRequest(w, r, &state)
+	case <-time.After(5 * time.Second):
+		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+	}
+}
@@ -0,0 +1,210 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// irregularRows are recorded at 0 s, 10 s, 40 s (after a gap) and 50 s.
+var irregularRows = []Row{
+	{"2026-03-09T10:00:00Z", []float64{0, 100}},
+	{"2026-03-09T10:00:10Z", []float64{10, 110}},
+	{"2026-03-09T10:00:40Z", []float64{40, 140}},
+	{"2026-03-09T10:00:50Z", []float64{50, 150}},
+}
+
+var wall0 = time.Date(2026, 6, 1, 8, 0, 0, 0, time.UTC)
+
+func newTestHead(speed float64, loop bool) *playhead {
+	stamps := make([]string, len(irregularRows))
+	for i, r := range irregularRows {
+		stamps[i] = r.Timestamp
+	}
+	p, _ := newPlayhead(stamps, 10*time.Second, speed, loop, wall0)
+	return p
+}
+
+// at returns the wall time after d of replay.
+func at(d time.Duration) time.Time { return wall0.Add(d) }
+
+// ── timestamp-faithful replay ─────────────────────────────────────────────────
+
+func TestPlayheadFollowsTimestamps(t *testing.T) {
+	p := newTestHead(1, true)
+	for _, tc := range []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{0, 0},
+		{9 * time.Second, 0},
+		{10 * time.Second, 10},
+		{39 * time.Second, 10}, // the gap in the recording is replayed as a gap
+		{40 * time.Second, 40},
+		{59 * time.Second, 50}, // the last row is held for the hold interval
+		{60 * time.Second, 0},  // then the replay starts over
+		{70 * time.Second, 10},
+	} {
+		values, _ := p.values(irregularRows, interpStep, at(tc.elapsed))
+		if values[0] != tc.want {
+			t.Errorf("after %v: value = %v, want %v", tc.elapsed, values[0], tc.want)
+		}
+	}
+
+	// Both columns come from the same row
+	values, _ := p.values(irregularRows, interpStep, at(45*time.Second))
+	if values[1] != 140 {
+		t.Errorf("second column = %v, want 140", values[1])
+	}
+}
+
+func TestPlayheadSpeed(t *testing.T) {
+	p := newTestHead(10, true)
+	values, stamp := p.values(irregularRows, interpStep, at(4500*time.Millisecond))
+	if values[0] != 40 {
+		t.Errorf("value after 4.5 s at 10x = %v, want 40", values[0])
+	}
+	if !stamp.Equal(at(4 * time.Second)) {
+		t.Errorf("stamp = %v, want the wall time the row became current", stamp)
+	}
+	// Stamps of a held row do not change between requests, so that consumers
+	// recognise an unchanged reading
+	_, again := p.values(irregularRows, interpStep, at(4900*time.Millisecond))
+	if !again.Equal(stamp) {
+		t.Errorf("stamp moved from %v to %v", stamp, again)
+	}
+	// Stamps of the next pass follow on
+	_, next := p.values(irregularRows, interpStep, at(7*time.Second))
+	if !next.Equal(at(7 * time.Second)) {
+		t.Errorf("stamp in second pass = %v", next)
+	}
+}
+
+func TestPlayheadLinear(t *testing.T) {
+	p := newTestHead(1, false)
+	values, stamp := p.values(irregularRows, interpLinear, at(25*time.Second))
+	if values[0] != 25 || values[1] != 125 {
+		t.Errorf("interpolated values = %v, want [25 125]", values)
+	}
+	if !stamp.Equal(at(25 * time.Second)) {
+		t.Errorf("stamp = %v, want the request time", stamp)
+	}
+}
+
+func TestPlayheadStopAtEnd(t *testing.T) {
+	p := newTestHead(1, false)
+	values, _ := p.values(irregularRows, interpLinear, at(2*time.Hour))
+	if values[0] != 50 {
+		t.Errorf("value after the end = %v, want the last value 50", values[0])
+	}
+	if _, _, ended := p.position(at(2 * time.Hour)); !ended {
+		t.Error("expected the replay to have ended")
+	}
+}
+
+// ── pause, resume, seek and speed ─────────────────────────────────────────────
+
+func TestPlayheadControls(t *testing.T) {
+	p := newTestHead(1, true)
+
+	p.pause(at(12 * time.Second))
+	if v, _ := p.values(irregularRows, interpStep, at(time.Hour)); v[0] != 10 {
+		t.Errorf("paused value = %v, want 10", v[0])
+	}
+	p.resume(at(time.Hour))
+	if v, _ := p.values(irregularRows, interpStep, at(time.Hour+28*time.Second)); v[0] != 40 {
+		t.Errorf("resumed value = %v, want 40", v[0])
+	}
+
+	p.seek(10*time.Second, at(2*time.Hour))
+	v, stamp := p.values(irregularRows, interpStep, at(2*time.Hour))
+	if v[0] != 10 || !stamp.Equal(at(2*time.Hour)) {
+		t.Errorf("after seek: value %v stamped %v", v[0], stamp)
+	}
+	p.setSpeed(30, at(2*time.Hour))
+	if v, _ := p.values(irregularRows, interpStep, at(2*time.Hour+time.Second)); v[0] != 40 {
+		t.Errorf("value 1 s after speeding up 30x = %v, want 40", v[0])
+	}
+}
+
+// ── playback service ──────────────────────────────────────────────────────────
+
+func putPlayback(t *testing.T, tr *Traits, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, "/emulator/signal/playback", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	serving(tr, w, req, "playback")
+	return w
+}
+
+func TestPlaybackService(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := &Traits{
+		InputFile:     writeFile(t, "data.csv", sampleCSV),
+		PlaybackSpeed: 1,
+		AtEnd:         endStop,
+		trayChan:      make(chan STray),
+	}
+	go tr.emulateAsset(ctx, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/emulator/signal/playback", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	serving(tr, w, req, "playback")
+	var state Playback_v1
+	json.Unmarshal(w.Body.Bytes(), &state)
+	if w.Code != http.StatusOK || state.State != statePlaying || state.Interpolation != interpStep || state.AtEnd != endStop {
+		t.Fatalf("GET = %d %+v", w.Code, state)
+	}
+
+	w = putPlayback(t, tr, `{"action":"pause","version":"Playback_v1"}`)
+	json.Unmarshal(w.Body.Bytes(), &state)
+	if state.State != statePaused {
+		t.Errorf("state after pause = %q", state.State)
+	}
+
+	w = putPlayback(t, tr, `{"action":"seek","position":"2026-03-09T10:02:00+01:00","version":"Playback_v1"}`)
+	json.Unmarshal(w.Body.Bytes(), &state)
+	if w.Code != http.StatusOK || state.State != stateEnded {
+		t.Errorf("seek to the last row: %d, state %q", w.Code, state.State)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/emulator/signal/access", nil)
+	w = httptest.NewRecorder()
+	serving(tr, w, req, "access")
+	if !strings.Contains(w.Body.String(), `"value": 30`) && !strings.Contains(w.Body.String(), `"value":30`) {
+		t.Errorf("value after seek: %s", w.Body.String())
+	}
+
+	for _, bad := range []string{
+		`{"action":"rewind","version":"Playback_v1"}`,
+		`{"action":"speed","speed":0,"version":"Playback_v1"}`,
+		`{"action":"seek","version":"Playback_v1"}`,
+		`{"value":1,"version":"SignalA_v1.0"}`,
+	} {
+		if w := putPlayback(t, tr, bad); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", bad, w.Code)
+		}
+	}
+}
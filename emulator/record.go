@@ -0,0 +1,429 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// Operating modes of a unit asset
+const (
+	modeReplay = "replay" // replay the input file (default)
+	modeRecord = "record" // record live services into the input file
+	modePlant  = "plant"  // emulate an actuator driving a plant model
+)
+
+// Record mode tuning
+const (
+	discoveryWait = time.Minute // how long to wait for all recorded services to be discovered
+	stampLayout   = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// RecordT is a service definition recorded in record mode, with optional
+// details to narrow the discovery (e.g. FunctionalLocation).
+type RecordT struct {
+	Name    string              `json:"serviceDefinition"`
+	Details map[string][]string `json:"details"`
+}
+
+// recColumn is a discovered provider, recorded as one signal column.
+type recColumn struct {
+	name    string
+	cer     *components.Cervice // cervice holding only this provider
+	details map[string][]string // details the provider registered
+}
+
+//-------------------------------------Discovery
+
+// discoverColumns looks up the providers of the recorded service definitions,
+// retrying until all are found or discoveryWait has passed with at least one.
+func (t *Traits) discoverColumns(ctx context.Context, sys *components.System) []recColumn {
+	protos := components.SProtocols(sys.Husk.ProtoPort)
+	deadline := time.Now().Add(discoveryWait)
+	for {
+		var cers []*components.Cervice
+		missing := 0
+		for _, rt := range t.Record {
+			cer := &components.Cervice{
+				Definition: rt.Name,
+				Details:    rt.Details,
+				Protos:     protos,
+				Nodes:      make(map[string][]components.NodeInfo),
+				Mode:       "get",
+			}
+			if err := usecases.Search4MultipleServices(cer, sys); err != nil || len(cer.Nodes) == 0 {
+				log.Printf("discovery failed for %s: %v\n", rt.Name, err)
+				missing++
+				continue
+			}
+			cers = append(cers, cer)
+		}
+		if cols := columnsOf(cers); len(cols) > 0 && (missing == 0 || time.Now().After(deadline)) {
+			return cols
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// columnsOf makes a column of each discovered provider. A column is named
+// after the service definition, with the provider's node name when several
+// systems offer the same definition.
+func columnsOf(cers []*components.Cervice) (cols []recColumn) {
+	for _, cer := range cers {
+		nodes := make([]string, 0, len(cer.Nodes))
+		for node := range cer.Nodes {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+		for _, node := range nodes {
+			for i, ni := range cer.Nodes[node] {
+				name := cer.Definition
+				if len(nodes) > 1 || len(cer.Nodes[node]) > 1 {
+					name += "_" + node
+				}
+				if i > 0 {
+					name += "_" + strconv.Itoa(i+1)
+				}
+				cols = append(cols, recColumn{
+					name: serviceName(name),
+					cer: &components.Cervice{
+						Definition: cer.Definition,
+						Details:    ni.Details,
+						Protos:     cer.Protos,
+						Nodes:      map[string][]components.NodeInfo{node: {ni}},
+					},
+					details: ni.Details,
+				})
+			}
+		}
+	}
+	return cols
+}
+
+//-------------------------------------Recording
+
+// recordAsset records the live services into the input file until shutdown.
+func (t *Traits) recordAsset(ctx context.Context, sys *components.System) {
+	cols := t.discoverColumns(ctx, sys)
+	if len(cols) == 0 {
+		return
+	}
+	t.record(ctx, sys, cols)
+}
+
+// record samples the columns every record period and writes the readings.
+// A reading that fails is left empty, which replay treats as holding the
+// previous value, or as the first value of the column if none was read yet.
+func (t *Traits) record(ctx context.Context, sys *components.System, cols []recColumn) {
+	period := time.Second
+	if t.RecordPeriod > 0 {
+		period = seconds(t.RecordPeriod)
+	}
+
+	// The first readings give the units of the columns
+	now := time.Now()
+	values, units := readColumns(cols, sys)
+	details := make([]map[string][]string, len(cols))
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = col.name
+		details[i] = make(map[string][]string)
+		for k, v := range col.details {
+			details[i][k] = v
+		}
+		if units[i] != "" {
+			details[i]["Unit"] = []string{units[i]}
+		}
+	}
+	out, err := newSink(t.InputFile, names, details)
+	if err != nil {
+		log.Printf("unable to record into %s: %v", t.InputFile, err)
+		return
+	}
+	defer out.close()
+	log.Printf("recording %s into %s every %v\n", strings.Join(names, ", "), t.InputFile, period)
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		if err := out.write(now, values); err != nil {
+			log.Printf("unable to record into %s: %v", t.InputFile, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case now = <-ticker.C:
+			values, _ = readColumns(cols, sys)
+		}
+	}
+}
+
+// readColumns reads the current value of each column, nil if it failed, and its unit.
+func readColumns(cols []recColumn, sys *components.System) ([]*float64, []string) {
+	values := make([]*float64, len(cols))
+	units := make([]string, len(cols))
+	for i, col := range cols {
+		if len(col.cer.Nodes) == 0 {
+			continue // lost provider
+		}
+		saved := col.cer.Nodes
+		f, err := usecases.GetState(col.cer, sys)
+		col.cer.Nodes = saved // keep the provider, GetState forgets it on failure
+		if err != nil {
+			log.Printf("unable to read %s: %v\n", col.name, err)
+			continue
+		}
+		switch sig := f.(type) {
+		case *forms.SignalA_v1a:
+			v := sig.Value
+			values[i], units[i] = &v, sig.Unit
+		case *forms.SignalB_v1a:
+			v := 0.0
+			if sig.Value {
+				v = 1
+			}
+			values[i] = &v
+		default:
+			log.Printf("unable to record %s: unexpected form %s\n", col.name, f.FormVersion())
+		}
+		if units[i] == "" && len(col.details["Unit"]) > 0 {
+			units[i] = col.details["Unit"][0]
+		}
+	}
+	return values, units
+}
+
+//-------------------------------------Recording files
+
+// sink writes recorded rows in the format loadRecording reads back.
+type sink interface {
+	write(stamp time.Time, values []*float64) error
+	close() error
+}
+
+// newSink creates the recording file(s) for the extension of the file name.
+// CSV files hold all columns, with a unit row; JSON and XML files hold a single
+// signal, so each column gets its own file when there are several. The column
+// details are written to the sidecar metadata file. Existing recordings are
+// not overwritten.
+func newSink(filename string, names []string, details []map[string][]string) (sink, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch ext {
+	case ".csv":
+		return newCSVSink(filename, names, details)
+	case ".json", ".xml":
+		s := &sampleSink{ext: ext}
+		for i, name := range names {
+			file := filename
+			if len(names) > 1 {
+				file = strings.TrimSuffix(filename, filepath.Ext(filename)) + "_" + name + ext
+			}
+			f, err := newSampleFile(file, ext)
+			if err != nil {
+				s.close()
+				return nil, err
+			}
+			s.files = append(s.files, f)
+			if err := writeMetadata(file, map[string]map[string][]string{valueColumn: details[i]}); err != nil {
+				s.close()
+				return nil, err
+			}
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported file extension: %s", ext)
+	}
+}
+
+// writeMetadata writes the sidecar metadata file of a recording.
+func writeMetadata(filename string, meta map[string]map[string][]string) error {
+	data, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metadataFile(filename), data, 0o644)
+}
+
+// csvSink appends one row per sampling instant to a CSV file.
+type csvSink struct {
+	file *os.File
+	w    *csv.Writer
+}
+
+func newCSVSink(filename string, names []string, details []map[string][]string) (*csvSink, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &csvSink{file: file, w: csv.NewWriter(file)}
+	s.w.Write(append([]string{"timestamp"}, names...))
+	units := []string{"unit"}
+	meta := make(map[string]map[string][]string)
+	for i, name := range names {
+		unit := ""
+		if u := details[i]["Unit"]; len(u) > 0 {
+			unit = u[0]
+		}
+		units = append(units, unit)
+		if len(details[i]) > 0 {
+			meta[name] = details[i]
+		}
+	}
+	s.w.Write(units)
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := writeMetadata(filename, meta); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// write appends a row; the row is flushed so that a recording survives a crash.
+func (s *csvSink) write(stamp time.Time, values []*float64) error {
+	row := []string{stamp.Format(stampLayout)}
+	for _, v := range values {
+		cell := ""
+		if v != nil {
+			cell = strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+		row = append(row, cell)
+	}
+	s.w.Write(row)
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) close() error {
+	return s.file.Close()
+}
+
+// sampleSink streams the samples of each column to a JSON or XML file. Each
+// sample is written over the closing tail of the document, followed by the
+// tail again, so that the file is complete after every write and neither the
+// memory nor the writing grows with the length of the recording.
+type sampleSink struct {
+	ext   string
+	files []*sampleFile
+}
+
+// sampleFile is a JSON array or an XML samples document being appended to.
+type sampleFile struct {
+	file *os.File
+	end  int64 // offset of the closing tail
+	n    int   // samples written
+}
+
+// Opening and closing of the recorded documents
+const (
+	jsonHead = "["
+	jsonTail = "\n]\n"
+	xmlHead  = xml.Header + "<samples>"
+	xmlTail  = "\n</samples>\n"
+)
+
+// newSampleFile creates an empty document, failing if the file exists.
+func newSampleFile(filename, ext string) (*sampleFile, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	head, tail := jsonHead, jsonTail
+	if ext == ".xml" {
+		head, tail = xmlHead, xmlTail
+	}
+	if _, err := file.WriteString(head + tail); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &sampleFile{file: file, end: int64(len(head))}, nil
+}
+
+func (s *sampleSink) write(stamp time.Time, values []*float64) error {
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		if err := s.files[i].append(s.ext, Sample{Timestamp: stamp.Format(stampLayout), Value: *v}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// append writes a sample and the closing tail after the previous samples.
+func (f *sampleFile) append(ext string, sample Sample) error {
+	var buf bytes.Buffer
+	tail := jsonTail
+	if ext == ".xml" {
+		buf.WriteString("\n  ")
+		if err := xml.NewEncoder(&buf).EncodeElement(sample, xml.StartElement{Name: xml.Name{Local: "sample"}}); err != nil {
+			return err
+		}
+		tail = xmlTail
+	} else {
+		if f.n > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString("\n  ")
+		data, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	element := int64(buf.Len())
+	buf.WriteString(tail)
+	if _, err := f.file.WriteAt(buf.Bytes(), f.end); err != nil {
+		return err
+	}
+	f.end += element
+	f.n++
+	return nil
+}
+
+func (s *sampleSink) close() error {
+	var errs []error
+	for _, f := range s.files {
+		errs = append(errs, f.file.Close())
+	}
+	return errors.Join(errs...)
+}
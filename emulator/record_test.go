@@ -0,0 +1,230 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// provider serves an increasing SignalA_v1a reading; the readings listed in
+// fail are answered with an error.
+func provider(t *testing.T, unit string, fail map[int64]bool) *httptest.Server {
+	t.Helper()
+	var n atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := n.Add(1)
+		if fail[i] {
+			http.Error(w, "Signal unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var f forms.SignalA_v1a
+		f.NewForm()
+		f.Value = float64(i)
+		f.Unit = unit
+		f.Timestamp = time.Now()
+		usecases.HTTPProcessGetRequest(w, r, &f)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestColumnsOf(t *testing.T) {
+	pressure := &components.Cervice{Definition: "pressure", Nodes: map[string][]components.NodeInfo{
+		"pm8/steam": {{URL: "http://a"}},
+	}}
+	temperature := &components.Cervice{Definition: "temperature", Nodes: map[string][]components.NodeInfo{
+		"ds18b20/kitchen": {{URL: "http://b"}},
+		"ds18b20/hall":    {{URL: "http://c"}},
+	}}
+	cols := columnsOf([]*components.Cervice{pressure, temperature})
+	var names []string
+	for _, c := range cols {
+		names = append(names, c.name)
+	}
+	want := []string{"pressure", "temperature_ds18b20_hall", "temperature_ds18b20_kitchen"}
+	if len(names) != len(want) {
+		t.Fatalf("columns = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("columns = %v, want %v", names, want)
+			break
+		}
+	}
+	if n := len(cols[1].cer.Nodes); n != 1 {
+		t.Errorf("a column's cervice has %d providers, want 1", n)
+	}
+}
+
+// recordFor records the providers into filename for a few sampling periods.
+func recordFor(t *testing.T, filename string, servers ...*httptest.Server) {
+	t.Helper()
+	var cers []*components.Cervice
+	for i, srv := range servers {
+		cers = append(cers, &components.Cervice{
+			Definition: []string{"pressure", "level"}[i],
+			Nodes: map[string][]components.NodeInfo{
+				"pm8/sensor": {{URL: srv.URL, Details: map[string][]string{"FunctionalLocation": {"PM8"}}}},
+			},
+		})
+	}
+	tr := &Traits{InputFile: filename, RecordPeriod: 0.05}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	tr.record(ctx, nil, columnsOf(cers))
+}
+
+func TestRecordCSVRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "pm8.csv")
+	recordFor(t, filename, provider(t, "kPa", nil), provider(t, "%", map[int64]bool{2: true}))
+
+	rec, err := loadRecording(filename)
+	if err != nil {
+		t.Fatalf("loadRecording: %v", err)
+	}
+	if len(rec.Columns) != 2 || rec.Columns[0] != "pressure" || rec.Columns[1] != "level" {
+		t.Fatalf("columns = %v", rec.Columns)
+	}
+	if rec.unit(0) != "kPa" || rec.unit(1) != "%" {
+		t.Errorf("units = %q, %q", rec.unit(0), rec.unit(1))
+	}
+	if loc := rec.Details[0]["FunctionalLocation"]; len(loc) != 1 || loc[0] != "PM8" {
+		t.Errorf("pressure details = %v", rec.Details[0])
+	}
+	if len(rec.Rows) < 3 {
+		t.Fatalf("recorded %d rows, want at least 3", len(rec.Rows))
+	}
+	// The failed second reading of the level holds the first one
+	if got := rec.Rows[1].Values; got[0] != 2 || got[1] != 1 {
+		t.Errorf("second row = %v, want [2 1]", got)
+	}
+	if got := rec.Rows[2].Values; got[1] != 3 {
+		t.Errorf("third row = %v, want the level back at 3", got)
+	}
+	if _, err := time.Parse(time.RFC3339, rec.Rows[0].Timestamp); err != nil {
+		t.Errorf("timestamp %q: %v", rec.Rows[0].Timestamp, err)
+	}
+
+	// An existing recording is not overwritten
+	if _, err := newSink(filename, []string{"pressure"}, []map[string][]string{{}}); err == nil {
+		t.Error("expected an error recording over an existing file")
+	}
+}
+
+// TestRecordReplayFailedFirstReading replays a recording whose first reading
+// of the level failed, leaving the first cell of its column empty.
+func TestRecordReplayFailedFirstReading(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "pm8.csv")
+	recordFor(t, filename, provider(t, "kPa", nil), provider(t, "%", map[int64]bool{1: true}))
+
+	rec, err := loadRecording(filename)
+	if err != nil {
+		t.Fatalf("loadRecording: %v", err)
+	}
+	if len(rec.Rows) < 2 {
+		t.Fatalf("recorded %d rows, want at least 2", len(rec.Rows))
+	}
+	if got := rec.Rows[0].Values; got[0] != 1 || got[1] != 2 {
+		t.Errorf("first row = %v, want [1 2]: the level takes its first reading", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tr := &Traits{InputFile: filename, rec: rec, trayChan: make(chan STray)}
+	tr.addColumnServices(components.Services{})
+	go tr.emulateAsset(ctx, nil)
+
+	w := httptest.NewRecorder()
+	tr.readColumn(w, httptest.NewRequest(http.MethodGet, "/level", nil), tr.columns["level"])
+	var f forms.SignalA_v1a
+	if err := json.Unmarshal(w.Body.Bytes(), &f); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("replayed level: %d %s", w.Code, w.Body)
+	}
+	if f.Value != 2 {
+		t.Errorf("replayed level = %v, want 2", f.Value)
+	}
+}
+
+func TestRecordJSONPerColumn(t *testing.T) {
+	dir := t.TempDir()
+	recordFor(t, filepath.Join(dir, "pm8.json"), provider(t, "kPa", nil), provider(t, "%", nil))
+
+	for _, name := range []string{"pm8_pressure.json", "pm8_level.json"} {
+		rec, err := loadRecording(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("loadRecording(%s): %v", name, err)
+		}
+		if len(rec.Rows) < 3 || rec.Rows[0].Values[0] != 1 {
+			t.Errorf("%s: rows = %v", name, rec.Rows)
+		}
+		if rec.unit(0) == "" {
+			t.Errorf("%s: no unit in the metadata", name)
+		}
+	}
+}
+
+// TestSampleSinkStreams reads JSON and XML recordings back after every
+// sample, as a crash would leave them.
+func TestSampleSinkStreams(t *testing.T) {
+	for _, ext := range []string{".json", ".xml"} {
+		filename := filepath.Join(t.TempDir(), "pm8"+ext)
+		s, err := newSink(filename, []string{"pressure"}, []map[string][]string{{"Unit": {"kPa"}}})
+		if err != nil {
+			t.Fatalf("%s: newSink: %v", ext, err)
+		}
+		start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+		for i := range 3 {
+			v := float64(i + 1)
+			if err := s.write(start.Add(time.Duration(i)*time.Second), []*float64{&v}); err != nil {
+				t.Fatalf("%s: write: %v", ext, err)
+			}
+			rec, err := loadRecording(filename)
+			if err != nil {
+				t.Fatalf("%s after %d samples: %v", ext, i+1, err)
+			}
+			if len(rec.Rows) != i+1 || rec.Rows[i].Values[0] != v {
+				t.Errorf("%s after %d samples: rows = %v", ext, i+1, rec.Rows)
+			}
+		}
+		if err := s.close(); err != nil {
+			t.Errorf("%s: close: %v", ext, err)
+		}
+		if _, err := newSink(filename, []string{"pressure"}, []map[string][]string{{}}); err == nil {
+			t.Errorf("%s: expected an error recording over an existing file", ext)
+		}
+	}
+}
+
+func TestRecordModeServices(t *testing.T) {
+	tr := &Traits{Mode: modeRecord}
+	req := httptest.NewRequest(http.MethodGet, "/emulator/signal/access", nil)
+	w := httptest.NewRecorder()
+	serving(tr, w, req, "access")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("reading while recording: status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,245 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// valueColumn is the header of the value column of a single-signal file.
+const valueColumn = "value"
+
+// recording is a table of signals recorded at the same instants: one row per
+// timestamp and one column per signal. All columns are replayed in lock-step.
+type recording struct {
+	Columns []string
+	Details []map[string][]string // per column metadata (e.g. Unit), from a unit row or a sidecar file
+	Rows    []Row
+}
+
+// Row holds the values of all signals at one timestamp.
+type Row struct {
+	Timestamp string
+	Values    []float64
+}
+
+// loadRecording reads a file into a recording. CSV files may hold many signal
+// columns; JSON and XML files hold a single signal. Column metadata is read
+// from the optional sidecar file <name>.meta.json next to the input file.
+func loadRecording(filename string) (*recording, error) {
+	var rec *recording
+	if strings.ToLower(filepath.Ext(filename)) == ".csv" {
+		var err error
+		if rec, err = loadCSVTable(filename); err != nil {
+			return nil, err
+		}
+	} else {
+		samples, err := loadSamples(filename)
+		if err != nil {
+			return nil, err
+		}
+		rec = &recording{Columns: []string{valueColumn}, Details: make([]map[string][]string, 1)}
+		for _, s := range samples {
+			rec.Rows = append(rec.Rows, Row{Timestamp: s.Timestamp, Values: []float64{s.Value}})
+		}
+	}
+	if err := rec.loadMetadata(metadataFile(filename)); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// metadataFile returns the name of the sidecar file of an input file.
+func metadataFile(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".meta.json"
+}
+
+// loadMetadata merges the sidecar file, a JSON object of column details such as
+//
+//	{"827PC2706": {"Unit": ["kPa"], "Description": ["steam pressure"]}}
+//
+// into the column metadata. A missing sidecar file is not an error.
+func (rec *recording) loadMetadata(filename string) error {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var meta map[string]map[string][]string
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("metadata file %s: %w", filename, err)
+	}
+	for name, details := range meta {
+		col := rec.column(name)
+		if col < 0 {
+			return fmt.Errorf("metadata file %s: unknown column %q", filename, name)
+		}
+		if rec.Details[col] == nil {
+			rec.Details[col] = make(map[string][]string)
+		}
+		for k, v := range details {
+			rec.Details[col][k] = v
+		}
+	}
+	return nil
+}
+
+// loadCSVTable reads a CSV file whose first column is the timestamp and whose
+// other columns are signals named by the header row. An optional second row
+// starting with "unit" gives the unit of each column:
+//
+//	timestamp,827PC2706,827PD2708
+//	unit,kPa,%
+//	2026-03-09T23:27:00+01:00,74.85,20.1
+//
+// An empty cell holds the previous value of its column; the empty cells at the
+// top of a column, left by a recorder whose first readings failed, take the
+// first value of the column.
+func loadCSVTable(filename string) (*recording, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1 // historian exports may leave trailing cells out
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return &recording{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) < 2 {
+		return nil, fmt.Errorf("%s: expected a timestamp column and at least one signal column", filename)
+	}
+	rec := &recording{Details: make([]map[string][]string, len(header)-1)}
+	for _, name := range header[1:] {
+		rec.Columns = append(rec.Columns, strings.TrimSpace(name))
+	}
+
+	held := make([]float64, len(rec.Columns)) // last value of each column
+	seen := make([]bool, len(rec.Columns))    // whether the column has had a value
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		first := strings.ToLower(strings.TrimSpace(record[0]))
+		if line == 2 && (first == "unit" || first == "units") {
+			for i, unit := range record[1:min(len(record), len(header))] {
+				if unit = strings.TrimSpace(unit); unit != "" {
+					rec.Details[i] = map[string][]string{"Unit": {unit}}
+				}
+			}
+			continue
+		}
+		if first == "" {
+			continue
+		}
+
+		values := make([]float64, len(rec.Columns))
+		for i := range values {
+			cell := ""
+			if i+1 < len(record) {
+				cell = strings.TrimSpace(record[i+1])
+			}
+			if cell == "" {
+				values[i] = held[i]
+				continue
+			}
+			if values[i], err = strconv.ParseFloat(cell, 64); err != nil {
+				return nil, fmt.Errorf("invalid value %q: %w", cell, err)
+			}
+			if !seen[i] {
+				for _, row := range rec.Rows {
+					row.Values[i] = values[i]
+				}
+				seen[i] = true
+			}
+		}
+		held = values
+		rec.Rows = append(rec.Rows, Row{Timestamp: strings.TrimSpace(record[0]), Values: values})
+	}
+	for i, ok := range seen {
+		if !ok && len(rec.Rows) > 0 {
+			return nil, fmt.Errorf("%s: no value for %s", filename, rec.Columns[i])
+		}
+	}
+	return rec, nil
+}
+
+// column returns the index of the named column, or -1.
+func (rec *recording) column(name string) int {
+	for i, c := range rec.Columns {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// samples returns the samples of one column.
+func (rec *recording) samples(col int) []Sample {
+	samples := make([]Sample, len(rec.Rows))
+	for i, row := range rec.Rows {
+		samples[i] = Sample{Timestamp: row.Timestamp, Value: row.Values[col]}
+	}
+	return samples
+}
+
+// unit returns the unit of a column, if the file gives one.
+func (rec *recording) unit(col int) string {
+	if u := rec.Details[col]["Unit"]; len(u) > 0 {
+		return u[0]
+	}
+	return ""
+}
+
+// multiSignal tells whether the recording holds more than the single "value"
+// signal of the original file format.
+func (rec *recording) multiSignal() bool {
+	return len(rec.Columns) > 1 || (len(rec.Columns) == 1 && rec.Columns[0] != valueColumn)
+}
+
+// serviceName turns a column header into a service definition and sub-path.
+func serviceName(column string) string {
+	var sb strings.Builder
+	for _, r := range column {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
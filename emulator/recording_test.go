@@ -0,0 +1,167 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// processCSV holds three process tags with a unit row; 827PD2708 has a gap.
+const processCSV = `timestamp,827PC2706,827PD2708,flow rate
+unit,kPa,%,
+2026-03-09T10:00:00+01:00,74.8,20.1,1.5
+2026-03-09T10:01:00+01:00,74.9,,1.6
+2026-03-09T10:02:00+01:00,75.0,19.8,1.7
+`
+
+// ── multi-column loading ──────────────────────────────────────────────────────
+
+func TestLoadCSVTable(t *testing.T) {
+	rec, err := loadRecording(writeFile(t, "process.csv", processCSV))
+	if err != nil {
+		t.Fatalf("loadRecording: %v", err)
+	}
+	if len(rec.Columns) != 3 || rec.Columns[2] != "flow rate" {
+		t.Fatalf("columns = %q", rec.Columns)
+	}
+	if len(rec.Rows) != 3 {
+		t.Fatalf("rows = %d, want 3 (the unit row is not data)", len(rec.Rows))
+	}
+	if rec.unit(0) != "kPa" || rec.unit(1) != "%" || rec.unit(2) != "" {
+		t.Errorf("units = %q %q %q", rec.unit(0), rec.unit(1), rec.unit(2))
+	}
+	if got := rec.Rows[1].Values[1]; got != 20.1 {
+		t.Errorf("empty cell = %v, want the held value 20.1", got)
+	}
+	if !rec.multiSignal() {
+		t.Error("expected a multi-signal recording")
+	}
+
+	// The original two-column format is a single signal
+	rec, err = loadRecording(writeFile(t, "data.csv", sampleCSV))
+	if err != nil {
+		t.Fatalf("loadRecording: %v", err)
+	}
+	if rec.multiSignal() || len(rec.Rows) != 3 {
+		t.Errorf("timestamp,value file: multiSignal %v with %d rows", rec.multiSignal(), len(rec.Rows))
+	}
+
+	// A gap at the top of a column takes the column's first value
+	rec, err = loadRecording(writeFile(t, "gap.csv", "timestamp,a,b\n2026-03-09T10:00:00Z,1,\n2026-03-09T10:00:01Z,,\n2026-03-09T10:00:02Z,3,7\n"))
+	if err != nil {
+		t.Fatalf("leading gap: %v", err)
+	}
+	for i, want := range [][]float64{{1, 7}, {1, 7}, {3, 7}} {
+		if got := rec.Rows[i].Values; got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("row %d = %v, want %v", i+1, got, want)
+		}
+	}
+
+	// A column without any value cannot be filled
+	if _, err := loadRecording(writeFile(t, "empty.csv", "timestamp,a,b\n2026-03-09T10:00:00Z,1,\n")); err == nil {
+		t.Error("expected an error for a column without values")
+	}
+}
+
+func TestLoadMetadataSidecar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "process.csv")
+	os.WriteFile(path, []byte(processCSV), 0644)
+	os.WriteFile(filepath.Join(dir, "process.meta.json"),
+		[]byte(`{"flow rate": {"Unit": ["m3/h"], "FunctionalLocation": ["PM8"]}, "827PC2706": {"Unit": ["bar"]}}`), 0644)
+
+	rec, err := loadRecording(path)
+	if err != nil {
+		t.Fatalf("loadRecording: %v", err)
+	}
+	if rec.unit(2) != "m3/h" || rec.Details[2]["FunctionalLocation"][0] != "PM8" {
+		t.Errorf("flow rate details = %v", rec.Details[2])
+	}
+	if rec.unit(0) != "bar" {
+		t.Errorf("sidecar unit %q should override the unit row", rec.unit(0))
+	}
+
+	os.WriteFile(filepath.Join(dir, "process.meta.json"), []byte(`{"nope": {"Unit": ["x"]}}`), 0644)
+	if _, err := loadRecording(path); err == nil {
+		t.Error("expected an error for metadata of an unknown column")
+	}
+}
+
+// ── one service per column, replayed in lock-step ─────────────────────────────
+
+func TestMultiSignalServices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := components.NewSystem("emulator", ctx)
+	sys.Husk = &components.Husk{Host: components.NewDevice(), ProtoPort: map[string]int{"http": 20156}}
+
+	traitJSON, _ := json.Marshal(Traits{InputFile: writeFile(t, "process.csv", processCSV), PlaybackSpeed: 1})
+	ua, cleanup := newResource(usecases.ConfigurableAsset{
+		Name:     "pm8",
+		Details:  map[string][]string{"Unit": {"Celsius"}},
+		Traits:   []json.RawMessage{traitJSON},
+		Services: []components.Service{{Definition: "signal", SubPath: "access"}},
+	}, &sys)
+	defer cleanup()
+
+	services := ua.GetServices()
+	for _, subPath := range []string{"access", "827PC2706", "827PD2708", "flow_rate"} {
+		if _, ok := services[subPath]; !ok {
+			t.Errorf("expected a %q service", subPath)
+		}
+	}
+	if u := services["827PC2706"].Details["Unit"]; len(u) != 1 || u[0] != "kPa" {
+		t.Errorf("827PC2706 service details = %v", services["827PC2706"].Details)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	read := func(subPath string) forms.SignalA_v1a {
+		req := httptest.NewRequest(http.MethodGet, "/emulator/pm8/"+subPath, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		ua.ServingFunc(w, req, subPath)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", subPath, w.Code)
+		}
+		var f forms.SignalA_v1a
+		json.Unmarshal(w.Body.Bytes(), &f)
+		return f
+	}
+	pressure, level, flow := read("827PC2706"), read("827PD2708"), read("flow_rate")
+	if pressure.Value != 74.8 || level.Value != 20.1 || flow.Value != 1.5 {
+		t.Errorf("first row = %v %v %v, want 74.8 20.1 1.5", pressure.Value, level.Value, flow.Value)
+	}
+	if pressure.Unit != "kPa" || flow.Unit != "Celsius" {
+		t.Errorf("units = %q %q, want the column unit or else the asset unit", pressure.Unit, flow.Unit)
+	}
+	if !pressure.Timestamp.Equal(flow.Timestamp) {
+		t.Error("signals of the same row must carry the same timestamp")
+	}
+	if access := read("access"); access.Value != 74.8 {
+		t.Errorf("access = %v, want the first column", access.Value)
+	}
+}
@@ -18,16 +18,16 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
@@ -38,20 +38,35 @@ import (
 
 // Define the types of requests the measurement manager can handle
 type STray struct {
-	Action string
-	ValueP chan forms.SignalA_v1a
-	Error  chan error
+	Action  string
+	Column  int         // signal column of the recording
+	Command Playback_v1 // playback command
+	Faults  Faults_v1   // fault request
+	Input   *float64    // actuator setting, nil to read it
+	ValueP  chan forms.SignalA_v1a
+	StateP  chan Playback_v1
+	FaultsP chan Faults_v1
+	FormP   chan forms.Form
+	Error   chan error
 }
 
 // -------------------------------------Define the unit asset
 
 // Traits are Asset-specific configurable parameters
 type Traits struct {
-	InputFile     string     `json:"inputFile"`
-	PlaybackSpeed float64    `json:"playbackSpeed"` // multiplier: 1 = real-time, 60 = 60× faster
-	sample        float64    `json:"-"`
-	tStamp        time.Time  `json:"-"`
-	trayChan      chan STray `json:"-"`
+	InputFile     string         `json:"inputFile"`
+	PlaybackSpeed float64        `json:"playbackSpeed"`   // multiplier: 1 = real-time, 60 = 60× faster
+	Interpolation string         `json:"interpolation"`   // "step" (default) or "linear" between recorded values
+	AtEnd         string         `json:"atEnd"`           // "loop" (default) or "stop" at the end of the file
+	Faults        []FaultT       `json:"faults"`          // faults scheduled from the system start
+	FaultLog      string         `json:"faultLog"`        // file the injected faults are logged to (JSON lines); none if empty
+	Mode          string         `json:"mode"`            // "replay" (default) the input file, "record" live services into it, or emulate a "plant"
+	Record        []RecordT      `json:"record"`          // service definitions recorded in record mode
+	RecordPeriod  float64        `json:"recordPeriod"`    // seconds between recorded samples
+	Plant         *PlantT        `json:"plant,omitempty"` // actuator and plant model emulated in plant mode
+	rec           *recording     `json:"-"`
+	columns       map[string]int `json:"-"` // signal column served at each service sub-path
+	trayChan      chan STray     `json:"-"`
 }
 
 //-------------------------------------Instantiate a unit asset template
@@ -64,7 +79,21 @@ func initTemplate() *components.UnitAsset {
 		SubPath:     "access",
 		Details:     map[string][]string{"Forms": {"SignalA_v1a"}},
 		RegPeriod:   30,
-		Description: "provides the (value, timestamp) signal as a service from the input file",
+		Description: "provides the (value, timestamp) signal as a service from the input file (the first column of a multi-signal file)",
+	}
+	playback := components.Service{
+		Definition:  "playback",
+		SubPath:     "playback",
+		Details:     map[string][]string{"Forms": {"Playback_v1"}},
+		RegPeriod:   30,
+		Description: "provides (GET) or controls (PUT pause, resume, seek or speed) the replay of the input file",
+	}
+	faultInjection := components.Service{
+		Definition:  "fault",
+		SubPath:     "fault",
+		Details:     map[string][]string{"Forms": {"Faults_v1"}},
+		RegPeriod:   30,
+		Description: "lists (GET) or injects and clears (PUT) faults in the replayed signals",
 	}
 
 	return &components.UnitAsset{
@@ -72,10 +101,19 @@ func initTemplate() *components.UnitAsset {
 		Mission: "replay_signal",
 		Details: map[string][]string{"Unit": {"Celsius"}, "FunctionalLocation": {"Kitchen"}},
 		ServicesMap: components.Services{
-			stream.SubPath: &stream,
+			stream.SubPath:         &stream,
+			playback.SubPath:       &playback,
+			faultInjection.SubPath: &faultInjection,
 		},
 		Traits: &Traits{
-			InputFile: "data/signal_data.json",
+			InputFile:     "data/signal_data.json",
+			PlaybackSpeed: 1,
+			Interpolation: interpStep,
+			AtEnd:         endLoop,
+			Faults:        []FaultT{},
+			Mode:          modeReplay,
+			Record:        []RecordT{{Name: "temperature"}},
+			RecordPeriod:  1,
 		},
 	}
 }
@@ -102,10 +140,45 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 		ServicesMap: usecases.MakeServiceMap(configuredAsset.Services),
 		Traits:      t,
 	}
+
 	ua.ServingFunc = func(w http.ResponseWriter, r *http.Request, servicePath string) {
 		serving(t, w, r, servicePath)
 	}
 
+	// In record mode, the input file is written rather than replayed, and no
+	// service is offered
+	if t.Mode == modeRecord {
+		ua.ServicesMap = components.Services{}
+		go t.recordAsset(sys.Ctx, sys)
+		return ua, func() {
+			log.Printf("disconnecting from %s\n", configuredAsset.Name)
+		}
+	}
+
+	// In plant mode, an actuator drives a plant model instead of a replay, and
+	// only the actuator and the plant output are offered
+	if t.Mode == modePlant {
+		ua.ServicesMap = components.Services{}
+		if t.Plant == nil {
+			log.Printf("unit asset %s is in plant mode without a plant model\n", configuredAsset.Name)
+		} else {
+			t.addPlantServices(ua.ServicesMap, configuredAsset.Details)
+			go t.simulatePlant(sys.Ctx)
+		}
+		return ua, func() {
+			log.Printf("disconnecting from %s\n", configuredAsset.Name)
+		}
+	}
+
+	// A multi-signal file offers one service per column
+	rec, err := loadRecording(t.InputFile)
+	if err != nil {
+		log.Printf("failed to load samples from %s: %v", t.InputFile, err)
+	} else {
+		t.rec = rec
+		t.addColumnServices(ua.ServicesMap)
+	}
+
 	go t.emulateAsset(sys.Ctx, configuredAsset.Details)
 
 	return ua, func() {
@@ -113,14 +186,48 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 	}
 }
 
+// addColumnServices adds a service for each signal column of a multi-signal
+// recording, named after the column header. A service already configured with
+// that sub-path keeps its configuration.
+func (t *Traits) addColumnServices(services components.Services) {
+	t.columns = make(map[string]int)
+	if !t.rec.multiSignal() {
+		return
+	}
+	for col, name := range t.rec.Columns {
+		subPath := serviceName(name)
+		t.columns[subPath] = col
+		if _, ok := services[subPath]; ok {
+			continue
+		}
+		details := map[string][]string{"Forms": {"SignalA_v1a"}}
+		for k, v := range t.rec.Details[col] {
+			details[k] = v
+		}
+		services[subPath] = &components.Service{
+			Definition:  subPath,
+			SubPath:     subPath,
+			Details:     details,
+			RegPeriod:   30,
+			Description: fmt.Sprintf("provides the %s signal replayed from the input file", name),
+		}
+	}
+}
+
 //-------------------------------------Service handlers
 
 // readSignal gets the unit asset's signal datum and sends it in a signal form
 func (t *Traits) readSignal(w http.ResponseWriter, r *http.Request) {
+	t.readColumn(w, r, 0)
+}
+
+// readColumn gets the current value of one signal column and sends it in a signal form
+func (t *Traits) readColumn(w http.ResponseWriter, r *http.Request, col int) {
 	switch r.Method {
 	case http.MethodGet:
 		getMeasuremet := STray{
 			Action: "read",
+			Column: col,
 			// Buffer 1 prevents emulateAsset from blocking forever if the handler exits early.
 			ValueP: make(chan forms.SignalA_v1a, 1),
 			Error:  make(chan error, 1),
@@ -143,6 +250,10 @@ func (t *Traits) readSignal(w http.ResponseWriter, r *http.Request) {
 		// Now wait for the response (or timeout/cancel)
 		select {
 		case err := <-getMeasuremet.Error:
+			if errors.Is(err, errDropout) {
+				http.Error(w, "Signal unavailable", http.StatusServiceUnavailable)
+				return
+			}
 			fmt.Printf("Logic error in getting measurement, %s\n", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -181,26 +292,26 @@ type XMLSamples struct {
 	Items   []Sample `xml:"sample"`
 }
 
-// emulateAsset runs the emulation loop for the unit asset.
+// emulateAsset runs the emulation loop for the unit asset. The replay follows
+// the recorded timestamps, scaled by the playback speed, and all signal columns
+// advance together, one row of the recording at a time.
 func (t *Traits) emulateAsset(ctx context.Context, details map[string][]string) {
-	samples, err := loadSamples(t.InputFile)
-	if err != nil {
-		// log-and-return, not Fatalf: this runs in a goroutine, so os.Exit
-		// would tear down the whole host process (and, in tests, the test
-		// binary) on a single asset's load failure.
-		log.Printf("failed to load samples from %s: %v", t.InputFile, err)
-		return
+	rec := t.rec
+	if rec == nil {
+		var err error
+		if rec, err = loadRecording(t.InputFile); err != nil {
+			// log-and-return, not Fatalf: this runs in a goroutine, so os.Exit
+			// would tear down the whole host process (and, in tests, the test
+			// binary) on a single asset's load failure.
+			log.Printf("failed to load samples from %s: %v", t.InputFile, err)
+			return
+		}
 	}
-	if len(samples) == 0 {
+	if len(rec.Rows) == 0 {
 		log.Printf("no samples found in %s", t.InputFile)
 		return
 	}
 
-	interval := detectInterval(samples)
-	if t.PlaybackSpeed > 0 {
-		interval = time.Duration(float64(interval) / t.PlaybackSpeed)
-	}
-
 	var sigUnit string
 	if details != nil {
 		if sigU, ok := details["Unit"]; ok && len(sigU) > 0 {
@@ -208,31 +319,95 @@ func (t *Traits) emulateAsset(ctx context.Context, details map[string][]string)
 		}
 	}
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	i := 0
-	// initialize immediately
-	t.sample = samples[i].Value
-	t.tStamp = time.Now()
+	// Replay follows the recorded timestamps; the last row is held for the
+	// sampling interval found at the end of the file
+	stamps := make([]string, len(rec.Rows))
+	for i, row := range rec.Rows {
+		stamps[i] = row.Timestamp
+	}
+	tail := rec.samples(0)[max(len(rec.Rows)-2, 0):]
+	origin := time.Now()
+	head, first := newPlayhead(stamps, detectInterval(tail), t.PlaybackSpeed, t.atEnd() == endLoop, origin)
+
+	// Faults are addressed by the sub-path of the signal's service
+	faults := &faultSet{rng: rand.New(rand.NewSource(origin.UnixNano())), name: t.InputFile}
+	if t.FaultLog != "" {
+		if logFile, err := openFaultLog(t.FaultLog); err != nil {
+			log.Printf("unable to open the fault log %s: %v", t.FaultLog, err)
+		} else {
+			defer logFile.Close()
+			faults.logTo = logFile
+		}
+	}
+	signals := map[string]int{"access": 0}
+	for subPath, col := range t.columns {
+		signals[subPath] = col
+	}
+	for _, ft := range t.Faults {
+		f, err := newFault(ft, signals, origin, origin)
+		if err != nil {
+			log.Printf("ignoring scheduled fault: %v", err)
+			continue
+		}
+		faults.add(f, origin)
+	}
+	faultTicker := time.NewTicker(time.Second)
+	defer faultTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
-		case <-ticker.C:
-			i = (i + 1) % len(samples)
-			t.sample = samples[i].Value
-			t.tStamp = time.Now()
+		case now := <-faultTicker.C:
+			values, _ := head.values(rec.Rows, t.interpolation(), now)
+			faults.update(now, values)
 
 		case order := <-t.trayChan:
-			var f forms.SignalA_v1a
-			f.NewForm()
-			f.Value = t.sample
-			f.Unit = sigUnit
-			f.Timestamp = t.tStamp
-			order.ValueP <- f
+			now := time.Now()
+			values, stamp := head.values(rec.Rows, t.interpolation(), now)
+			faults.update(now, values)
+			switch order.Action {
+			case "playback":
+				if err := t.control(head, first, order.Command, now); err != nil {
+					order.Error <- err
+					continue
+				}
+				order.StateP <- t.playbackState(head, first, now)
+
+			case "fault":
+				if err := faults.handle(order.Faults, signals, origin, now); err != nil {
+					order.Error <- err
+					continue
+				}
+				faults.update(now, values) // faults injected for now start at once
+				order.FaultsP <- faults.form()
+
+			default:
+				if order.Column < 0 || order.Column >= len(values) {
+					order.Error <- fmt.Errorf("no signal column %d in %s", order.Column, t.InputFile)
+					continue
+				}
+				value, delay, err := faults.apply(order.Column, values[order.Column], now)
+				if err != nil {
+					order.Error <- err
+					continue
+				}
+				var f forms.SignalA_v1a
+				f.NewForm()
+				f.Value = value
+				f.Unit = sigUnit
+				if u := rec.unit(order.Column); u != "" {
+					f.Unit = u
+				}
+				f.Timestamp = stamp
+				if delay > 0 {
+					// Answer late without holding up the emulation loop
+					time.AfterFunc(delay, func() { order.ValueP <- f })
+					continue
+				}
+				order.ValueP <- f
+			}
 		}
 	}
 }
@@ -252,52 +427,13 @@ func loadSamples(filename string) ([]Sample, error) {
 	}
 }
 
+// loadCSV loads the samples of the first signal column of a CSV file.
 func loadCSV(filename string) ([]Sample, error) {
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	r := csv.NewReader(f)
-
-	// Read and discard header row (timestamp,value)
-	if _, err := r.Read(); err != nil {
-		if err == io.EOF {
-			return nil, nil
-		}
+	rec, err := loadCSVTable(filename)
+	if err != nil || len(rec.Columns) == 0 {
 		return nil, err
 	}
-
-	var samples []Sample
-
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		if len(record) < 2 {
-			continue
-		}
-
-		ts := strings.TrimSpace(record[0])
-		valStr := strings.TrimSpace(record[1])
-
-		v, err := strconv.ParseFloat(valStr, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid value %q: %w", valStr, err)
-		}
-
-		samples = append(samples, Sample{
-			Timestamp: ts,
-			Value:     v,
-		})
-	}
-
-	return samples, nil
+	return rec.samples(0), nil
 }
 
 func loadJSON(filename string) ([]Sample, error) {
@@ -282,6 +282,36 @@ func TestNewResource(t *testing.T) {
 			t.Errorf("InputFile = %q, want %q", tr.InputFile, path)
 		}
 	})
+
+	t.Run("offers only the actuator and the output in plant mode", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		sys := components.NewSystem("emulator", ctx)
+		traitJSON, _ := json.Marshal(Traits{Mode: modePlant, Plant: &heater})
+		cfgAsset := usecases.ConfigurableAsset{
+			Name:   "heater",
+			Traits: []json.RawMessage{traitJSON},
+			Services: []components.Service{
+				{Definition: "signal", SubPath: "access"},
+				{Definition: "playback", SubPath: "playback"},
+				{Definition: "fault", SubPath: "fault"},
+			},
+		}
+
+		ua, cleanup := newResource(cfgAsset, &sys)
+		defer cleanup()
+
+		services := ua.GetServices()
+		if len(services) != 2 {
+			t.Errorf("services = %v, want the actuator and the output", services)
+		}
+		for _, subPath := range []string{serviceName(heater.Actuator), serviceName(heater.Output)} {
+			if _, ok := services[subPath]; !ok {
+				t.Errorf("expected %q service", subPath)
+			}
+		}
+	})
 }
 
 // ── serving ───────────────────────────────────────────────────────────────────
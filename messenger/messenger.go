@@ -71,6 +71,8 @@ func serving(t *Traits, w http.ResponseWriter, r *http.Request, servicePath stri
 	switch servicePath {
 	case "message":
 		t.handleNewMessage(w, r)
+	case "otlp":
+		t.handleOTLPLogs(w, r)
 	case "dashboard":
 		t.handleDashboard(w, r)
 	default:
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sdoque/mbaigo/forms"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxOTLPBody is the largest OTLP export accepted, in bytes.
+const maxOTLPBody = 4 << 20
+
+// The OTLP/HTTP JSON encoding of an ExportLogsServiceRequest, reduced to the
+// fields the messenger keeps. Protobuf payloads are decoded into the generated
+// collogspb types instead.
+type otlpLogsRequest struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeLogs []struct {
+			LogRecords []otlpLogRecord `json:"logRecords"`
+		} `json:"scopeLogs"`
+	} `json:"resourceLogs"`
+}
+
+type otlpLogRecord struct {
+	SeverityNumber int       `json:"severityNumber"`
+	SeverityText   string    `json:"severityText"`
+	Body           otlpValue `json:"body"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpValue is an AnyValue; only its scalar variants are rendered.
+type otlpValue struct {
+	StringValue *string          `json:"stringValue,omitempty"`
+	BoolValue   *bool            `json:"boolValue,omitempty"`
+	IntValue    *json.RawMessage `json:"intValue,omitempty"` // int64 is sent as a JSON string
+	DoubleValue *float64         `json:"doubleValue,omitempty"`
+}
+
+func (v otlpValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return fmt.Sprint(*v.BoolValue)
+	case v.IntValue != nil:
+		return strings.Trim(string(*v.IntValue), `"`)
+	case v.DoubleValue != nil:
+		return fmt.Sprint(*v.DoubleValue)
+	default:
+		return ""
+	}
+}
+
+// handleOTLPLogs accepts OTLP/HTTP log exports (protobuf or JSON encoding) and
+// stores every log record under the name of the service that produced it.
+func (t *Traits) handleOTLPLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != "application/x-protobuf" {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	defer r.Body.Close()
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOTLPBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+
+	if mediaType == "application/x-protobuf" {
+		var req collogspb.ExportLogsServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		t.storeOTLPProto(&req)
+		// An empty ExportLogsServiceResponse encodes to no bytes at all
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var req otlpLogsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	for _, rl := range req.ResourceLogs {
+		system := otlpSystem(rl.Resource.Attributes)
+		for _, sl := range rl.ScopeLogs {
+			for _, rec := range sl.LogRecords {
+				t.storeMessage(message{
+					level:  otlpLevel(rec.SeverityNumber, rec.SeverityText),
+					system: system,
+					source: sourceOTLP,
+					body:   rec.Body.String(),
+				})
+			}
+		}
+	}
+	// An empty ExportLogsServiceResponse signals full success
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte("{}"))
+}
+
+// storeOTLPProto stores the log records of a protobuf-encoded export.
+func (t *Traits) storeOTLPProto(req *collogspb.ExportLogsServiceRequest) {
+	for _, rl := range req.GetResourceLogs() {
+		var service, host string
+		for _, kv := range rl.GetResource().GetAttributes() {
+			switch kv.GetKey() {
+			case "service.name":
+				service = protoValue(kv.GetValue())
+			case "host.name":
+				host = protoValue(kv.GetValue())
+			}
+		}
+		system := senderName(host, service, "otlp")
+		for _, sl := range rl.GetScopeLogs() {
+			for _, rec := range sl.GetLogRecords() {
+				t.storeMessage(message{
+					level:  otlpLevel(int(rec.GetSeverityNumber()), rec.GetSeverityText()),
+					system: system,
+					source: sourceOTLP,
+					body:   protoValue(rec.GetBody()),
+				})
+			}
+		}
+	}
+}
+
+// protoValue renders the scalar variants of a protobuf AnyValue, as
+// otlpValue does for the JSON encoding.
+func protoValue(v *commonpb.AnyValue) string {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return fmt.Sprint(x.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(x.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return fmt.Sprint(x.DoubleValue)
+	default:
+		return ""
+	}
+}
+
+// otlpSystem names the sender from its service.name resource attribute,
+// prefixed by host.name when present.
+func otlpSystem(attributes []otlpKeyValue) string {
+	var service, host string
+	for _, kv := range attributes {
+		switch kv.Key {
+		case "service.name":
+			service = kv.Value.String()
+		case "host.name":
+			host = kv.Value.String()
+		}
+	}
+	return senderName(host, service, "otlp")
+}
+
+// otlpLevel maps an OTLP severity number (1-4 trace, 5-8 debug, 9-12 info,
+// 13-16 warn, 17-24 error and fatal) to a message level. When the number is
+// unspecified, the severity text is used instead.
+func otlpLevel(number int, text string) forms.MessageLevel {
+	if number == 0 {
+		switch strings.ToUpper(text) {
+		case "TRACE", "DEBUG":
+			return forms.LevelDebug
+		case "WARN", "WARNING":
+			return forms.LevelWarn
+		case "ERROR", "FATAL", "CRITICAL":
+			return forms.LevelError
+		default:
+			return forms.LevelInfo
+		}
+	}
+	switch {
+	case number <= 8:
+		return forms.LevelDebug
+	case number <= 12:
+		return forms.LevelInfo
+	case number <= 16:
+		return forms.LevelWarn
+	default:
+		return forms.LevelError
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sdoque/mbaigo/forms"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const otlpBody string = `{
+  "resourceLogs": [{
+    "resource": {"attributes": [
+      {"key": "service.name", "value": {"stringValue": "yolo"}},
+      {"key": "host.name", "value": {"stringValue": "vision"}}
+    ]},
+    "scopeLogs": [{
+      "scope": {"name": "recognizer"},
+      "logRecords": [
+        {"timeUnixNano": "1700000000000000000", "severityNumber": 9, "severityText": "INFO",
+         "body": {"stringValue": "model loaded"}},
+        {"severityNumber": 17, "body": {"stringValue": "camera lost"}},
+        {"severityText": "WARN", "body": {"intValue": "42"}}
+      ]
+    }]
+  }, {
+    "resource": {},
+    "scopeLogs": [{"logRecords": [{"severityNumber": 5, "body": {"boolValue": true}}]}]
+  }]
+}`
+
+func TestHandleOTLPLogs(t *testing.T) {
+	table := []struct {
+		expectedStatus int
+		method         string
+		content        string
+		body           io.ReadCloser
+	}{
+		// Method not post
+		{http.StatusMethodNotAllowed, http.MethodGet, "application/json", nil},
+		// Unsupported encoding
+		{http.StatusUnsupportedMediaType, http.MethodPost, "text/plain", nil},
+		// Too large
+		{http.StatusRequestEntityTooLarge, http.MethodPost, "application/json",
+			io.NopCloser(strings.NewReader(strings.Repeat(" ", maxOTLPBody+1))),
+		},
+		// Not protobuf
+		{http.StatusBadRequest, http.MethodPost, "application/x-protobuf",
+			io.NopCloser(strings.NewReader("\xff\xff\xff")),
+		},
+		// Read body error
+		{http.StatusInternalServerError, http.MethodPost, "application/json", &errorReader{}},
+		// Not JSON
+		{http.StatusBadRequest, http.MethodPost, "application/json",
+			io.NopCloser(strings.NewReader(`resourceLogs`)),
+		},
+		// All ok
+		{http.StatusOK, http.MethodPost, "application/json; charset=utf-8",
+			io.NopCloser(strings.NewReader(otlpBody)),
+		},
+	}
+
+	ua := &Traits{
+		messages: make(map[string][]message),
+	}
+	for _, test := range table {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(test.method, "/otlp", test.body)
+		req.Header.Set("Content-Type", test.content)
+		ua.handleOTLPLogs(rec, req)
+
+		res := rec.Result()
+		if got, want := res.StatusCode, test.expectedStatus; got != want {
+			t.Errorf("expected status %d, got %d", want, got)
+		}
+	}
+}
+
+func TestOTLPSender(t *testing.T) {
+	ua := &Traits{
+		messages: make(map[string][]message),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serving(ua, w, r, "otlp")
+	}))
+	defer srv.Close()
+
+	// A dedicated client, since other tests replace the default client's transport
+	client := &http.Client{Transport: &http.Transport{}}
+	res, err := client.Post(srv.URL, "application/json", strings.NewReader(otlpBody))
+	if err != nil {
+		t.Fatalf("expected no error from post, got %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if got, want := string(body), "{}"; got != want {
+		t.Errorf("expected response '%s', got '%s'", want, got)
+	}
+
+	expected := map[string][]message{
+		"vision/yolo": {
+			{level: forms.LevelInfo, body: "model loaded"},
+			{level: forms.LevelError, body: "camera lost"},
+			{level: forms.LevelWarn, body: "42"},
+		},
+		"otlp": {
+			{level: forms.LevelDebug, body: "true"},
+		},
+	}
+	for system, want := range expected {
+		got := ua.messages[system]
+		if len(got) != len(want) {
+			t.Errorf("expected %d messages from %s, got %d", len(want), system, len(got))
+			continue
+		}
+		for i := range want {
+			if got[i].level != want[i].level || got[i].body != want[i].body {
+				t.Errorf("expected %s message '%s' at level %d, got '%s' at level %d",
+					system, want[i].body, want[i].level, got[i].body, got[i].level)
+			}
+			if got[i].source != sourceOTLP {
+				t.Errorf("expected source '%s', got '%s'", sourceOTLP, got[i].source)
+			}
+		}
+	}
+}
+
+// TestOTLPProtobufSender posts an export in the binary protobuf encoding, the
+// default of OTLP/HTTP exporters such as the Python SDK's.
+func TestOTLPProtobufSender(t *testing.T) {
+	ua := &Traits{
+		messages: make(map[string][]message),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serving(ua, w, r, "otlp")
+	}))
+	defer srv.Close()
+
+	str := func(s string) *commonpb.AnyValue {
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+	}
+	export := &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			{Key: "service.name", Value: str("yolo")},
+			{Key: "host.name", Value: str("vision")},
+		}},
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{
+			{SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_INFO, Body: str("model loaded")},
+			{SeverityText: "ERROR", Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 42}}},
+		}}},
+	}}}
+	payload, err := proto.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{}}
+	res, err := client.Post(srv.URL, "application/x-protobuf", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("expected no error from post, got %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/x-protobuf" {
+		t.Fatalf("expected 200 application/x-protobuf, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if err := proto.Unmarshal(body, &collogspb.ExportLogsServiceResponse{}); err != nil {
+		t.Errorf("response is not an ExportLogsServiceResponse: %v", err)
+	}
+
+	got := ua.messages["vision/yolo"]
+	if len(got) != 2 || got[0].level != forms.LevelInfo || got[0].body != "model loaded" ||
+		got[1].level != forms.LevelError || got[1].body != "42" || got[1].source != sourceOTLP {
+		t.Errorf("unexpected messages %+v", got)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// Largest syslog message accepted, in bytes. RFC 5424 requires receivers to
+// handle at least 480 bytes and recommends 2048; transports may allow more.
+const maxSyslogSize int = 8192
+
+// startSyslog opens the configured syslog listeners and starts serving them in
+// the background. The returned listeners are closed by the resource cleanup.
+func (t *Traits) startSyslog() (listeners []io.Closer, err error) {
+	if t.SyslogUDP != "" {
+		conn, err := net.ListenPacket("udp", t.SyslogUDP)
+		if err != nil {
+			return nil, fmt.Errorf("syslog udp listener: %w", err)
+		}
+		listeners = append(listeners, conn)
+		go t.serveSyslogUDP(conn)
+	}
+	if t.SyslogTCP != "" {
+		l, err := net.Listen("tcp", t.SyslogTCP)
+		if err != nil {
+			for _, c := range listeners {
+				c.Close()
+			}
+			return nil, fmt.Errorf("syslog tcp listener: %w", err)
+		}
+		listeners = append(listeners, l)
+		go t.serveSyslogTCP(l)
+	}
+	return listeners, nil
+}
+
+// serveSyslogUDP reads one syslog message per datagram until the connection is closed.
+func (t *Traits) serveSyslogUDP(conn net.PacketConn) {
+	buf := make([]byte, maxSyslogSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				usecases.LogWarn(t.owner, "syslog udp read: %v", err)
+			}
+			return
+		}
+		t.ingestSyslog(buf[:n])
+	}
+}
+
+// serveSyslogTCP accepts syslog senders until the listener is closed.
+func (t *Traits) serveSyslogTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				usecases.LogWarn(t.owner, "syslog tcp accept: %v", err)
+			}
+			return
+		}
+		go t.handleSyslogConn(conn)
+	}
+}
+
+// handleSyslogConn reads framed syslog messages from a TCP stream. Both framing
+// methods of RFC 6587 are accepted: octet counting ("LEN SP MSG") and
+// newline-terminated messages, detected per frame from its first byte.
+func (t *Traits) handleSyslogConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := readSyslogFrame(r)
+		if len(frame) > 0 {
+			t.ingestSyslog(frame)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// readSyslogFrame returns the next syslog message from a TCP stream.
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] < '1' || first[0] > '9' {
+		line, err := r.ReadBytes('\n')
+		return bytes.TrimRight(line, "\r\n"), err
+	}
+	lenField, err := r.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(lenField))
+	if err != nil || size > maxSyslogSize {
+		return nil, fmt.Errorf("bad octet count %q", lenField)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// ingestSyslog parses a raw syslog message and stores it. Messages that are not
+// valid RFC 5424 are kept anyway, verbatim, so nothing sent to us goes missing.
+func (t *Traits) ingestSyslog(raw []byte) {
+	msg, err := parseSyslog(raw)
+	if err != nil {
+		msg = message{
+			level:  forms.LevelInfo,
+			system: "syslog",
+			body:   strings.TrimSpace(string(raw)),
+		}
+	}
+	msg.source = sourceSyslog
+	t.storeMessage(msg)
+}
+
+// parseSyslog normalises an RFC 5424 message:
+//
+//	<PRI>VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+//
+// The sending host and application name become the message's system, the
+// severity is mapped onto the messenger levels and the MSG part becomes the body.
+// Like other messages, it is stamped with the time of arrival by storeMessage.
+func parseSyslog(raw []byte) (m message, err error) {
+	s := string(raw)
+	if !strings.HasPrefix(s, "<") {
+		return m, fmt.Errorf("missing priority")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return m, fmt.Errorf("bad priority")
+	}
+	pri, err := strconv.Atoi(s[1:end])
+	if err != nil || pri > 191 {
+		return m, fmt.Errorf("bad priority %q", s[1:end])
+	}
+	s = s[end+1:]
+
+	// VERSION, TIMESTAMP, HOSTNAME, APP-NAME, PROCID and MSGID are single tokens
+	header := make([]string, 6)
+	for i := range header {
+		token, rest, found := strings.Cut(s, " ")
+		if !found {
+			return m, fmt.Errorf("truncated header")
+		}
+		header[i], s = token, rest
+	}
+	if header[0] != "1" {
+		return m, fmt.Errorf("unsupported syslog version %q", header[0])
+	}
+
+	s, err = skipStructuredData(s)
+	if err != nil {
+		return m, err
+	}
+	s = strings.TrimPrefix(s, " ")
+	s = strings.TrimPrefix(s, "\ufeff") // UTF-8 byte order mark allowed before MSG
+
+	m.level = syslogLevel(pri % 8)
+	m.system = senderName(nilToEmpty(header[2]), nilToEmpty(header[3]), "syslog")
+	m.body = strings.TrimRight(s, "\r\n")
+	return m, nil
+}
+
+// skipStructuredData returns what follows the STRUCTURED-DATA field, which is
+// either the nil value "-" or one or more [SD-ID PARAM="VALUE" ...] elements
+// where quoted values may contain escaped \" and \] characters.
+func skipStructuredData(s string) (string, error) {
+	if strings.HasPrefix(s, "-") {
+		return s[1:], nil
+	}
+	for strings.HasPrefix(s, "[") {
+		end := sdElementEnd(s)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated structured data")
+		}
+		s = s[end+1:]
+	}
+	if s != "" && !strings.HasPrefix(s, " ") {
+		return "", fmt.Errorf("bad structured data")
+	}
+	return s, nil
+}
+
+// sdElementEnd returns the index of the "]" closing the SD-ELEMENT that starts s, or -1.
+func sdElementEnd(s string) int {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++ // skip the escaped character
+		case c == '"':
+			quoted = !quoted
+		case c == ']' && !quoted:
+			return i
+		}
+	}
+	return -1
+}
+
+// syslogLevel maps an RFC 5424 severity (0 emergency ... 7 debug) to a message level.
+func syslogLevel(severity int) forms.MessageLevel {
+	switch {
+	case severity <= 3: // emergency, alert, critical, error
+		return forms.LevelError
+	case severity == 4: // warning
+		return forms.LevelWarn
+	case severity <= 6: // notice, informational
+		return forms.LevelInfo
+	default:
+		return forms.LevelDebug
+	}
+}
+
+// nilToEmpty turns the syslog NILVALUE "-" into an empty string.
+func nilToEmpty(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
+
+// senderName names an external sender from its host and application, either of
+// which may be unknown (empty), falling back to the name of the ingestion path.
+func senderName(host, app, fallback string) string {
+	switch {
+	case host == "" && app == "":
+		return fallback
+	case host == "":
+		return app
+	case app == "":
+		return host
+	default:
+		return host + "/" + app
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+)
+
+func TestParseSyslog(t *testing.T) {
+	table := []struct {
+		raw       string
+		expectErr bool
+		level     forms.MessageLevel
+		system    string
+		body      string
+	}{
+		// Missing priority
+		{"1 - - - - - - hello", true, 0, "", ""},
+		// Bad priority
+		{"<x>1 - - - - - - hello", true, 0, "", ""},
+		// Priority out of range
+		{"<192>1 - - - - - - hello", true, 0, "", ""},
+		// Old BSD style message
+		{"<34>Oct 11 22:14:15 mymachine su: 'su root' failed", true, 0, "", ""},
+		// Truncated header
+		{"<34>1 2003-10-11T22:14:15.003Z host", true, 0, "", ""},
+		// Unterminated structured data
+		{`<34>1 - host app - - [id a="b" hello`, true, 0, "", ""},
+		// Critical from RFC 5424 example, with BOM
+		{"<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - \ufeff'su root' failed",
+			false, forms.LevelError, "mymachine.example.com/su", "'su root' failed"},
+		// Warning with structured data containing escapes
+		{`<164>1 2003-10-11T22:14:15.003Z graphdb java 42 - [exampleSDID@32473 iut="3" eventID="\]1011"][x@1 y="\"z\""] heap low`,
+			false, forms.LevelWarn, "graphdb/java", "heap low"},
+		// Notice without message and unknown host
+		{"<13>1 - - yolo - - -", false, forms.LevelInfo, "yolo", ""},
+		// Debug with nothing known about the sender
+		{"<15>1 - - - - - - trace me\n", false, forms.LevelDebug, "syslog", "trace me"},
+	}
+
+	for _, test := range table {
+		msg, err := parseSyslog([]byte(test.raw))
+		if got, want := err != nil, test.expectErr; got != want {
+			t.Errorf("%q: expected error %v, got: %v", test.raw, want, err)
+		}
+		if err != nil {
+			continue
+		}
+		if got, want := msg.level, test.level; got != want {
+			t.Errorf("%q: expected level %d, got %d", test.raw, want, got)
+		}
+		if got, want := msg.system, test.system; got != want {
+			t.Errorf("%q: expected system '%s', got '%s'", test.raw, want, got)
+		}
+		if got, want := msg.body, test.body; got != want {
+			t.Errorf("%q: expected body '%s', got '%s'", test.raw, want, got)
+		}
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	want := []string{"<13>1 - h a - - - one", "<13>1 - h a - - - two"}
+	stream := fmt.Sprintf("%d %s%s\n5 <13>", len(want[0]), want[0], want[1])
+	r := bufio.NewReader(strings.NewReader(stream))
+
+	for _, w := range want {
+		frame, err := readSyslogFrame(r)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if got := string(frame); got != w {
+			t.Errorf("expected frame '%s', got '%s'", w, got)
+		}
+	}
+	// The last frame is shorter than its octet count
+	if _, err := readSyslogFrame(r); err == nil {
+		t.Errorf("expected error from truncated frame, got nil")
+	}
+}
+
+// waitForMessages polls the log of a system until it holds count messages.
+func waitForMessages(tr *Traits, system string, count int) []message {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		tr.mutex.RLock()
+		msgs := append([]message(nil), tr.messages[system]...)
+		tr.mutex.RUnlock()
+		if len(msgs) >= count {
+			return msgs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestSyslogListeners(t *testing.T) {
+	sys := components.NewSystem("test sys", context.Background())
+	tr := &Traits{
+		SyslogUDP: "127.0.0.1:0",
+		SyslogTCP: "127.0.0.1:0",
+		messages:  make(map[string][]message),
+		owner:     &sys,
+	}
+	listeners, err := tr.startSyslog()
+	if err != nil {
+		t.Fatalf("expected no error from startSyslog, got %v", err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	udpAddr := listeners[0].(net.PacketConn).LocalAddr().String()
+	tcpAddr := listeners[1].(net.Listener).Addr().String()
+
+	// In-process UDP sender, one message per datagram
+	udp, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		t.Fatalf("dial udp: %v", err)
+	}
+	fmt.Fprint(udp, "<11>1 - fa3st server - - - connection refused")
+	udp.Close()
+
+	// In-process TCP sender, mixing both framing methods
+	tcp, err := net.Dial("tcp", tcpAddr)
+	if err != nil {
+		t.Fatalf("dial tcp: %v", err)
+	}
+	line := "<12>1 - fa3st server - - - slow response"
+	fmt.Fprintf(tcp, "%d %s", len(line), line)
+	fmt.Fprint(tcp, "<14>1 - fa3st server - - - started\n")
+	fmt.Fprint(tcp, "not syslog at all\n")
+	tcp.Close()
+
+	msgs := waitForMessages(tr, "fa3st/server", 3)
+	if got, want := len(msgs), 3; got != want {
+		t.Fatalf("expected %d messages, got %d", want, got)
+	}
+	levels := map[forms.MessageLevel]bool{}
+	for _, m := range msgs {
+		levels[m.level] = true
+		if got, want := m.source, sourceSyslog; got != want {
+			t.Errorf("expected source '%s', got '%s'", want, got)
+		}
+	}
+	for _, lvl := range []forms.MessageLevel{forms.LevelError, forms.LevelWarn, forms.LevelInfo} {
+		if !levels[lvl] {
+			t.Errorf("expected a %s message", forms.LevelToString(lvl))
+		}
+	}
+	// Unparsable messages are still kept
+	if got := waitForMessages(tr, "syslog", 1); len(got) != 1 || got[0].body != "not syslog at all" {
+		t.Errorf("expected the raw message to be stored, got %v", got)
+	}
+}
+
+func TestStartSyslogBadAddress(t *testing.T) {
+	table := []struct {
+		udp string
+		tcp string
+	}{
+		// Bad UDP address
+		{"bad address", ""},
+		// Bad TCP address, the UDP listener is closed again
+		{"127.0.0.1:0", "bad address"},
+	}
+	for _, test := range table {
+		tr := &Traits{SyslogUDP: test.udp, SyslogTCP: test.tcp}
+		if _, err := tr.startSyslog(); err == nil {
+			t.Errorf("expected error for udp '%s' and tcp '%s', got nil", test.udp, test.tcp)
+		}
+	}
+}
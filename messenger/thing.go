@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
@@ -20,24 +21,36 @@ import (
 	"github.com/sdoque/mbaigo/usecases"
 )
 
+// Source labels tell where a message entered the messenger
+const (
+	sourceMbaigo string = "mbaigo" // SystemMessage_v1 posted by an mbaigo system
+	sourceSyslog string = "syslog" // RFC 5424 syslog over UDP or TCP
+	sourceOTLP   string = "otlp"   // OpenTelemetry log record over OTLP/HTTP
+)
+
 type message struct {
 	time   time.Time
 	level  forms.MessageLevel
 	system string
+	source string
 	body   string
 }
 
 func (m message) String() string {
-	return fmt.Sprintf("%s - %s - %s: %s",
+	return fmt.Sprintf("%s [%s] - %s - %s: %s",
 		m.system,
+		m.source,
 		m.time.Format("2006-01-02 15:04:05"),
 		forms.LevelToString(m.level),
 		m.body,
 	)
 }
 
-// Traits holds the asset-specific runtime state for the messenger
+// Traits holds the asset-specific configuration and runtime state for the messenger
 type Traits struct {
+	SyslogUDP string `json:"syslogUDP"` // Listen address for RFC 5424 syslog over UDP (empty disables it)
+	SyslogTCP string `json:"syslogTCP"` // Listen address for RFC 5424 syslog over TCP (empty disables it)
+
 	cachedRegMsg  []byte               // Caches the MessengerRegistration form
 	messages      map[string][]message // Per system msg log
 	mutex         sync.RWMutex         // Protects concurrent access to previous field
@@ -53,10 +66,24 @@ func initTemplate() *components.UnitAsset {
 		RegPeriod:   30,
 		Description: "stores a new message in the log database",
 	}
+	otlpService := components.Service{
+		Definition:  "otlp_logs",
+		SubPath:     "otlp",
+		Details:     map[string][]string{"Forms": {"OTLP/HTTP protobuf", "OTLP/HTTP JSON"}},
+		RegPeriod:   30,
+		Description: "stores OpenTelemetry log records exported over OTLP/HTTP (POST)",
+	}
 	return &components.UnitAsset{
-		Name:        "log",
-		Details:     map[string][]string{},
-		ServicesMap: components.Services{service.SubPath: &service},
+		Name:    "log",
+		Details: map[string][]string{},
+		ServicesMap: components.Services{
+			service.SubPath:     &service,
+			otlpService.SubPath: &otlpService,
+		},
+		Traits: &Traits{
+			SyslogUDP: ":5514",
+			SyslogTCP: ":5514",
+		},
 	}
 }
 
@@ -77,6 +104,11 @@ func newResource(ca usecases.ConfigurableAsset, sys *components.System) (*compon
 		ServicesMap: usecases.MakeServiceMap(ca.Services),
 		Traits:      t,
 	}
+	if len(ca.Traits) > 0 {
+		if err := json.Unmarshal(ca.Traits[0], t); err != nil {
+			return nil, nil, fmt.Errorf("unmarshal traits: %w", err)
+		}
+	}
 
 	var err error
 	t.tmplDashboard, err = template.New("dashboard").Parse(tmplDashboard)
@@ -92,8 +124,17 @@ func newResource(ca usecases.ConfigurableAsset, sys *components.System) (*compon
 		serving(t, w, r, servicePath)
 	}
 
+	listeners, err := t.startSyslog()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	go t.runBeacon()
-	f := func() {}
+	f := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
 	return ua, f, nil
 }
 
@@ -279,17 +320,24 @@ const maxMessages int = 10
 // oldest, if the log's size is larger than maxMessages.
 // Note that this function sets the timestamp of the incoming msg too.
 func (t *Traits) addMessage(msg forms.SystemMessage_v1) {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
-	t.messages[msg.System] = append(t.messages[msg.System], message{
-		time:   time.Now(),
+	t.storeMessage(message{
 		level:  msg.Level,
 		system: msg.System,
+		source: sourceMbaigo,
 		body:   msg.Body,
 	})
-	if len(t.messages[msg.System]) > maxMessages {
+}
+
+// storeMessage appends a normalised message to its system's log, stamping it
+// with the time of arrival like any other message, and trims the log to maxMessages.
+func (t *Traits) storeMessage(m message) {
+	m.time = time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.messages[m.system] = append(t.messages[m.system], m)
+	if len(t.messages[m.system]) > maxMessages {
 		// Strips the oldest msg from the front of the slice
-		t.messages[msg.System] = t.messages[msg.System][1:]
+		t.messages[m.system] = t.messages[m.system][1:]
 	}
 }
 
@@ -0,0 +1,247 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ *   Thomas Hedeler, Hamburg - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// Defaults of the actuator resolution cache
+const (
+	defaultResolveTTL   = 3600 // seconds a resolved actuator is trusted
+	defaultResolveRetry = 60   // seconds between attempts on an unresolved node
+)
+
+// diagnosesActuatorDetail is the registration detail by which a sensor
+// provider can name the actuator it diagnoses, by FL tag and optionally IRI.
+const diagnosesActuatorDetail = "DiagnosesActuator"
+
+// ActuatorT is the actuator a sensor diagnoses, as configured in the traits.
+type ActuatorT struct {
+	Tag string `json:"tag"`           // functional-location tag, e.g. "827-PV2708-200"
+	IRI string `json:"iri,omitempty"` // functional location in the STEP graph
+}
+
+// actuatorLookup is the answer of GraphDB for the sensor of a node.
+type actuatorLookup struct {
+	node     string
+	sensor   string
+	details  map[string][]string
+	tag, iri string
+	err      error
+}
+
+// actuatorLookups tracks the GraphDB lookups of a signal, which run outside
+// the sampling loop. The loop collects their answers on its next tick.
+type actuatorLookups struct {
+	mu       sync.Mutex
+	inFlight map[string]bool // node → lookup running
+	done     []actuatorLookup
+	wg       sync.WaitGroup
+}
+
+// start runs a lookup for a node unless one is already running.
+func (l *actuatorLookups) start(endpoint, node, sensorName string, details map[string][]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[node] {
+		return
+	}
+	l.inFlight[node] = true
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		tag, iri, err := resolveActuator(endpoint, sensorName, 5*time.Second)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.done = append(l.done, actuatorLookup{node: node, sensor: sensorName, details: details, tag: tag, iri: iri, err: err})
+	}()
+}
+
+// collect returns the finished lookups.
+func (l *actuatorLookups) collect() []actuatorLookup {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	done := l.done
+	l.done = nil
+	for _, r := range done {
+		delete(l.inFlight, r.node)
+	}
+	return done
+}
+
+// running tells whether a lookup for a node is still running.
+func (l *actuatorLookups) running(node string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight[node]
+}
+
+// resolveActuators looks up the actuator (e.g. valve) functional-location tag
+// for the nodes in nodes whose cached result expired or was never obtained.
+// The sources are tried in turn: GraphDB, the DiagnosesActuator detail of the
+// sensor's registration record, then the actuators trait. A node none of them
+// can resolve is skipped in polls — emitting a maintenance order against a
+// misidentified asset is a worse failure mode than emitting nothing — and
+// tried again every resolveRetry seconds, so that monitoring resumes once
+// GraphDB answers or the relationship is added. A resolved node whose cache
+// expires keeps its actuator if the new lookup fails.
+//
+// GraphDB is asked in the background so that a slow triple store does not
+// hold up the sampling: until it answers, a new node uses the actuator of
+// its registration record or of the traits, and its answer is taken up on a
+// later call.
+//
+// The expected graph shape is:
+//
+//	<sensor-iri>  afo:hasName            "<sensor-tag>" .
+//	<sensor-iri>  afo:diagnosesActuator  <valve-fl-iri> .
+//	<valve-fl-iri> arrowhead:functionalLocation "<valve-tag>" .
+//
+// The third triple is already in the Skoghall store for modelled equipment;
+// the first two must be added by INSERT when the sensor's knowledge graph
+// is published to GraphDB.
+func (t *Traits) resolveActuators(sig *SignalT, nodes map[string][]components.NodeInfo, now time.Time) {
+	if sig.lookups == nil {
+		sig.lookups = &actuatorLookups{inFlight: make(map[string]bool)}
+	}
+	retryAt := now.Add(time.Duration(orInt(t.ResolveRetry, defaultResolveRetry)) * time.Second)
+	for _, r := range sig.lookups.collect() {
+		if r.err == nil {
+			t.resolved(sig, r.node, r.sensor, r.tag, r.iri, "GraphDB", now)
+			continue
+		}
+		tag, iri, source, err := t.fallbackActuator(r.sensor, r.details)
+		if err == nil {
+			t.resolved(sig, r.node, r.sensor, tag, iri, source, now)
+			continue
+		}
+		t.unresolved(sig, r.node, r.sensor, errors.Join(fmt.Errorf("GraphDB: %w", r.err), err), retryAt)
+	}
+
+	for node, nodeInfos := range nodes {
+		if len(nodeInfos) == 0 || sig.lookups.running(node) {
+			continue
+		}
+		if until, ok := sig.ResolvedUntil[node]; ok && now.Before(until) {
+			continue
+		}
+		if retry, ok := sig.UnresolvableNodes[node]; ok && now.Before(retry) {
+			continue
+		}
+		sensorName := assetNameFromURL(nodeInfos[0].URL)
+		if sensorName == "" {
+			log.Printf("nurse: cannot extract sensor name from %s; node %s unresolvable", nodeInfos[0].URL, node)
+			sig.UnresolvableNodes[node] = retryAt
+			continue
+		}
+		details := nodeInfos[0].Details
+		if t.GraphDB_URL != "" {
+			sig.lookups.start(t.GraphDB_URL, node, sensorName, details)
+			if _, known := sig.ValveTagByNode[node]; known {
+				continue // the cached actuator is used until GraphDB answers
+			}
+		}
+		tag, iri, source, err := t.fallbackActuator(sensorName, details)
+		switch {
+		case err == nil && t.GraphDB_URL != "":
+			log.Printf("nurse: sensor %s on node %s diagnoses actuator %s (FL IRI %s, from %s) until GraphDB answers",
+				sensorName, node, tag, iri, source)
+			sig.ValveTagByNode[node] = tag
+			sig.ValveIRIByNode[node] = iri
+			delete(sig.UnresolvableNodes, node)
+		case err == nil:
+			t.resolved(sig, node, sensorName, tag, iri, source, now)
+		case t.GraphDB_URL != "":
+			sig.UnresolvableNodes[node] = retryAt // skipped until GraphDB answers
+		default:
+			t.unresolved(sig, node, sensorName, err, retryAt)
+		}
+	}
+}
+
+// resolved caches the actuator of a node.
+func (t *Traits) resolved(sig *SignalT, node, sensorName, tag, iri, source string, now time.Time) {
+	log.Printf("nurse: sensor %s on node %s diagnoses actuator %s (FL IRI %s, from %s)",
+		sensorName, node, tag, iri, source)
+	sig.ValveTagByNode[node] = tag
+	sig.ValveIRIByNode[node] = iri
+	sig.ResolvedUntil[node] = now.Add(time.Duration(orInt(t.ResolveTTL, defaultResolveTTL)) * time.Second)
+	delete(sig.UnresolvableNodes, node)
+}
+
+// unresolved keeps the cached actuator of a node until retryAt, or parks the
+// node until then if it has none.
+func (t *Traits) unresolved(sig *SignalT, node, sensorName string, err error, retryAt time.Time) {
+	if _, known := sig.ValveTagByNode[node]; known {
+		log.Printf("nurse: cannot refresh actuator for sensor %s on node %s, keeping %s: %v",
+			sensorName, node, sig.ValveTagByNode[node], err)
+		sig.ResolvedUntil[node] = retryAt
+		return
+	}
+	log.Printf("nurse: cannot resolve actuator for sensor %s on node %s, retrying at %s: %v",
+		sensorName, node, retryAt.Format(time.RFC3339), err)
+	sig.UnresolvableNodes[node] = retryAt
+}
+
+// fallbackActuator returns the actuator a sensor diagnoses according to its
+// registration record or the traits, and the source that named it.
+func (t *Traits) fallbackActuator(sensorName string, details map[string][]string) (tag, iri, source string, err error) {
+	var errs []error
+	if tag, iri, err = actuatorFromDetails(details); err == nil {
+		return tag, iri, "registration record", nil
+	}
+	errs = append(errs, fmt.Errorf("registration record: %w", err))
+	if a, ok := t.Actuators[sensorName]; ok && a.Tag != "" {
+		return a.Tag, a.IRI, "traits", nil
+	}
+	errs = append(errs, fmt.Errorf("traits: no actuator for sensor %q", sensorName))
+	return "", "", "", errors.Join(errs...)
+}
+
+// actuatorFromDetails reads the DiagnosesActuator detail of a registration
+// record. Its values are the actuator's FL tag and, optionally, its IRI (told
+// apart by the scheme); several tags are ambiguous.
+func actuatorFromDetails(details map[string][]string) (tag, iri string, err error) {
+	for _, v := range details[diagnosesActuatorDetail] {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+		case strings.Contains(v, "://"):
+			if iri != "" && iri != v {
+				return "", "", fmt.Errorf("ambiguous %s detail (%s, %s)", diagnosesActuatorDetail, iri, v)
+			}
+			iri = v
+		default:
+			if tag != "" && tag != v {
+				return "", "", fmt.Errorf("ambiguous %s detail (%s, %s)", diagnosesActuatorDetail, tag, v)
+			}
+			tag = v
+		}
+	}
+	if tag == "" {
+		return "", "", fmt.Errorf("no %s detail", diagnosesActuatorDetail)
+	}
+	return tag, iri, nil
+}
@@ -0,0 +1,225 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// fakeGraphDB answers the diagnosesActuator query from its table, or fails
+// every request while down.
+type fakeGraphDB struct {
+	mu      sync.Mutex
+	down    bool
+	queries int
+	valves  map[string]string // sensor name → valve tag
+}
+
+func (g *fakeGraphDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.queries++
+	if g.down {
+		http.Error(w, "repository unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	query, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/sparql-results+json")
+	for sensor, tag := range g.valves {
+		if strings.Contains(string(query), fmt.Sprintf("%q", sensor)) {
+			fmt.Fprintf(w, `{"results":{"bindings":[{"valveFL":{"value":"https://example.org/fl/%s"},"valveTag":{"value":%q}}]}}`, tag, tag)
+			return
+		}
+	}
+	w.Write([]byte(`{"results":{"bindings":[]}}`))
+}
+
+func actuatorTraits(graphDB string) (*Traits, *SignalT) {
+	tr := &Traits{
+		GraphDB_URL: graphDB,
+		Signals: []SignalT{{
+			Name:              "pressure",
+			ValveTagByNode:    map[string]string{},
+			ValveIRIByNode:    map[string]string{},
+			ResolvedUntil:     map[string]time.Time{},
+			UnresolvableNodes: map[string]time.Time{},
+		}},
+	}
+	return tr, &tr.Signals[0]
+}
+
+func sensorNodes(details map[string][]string) map[string][]components.NodeInfo {
+	return map[string][]components.NodeInfo{
+		"emulator/827PD2708": {{URL: "http://10.0.0.5:20153/emulator/827PD2708/pressure", Details: details}},
+	}
+}
+
+// resolveAndWait resolves the actuators and takes up the GraphDB answers.
+func resolveAndWait(tr *Traits, sig *SignalT, nodes map[string][]components.NodeInfo, now time.Time) {
+	tr.resolveActuators(sig, nodes, now)
+	sig.lookups.wg.Wait()
+	tr.resolveActuators(sig, nodes, now)
+}
+
+func TestActuatorFromGraphDB(t *testing.T) {
+	g := &fakeGraphDB{valves: map[string]string{"827PD2708": "827-PV2708-200"}}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	tr, sig := actuatorTraits(srv.URL)
+	details := map[string][]string{diagnosesActuatorDetail: {"OTHER-TAG"}}
+	resolveAndWait(tr, sig, sensorNodes(details), t0)
+
+	node := "emulator/827PD2708"
+	if sig.ValveTagByNode[node] != "827-PV2708-200" || sig.ValveIRIByNode[node] != "https://example.org/fl/827-PV2708-200" {
+		t.Fatalf("resolved %q (%q)", sig.ValveTagByNode[node], sig.ValveIRIByNode[node])
+	}
+
+	// Cached until the TTL expires
+	resolveAndWait(tr, sig, sensorNodes(details), t0.Add(59*time.Minute))
+	if g.queries != 1 {
+		t.Errorf("%d queries within the TTL, want 1", g.queries)
+	}
+	resolveAndWait(tr, sig, sensorNodes(details), t0.Add(time.Hour))
+	if g.queries != 2 {
+		t.Errorf("%d queries after the TTL, want 2", g.queries)
+	}
+
+	// A failed refresh keeps the cached actuator
+	g.down = true
+	tr.Actuators = nil
+	resolveAndWait(tr, sig, sensorNodes(nil), t0.Add(2*time.Hour))
+	if sig.ValveTagByNode[node] != "827-PV2708-200" {
+		t.Errorf("lost the cached actuator: %q", sig.ValveTagByNode[node])
+	}
+	if _, unresolved := sig.UnresolvableNodes[node]; unresolved {
+		t.Error("a resolved node was parked")
+	}
+}
+
+func TestActuatorFallbacks(t *testing.T) {
+	g := &fakeGraphDB{down: true}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	node := "emulator/827PD2708"
+
+	// Registration record
+	tr, sig := actuatorTraits(srv.URL)
+	tr.Actuators = map[string]ActuatorT{"827PD2708": {Tag: "FROM-TRAITS"}}
+	resolveAndWait(tr, sig, sensorNodes(map[string][]string{
+		diagnosesActuatorDetail: {"827-PV2708-200", "https://example.org/fl/827-PV2708-200"},
+	}), t0)
+	if sig.ValveTagByNode[node] != "827-PV2708-200" || sig.ValveIRIByNode[node] != "https://example.org/fl/827-PV2708-200" {
+		t.Errorf("from the record: %q (%q)", sig.ValveTagByNode[node], sig.ValveIRIByNode[node])
+	}
+
+	// Traits
+	tr, sig = actuatorTraits(srv.URL)
+	tr.Actuators = map[string]ActuatorT{"827PD2708": {Tag: "FROM-TRAITS"}}
+	resolveAndWait(tr, sig, sensorNodes(nil), t0)
+	if sig.ValveTagByNode[node] != "FROM-TRAITS" || sig.ValveIRIByNode[node] != "" {
+		t.Errorf("from the traits: %q (%q)", sig.ValveTagByNode[node], sig.ValveIRIByNode[node])
+	}
+}
+
+func TestUnresolvedNodeIsRetried(t *testing.T) {
+	g := &fakeGraphDB{valves: map[string]string{}}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	node := "emulator/827PD2708"
+
+	tr, sig := actuatorTraits(srv.URL)
+	tr.ResolveRetry = 30
+	resolveAndWait(tr, sig, sensorNodes(nil), t0)
+	if retry, ok := sig.UnresolvableNodes[node]; !ok || !retry.Equal(t0.Add(30*time.Second)) {
+		t.Fatalf("unresolvable nodes = %v", sig.UnresolvableNodes)
+	}
+
+	resolveAndWait(tr, sig, sensorNodes(nil), t0.Add(10*time.Second))
+	if g.queries != 1 {
+		t.Errorf("retried before the retry period (%d queries)", g.queries)
+	}
+
+	// The relationship is published in the graph
+	g.valves["827PD2708"] = "827-PV2708-200"
+	resolveAndWait(tr, sig, sensorNodes(nil), t0.Add(30*time.Second))
+	if sig.ValveTagByNode[node] != "827-PV2708-200" {
+		t.Errorf("not resolved after the retry: %v", sig.ValveTagByNode)
+	}
+	if _, still := sig.UnresolvableNodes[node]; still {
+		t.Error("the node is still parked")
+	}
+}
+
+func TestActuatorFromDetails(t *testing.T) {
+	if _, _, err := actuatorFromDetails(map[string][]string{diagnosesActuatorDetail: {"A", "B"}}); err == nil {
+		t.Error("two tags should be ambiguous")
+	}
+	if _, _, err := actuatorFromDetails(map[string][]string{diagnosesActuatorDetail: {"https://example.org/fl/A"}}); err == nil {
+		t.Error("an IRI alone has no tag")
+	}
+	if tag, iri, err := actuatorFromDetails(map[string][]string{diagnosesActuatorDetail: {" A ", "A"}}); err != nil || tag != "A" || iri != "" {
+		t.Errorf("got %q %q %v", tag, iri, err)
+	}
+}
+
+func TestSlowGraphDBDoesNotHoldSampling(t *testing.T) {
+	release := make(chan struct{})
+	g := &fakeGraphDB{valves: map[string]string{"827PD2708": "827-PV2708-200"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		g.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	node := "emulator/827PD2708"
+
+	tr, sig := actuatorTraits(srv.URL)
+	tr.Actuators = map[string]ActuatorT{"827PD2708": {Tag: "FROM-TRAITS"}}
+	begin := time.Now()
+	tr.resolveActuators(sig, sensorNodes(nil), t0)
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("resolution waited %s for GraphDB", elapsed)
+	}
+	if sig.ValveTagByNode[node] != "FROM-TRAITS" {
+		t.Errorf("meanwhile: %q, want the traits' actuator", sig.ValveTagByNode[node])
+	}
+	if _, unresolved := sig.UnresolvableNodes[node]; unresolved {
+		t.Error("a node with a fallback actuator was parked")
+	}
+
+	// A later tick does not ask again while the lookup runs
+	tr.resolveActuators(sig, sensorNodes(nil), t0.Add(10*time.Second))
+	close(release)
+	sig.lookups.wg.Wait()
+	if g.queries != 1 {
+		t.Errorf("%d queries, want 1", g.queries)
+	}
+
+	// GraphDB's answer takes over on the next tick
+	tr.resolveActuators(sig, sensorNodes(nil), t0.Add(20*time.Second))
+	if sig.ValveTagByNode[node] != "827-PV2708-200" {
+		t.Errorf("after GraphDB answered: %q", sig.ValveTagByNode[node])
+	}
+}
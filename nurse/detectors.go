@@ -0,0 +1,524 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ *   Thomas Hedeler, Hamburg - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Detector kinds
+const (
+	detectBand     = "band"     // value outside [lower, upper]
+	detectRate     = "rate"     // rate of change beyond maxRate per second
+	detectZScore   = "zscore"   // value too many standard deviations from a rolling window
+	detectEWMA     = "ewma"     // exponentially weighted moving average drifted from the target
+	detectCUSUM    = "cusum"    // cumulative sum of deviations from the target
+	detectFlatline = "flatline" // value not moving: stuck sensor
+	detectCross    = "cross"    // value disagrees with another monitored signal
+)
+
+// DetectorT configures an anomaly detector of a signal. Each detector scores
+// every reading; a score of 1 or more is anomalous, and count anomalous
+// readings in a row from the same source raise a maintenance order.
+type DetectorT struct {
+	Kind      string   `json:"kind"`
+	Count     int      `json:"count,omitempty"`     // consecutive anomalous readings; 5 for a band, 1 otherwise
+	Lower     *float64 `json:"lower,omitempty"`     // band; the signal's thresholds if not given
+	Upper     *float64 `json:"upper,omitempty"`     // band
+	MaxRate   float64  `json:"maxRate,omitempty"`   // rate: units per second
+	Window    int      `json:"window,omitempty"`    // zscore, ewma, cusum (learning), flatline: number of readings
+	Threshold float64  `json:"threshold,omitempty"` // zscore and ewma: limit in standard deviations (3); cusum: decision interval (5)
+	Lambda    *float64 `json:"lambda,omitempty"`    // ewma smoothing factor (0.2); 0 holds the average at the target
+	Slack     *float64 `json:"slack,omitempty"`     // cusum allowance in standard deviations (0.5)
+	Target    *float64 `json:"target,omitempty"`    // ewma and cusum mean; learned from the first window if not given
+	Sigma     *float64 `json:"sigma,omitempty"`     // ewma and cusum standard deviation; learned if not given
+	Tolerance float64  `json:"tolerance,omitempty"` // flatline: largest spread still flat; cross: largest disagreement
+	Signal    string   `json:"signal,omitempty"`    // cross: the other monitored signal's service definition
+	Gain      *float64 `json:"gain,omitempty"`      // cross: expected value = gain × other + offset (gain 1)
+	Offset    float64  `json:"offset,omitempty"`    // cross
+	MaxAge    float64  `json:"maxAge,omitempty"`    // cross: seconds after which the other reading is too old (60)
+	PairBy    string   `json:"pairBy,omitempty"`    // cross: provider detail pairing the readings, e.g. "FunctionalLocation"; the node if not given
+
+	OrderTemplate *OrderTemplateT `json:"orderTemplate,omitempty"` // orders raised by this detector
+}
+
+// finding is an anomaly confirmed by a detector.
+type finding struct {
+	Detector string
+	Value    float64
+	Score    float64
+	Reason   string
+	Template *OrderTemplateT // the detector's order template, if any
+}
+
+// describe explains the finding in a maintenance order's description.
+func (f *finding) describe(signal string) string {
+	return fmt.Sprintf("Signal %s: %s (%s detector, score %.2f)", signal, f.Reason, f.Detector, f.Score)
+}
+
+// reading is the latest value of a signal, for cross-signal rules.
+type reading struct {
+	value float64
+	at    time.Time
+}
+
+// source is a provider of a signal: its node and the details it registered.
+type source struct {
+	node    string
+	details map[string][]string
+}
+
+// detector scores a reading. The reason explains a score of 1 or more.
+type detector interface {
+	observe(v float64, at time.Time) (score float64, reason string)
+}
+
+// detectorSet holds the detectors of one source of a signal and their
+// consecutive anomaly counts.
+type detectorSet struct {
+	cfgs      []DetectorT
+	detectors []detector
+	counts    []int
+}
+
+// detectorConfigs returns the detectors of a signal: a band on its thresholds
+// confirmed by 5 readings unless detectors are configured.
+func (sig *SignalT) detectorConfigs() []DetectorT {
+	if len(sig.Detectors) > 0 {
+		return sig.Detectors
+	}
+	return []DetectorT{{Kind: detectBand}}
+}
+
+// validateDetectors checks the detector configuration of a signal.
+func (t *Traits) validateDetectors(sig *SignalT) error {
+	if err := sig.OrderTemplate.validate(); err != nil {
+		return fmt.Errorf("signal %s: %w", sig.Name, err)
+	}
+	for _, cfg := range sig.detectorConfigs() {
+		if _, err := newDetector(cfg, sig, t.pairedReadings(source{}, cfg.PairBy)); err != nil {
+			return fmt.Errorf("signal %s: %w", sig.Name, err)
+		}
+		if cfg.Kind == detectCross && t.findSignal(cfg.Signal) == nil {
+			return fmt.Errorf("signal %s: cross detector refers to %q, which is not monitored", sig.Name, cfg.Signal)
+		}
+		if err := cfg.OrderTemplate.validate(); err != nil {
+			return fmt.Errorf("signal %s, %s detector: %w", sig.Name, cfg.Kind, err)
+		}
+	}
+	return nil
+}
+
+// newDetector makes a detector from its configuration. The signal gives the
+// default band, and latest the other signals' readings paired with the source.
+func newDetector(cfg DetectorT, sig *SignalT, latest func(string) (reading, bool)) (detector, error) {
+	if cfg.Count < 0 || cfg.Window < 0 || cfg.Threshold < 0 || cfg.Tolerance < 0 {
+		return nil, fmt.Errorf("%s detector: count, window, threshold and tolerance must not be negative", cfg.Kind)
+	}
+	switch cfg.Kind {
+	case detectBand:
+		d := &bandDetector{lower: sig.LowerThreshold, upper: sig.UpperThreshold}
+		if cfg.Lower != nil {
+			d.lower = *cfg.Lower
+		}
+		if cfg.Upper != nil {
+			d.upper = *cfg.Upper
+		}
+		if d.upper < d.lower {
+			return nil, fmt.Errorf("band detector: upper %g below lower %g", d.upper, d.lower)
+		}
+		return d, nil
+	case detectRate:
+		if cfg.MaxRate <= 0 {
+			return nil, fmt.Errorf("rate detector: maxRate must be positive")
+		}
+		return &rateDetector{maxRate: cfg.MaxRate}, nil
+	case detectZScore:
+		return &zScoreDetector{window: orInt(cfg.Window, 30), limit: orFloat(cfg.Threshold, 3)}, nil
+	case detectEWMA, detectCUSUM:
+		lambda, slack := valueOr(cfg.Lambda, 0.2), valueOr(cfg.Slack, 0.5)
+		if lambda < 0 || lambda > 1 || slack < 0 || (cfg.Sigma != nil && *cfg.Sigma <= 0) {
+			return nil, fmt.Errorf("%s detector: lambda must be in [0, 1], slack not negative and sigma positive", cfg.Kind)
+		}
+		b := baseline{window: orInt(cfg.Window, 30), target: cfg.Target, sigma: cfg.Sigma}
+		if cfg.Kind == detectEWMA {
+			return &ewmaDetector{baseline: b, lambda: lambda, limit: orFloat(cfg.Threshold, 3)}, nil
+		}
+		return &cusumDetector{baseline: b, slack: slack, limit: orFloat(cfg.Threshold, 5)}, nil
+	case detectFlatline:
+		return &flatlineDetector{window: orInt(cfg.Window, 10), tolerance: orFloat(cfg.Tolerance, 1e-9)}, nil
+	case detectCross:
+		if cfg.Signal == "" || cfg.Tolerance <= 0 {
+			return nil, fmt.Errorf("cross detector: signal and a positive tolerance are required")
+		}
+		return &crossDetector{
+			signal: cfg.Signal, gain: valueOr(cfg.Gain, 1), offset: cfg.Offset, tolerance: cfg.Tolerance,
+			maxAge: time.Duration(orFloat(cfg.MaxAge, 60) * float64(time.Second)), latest: latest,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown detector kind %q", cfg.Kind)
+	}
+}
+
+// confirmations returns how many anomalous readings in a row trip a detector.
+func (cfg DetectorT) confirmations() int {
+	if cfg.Count > 0 {
+		return cfg.Count
+	}
+	if cfg.Kind == detectBand {
+		return 5
+	}
+	return 1
+}
+
+// evaluate runs the detectors of a source on a reading. It returns the
+// finding of the detector that tripped with the highest score, or nil, and
+// the largest consecutive anomaly count.
+func (t *Traits) evaluate(sig *SignalT, src source, v float64, at time.Time) (*finding, int) {
+	if sig.detectors == nil {
+		sig.detectors = make(map[string]*detectorSet)
+	}
+	set, ok := sig.detectors[src.node]
+	if !ok {
+		set = &detectorSet{cfgs: sig.detectorConfigs()}
+		for _, cfg := range set.cfgs {
+			d, err := newDetector(cfg, sig, t.pairedReadings(src, cfg.PairBy))
+			if err != nil {
+				d = &bandDetector{lower: sig.LowerThreshold, upper: sig.UpperThreshold} // validated at startup
+			}
+			set.detectors = append(set.detectors, d)
+		}
+		set.counts = make([]int, len(set.detectors))
+		sig.detectors[src.node] = set
+	}
+
+	var tripped *finding
+	most := 0
+	for i, d := range set.detectors {
+		score, reason := d.observe(v, at)
+		if score < 1 {
+			set.counts[i] = 0
+			continue
+		}
+		set.counts[i]++
+		most = max(most, set.counts[i])
+		if set.counts[i] >= set.cfgs[i].confirmations() && (tripped == nil || score > tripped.Score) {
+			tripped = &finding{Detector: set.cfgs[i].Kind, Value: v, Score: score, Reason: reason, Template: set.cfgs[i].OrderTemplate}
+		}
+	}
+	return tripped, most
+}
+
+// readingBook holds the latest reading of each source of each signal, shared
+// by the signals' monitoring goroutines.
+type readingBook struct {
+	mu       sync.Mutex
+	readings map[string]map[string]sourceReading // signal → node → latest reading
+}
+
+// sourceReading is the latest reading of a source, with the source's details.
+type sourceReading struct {
+	reading
+	details map[string][]string
+}
+
+func newReadingBook() *readingBook {
+	return &readingBook{readings: make(map[string]map[string]sourceReading)}
+}
+
+// recordReading keeps the latest reading of a source for cross-signal rules.
+func (t *Traits) recordReading(name string, src source, v float64, at time.Time) {
+	if t.readings == nil {
+		return
+	}
+	t.readings.mu.Lock()
+	defer t.readings.mu.Unlock()
+	if t.readings.readings[name] == nil {
+		t.readings.readings[name] = make(map[string]sourceReading)
+	}
+	t.readings.readings[name][src.node] = sourceReading{reading: reading{value: v, at: at}, details: src.details}
+}
+
+// pairedReadings returns the lookup of the other signals' readings paired
+// with a source: those of the same node or, with pairBy, the latest of the
+// sources sharing a value of that detail (e.g. the same FunctionalLocation).
+func (t *Traits) pairedReadings(src source, pairBy string) func(string) (reading, bool) {
+	return func(name string) (reading, bool) {
+		if t.readings == nil {
+			return reading{}, false
+		}
+		t.readings.mu.Lock()
+		defer t.readings.mu.Unlock()
+		if pairBy == "" {
+			r, ok := t.readings.readings[name][src.node]
+			return r.reading, ok
+		}
+		var latest reading
+		found := false
+		for _, r := range t.readings.readings[name] {
+			if shareValue(r.details[pairBy], src.details[pairBy]) && (!found || r.at.After(latest.at)) {
+				latest, found = r.reading, true
+			}
+		}
+		return latest, found
+	}
+}
+
+// shareValue reports whether two detail lists have a value in common.
+func shareValue(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//-------------------------------------Detectors
+
+// bandDetector scores the distance from the band's centre: 1 at its edges.
+type bandDetector struct {
+	lower, upper float64
+}
+
+func (d *bandDetector) observe(v float64, _ time.Time) (float64, string) {
+	half := (d.upper - d.lower) / 2
+	dist := math.Abs(v - (d.lower + half))
+	if half == 0 {
+		if dist == 0 {
+			return 0, ""
+		}
+		return 1 + dist, fmt.Sprintf("value %.2f outside range [%.2f, %.2f]", v, d.lower, d.upper)
+	}
+	score := dist / half
+	if v >= d.lower && v <= d.upper {
+		return math.Min(score, math.Nextafter(1, 0)), "" // the edges are in range
+	}
+	return math.Max(score, 1), fmt.Sprintf("value %.2f outside range [%.2f, %.2f]", v, d.lower, d.upper)
+}
+
+// rateDetector scores the rate of change between consecutive readings.
+type rateDetector struct {
+	maxRate float64
+	last    *reading
+}
+
+func (d *rateDetector) observe(v float64, at time.Time) (float64, string) {
+	defer func() { d.last = &reading{value: v, at: at} }()
+	if d.last == nil {
+		return 0, ""
+	}
+	dt := at.Sub(d.last.at).Seconds()
+	if dt <= 0 {
+		return 0, ""
+	}
+	rate := (v - d.last.value) / dt
+	score := math.Abs(rate) / d.maxRate
+	return score, fmt.Sprintf("rate of change %.3f/s exceeds %.3f/s", rate, d.maxRate)
+}
+
+// zScoreDetector scores a reading against the mean and standard deviation
+// of the previous window of readings.
+type zScoreDetector struct {
+	window int
+	limit  float64
+	values []float64
+}
+
+func (d *zScoreDetector) observe(v float64, _ time.Time) (float64, string) {
+	defer func() {
+		d.values = append(d.values, v)
+		if len(d.values) > d.window {
+			d.values = d.values[1:]
+		}
+	}()
+	if len(d.values) < d.window {
+		return 0, ""
+	}
+	mean, std := meanStd(d.values)
+	if std == 0 {
+		return 0, "" // a flat signal is the flatline detector's business
+	}
+	z := (v - mean) / std
+	return math.Abs(z) / d.limit, fmt.Sprintf("z-score %.2f beyond %.1f (mean %.2f, std %.2f over %d readings)", z, d.limit, mean, std, d.window)
+}
+
+// baseline is the in-control mean and standard deviation of a signal, given
+// or learned from its first window of readings.
+type baseline struct {
+	window  int
+	target  *float64
+	sigma   *float64
+	learned []float64
+}
+
+// ready learns the baseline; it reports whether it is known.
+func (b *baseline) ready(v float64) bool {
+	if b.target != nil && b.sigma != nil {
+		return true
+	}
+	b.learned = append(b.learned, v)
+	if len(b.learned) < b.window {
+		return false
+	}
+	mean, std := meanStd(b.learned)
+	if b.target == nil {
+		b.target = &mean
+	}
+	if b.sigma == nil {
+		if std == 0 {
+			b.learned = b.learned[1:] // keep learning until the signal varies
+			return false
+		}
+		b.sigma = &std
+	}
+	b.learned = nil
+	return false
+}
+
+// ewmaDetector scores the exponentially weighted moving average against its
+// control limits.
+type ewmaDetector struct {
+	baseline
+	lambda float64
+	limit  float64
+	ewma   *float64
+}
+
+func (d *ewmaDetector) observe(v float64, _ time.Time) (float64, string) {
+	if !d.ready(v) {
+		return 0, ""
+	}
+	if d.ewma == nil {
+		d.ewma = d.target
+	}
+	z := d.lambda*v + (1-d.lambda)**d.ewma
+	d.ewma = &z
+	limit := d.limit * *d.sigma * math.Sqrt(d.lambda/(2-d.lambda))
+	if limit == 0 {
+		return 0, "" // a lambda of 0 holds the average at the target
+	}
+	return math.Abs(z-*d.target) / limit, fmt.Sprintf("moving average %.2f drifted from %.2f beyond ±%.2f", z, *d.target, limit)
+}
+
+// cusumDetector scores the two-sided cumulative sum of standardised
+// deviations against the decision interval.
+type cusumDetector struct {
+	baseline
+	slack     float64
+	limit     float64
+	high, low float64
+}
+
+func (d *cusumDetector) observe(v float64, _ time.Time) (float64, string) {
+	if !d.ready(v) {
+		return 0, ""
+	}
+	x := (v - *d.target) / *d.sigma
+	d.high = math.Max(0, d.high+x-d.slack)
+	d.low = math.Max(0, d.low-x-d.slack)
+	direction, sum := "upward", d.high
+	if d.low > d.high {
+		direction, sum = "downward", d.low
+	}
+	return sum / d.limit, fmt.Sprintf("%s drift from %.2f: cumulative sum %.2f beyond %.1f", direction, *d.target, sum, d.limit)
+}
+
+// flatlineDetector flags a window of readings that do not move.
+type flatlineDetector struct {
+	window    int
+	tolerance float64
+	values    []float64
+}
+
+func (d *flatlineDetector) observe(v float64, _ time.Time) (float64, string) {
+	d.values = append(d.values, v)
+	if len(d.values) > d.window {
+		d.values = d.values[1:]
+	}
+	if len(d.values) < d.window {
+		return 0, ""
+	}
+	lo, hi := d.values[0], d.values[0]
+	for _, x := range d.values {
+		lo, hi = math.Min(lo, x), math.Max(hi, x)
+	}
+	spread := hi - lo
+	if spread <= d.tolerance {
+		return 1, fmt.Sprintf("value stuck at %.2f for %d readings", v, d.window)
+	}
+	return d.tolerance / spread, ""
+}
+
+// crossDetector compares a reading with the value expected from another
+// signal, e.g. a flow with its valve position.
+type crossDetector struct {
+	signal       string
+	gain, offset float64
+	tolerance    float64
+	maxAge       time.Duration
+	latest       func(string) (reading, bool)
+}
+
+func (d *crossDetector) observe(v float64, at time.Time) (float64, string) {
+	other, ok := d.latest(d.signal)
+	if !ok || at.Sub(other.at) > d.maxAge || other.at.Sub(at) > d.maxAge {
+		return 0, ""
+	}
+	expected := d.gain*other.value + d.offset
+	return math.Abs(v-expected) / d.tolerance,
+		fmt.Sprintf("value %.2f disagrees with %s %.2f (expected %.2f ± %.2f)", v, d.signal, other.value, expected, d.tolerance)
+}
+
+func meanStd(values []float64) (mean, std float64) {
+	for _, x := range values {
+		mean += x
+	}
+	mean /= float64(len(values))
+	for _, x := range values {
+		std += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(std / float64(len(values)))
+}
+
+func orInt(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+func orFloat(v, def float64) float64 {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// valueOr returns a configured value, or def if it is not given: unlike
+// orFloat, an explicit 0 is kept.
+func valueOr(p *float64, def float64) float64 {
+	if p == nil {
+		return def
+	}
+	return *p
+}
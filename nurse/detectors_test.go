@@ -0,0 +1,212 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2026, 5, 4, 8, 0, 0, 0, time.UTC)
+
+// feed evaluates readings one second apart and returns the first finding and
+// the index of the reading that produced it (-1 if none).
+func feed(tr *Traits, sig *SignalT, values []float64) (*finding, int) {
+	for i, v := range values {
+		if f, _ := tr.evaluate(sig, source{node: "node1"}, v, t0.Add(time.Duration(i)*time.Second)); f != nil {
+			return f, i
+		}
+	}
+	return nil, -1
+}
+
+func repeat(v float64, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = v
+	}
+	return out
+}
+
+// noisy returns n readings alternating around mean.
+func noisy(mean, amplitude float64, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = mean + amplitude*math.Sin(float64(i))
+	}
+	return out
+}
+
+// ── default band ──────────────────────────────────────────────────────────────
+
+func TestBandDefaultsToFiveReadings(t *testing.T) {
+	sig := &SignalT{Name: "pressure", LowerThreshold: 10, UpperThreshold: 25}
+	tr := &Traits{Signals: []SignalT{*sig}}
+
+	values := append([]float64{30, 30, 30, 30, 20}, repeat(30, 5)...) // back in range resets the count
+	f, i := feed(tr, sig, values)
+	if f == nil || i != 9 {
+		t.Fatalf("band tripped at reading %d, want 9", i)
+	}
+	if f.Detector != detectBand || f.Score < 1 || !strings.Contains(f.Reason, "outside range [10.00, 25.00]") {
+		t.Errorf("finding = %+v", f)
+	}
+	if d := f.describe("pressure"); !strings.Contains(d, "pressure") || !strings.Contains(d, "score") {
+		t.Errorf("description = %q", d)
+	}
+
+	// The edges are in range
+	if f, n := tr.evaluate(&SignalT{LowerThreshold: 10, UpperThreshold: 25}, source{node: "n"}, 25, t0); f != nil || n != 0 {
+		t.Errorf("upper edge: finding %+v, count %d", f, n)
+	}
+}
+
+// ── other detectors ───────────────────────────────────────────────────────────
+
+func TestDetectors(t *testing.T) {
+	target, sigma := 50.0, 1.0
+	for _, tc := range []struct {
+		name   string
+		cfg    DetectorT
+		values []float64
+		want   int // index of the reading that trips, -1 for none
+	}{
+		{"rate", DetectorT{Kind: detectRate, MaxRate: 2}, []float64{10, 11, 12.5, 16}, 3},
+		{"rate within limit", DetectorT{Kind: detectRate, MaxRate: 2}, []float64{10, 11, 12.5, 14}, -1},
+		{"rate confirmed twice", DetectorT{Kind: detectRate, MaxRate: 2, Count: 2}, []float64{10, 20, 21, 30, 40}, 4},
+		{"zscore", DetectorT{Kind: detectZScore, Window: 20}, append(noisy(50, 1, 20), 50.5, 58), 21},
+		{"ewma drift", DetectorT{Kind: detectEWMA, Target: &target, Sigma: &sigma}, append(repeat(50, 5), repeat(51.5, 10)...), 9},
+		{"ewma learned", DetectorT{Kind: detectEWMA, Window: 20}, append(noisy(50, 1, 40), repeat(53, 10)...), 41},
+		{"cusum drift", DetectorT{Kind: detectCUSUM, Target: &target, Sigma: &sigma}, append(repeat(50, 5), repeat(51, 20)...), 14},
+		{"flatline", DetectorT{Kind: detectFlatline, Window: 5}, append(noisy(50, 1, 10), repeat(42, 5)...), 14},
+	} {
+		sig := &SignalT{Name: "flow", Detectors: []DetectorT{tc.cfg}}
+		tr := &Traits{Signals: []SignalT{*sig}}
+		if err := tr.validateDetectors(sig); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		f, i := feed(tr, sig, tc.values)
+		if i != tc.want {
+			t.Errorf("%s: tripped at reading %d, want %d (%+v)", tc.name, i, tc.want, f)
+			continue
+		}
+		if f != nil && (f.Detector != tc.cfg.Kind || f.Reason == "") {
+			t.Errorf("%s: finding = %+v", tc.name, f)
+		}
+	}
+}
+
+func TestCrossSignalRule(t *testing.T) {
+	// The flow is expected to follow the valve position: 2 × position ± 10
+	valve := SignalT{Name: "position"}
+	flow := SignalT{Name: "flow", Detectors: []DetectorT{{Kind: detectCross, Signal: "position", Gain: floatPtr(2), Tolerance: 10}}}
+	tr := &Traits{Signals: []SignalT{valve, flow}, readings: newReadingBook()}
+	if err := tr.validateDetectors(&tr.Signals[1]); err != nil {
+		t.Fatal(err)
+	}
+	sig := &tr.Signals[1]
+
+	if f, _ := tr.evaluate(sig, source{node: "n"}, 200, t0); f != nil {
+		t.Errorf("without a valve reading: %+v", f)
+	}
+	tr.recordReading("position", source{node: "n"}, 50, t0)
+	if f, _ := tr.evaluate(sig, source{node: "n"}, 105, t0.Add(time.Second)); f != nil {
+		t.Errorf("flow in agreement: %+v", f)
+	}
+	f, _ := tr.evaluate(sig, source{node: "n"}, 60, t0.Add(2*time.Second))
+	if f == nil || f.Score != 4 || !strings.Contains(f.Reason, "disagrees with position") {
+		t.Errorf("flow in disagreement: %+v", f)
+	}
+	if f, _ := tr.evaluate(sig, source{node: "n"}, 60, t0.Add(2*time.Hour)); f != nil {
+		t.Errorf("stale valve reading: %+v", f)
+	}
+}
+
+func TestCrossSignalPairing(t *testing.T) {
+	valve := SignalT{Name: "position"}
+	fl := func(tag string) map[string][]string { return map[string][]string{"FunctionalLocation": {tag}} }
+
+	// By node: each flow meter is compared with the valve of its own node
+	flow := SignalT{Name: "flow", Detectors: []DetectorT{{Kind: detectCross, Signal: "position", Gain: floatPtr(2), Tolerance: 10}}}
+	tr := &Traits{Signals: []SignalT{valve, flow}, readings: newReadingBook()}
+	sig := &tr.Signals[1]
+	tr.recordReading("position", source{node: "line1"}, 50, t0)
+	tr.recordReading("position", source{node: "line2"}, 10, t0)
+	if f, _ := tr.evaluate(sig, source{node: "line1"}, 100, t0); f != nil {
+		t.Errorf("line 1 compared with line 2's valve: %+v", f)
+	}
+	if f, _ := tr.evaluate(sig, source{node: "line2"}, 100, t0); f == nil {
+		t.Error("line 2 compared with line 1's valve")
+	}
+	if f, _ := tr.evaluate(sig, source{node: "line3"}, 100, t0); f != nil {
+		t.Errorf("line 3 has no valve: %+v", f)
+	}
+
+	// By functional location: the valve and the flow meter are different systems
+	flow.Detectors[0].PairBy = "FunctionalLocation"
+	tr = &Traits{Signals: []SignalT{valve, flow}, readings: newReadingBook()}
+	sig = &tr.Signals[1]
+	tr.recordReading("position", source{node: "plc/valve1", details: fl("827-PV2708")}, 50, t0)
+	tr.recordReading("position", source{node: "plc/valve2", details: fl("827-PV2709")}, 10, t0)
+	if f, _ := tr.evaluate(sig, source{node: "meter/ft1", details: fl("827-PV2708")}, 100, t0); f != nil {
+		t.Errorf("meter 1 compared with the wrong valve: %+v", f)
+	}
+	if f, _ := tr.evaluate(sig, source{node: "meter/ft2", details: fl("827-PV2709")}, 100, t0); f == nil {
+		t.Error("meter 2 compared with the wrong valve")
+	}
+}
+
+func TestExplicitZeroParameters(t *testing.T) {
+	// A gain of 0 expects the flow at the offset whatever the valve
+	flow := SignalT{Name: "flow", Detectors: []DetectorT{{Kind: detectCross, Signal: "position", Gain: floatPtr(0), Offset: 5, Tolerance: 1}}}
+	tr := &Traits{Signals: []SignalT{{Name: "position"}, flow}, readings: newReadingBook()}
+	tr.recordReading("position", source{node: "n"}, 50, t0)
+	if f, _ := tr.evaluate(&tr.Signals[1], source{node: "n"}, 5, t0); f != nil {
+		t.Errorf("gain 0: %+v", f)
+	}
+
+	// A lambda of 0 holds the moving average at the target
+	d, err := newDetector(DetectorT{Kind: detectEWMA, Lambda: floatPtr(0), Target: floatPtr(10), Sigma: floatPtr(1)}, &SignalT{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score, _ := d.observe(100, t0); score != 0 {
+		t.Errorf("lambda 0: score %v", score)
+	}
+	// A slack of 0 accumulates every deviation
+	d, _ = newDetector(DetectorT{Kind: detectCUSUM, Slack: floatPtr(0), Target: floatPtr(10), Sigma: floatPtr(1)}, &SignalT{}, nil)
+	if score, _ := d.observe(11, t0); score != 1.0/5 {
+		t.Errorf("slack 0: score %v, want 0.2", score)
+	}
+}
+
+func TestValidateDetectors(t *testing.T) {
+	for _, cfg := range []DetectorT{
+		{Kind: "magic"},
+		{Kind: detectRate},
+		{Kind: detectCross, Signal: "position"}, // no tolerance
+		{Kind: detectCross, Signal: "level", Tolerance: 1},
+		{Kind: detectEWMA, Lambda: floatPtr(2)},
+	} {
+		sig := &SignalT{Name: "flow", Detectors: []DetectorT{cfg}}
+		tr := &Traits{Signals: []SignalT{*sig, {Name: "position"}}}
+		if err := tr.validateDetectors(sig); err == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ *   Thomas Hedeler, Hamburg - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OrderTemplateT shapes the maintenance orders the nurse raises, so that the
+// same nurse can follow different plants' SAP conventions. Templates are
+// given for the unit asset, a signal or a detector; the most specific one
+// wins field by field, and unset fields fall back to defaultOrderTemplate.
+//
+// Texts may hold the placeholders {signal}, {value}, {unit}, {lower},
+// {upper}, {sensor}, {actuator}, {detector}, {score} and {reason}.
+type OrderTemplateT struct {
+	Plant       string              `json:"plant,omitempty"`
+	OrderType   string              `json:"maintenanceOrderType,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Priority    string              `json:"priority,omitempty"`   // when no priority rule applies
+	Priorities  []PriorityRuleT     `json:"priorities,omitempty"` // priority by anomaly score
+	StartAfter  *float64            `json:"startAfter,omitempty"` // hours from detection to the planned start
+	Duration    *float64            `json:"duration,omitempty"`   // hours from the planned start to the planned end
+	Operations  []OperationTemplate `json:"operations,omitempty"`
+}
+
+// PriorityRuleT gives the priority of orders whose anomaly score is at least MinScore.
+type PriorityRuleT struct {
+	MinScore float64 `json:"minScore"`
+	Priority string  `json:"priority"`
+}
+
+// OperationTemplate is an operation of a templated order, with the material
+// components it needs.
+type OperationTemplate struct {
+	OperationID  string                 `json:"operationId,omitempty"`
+	Text         string                 `json:"text"`
+	WorkCenter   string                 `json:"workCenter,omitempty"`
+	Duration     float64                `json:"duration,omitempty"`
+	DurationUnit string                 `json:"durationUnit,omitempty"`
+	Components   []MaintenanceComponent `json:"components,omitempty"`
+}
+
+// defaultOrderTemplate is the order raised when no template says otherwise.
+var defaultOrderTemplate = OrderTemplateT{
+	Plant:       "1000",
+	OrderType:   "PM01",
+	Description: "Signal {signal}: {reason} ({detector} detector, score {score})",
+	Priority:    "3",
+	StartAfter:  floatPtr(24),
+	Duration:    floatPtr(8),
+	Operations: []OperationTemplate{
+		{
+			OperationID:  "0010",
+			Text:         "Inspect and service equipment for signal {signal}",
+			WorkCenter:   "MAINT-WC01",
+			Duration:     4,
+			DurationUnit: "H",
+		},
+	},
+}
+
+// orderContext fills the placeholders of an order template.
+type orderContext struct {
+	sig         *SignalT
+	found       *finding
+	equipmentID string // sensor that raised the anomaly
+	locationTag string // actuator the order targets
+	locationIRI string
+	detected    time.Time
+}
+
+// orderTemplate merges the templates that apply to a finding on a signal.
+func (t *Traits) orderTemplate(sig *SignalT, found *finding) OrderTemplateT {
+	out := defaultOrderTemplate
+	for _, tmpl := range []*OrderTemplateT{t.OrderTemplate, sig.OrderTemplate, found.Template} {
+		if tmpl != nil {
+			out = out.override(*tmpl)
+		}
+	}
+	return out
+}
+
+// override returns the template with the fields set in o replaced.
+func (base OrderTemplateT) override(o OrderTemplateT) OrderTemplateT {
+	if o.Plant != "" {
+		base.Plant = o.Plant
+	}
+	if o.OrderType != "" {
+		base.OrderType = o.OrderType
+	}
+	if o.Description != "" {
+		base.Description = o.Description
+	}
+	if o.Priority != "" {
+		base.Priority = o.Priority
+	}
+	if len(o.Priorities) > 0 {
+		base.Priorities = o.Priorities
+	}
+	if o.StartAfter != nil {
+		base.StartAfter = o.StartAfter
+	}
+	if o.Duration != nil {
+		base.Duration = o.Duration
+	}
+	if len(o.Operations) > 0 {
+		base.Operations = o.Operations
+	}
+	return base
+}
+
+// validate checks a template given in the configuration.
+func (tmpl *OrderTemplateT) validate() error {
+	if tmpl == nil {
+		return nil
+	}
+	if (tmpl.StartAfter != nil && *tmpl.StartAfter < 0) || (tmpl.Duration != nil && *tmpl.Duration < 0) {
+		return fmt.Errorf("order template: startAfter and duration must not be negative")
+	}
+	for _, rule := range tmpl.Priorities {
+		if rule.Priority == "" {
+			return fmt.Errorf("order template: priority rule for score %g has no priority", rule.MinScore)
+		}
+	}
+	for _, op := range tmpl.Operations {
+		if op.Text == "" {
+			return fmt.Errorf("order template: operation %q has no text", op.OperationID)
+		}
+	}
+	return nil
+}
+
+// priority returns the priority of the rule with the highest minimum score
+// the anomaly reaches, or the template's priority.
+func (tmpl OrderTemplateT) priority(score float64) string {
+	rules := append([]PriorityRuleT(nil), tmpl.Priorities...)
+	sort.Slice(rules, func(i, j int) bool { return rules[i].MinScore > rules[j].MinScore })
+	for _, rule := range rules {
+		if score >= rule.MinScore {
+			return rule.Priority
+		}
+	}
+	return tmpl.Priority
+}
+
+// build makes the maintenance order of a finding.
+func (tmpl OrderTemplateT) build(c orderContext) MaintenanceOrderEvent {
+	unit := ""
+	if u := c.sig.Details["Unit"]; len(u) > 0 {
+		unit = u[0]
+	}
+	fill := strings.NewReplacer(
+		"{signal}", c.sig.Name,
+		"{value}", formatValue(c.found.Value),
+		"{unit}", unit,
+		"{lower}", formatValue(c.sig.LowerThreshold),
+		"{upper}", formatValue(c.sig.UpperThreshold),
+		"{sensor}", c.equipmentID,
+		"{actuator}", c.locationTag,
+		"{detector}", c.found.Detector,
+		"{score}", strconv.FormatFloat(c.found.Score, 'f', 2, 64),
+		"{reason}", c.found.Reason,
+	).Replace
+
+	start := c.detected.Add(hours(*tmpl.StartAfter))
+	end := start.Add(hours(*tmpl.Duration))
+	order := MaintenanceOrderEvent{
+		EquipmentID:           c.equipmentID,
+		FunctionalLocation:    c.locationTag,
+		FunctionalLocationIRI: c.locationIRI,
+		Plant:                 tmpl.Plant,
+		Description:           fill(tmpl.Description),
+		Priority:              tmpl.priority(c.found.Score),
+		MaintenanceOrderType:  tmpl.OrderType,
+		PlannedStartTime:      &start,
+		PlannedEndTime:        &end,
+	}
+	for _, op := range tmpl.Operations {
+		components := make([]MaintenanceComponent, len(op.Components))
+		for i, comp := range op.Components {
+			comp.Description = fill(comp.Description)
+			if comp.Plant == "" {
+				comp.Plant = tmpl.Plant
+			}
+			components[i] = comp
+		}
+		order.Operations = append(order.Operations, MaintenanceOperation{
+			OperationID:  op.OperationID,
+			Text:         fill(op.Text),
+			WorkCenter:   op.WorkCenter,
+			Duration:     op.Duration,
+			DurationUnit: op.DurationUnit,
+			Components:   components,
+		})
+	}
+	return order
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func hours(h float64) time.Duration {
+	return time.Duration(h * float64(time.Hour))
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,140 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+var lowPressure = &finding{Detector: detectBand, Value: 8.1, Score: 1.47, Reason: "value 8.10 outside range [10.00, 25.00]"}
+
+func pressureSignal() *SignalT {
+	return &SignalT{Name: "pressure", Details: map[string][]string{"Unit": {"kPa"}}, LowerThreshold: 10, UpperThreshold: 25}
+}
+
+func TestDefaultOrderTemplate(t *testing.T) {
+	tr := &Traits{}
+	sig := pressureSignal()
+	order := tr.orderTemplate(sig, lowPressure).build(orderContext{
+		sig: sig, found: lowPressure, equipmentID: "827PD2708", locationTag: "827-PV2708-200", detected: t0,
+	})
+	if order.Plant != "1000" || order.Priority != "3" || order.MaintenanceOrderType != "PM01" {
+		t.Errorf("order header = %+v", order)
+	}
+	if want := "Signal pressure: value 8.10 outside range [10.00, 25.00] (band detector, score 1.47)"; order.Description != want {
+		t.Errorf("description = %q, want %q", order.Description, want)
+	}
+	if !order.PlannedStartTime.Equal(t0.Add(24*time.Hour)) || !order.PlannedEndTime.Equal(t0.Add(32*time.Hour)) {
+		t.Errorf("planned window = %v – %v", order.PlannedStartTime, order.PlannedEndTime)
+	}
+	if len(order.Operations) != 1 || order.Operations[0].WorkCenter != "MAINT-WC01" || order.Operations[0].Duration != 4 {
+		t.Errorf("operations = %+v", order.Operations)
+	}
+}
+
+func TestOrderTemplateOverrides(t *testing.T) {
+	tr := &Traits{OrderTemplate: &OrderTemplateT{
+		Plant:      "SK01",
+		Priorities: []PriorityRuleT{{MinScore: 1, Priority: "4"}, {MinScore: 3, Priority: "1"}, {MinScore: 1.5, Priority: "2"}},
+		StartAfter: floatPtr(0),
+	}}
+	sig := pressureSignal()
+	sig.OrderTemplate = &OrderTemplateT{
+		Description: "{signal} at {value} {unit} ({lower}–{upper}) on {actuator}",
+		Duration:    floatPtr(2),
+		Operations: []OperationTemplate{{
+			OperationID: "0010", Text: "Replace positioner of {actuator}", WorkCenter: "INST-WC", Duration: 1.5, DurationUnit: "H",
+			Components: []MaintenanceComponent{{Material: "POS-100", Description: "positioner for {actuator}", Quantity: 1, Unit: "EA"}},
+		}},
+	}
+	found := *lowPressure
+	found.Template = &OrderTemplateT{OrderType: "PM02"}
+
+	for _, tc := range []struct {
+		score    float64
+		priority string
+	}{{1.2, "4"}, {1.5, "2"}, {7, "1"}, {0.5, "3"}} {
+		found.Score = tc.score
+		order := tr.orderTemplate(sig, &found).build(orderContext{
+			sig: sig, found: &found, equipmentID: "827PD2708", locationTag: "827-PV2708-200", detected: t0,
+		})
+		if order.Priority != tc.priority {
+			t.Errorf("score %v: priority %q, want %q", tc.score, order.Priority, tc.priority)
+		}
+		if order.Plant != "SK01" || order.MaintenanceOrderType != "PM02" {
+			t.Errorf("plant %q, order type %q", order.Plant, order.MaintenanceOrderType)
+		}
+		if want := "pressure at 8.10 kPa (10.00–25.00) on 827-PV2708-200"; order.Description != want {
+			t.Errorf("description = %q, want %q", order.Description, want)
+		}
+		if !order.PlannedStartTime.Equal(t0) || !order.PlannedEndTime.Equal(t0.Add(2*time.Hour)) {
+			t.Errorf("planned window = %v – %v", order.PlannedStartTime, order.PlannedEndTime)
+		}
+		op := order.Operations[0]
+		if op.Text != "Replace positioner of 827-PV2708-200" || len(op.Components) != 1 ||
+			op.Components[0].Description != "positioner for 827-PV2708-200" || op.Components[0].Plant != "SK01" {
+			t.Errorf("operation = %+v", op)
+		}
+	}
+	if sig.OrderTemplate.Operations[0].Components[0].Plant != "" {
+		t.Error("building an order changed the template")
+	}
+}
+
+func TestOrderTemplateValidation(t *testing.T) {
+	for _, tmpl := range []*OrderTemplateT{
+		{StartAfter: floatPtr(-1)},
+		{Priorities: []PriorityRuleT{{MinScore: 2}}},
+		{Operations: []OperationTemplate{{OperationID: "0010"}}},
+	} {
+		if err := tmpl.validate(); err == nil {
+			t.Errorf("%+v: expected an error", tmpl)
+		}
+	}
+}
+
+func TestRequestMaintenanceOrderUsesTemplate(t *testing.T) {
+	var got MaintenanceOrderEvent
+	sapper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"maintenanceOrder":"4000042","status":"CRTD"}`))
+	}))
+	defer sapper.Close()
+
+	tr := &Traits{
+		OrderTemplate: &OrderTemplateT{Plant: "SK01"},
+		sapper: &components.Cervice{Definition: "MaintenanceOrder", Nodes: map[string][]components.NodeInfo{
+			"sapper/SAPSimulator": {{URL: sapper.URL}},
+		}},
+	}
+	sig := pressureSignal()
+	order := tr.orderTemplate(sig, lowPressure).build(orderContext{
+		sig: sig, found: lowPressure, equipmentID: "827PD2708", locationTag: "827-PV2708-200", detected: time.Now(),
+	})
+	if id, err := tr.requestMaintenanceOrder(order, "nurse/pressure/node1"); err != nil || id != "4000042" {
+		t.Fatalf("order ID = %q, %v", id, err)
+	}
+	if got.Plant != "SK01" || got.FunctionalLocation != "827-PV2708-200" || got.EquipmentID != "827PD2708" {
+		t.Errorf("posted order = %+v", got)
+	}
+}
@@ -0,0 +1,332 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ *   Thomas Hedeler, Hamburg - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Retry schedule of failed order requests
+const (
+	retryBase = 30 * time.Second
+	retryMax  = 30 * time.Minute
+)
+
+// Order statuses after which the signal is monitored again
+var completedStatuses = map[string]bool{"TECO": true, "CLSD": true}
+
+// errOrderUnknown is returned when the Sapper does not know an order.
+var errOrderUnknown = errors.New("order unknown to the Sapper")
+
+// pendingOrder is the maintenance order raised for an anomaly on one source
+// of a signal, kept until the order is completed. An order without an ID has
+// not been created yet and is retried.
+type pendingOrder struct {
+	Signal      string                `json:"signal"`
+	Node        string                `json:"node"`
+	OrderID     string                `json:"orderId,omitempty"`
+	Order       MaintenanceOrderEvent `json:"order"`  // the request, retried unchanged
+	Reason      string                `json:"reason"` // the finding, for the order's graph context
+	DetectedAt  time.Time             `json:"detectedAt"`
+	Attempts    int                   `json:"attempts,omitempty"`
+	NextAttempt time.Time             `json:"nextAttempt,omitempty"`
+	LastError   string                `json:"lastError,omitempty"`
+	reconciled  bool                  // status checked with the Sapper since startup
+}
+
+// key identifies the source the order was raised for: one anomaly, one order.
+func (po *pendingOrder) key() string {
+	return po.Signal + "/" + po.Node
+}
+
+// idempotencyKey identifies the anomaly the order was raised for, so that the
+// Sapper answers every attempt of the same order with the same order.
+func (po *pendingOrder) idempotencyKey() string {
+	return "nurse/" + po.key() + "@" + po.DetectedAt.UTC().Format(time.RFC3339Nano)
+}
+
+// orderBook holds the pending orders, saved to a state file at every change
+// so that a restart neither raises duplicates nor forgets to resume monitoring.
+type orderBook struct {
+	mu     sync.Mutex
+	path   string // no persistence if empty
+	orders map[string]*pendingOrder
+}
+
+// loadOrderBook reads the pending orders from the state file, if any.
+func loadOrderBook(path string) (*orderBook, error) {
+	b := &orderBook{path: path, orders: make(map[string]*pendingOrder)}
+	if path == "" {
+		return b, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []*pendingOrder
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, po := range saved {
+		b.orders[po.key()] = po
+	}
+	return b, nil
+}
+
+// save writes the state file through a temporary file. The lock must be held.
+func (b *orderBook) save() {
+	if b.path == "" {
+		return
+	}
+	list := make([]*pendingOrder, 0, len(b.orders))
+	for _, po := range b.orders {
+		list = append(list, po)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].key() < list[j].key() })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err == nil {
+		if err = os.WriteFile(b.path+".tmp", data, 0o644); err == nil {
+			err = os.Rename(b.path+".tmp", b.path)
+		}
+	}
+	if err != nil {
+		log.Printf("nurse: unable to save the pending orders to %s: %v\n", b.path, err)
+	}
+}
+
+// open records a new pending order. It returns false if the source already
+// has one, so that a fault produces a single order.
+func (b *orderBook) open(po *pendingOrder) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.orders[po.key()]; ok {
+		return false
+	}
+	b.orders[po.key()] = po
+	b.save()
+	return true
+}
+
+// update applies a change to a pending order and saves it.
+func (b *orderBook) update(po *pendingOrder, change func(*pendingOrder)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	change(po)
+	b.save()
+}
+
+// close removes the pending order with the given ID and returns it.
+func (b *orderBook) close(orderID string) *pendingOrder {
+	if b == nil || orderID == "" {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, po := range b.orders {
+		if po.OrderID == orderID {
+			delete(b.orders, key)
+			b.save()
+			return po
+		}
+	}
+	return nil
+}
+
+// of returns the pending orders of a signal.
+func (b *orderBook) of(signal string) []*pendingOrder {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var list []*pendingOrder
+	for _, po := range b.orders {
+		if po.Signal == signal {
+			list = append(list, po)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].key() < list[j].key() })
+	return list
+}
+
+// backoff returns the delay before the next attempt after the given number of failures.
+func backoff(attempts int) time.Duration {
+	d := retryBase
+	for i := 1; i < attempts && d < retryMax; i++ {
+		d *= 2
+	}
+	return min(d, retryMax)
+}
+
+//-------------------------------------Order lifecycle
+
+// restorePending marks the sources with pending orders as such after a restart.
+func (t *Traits) restorePending() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.Signals {
+		sig := &t.Signals[i]
+		for _, po := range t.book.of(sig.Name) {
+			sig.WorkRequested[po.Node] = true
+			sig.Operational = false
+			if po.OrderID != "" {
+				t.pendingOrders[po.OrderID] = sig.Name
+			}
+			log.Printf("nurse: resuming pending order %q for %s\n", po.OrderID, po.key())
+		}
+	}
+}
+
+// raiseOrder records the order of a finding on a source and requests it.
+// A source that already has a pending order gets no other.
+func (t *Traits) raiseOrder(sig *SignalT, node string, found *finding, equipmentID, locationTag, locationIRI string) {
+	now := time.Now()
+	po := &pendingOrder{
+		Signal: sig.Name,
+		Node:   node,
+		Order: t.orderTemplate(sig, found).build(orderContext{
+			sig:         sig,
+			found:       found,
+			equipmentID: equipmentID,
+			locationTag: locationTag,
+			locationIRI: locationIRI,
+			detected:    now,
+		}),
+		Reason:     found.describe(sig.Name),
+		DetectedAt: now,
+	}
+	if !t.book.open(po) {
+		log.Printf("nurse: %s already has a pending order; not raising another\n", po.key())
+		return
+	}
+	t.submitOrder(sig, po)
+}
+
+// submitOrder requests a pending order from the Sapper, scheduling a retry
+// with exponential backoff if it fails. A failed attempt may have created the
+// order all the same (its answer lost to a timeout), so a retry first asks the
+// Sapper for an open order under the order's idempotency key.
+func (t *Traits) submitOrder(sig *SignalT, po *pendingOrder) {
+	key := po.idempotencyKey()
+	var orderID string
+	var err error
+	if po.Attempts > 0 {
+		if orderID, err = t.lookupOrder(key); err == nil {
+			log.Printf("nurse: order %s for %s was created by an earlier attempt\n", orderID, po.key())
+		}
+	}
+	if orderID == "" {
+		orderID, err = t.requestMaintenanceOrder(po.Order, key)
+	}
+	if err != nil {
+		t.book.update(po, func(po *pendingOrder) {
+			po.Attempts++
+			po.NextAttempt = time.Now().Add(backoff(po.Attempts))
+			po.LastError = err.Error()
+		})
+		log.Printf("SAP order failed for %s (attempt %d): %v; retrying at %s\n",
+			po.key(), po.Attempts, err, po.NextAttempt.Format(time.RFC3339))
+		return
+	}
+	t.book.update(po, func(po *pendingOrder) {
+		po.OrderID = orderID
+		po.LastError = ""
+		po.reconciled = true
+	})
+	t.mu.Lock()
+	t.pendingOrders[orderID] = sig.Name
+	t.mu.Unlock()
+	log.Printf("Maintenance order %s created for %s\n", orderID, po.key())
+	go t.pushOrderContext(orderID, po.Order.EquipmentID, po.Order.FunctionalLocation, po.Reason)
+}
+
+// followUpOrders retries the signal's orders that could not be created, and
+// checks with the Sapper the status of the orders pending since startup.
+func (t *Traits) followUpOrders(sig *SignalT) {
+	now := time.Now()
+	for _, po := range t.book.of(sig.Name) {
+		switch {
+		case po.OrderID == "":
+			if !now.Before(po.NextAttempt) {
+				t.submitOrder(sig, po)
+			}
+		case !po.reconciled:
+			status, err := t.queryOrderStatus(po.OrderID)
+			switch {
+			case errors.Is(err, errOrderUnknown):
+				// The Sapper lost the order: raise it again
+				log.Printf("nurse: order %s for %s is unknown to the Sapper; raising it again\n", po.OrderID, po.key())
+				t.mu.Lock()
+				delete(t.pendingOrders, po.OrderID)
+				t.mu.Unlock()
+				t.book.update(po, func(po *pendingOrder) { po.OrderID = "" })
+				t.submitOrder(sig, po)
+			case err != nil:
+				log.Printf("nurse: unable to check order %s: %v\n", po.OrderID, err)
+			case completedStatuses[status]:
+				log.Printf("nurse: order %s was completed (%s) while the nurse was away\n", po.OrderID, status)
+				t.completeOrder(po.OrderID, status)
+			default:
+				log.Printf("nurse: order %s for %s is still %s\n", po.OrderID, po.key(), status)
+				t.book.update(po, func(po *pendingOrder) { po.reconciled = true })
+			}
+		}
+	}
+}
+
+// completeOrder restores the source of a completed order to monitoring. It
+// reports whether the order was known.
+func (t *Traits) completeOrder(orderID, status string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	signalName, ok := t.pendingOrders[orderID]
+	po := t.book.close(orderID)
+	if po != nil {
+		signalName, ok = po.Signal, true
+	}
+	if !ok {
+		return false
+	}
+	delete(t.pendingOrders, orderID)
+	sig := t.findSignal(signalName)
+	if sig == nil {
+		return true
+	}
+	if po == nil {
+		// An order of unknown source restores the whole signal
+		sig.WorkRequested = make(map[string]bool)
+		sig.TOverCount = make(map[string]int)
+		sig.detectors = nil
+	} else {
+		delete(sig.WorkRequested, po.Node)
+		delete(sig.TOverCount, po.Node)
+		delete(sig.detectors, po.Node) // relearn after the maintenance
+	}
+	sig.Operational = len(sig.WorkRequested) == 0
+	log.Printf("Signal %s restored to operational (order %s: %s)\n", signalName, orderID, status)
+	return true
+}
@@ -0,0 +1,376 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// fakeSapper creates orders (failing the first `failures` requests and
+// dropping the answer to the next `lost` ones) and answers status queries
+// from its own table.
+type fakeSapper struct {
+	mu       sync.Mutex
+	failures int
+	lost     int
+	posts    int
+	next     int
+	statuses map[string]string
+	keys     map[string]string // idempotency key → order ID
+}
+
+func (s *fakeSapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPost:
+		s.posts++
+		if s.posts <= s.failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		key := r.Header.Get("Idempotency-Key")
+		if id, ok := s.keys[key]; ok {
+			json.NewEncoder(w).Encode(MaintenanceOrderResponse{MaintenanceOrder: id, Status: s.statuses[id]})
+			return
+		}
+		s.next++
+		id := strconv.Itoa(400000 + s.next)
+		s.statuses[id] = "CRTD"
+		if s.keys != nil {
+			s.keys[key] = id
+		}
+		if s.lost > 0 {
+			s.lost--
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(MaintenanceOrderResponse{MaintenanceOrder: id, Status: "CRTD"})
+	case http.MethodGet:
+		if key := r.URL.Query().Get("key"); key != "" {
+			id, ok := s.keys[key]
+			if !ok {
+				http.Error(w, "order not found", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"maintenanceOrder": id, "status": s.statuses[id]})
+			return
+		}
+		status, ok := s.statuses[r.URL.Query().Get("id")]
+		if !ok {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"maintenanceOrder": r.URL.Query().Get("id"), "status": status})
+	}
+}
+
+// pendingTraits returns a nurse with a pressure signal, an order book saved
+// to path and the given Sapper.
+func pendingTraits(t *testing.T, path string, sapperURL string) *Traits {
+	t.Helper()
+	book, err := loadOrderBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := pressureSignal()
+	sig.Operational = true
+	sig.TOverCount = make(map[string]int)
+	sig.WorkRequested = make(map[string]bool)
+	tr := &Traits{
+		Signals:       []SignalT{*sig},
+		pendingOrders: make(map[string]string),
+		book:          book,
+		sapper: &components.Cervice{Definition: "MaintenanceOrder", Nodes: map[string][]components.NodeInfo{
+			"sapper/SAPSimulator": {{URL: sapperURL}},
+		}},
+	}
+	tr.restorePending()
+	return tr
+}
+
+func TestBackoff(t *testing.T) {
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, d := range want {
+		if got := backoff(i + 1); got != d {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, d)
+		}
+	}
+	if got := backoff(20); got != retryMax {
+		t.Errorf("backoff(20) = %v, want %v", got, retryMax)
+	}
+}
+
+func TestOneOrderPerFault(t *testing.T) {
+	fs := &fakeSapper{statuses: map[string]string{}}
+	sapper := httptest.NewServer(fs)
+	defer sapper.Close()
+
+	tr := pendingTraits(t, "", sapper.URL)
+	sig := &tr.Signals[0]
+	tr.raiseOrder(sig, "node1", lowPressure, "827PD2708", "827-PV2708-200", "")
+	tr.raiseOrder(sig, "node1", lowPressure, "827PD2708", "827-PV2708-200", "")
+	tr.raiseOrder(sig, "node2", lowPressure, "827PD2709", "827-PV2708-200", "")
+
+	if fs.posts != 2 {
+		t.Errorf("Sapper received %d orders, want one per source", fs.posts)
+	}
+	if len(tr.pendingOrders) != 2 {
+		t.Errorf("pending orders = %v", tr.pendingOrders)
+	}
+}
+
+func TestFailedOrderIsRetried(t *testing.T) {
+	fs := &fakeSapper{failures: 1, statuses: map[string]string{}}
+	sapper := httptest.NewServer(fs)
+	defer sapper.Close()
+
+	tr := pendingTraits(t, "", sapper.URL)
+	sig := &tr.Signals[0]
+	tr.raiseOrder(sig, "node1", lowPressure, "827PD2708", "827-PV2708-200", "")
+
+	po := tr.book.of("pressure")[0]
+	if po.OrderID != "" || po.Attempts != 1 || po.LastError == "" {
+		t.Fatalf("after a failure: %+v", po)
+	}
+	if d := time.Until(po.NextAttempt); d < 25*time.Second || d > retryBase {
+		t.Errorf("next attempt in %v, want about %v", d, retryBase)
+	}
+
+	tr.followUpOrders(sig) // not due yet
+	if fs.posts != 1 {
+		t.Fatalf("retried before the backoff elapsed (%d posts)", fs.posts)
+	}
+	po.NextAttempt = time.Now().Add(-time.Second)
+	tr.followUpOrders(sig)
+	if fs.posts != 2 || po.OrderID != "400001" {
+		t.Fatalf("after the retry: %d posts, %+v", fs.posts, po)
+	}
+	if tr.pendingOrders["400001"] != "pressure" || po.LastError != "" {
+		t.Errorf("pending orders = %v", tr.pendingOrders)
+	}
+}
+
+func TestPendingOrdersSurviveRestart(t *testing.T) {
+	fs := &fakeSapper{failures: 1, statuses: map[string]string{}}
+	sapper := httptest.NewServer(fs)
+	defer sapper.Close()
+	path := filepath.Join(t.TempDir(), "pendingOrders.json")
+
+	tr := pendingTraits(t, path, sapper.URL)
+	sig := &tr.Signals[0]
+	sig.WorkRequested["node1"], sig.WorkRequested["node2"] = true, true
+	tr.raiseOrder(sig, "node1", lowPressure, "827PD2708", "827-PV2708-200", "") // fails
+	tr.raiseOrder(sig, "node2", lowPressure, "827PD2709", "827-PV2708-200", "") // 400001
+
+	// Restart: both sources stay paused and no order is raised twice
+	tr = pendingTraits(t, path, sapper.URL)
+	sig = &tr.Signals[0]
+	if sig.Operational || !sig.WorkRequested["node1"] || !sig.WorkRequested["node2"] {
+		t.Fatalf("after restart: operational %t, work requested %v", sig.Operational, sig.WorkRequested)
+	}
+	if tr.pendingOrders["400001"] != "pressure" {
+		t.Errorf("pending orders = %v", tr.pendingOrders)
+	}
+	tr.raiseOrder(sig, "node2", lowPressure, "827PD2709", "827-PV2708-200", "")
+	if fs.posts != 2 {
+		t.Errorf("a restart raised a duplicate order (%d posts)", fs.posts)
+	}
+	list := tr.book.of("pressure")
+	if len(list) != 2 || list[0].Attempts != 1 || list[0].Order.EquipmentID != "827PD2708" {
+		t.Errorf("restored orders = %+v", list)
+	}
+}
+
+func TestReconcileAtStartup(t *testing.T) {
+	fs := &fakeSapper{statuses: map[string]string{"400007": "TECO", "400008": "REL"}}
+	sapper := httptest.NewServer(fs)
+	defer sapper.Close()
+	path := filepath.Join(t.TempDir(), "pendingOrders.json")
+
+	saved, _ := json.Marshal([]pendingOrder{
+		{Signal: "pressure", Node: "node1", OrderID: "400007"}, // completed while away
+		{Signal: "pressure", Node: "node2", OrderID: "400008"}, // still released
+		{Signal: "pressure", Node: "node3", OrderID: "399999"}, // lost by the Sapper
+	})
+	if err := os.WriteFile(path, saved, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := pendingTraits(t, path, sapper.URL)
+	sig := &tr.Signals[0]
+	tr.followUpOrders(sig)
+
+	if sig.WorkRequested["node1"] {
+		t.Error("the completed order's source should be monitored again")
+	}
+	if !sig.WorkRequested["node2"] || !sig.WorkRequested["node3"] || sig.Operational {
+		t.Errorf("work requested = %v, operational %t", sig.WorkRequested, sig.Operational)
+	}
+	if fs.posts != 1 {
+		t.Errorf("the lost order should be raised again (%d posts)", fs.posts)
+	}
+	list := tr.book.of("pressure")
+	if len(list) != 2 || list[0].OrderID != "400008" || list[1].OrderID != "400001" {
+		t.Fatalf("pending orders = %+v", list)
+	}
+
+	// Reconciled orders are not queried again
+	fs.statuses["400008"] = "TECO"
+	tr.followUpOrders(sig)
+	if len(tr.book.of("pressure")) != 2 {
+		t.Error("a reconciled order was queried again")
+	}
+}
+
+func TestCompletionRestoresOnlyItsSource(t *testing.T) {
+	fs := &fakeSapper{statuses: map[string]string{}}
+	sapper := httptest.NewServer(fs)
+	defer sapper.Close()
+	path := filepath.Join(t.TempDir(), "pendingOrders.json")
+
+	tr := pendingTraits(t, path, sapper.URL)
+	sig := &tr.Signals[0]
+	for _, node := range []string{"node1", "node2"} {
+		sig.WorkRequested[node] = true
+		sig.TOverCount[node] = 5
+		tr.raiseOrder(sig, node, lowPressure, "827PD2708", "827-PV2708-200", "")
+	}
+	sig.Operational = false
+
+	body := []byte(`{"orderId":"400001","status":"TECO"}`)
+	tr.update(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/nurse/HealthTracker/monitor", bytes.NewReader(body)))
+
+	if sig.WorkRequested["node1"] || sig.TOverCount["node1"] != 0 {
+		t.Error("node1 should be monitored again")
+	}
+	if !sig.WorkRequested["node2"] || sig.Operational {
+		t.Error("node2's order is still pending")
+	}
+	if restored, _ := loadOrderBook(path); len(restored.orders) != 1 {
+		t.Errorf("state file holds %d orders, want 1", len(restored.orders))
+	}
+}
+
+func TestRetryAfterLostAnswer(t *testing.T) {
+	fs := &fakeSapper{lost: 1, statuses: map[string]string{}, keys: map[string]string{}}
+	sapper := httptest.NewServer(fs)
+	defer sapper.Close()
+
+	tr := pendingTraits(t, "", sapper.URL)
+	sig := &tr.Signals[0]
+	tr.raiseOrder(sig, "node1", lowPressure, "827PD2708", "827-PV2708-200", "")
+	po := tr.book.of("pressure")[0]
+	if po.OrderID != "" || po.Attempts != 1 {
+		t.Fatalf("after the lost answer: %+v", po)
+	}
+
+	// The retry, once the Sapper is rediscovered, finds the order the first attempt created
+	tr.sapper.Nodes = map[string][]components.NodeInfo{"sapper/SAPSimulator": {{URL: sapper.URL}}}
+	po.NextAttempt = time.Now().Add(-time.Second)
+	tr.followUpOrders(sig)
+	if fs.posts != 1 || po.OrderID != "400001" {
+		t.Fatalf("after the retry: %d posts, %+v", fs.posts, po)
+	}
+	if len(fs.statuses) != 1 || tr.pendingOrders["400001"] != "pressure" {
+		t.Errorf("Sapper orders = %v, pending orders = %v", fs.statuses, tr.pendingOrders)
+	}
+}
+
+func TestSameAnomalySameKey(t *testing.T) {
+	detected := time.Date(2026, 5, 30, 13, 58, 21, 0, time.FixedZone("CEST", 2*3600))
+	a := &pendingOrder{Signal: "pressure", Node: "node1", DetectedAt: detected}
+	b := &pendingOrder{Signal: "pressure", Node: "node1", DetectedAt: detected.UTC()}
+	if a.idempotencyKey() != b.idempotencyKey() || a.idempotencyKey() != "nurse/pressure/node1@2026-05-30T11:58:21Z" {
+		t.Errorf("keys %q and %q", a.idempotencyKey(), b.idempotencyKey())
+	}
+	b.DetectedAt = detected.Add(time.Hour) // a later anomaly on the same source
+	if a.idempotencyKey() == b.idempotencyKey() {
+		t.Error("two anomalies share a key")
+	}
+}
+
+// TestCompletionDuringMonitoring completes orders through the monitor service
+// while the source is being sampled; run with -race.
+func TestCompletionDuringMonitoring(t *testing.T) {
+	fs := &fakeSapper{statuses: map[string]string{}, keys: map[string]string{}}
+	sapper := httptest.NewServer(fs)
+	defer sapper.Close()
+
+	tr := pendingTraits(t, filepath.Join(t.TempDir(), "pendingOrders.json"), sapper.URL)
+	tr.ua = &components.UnitAsset{Name: "HealthTracker"}
+	sig := &tr.Signals[0]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			at := time.Now()
+			for _, node := range []string{"node1", "node2"} {
+				if tr.workRequested(sig, node) {
+					continue
+				}
+				if found := tr.assess(sig, source{node: node}, 5, at); found != nil {
+					tr.raiseOrder(sig, node, found, "827PD2708", "827-PV2708-200", "")
+				}
+			}
+			tr.followUpOrders(sig)
+		}
+	}()
+	complete := func() {
+		fs.mu.Lock()
+		ids := make([]string, 0, len(fs.statuses))
+		for id := range fs.statuses {
+			ids = append(ids, id)
+		}
+		fs.mu.Unlock()
+		for _, id := range ids {
+			body := []byte(`{"orderId":"` + id + `","status":"TECO"}`)
+			tr.update(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/nurse/HealthTracker/monitor", bytes.NewReader(body)))
+			tr.state(httptest.NewRecorder())
+		}
+	}
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			complete()
+		}
+	}
+	complete()
+
+	if len(fs.statuses) < 2 {
+		t.Errorf("%d orders raised, want several per source", len(fs.statuses))
+	}
+	if len(tr.pendingOrders) != 0 || len(sig.WorkRequested) != 0 || !sig.Operational {
+		t.Errorf("after completing every order: pending %v, work requested %v, operational %t",
+			tr.pendingOrders, sig.WorkRequested, sig.Operational)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sdoque/mbaigo/components"
@@ -37,27 +38,40 @@ import (
 
 // -------------------------------------Define a measurement (or signal)
 type SignalT struct {
-	Name              string              `json:"serviceDefinition"`
-	Details           map[string][]string `json:"details"`
-	Period            time.Duration       `json:"samplingPeriod"`
-	LowerThreshold    float64             `json:"lowerThreshold"`
-	UpperThreshold    float64             `json:"upperThreshold"`
-	TOverCount        map[string]int      `json:"-"` // consecutive out-of-range count per source node
-	WorkRequested     map[string]bool     `json:"-"` // pending maintenance order per source node
-	Operational       bool                `json:"-"` // false when any node has a pending order
-	ValveTagByNode    map[string]string   `json:"-"` // node → actuator FL tag resolved from GraphDB (human-readable)
-	ValveIRIByNode    map[string]string   `json:"-"` // node → actuator FL IRI (used to link the work request in the STEP graph)
-	UnresolvableNodes map[string]bool     `json:"-"` // nodes whose actuator could not be resolved; skipped
+	Name              string                  `json:"serviceDefinition"`
+	Details           map[string][]string     `json:"details"`
+	Period            time.Duration           `json:"samplingPeriod"`
+	LowerThreshold    float64                 `json:"lowerThreshold"`
+	UpperThreshold    float64                 `json:"upperThreshold"`
+	Detectors         []DetectorT             `json:"detectors,omitempty"`     // a band on the thresholds if none
+	OrderTemplate     *OrderTemplateT         `json:"orderTemplate,omitempty"` // orders raised for this signal
+	TOverCount        map[string]int          `json:"-"`                       // consecutive anomalous readings per source node (largest over the detectors)
+	WorkRequested     map[string]bool         `json:"-"`                       // pending maintenance order per source node
+	Operational       bool                    `json:"-"`                       // false when any node has a pending order
+	ValveTagByNode    map[string]string       `json:"-"`                       // node → actuator FL tag (human-readable)
+	ValveIRIByNode    map[string]string       `json:"-"`                       // node → actuator FL IRI (used to link the work request in the STEP graph); may be empty
+	ResolvedUntil     map[string]time.Time    `json:"-"`                       // node → when its actuator is resolved again
+	UnresolvableNodes map[string]time.Time    `json:"-"`                       // node → next resolution attempt; skipped until resolved
+	detectors         map[string]*detectorSet `json:"-"`                       // node → detector state
+	lookups           *actuatorLookups        `json:"-"`                       // GraphDB lookups running for the nodes
 }
 
 //-------------------------------------Define the unit asset
 
 // Traits holds the configurable parameters for the nurse unit asset.
 type Traits struct {
-	GraphDB_URL   string              `json:"graphdb_url"`
-	Signals       []SignalT           `json:"signals"`
-	pendingOrders map[string]string   // orderID → signalName; not serialized
-	sapper        *components.Cervice // discovers the Sapper's MaintenanceOrder service
+	GraphDB_URL   string               `json:"graphdb_url"`
+	Signals       []SignalT            `json:"signals"`
+	OrderTemplate *OrderTemplateT      `json:"orderTemplate,omitempty"` // orders raised for any signal
+	StateFile     string               `json:"stateFile,omitempty"`     // where pending orders survive restarts; none if empty
+	Actuators     map[string]ActuatorT `json:"actuators,omitempty"`     // sensor name → actuator, when neither GraphDB nor the registry knows it
+	ResolveTTL    int                  `json:"resolveTTL,omitempty"`    // seconds a resolved actuator is cached (default 3600)
+	ResolveRetry  int                  `json:"resolveRetry,omitempty"`  // seconds between attempts on an unresolved node (default 60)
+	pendingOrders map[string]string    // orderID → signalName; not serialized
+	mu            sync.Mutex           // guards pendingOrders and the signals' counters, pending work and detectors
+	book          *orderBook           // pending orders by source, persisted to StateFile
+	readings      *readingBook         // latest reading of each signal, for cross-signal detectors
+	sapper        *components.Cervice  // discovers the Sapper's MaintenanceOrder service
 	owner         *components.System
 	ua            *components.UnitAsset
 }
@@ -90,6 +104,7 @@ func initTemplate() *components.UnitAsset {
 		},
 		Traits: &Traits{
 			GraphDB_URL: "http://13.79.36.131:7200/repositories/arrowhead-skoghall-v2",
+			StateFile:   "pendingOrders.json",
 			Signals: []SignalT{
 				{
 					Name:           "pressure",
@@ -111,6 +126,7 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 	t := &Traits{
 		owner:         sys,
 		pendingOrders: make(map[string]string),
+		readings:      newReadingBook(),
 	}
 
 	if len(configuredAsset.Traits) > 0 {
@@ -126,24 +142,42 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 		t.Signals[i].WorkRequested = make(map[string]bool)
 		t.Signals[i].ValveTagByNode = make(map[string]string)
 		t.Signals[i].ValveIRIByNode = make(map[string]string)
-		t.Signals[i].UnresolvableNodes = make(map[string]bool)
+		t.Signals[i].ResolvedUntil = make(map[string]time.Time)
+		t.Signals[i].UnresolvableNodes = make(map[string]time.Time)
+	}
+	if err := t.OrderTemplate.validate(); err != nil {
+		log.Fatalf("nurse: %v", err)
+	}
+	for i := range t.Signals {
+		if err := t.validateDetectors(&t.Signals[i]); err != nil {
+			log.Fatalf("nurse: %v", err)
+		}
 	}
 
 	// The Sapper is discovered via Arrowhead at order-creation time — no
 	// startup healthcheck. If the Sapper isn't yet registered when an order
-	// needs to be raised, requestMaintenanceOrder logs the failure and the
-	// signal stays in WorkRequested until the next process restart.
+	// needs to be raised, the order stays pending and is retried with backoff.
+	// Pending orders survive restarts in the state file; those already created
+	// are checked with the Sapper on the first monitoring tick.
+	book, err := loadOrderBook(t.StateFile)
+	if err != nil {
+		log.Fatalf("nurse: reading the pending orders: %v", err)
+	}
+	t.book = book
+	t.restorePending()
 
-	// GraphDB is load-bearing: without it the nurse cannot resolve a sensor
-	// to its associated asset and any maintenance order it raised would be
-	// misdirected. Refuse to start rather than emit confused work orders.
+	// GraphDB is the primary source of the sensor-to-actuator relationship
+	// and receives the orders' context. While it is unreachable, actuators
+	// are resolved from the sensors' registration records or the actuators
+	// trait, and unresolved sensors are retried until it answers.
 	if t.GraphDB_URL == "" {
 		log.Fatalf("nurse: graphdb_url is required in configuration")
 	}
 	if err := CheckGraphDBUp(t.GraphDB_URL, 5*time.Second); err != nil {
-		log.Fatalf("nurse: GraphDB unreachable at %s: %v", t.GraphDB_URL, err)
+		log.Printf("nurse: GraphDB unreachable at %s: %v; falling back on registration records and configured actuators", t.GraphDB_URL, err)
+	} else {
+		log.Printf("GraphDB reachable at %s", t.GraphDB_URL)
 	}
-	log.Printf("GraphDB reachable at %s", t.GraphDB_URL)
 
 	sProtocols := components.SProtocols(sys.Husk.ProtoPort)
 	cervices := make(components.Cervices)
@@ -201,11 +235,11 @@ func (t *Traits) findSignal(name string) *SignalT {
 }
 
 // UnmarshalTraits unmarshals a slice of json.RawMessage into a slice of Traits.
-func UnmarshalTraits(rawTraits []json.RawMessage) ([]Traits, error) {
-	var traitsList []Traits
+func UnmarshalTraits(rawTraits []json.RawMessage) ([]*Traits, error) {
+	var traitsList []*Traits
 	for _, raw := range rawTraits {
-		var t Traits
-		if err := json.Unmarshal(raw, &t); err != nil {
+		t := new(Traits)
+		if err := json.Unmarshal(raw, t); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal trait: %w", err)
 		}
 		traitsList = append(traitsList, t)
@@ -216,7 +250,7 @@ func UnmarshalTraits(rawTraits []json.RawMessage) ([]Traits, error) {
 //-------------------------------------Unit asset's function methods
 
 // sigMon periodically monitors all providers of a signal and requests maintenance
-// when any single source stays outside the [lower, upper] range 5 consecutive times.
+// when a detector of any single source confirms an anomaly.
 func (t *Traits) sigMon(name string, period time.Duration) error {
 	ticker := time.NewTicker(period)
 	defer ticker.Stop()
@@ -248,7 +282,7 @@ func (t *Traits) sigMon(name string, period time.Duration) error {
 			// Resolve the actuator (e.g. valve) each provider's sensor diagnoses.
 			// Done here, before polling, so a missing diagnosesActuator relationship
 			// surfaces during normal operation rather than under an anomaly.
-			t.resolveActuators(sig, cer.Nodes)
+			t.resolveActuators(sig, cer.Nodes, time.Now())
 
 			// Query each provider individually to preserve its identity for per-source counting.
 			failed := false
@@ -258,12 +292,12 @@ func (t *Traits) sigMon(name string, period time.Duration) error {
 				}
 				// Skip nodes whose actuator could not be resolved — sending an order
 				// for a misidentified asset is worse than sending no order at all.
-				if sig.UnresolvableNodes[node] {
+				if _, unresolved := sig.UnresolvableNodes[node]; unresolved {
 					continue
 				}
 				for _, ni := range nodeInfos {
 					// Skip this node while its maintenance order is pending.
-					if sig.WorkRequested[node] {
+					if t.workRequested(sig, node) {
 						continue
 					}
 					tmp := &components.Cervice{
@@ -288,44 +322,69 @@ func (t *Traits) sigMon(name string, period time.Duration) error {
 					log.Printf("Measurement: %s from %s, Value: %.2f, Time: %s\n",
 						name, node, tup.Value, time.Now().Format(time.RFC3339))
 
-					if tup.Value < sig.LowerThreshold || tup.Value > sig.UpperThreshold {
-						sig.TOverCount[node]++
-						log.Printf("ALERT: %s/%s value %.2f outside range [%.2f, %.2f] (count: %d/5)\n",
-							name, node, tup.Value, sig.LowerThreshold, sig.UpperThreshold, sig.TOverCount[node])
-						if sig.TOverCount[node] >= 5 {
-							sig.Operational = false
-							sig.WorkRequested[node] = true
-							log.Printf("Signal %s/%s non-operational, requesting maintenance\n", name, node)
-							equipmentID := assetNameFromURL(ni.URL)
-							// The actuator FL tag (human label) and IRI (graph linkage)
-							// both come from the GraphDB resolution. The sensor only
-							// diagnoses the fault; the work order targets the valve.
-							locationTag := sig.ValveTagByNode[node]
-							locationIRI := sig.ValveIRIByNode[node]
-							orderID := t.requestMaintenanceOrder(sig, equipmentID, locationTag, locationIRI)
-							if orderID != "" {
-								t.pendingOrders[orderID] = name
-								log.Printf("Maintenance order %s created for signal %s/%s\n", orderID, name, node)
-								reason := fmt.Sprintf("Signal %s out of range [%.2f, %.2f]",
-									sig.Name, sig.LowerThreshold, sig.UpperThreshold)
-								go t.pushOrderContext(orderID, equipmentID, locationTag, reason)
-							} else {
-								log.Printf("SAP order failed for signal %s/%s; monitoring paused until system restart\n", name, node)
-							}
-						}
-					} else if sig.TOverCount[node] > 0 {
-						log.Printf("Signal %s/%s back in range (resetting count from %d)\n",
-							name, node, sig.TOverCount[node])
-						sig.TOverCount[node] = 0
+					at := tup.Timestamp
+					if at.IsZero() {
+						at = time.Now()
+					}
+					src := source{node: node, details: ni.Details}
+					t.recordReading(name, src, tup.Value, at)
+					found := t.assess(sig, src, tup.Value, at)
+					if found == nil {
+						continue
 					}
+					// The actuator FL tag (human label) and IRI (graph linkage)
+					// both come from the GraphDB resolution. The sensor only
+					// diagnoses the fault; the work order targets the valve.
+					t.raiseOrder(sig, node, found, assetNameFromURL(ni.URL), sig.ValveTagByNode[node], sig.ValveIRIByNode[node])
 				}
 			}
+
+			// Retry the orders that could not be created and check the ones
+			// pending since startup.
+			t.followUpOrders(sig)
 		}
 	}
 }
 
+// workRequested reports whether a source has a pending maintenance order.
+func (t *Traits) workRequested(sig *SignalT, node string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return sig.WorkRequested[node]
+}
+
+// assess runs the detectors of a source on a reading and keeps its anomaly
+// count. It returns the finding for which an order must be raised, the source
+// being marked as having one, or nil.
+func (t *Traits) assess(sig *SignalT, src source, v float64, at time.Time) *finding {
+	node := src.node
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	found, count := t.evaluate(sig, src, v, at)
+	if count == 0 {
+		if sig.TOverCount[node] > 0 {
+			log.Printf("Signal %s/%s back to normal (resetting count from %d)\n",
+				sig.Name, node, sig.TOverCount[node])
+			sig.TOverCount[node] = 0
+		}
+		return nil
+	}
+	sig.TOverCount[node] = count
+	if found == nil {
+		log.Printf("ALERT: %s/%s value %.2f anomalous (count: %d)\n", sig.Name, node, v, count)
+		return nil
+	}
+	sig.Operational = false
+	sig.WorkRequested[node] = true
+	log.Printf("Signal %s/%s non-operational (%s detector, score %.2f: %s), requesting maintenance\n",
+		sig.Name, node, found.Detector, found.Score, found.Reason)
+	return found
+}
+
 // state reports the current status of all monitored signals.
 func (t *Traits) state(w http.ResponseWriter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	text := "The list of measurements that are monitored by " + t.ua.Name + "\n"
 	for _, signal := range t.Signals {
 		counts := ""
@@ -342,8 +401,12 @@ func (t *Traits) state(w http.ResponseWriter) {
 		if pending == "" {
 			pending = " none"
 		}
-		text += fmt.Sprintf("Signal: %s, Range: [%.2f, %.2f], TOverCount:[%s], Operational: %t, WorkRequested:[%s]\n",
-			signal.Name, signal.LowerThreshold, signal.UpperThreshold, counts, signal.Operational, pending)
+		kinds := make([]string, 0, len(signal.detectorConfigs()))
+		for _, cfg := range signal.detectorConfigs() {
+			kinds = append(kinds, cfg.Kind)
+		}
+		text += fmt.Sprintf("Signal: %s, Range: [%.2f, %.2f], Detectors: %s, TOverCount:[%s], Operational: %t, WorkRequested:[%s]\n",
+			signal.Name, signal.LowerThreshold, signal.UpperThreshold, strings.Join(kinds, ", "), counts, signal.Operational, pending)
 	}
 	w.Write([]byte(text))
 }
@@ -411,17 +474,8 @@ func (t *Traits) update(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Find the signal that raised this order and restore it to operational.
-	if signalName, ok := t.pendingOrders[event.OrderID]; ok {
-		if sig := t.findSignal(signalName); sig != nil {
-			sig.Operational = true
-			sig.WorkRequested = make(map[string]bool)
-			sig.TOverCount = make(map[string]int)
-			delete(t.pendingOrders, event.OrderID)
-			log.Printf("Signal %s restored to operational (order %s: %s)\n",
-				signalName, event.OrderID, event.Status)
-		}
-	} else {
+	// Find the source that raised this order and restore it to operational.
+	if !t.completeOrder(event.OrderID, event.Status) {
 		log.Printf("Received completion for unknown order %s\n", event.OrderID)
 	}
 
@@ -469,55 +523,6 @@ INSERT DATA {
 	log.Printf("← GraphDB %s  body=%s\n", resp.Status, string(msg))
 }
 
-// resolveActuators looks up the actuator (e.g. valve) functional-location tag
-// for every newly-discovered node in nodes. The result is cached on the signal
-// so each sensor pays the lookup cost at most once. A sensor with no
-// diagnosesActuator relationship, with multiple candidates, or whose lookup
-// fails for any reason is marked unresolvable and skipped in future polls —
-// emitting a maintenance order against a misidentified asset is a worse
-// failure mode than emitting nothing.
-//
-// The expected graph shape is:
-//
-//	<sensor-iri>  afo:hasName            "<sensor-tag>" .
-//	<sensor-iri>  afo:diagnosesActuator  <valve-fl-iri> .
-//	<valve-fl-iri> arrowhead:functionalLocation "<valve-tag>" .
-//
-// The third triple is already in the Skoghall store for modelled equipment;
-// the first two must be added by INSERT when the sensor's knowledge graph
-// is published to GraphDB.
-func (t *Traits) resolveActuators(sig *SignalT, nodes map[string][]components.NodeInfo) {
-	for node, nodeInfos := range nodes {
-		if _, ok := sig.ValveTagByNode[node]; ok {
-			continue
-		}
-		if sig.UnresolvableNodes[node] {
-			continue
-		}
-		if len(nodeInfos) == 0 {
-			continue
-		}
-		sensorName := assetNameFromURL(nodeInfos[0].URL)
-		if sensorName == "" {
-			log.Printf("nurse: cannot extract sensor name from %s; marking node %s unresolvable",
-				nodeInfos[0].URL, node)
-			sig.UnresolvableNodes[node] = true
-			continue
-		}
-		tag, iri, err := resolveActuator(t.GraphDB_URL, sensorName, 5*time.Second)
-		if err != nil {
-			log.Printf("nurse: cannot resolve actuator for sensor %s on node %s: %v",
-				sensorName, node, err)
-			sig.UnresolvableNodes[node] = true
-			continue
-		}
-		log.Printf("nurse: sensor %s on node %s diagnoses actuator %s (FL IRI %s)",
-			sensorName, node, tag, iri)
-		sig.ValveTagByNode[node] = tag
-		sig.ValveIRIByNode[node] = iri
-	}
-}
-
 // resolveActuator asks GraphDB for the actuator a sensor diagnoses and
 // returns both its functional-location tag (human-readable, e.g.
 // "827-PV2708-200") and the FL's IRI (used to link the work order to the
@@ -637,62 +642,21 @@ func assetNameFromURL(rawURL string) string {
 	return parts[1]
 }
 
-// requestMaintenanceOrder posts a maintenance order to the SAP system for the given signal
-// and returns the SAP order ID on success, or an empty string on failure.
-// locationTag is the human-readable FL tag (e.g. "827-PV2708-200"); locationIRI is
-// the FL's IRI in the STEP graph, used by the SAP side to link the work request to
+// requestMaintenanceOrder posts a maintenance order, shaped by the order templates,
+// to the SAP system and returns the SAP order ID.
+// The order's functional location is the human-readable FL tag (e.g. "827-PV2708-200")
+// and its IRI in the STEP graph, used by the SAP side to link the work request to
 // the physical asset. The IRI may be empty for consumers that haven't resolved it.
-func (t *Traits) requestMaintenanceOrder(sig *SignalT, equipmentID, locationTag, locationIRI string) string {
-	start := time.Now().Add(24 * time.Hour)
-	end := start.Add(8 * time.Hour)
-
-	payload := MaintenanceOrderEvent{
-		EquipmentID:           equipmentID,
-		FunctionalLocation:    locationTag,
-		FunctionalLocationIRI: locationIRI,
-		Plant:                 "1000",
-		Description:           fmt.Sprintf("Signal %s out of range [%.2f, %.2f]", sig.Name, sig.LowerThreshold, sig.UpperThreshold),
-		Priority:              "3",
-		MaintenanceOrderType:  "PM01",
-		PlannedStartTime:      &start,
-		PlannedEndTime:        &end,
-		Operations: []MaintenanceOperation{
-			{
-				OperationID:  "0010",
-				Text:         fmt.Sprintf("Inspect and service equipment for signal %s", sig.Name),
-				WorkCenter:   "MAINT-WC01",
-				Duration:     4,
-				DurationUnit: "H",
-			},
-		},
-	}
-
+// The idempotency key makes the Sapper answer a retry with the order already created.
+func (t *Traits) requestMaintenanceOrder(payload MaintenanceOrderEvent, key string) (string, error) {
 	bodyBytes, err := json.MarshalIndent(payload, "", "  ")
 	if err != nil {
-		log.Printf("requestMaintenanceOrder: marshal payload: %v\n", err)
-		return ""
+		return "", fmt.Errorf("marshal payload: %w", err)
 	}
 
-	// Discover the Sapper's MaintenanceOrder service via Arrowhead. The
-	// previous design hardcoded a sap_url; orchestration removes that
-	// topology dependency from the config.
-	if len(t.sapper.Nodes) == 0 {
-		if err := usecases.Search4Services(t.sapper, t.owner); err != nil {
-			log.Printf("requestMaintenanceOrder: discovery failed: %v\n", err)
-			return ""
-		}
-	}
-	var sapURL string
-	for _, nodes := range t.sapper.Nodes {
-		if len(nodes) > 0 {
-			sapURL = nodes[0].URL
-			break
-		}
-	}
-	if sapURL == "" {
-		log.Printf("requestMaintenanceOrder: no MaintenanceOrder provider found\n")
-		t.sapper.Nodes = make(map[string][]components.NodeInfo) // force re-discovery next time
-		return ""
+	sapURL, err := t.sapperURL()
+	if err != nil {
+		return "", err
 	}
 
 	log.Printf("→ SAP POST %s\n%s\n", sapURL, string(bodyBytes))
@@ -702,23 +666,22 @@ func (t *Traits) requestMaintenanceOrder(sig *SignalT, equipmentID, locationTag,
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sapURL, bytes.NewReader(bodyBytes))
 	if err != nil {
-		log.Printf("requestMaintenanceOrder: new request: %v\n", err)
-		return ""
+		return "", fmt.Errorf("new request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Idempotency-Key", key)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		log.Printf("requestMaintenanceOrder: do request: %v\n", err)
-		return ""
+		t.sapper.Nodes = make(map[string][]components.NodeInfo) // the Sapper may have moved
+		return "", fmt.Errorf("do request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("requestMaintenanceOrder: read response: %v\n", err)
-		return ""
+		return "", fmt.Errorf("read response: %w", err)
 	}
 
 	var prettyResp bytes.Buffer
@@ -729,17 +692,85 @@ func (t *Traits) requestMaintenanceOrder(sig *SignalT, equipmentID, locationTag,
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return ""
+		return "", fmt.Errorf("the Sapper answered %s", resp.Status)
 	}
 
 	var out MaintenanceOrderResponse
 	if err := json.Unmarshal(respBody, &out); err != nil {
-		log.Printf("requestMaintenanceOrder: unmarshal response: %v\n", err)
-		return ""
+		return "", fmt.Errorf("unmarshal response: %w", err)
+	}
+	if out.MaintenanceOrder == "" {
+		return "", fmt.Errorf("the Sapper returned no order ID")
+	}
+
+	log.Printf("Maintenance order %s created for equipment %s\n", out.MaintenanceOrder, payload.EquipmentID)
+	return out.MaintenanceOrder, nil
+}
+
+// queryOrderStatus asks the Sapper for the status of an order.
+func (t *Traits) queryOrderStatus(orderID string) (string, error) {
+	out, err := t.queryOrder("id", orderID)
+	return out.Status, err
+}
+
+// lookupOrder asks the Sapper for the open order created under an idempotency key.
+func (t *Traits) lookupOrder(key string) (string, error) {
+	out, err := t.queryOrder("key", key)
+	return out.MaintenanceOrder, err
+}
+
+// queryOrder asks the Sapper for an order by ID or by idempotency key.
+func (t *Traits) queryOrder(param, value string) (MaintenanceOrderResponse, error) {
+	var out MaintenanceOrderResponse
+	sapURL, err := t.sapperURL()
+	if err != nil {
+		return out, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sapURL+"?"+param+"="+url.QueryEscape(value), nil)
+	if err != nil {
+		return out, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.sapper.Nodes = make(map[string][]components.NodeInfo)
+		return out, fmt.Errorf("do request: %w", err)
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return out, errOrderUnknown
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return out, fmt.Errorf("the Sapper answered %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return out, fmt.Errorf("unmarshal response: %w", err)
+	}
+	return out, nil
+}
 
-	log.Printf("Maintenance order %s created for equipment %s\n", out.MaintenanceOrder, equipmentID)
-	return out.MaintenanceOrder
+// sapperURL returns the URL of the Sapper's MaintenanceOrder service.
+func (t *Traits) sapperURL() (string, error) {
+	// Discover the Sapper's MaintenanceOrder service via Arrowhead. The
+	// previous design hardcoded a sap_url; orchestration removes that
+	// topology dependency from the config.
+	if len(t.sapper.Nodes) == 0 {
+		if err := usecases.Search4Services(t.sapper, t.owner); err != nil {
+			return "", fmt.Errorf("discovery failed: %w", err)
+		}
+	}
+	for _, nodes := range t.sapper.Nodes {
+		if len(nodes) > 0 {
+			return nodes[0].URL, nil
+		}
+	}
+	t.sapper.Nodes = make(map[string][]components.NodeInfo) // force re-discovery next time
+	return "", fmt.Errorf("no MaintenanceOrder provider found")
 }
 
 // -----------------------------Turtle conversion functions
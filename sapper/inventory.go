@@ -0,0 +1,428 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Movement types of the material documents, after SAP MM.
+const (
+	movementInitialEntry = "561"
+	movementReceipt      = "101"
+	movementIssueToOrder = "261"
+)
+
+const (
+	reservationOpen   = "open"
+	reservationIssued = "issued"
+)
+
+// defaultStorageLocation is where a component is taken from when it names
+// none.
+const defaultStorageLocation = "0001"
+
+// userStatusWaitMaterial marks an order whose components are short.
+const userStatusWaitMaterial = "WMAT"
+
+// errStockShort is returned when a goods issue finds too little stock.
+var errStockShort = errors.New("stock is short")
+
+// inventory is the simulated MM: the stock per material and storage location,
+// the reservations of released orders and the posted material documents. It
+// is guarded by the traits' mutex, like the orders it serves.
+type inventory struct {
+	stock        map[string]*Stock // by stockKey
+	reservations []*Reservation
+	movements    []GoodsMovement
+	lastRes      int64 // last reservation number
+	lastDoc      int64 // last material document number
+}
+
+func stockKey(material, plant, sloc string) string {
+	return material + "/" + plant + "/" + sloc
+}
+
+func newInventory() *inventory {
+	return &inventory{stock: make(map[string]*Stock)}
+}
+
+// reserved returns the quantity of a stock held by open reservations.
+func (inv *inventory) reserved(key string) float64 {
+	var q float64
+	for _, r := range inv.reservations {
+		if r.Status == reservationOpen && stockKey(r.Material, r.Plant, r.StorageLocation) == key {
+			q += r.Quantity
+		}
+	}
+	return q
+}
+
+// post records a material document with the next document number.
+func (inv *inventory) post(m GoodsMovement) GoodsMovement {
+	inv.lastDoc++
+	m.Document = fmt.Sprintf("49%08d", inv.lastDoc)
+	inv.movements = append(inv.movements, m)
+	return m
+}
+
+// restoreInventory loads the stored inventory and then puts the traits'
+// initial stock of the materials and locations not stocked yet.
+func (t *Traits) restoreInventory() error {
+	stock, reservations, movements, err := t.store.loadInventory()
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inv = newInventory()
+	for _, st := range stock {
+		t.inv.stock[stockKey(st.Material, st.Plant, st.StorageLocation)] = st
+	}
+	t.inv.reservations = reservations
+	t.inv.movements = movements
+	for _, r := range reservations {
+		if n, err := strconv.ParseInt(r.Reservation, 10, 64); err == nil && n > t.inv.lastRes {
+			t.inv.lastRes = n
+		}
+	}
+	for _, m := range movements {
+		if n, err := strconv.ParseInt(m.Document, 10, 64); err == nil && n-4900000000 > t.inv.lastDoc {
+			t.inv.lastDoc = n - 4900000000
+		}
+	}
+	if t.inv.lastRes == 0 {
+		t.inv.lastRes = 1000000000
+	}
+	for _, gr := range t.Inventory {
+		if gr.StorageLocation == "" {
+			gr.StorageLocation = defaultStorageLocation
+		}
+		if _, stocked := t.inv.stock[stockKey(gr.Material, gr.Plant, gr.StorageLocation)]; stocked {
+			continue
+		}
+		m, err := t.receive(gr, movementInitialEntry, time.Now())
+		if err != nil {
+			return fmt.Errorf("initial stock: %w", err)
+		}
+		go t.recordMovements([]GoodsMovement{m})
+	}
+	return nil
+}
+
+// receive puts a quantity into stock and records the material document. The
+// caller holds t.mu.
+func (t *Traits) receive(gr GoodsReceipt, movementType string, at time.Time) (GoodsMovement, error) {
+	if gr.Material == "" || gr.Plant == "" {
+		return GoodsMovement{}, errors.New("material and plant are required")
+	}
+	if gr.Quantity <= 0 {
+		return GoodsMovement{}, fmt.Errorf("the quantity of %s must be positive", gr.Material)
+	}
+	if gr.StorageLocation == "" {
+		gr.StorageLocation = defaultStorageLocation
+	}
+	key := stockKey(gr.Material, gr.Plant, gr.StorageLocation)
+	st, ok := t.inv.stock[key]
+	if !ok {
+		unit := gr.Unit
+		if unit == "" {
+			unit = "EA"
+		}
+		st = &Stock{Material: gr.Material, Description: gr.Description, Plant: gr.Plant, StorageLocation: gr.StorageLocation, Unit: unit}
+		t.inv.stock[key] = st
+	}
+	if gr.Unit != "" && gr.Unit != st.Unit {
+		return GoodsMovement{}, fmt.Errorf("%s is stocked in %s, not %s", gr.Material, st.Unit, gr.Unit)
+	}
+	if st.Description == "" {
+		st.Description = gr.Description
+	}
+	st.Unrestricted += gr.Quantity
+	m := t.inv.post(GoodsMovement{MovementType: movementType, Material: gr.Material, Plant: gr.Plant,
+		StorageLocation: gr.StorageLocation, Quantity: gr.Quantity, Unit: st.Unit, PostedAt: at})
+	t.saveInventory(st, nil, &m)
+	return m, nil
+}
+
+// saveInventory writes what changed to the store; any of the three may be nil.
+func (t *Traits) saveInventory(st *Stock, r *Reservation, m *GoodsMovement) {
+	var errs []error
+	if st != nil {
+		errs = append(errs, t.store.saveStock(st))
+	}
+	if r != nil {
+		errs = append(errs, t.store.saveReservation(r))
+	}
+	if m != nil {
+		errs = append(errs, t.store.saveMovement(*m))
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("sapper: %v\n", err)
+	}
+}
+
+// reserve creates the reservation of a released order, one item per
+// component, and returns the components that the available stock does not
+// cover. The caller holds t.mu.
+func (t *Traits) reserve(o *Order, at time.Time) (short []string) {
+	if t.inv == nil {
+		return nil
+	}
+	item := 0
+	var number string
+	for _, op := range o.Request.Operations {
+		for _, c := range op.Components {
+			if c.Material == "" || c.Quantity <= 0 {
+				continue
+			}
+			if number == "" {
+				t.inv.lastRes++
+				number = strconv.FormatInt(t.inv.lastRes, 10)
+			}
+			plant, sloc := c.Plant, c.StorageLocation
+			if plant == "" {
+				plant = o.Request.Plant
+			}
+			if sloc == "" {
+				sloc = defaultStorageLocation
+			}
+			key := stockKey(c.Material, plant, sloc)
+			unit := c.Unit
+			if st, ok := t.inv.stock[key]; ok {
+				unit = st.Unit
+				if available := st.Unrestricted - t.inv.reserved(key); available < c.Quantity {
+					short = append(short, fmt.Sprintf("%s needs %g %s, %g available", c.Material, c.Quantity, unit, available))
+				}
+			} else {
+				short = append(short, fmt.Sprintf("%s is not stocked at %s/%s", c.Material, plant, sloc))
+			}
+			item++
+			r := &Reservation{Reservation: number, Item: item, OrderID: o.ID, OperationID: op.OperationID,
+				Material: c.Material, Plant: plant, StorageLocation: sloc, Quantity: c.Quantity, Unit: unit,
+				Status: reservationOpen, CreatedAt: at}
+			t.inv.reservations = append(t.inv.reservations, r)
+			t.saveInventory(nil, r, nil)
+		}
+	}
+	if len(short) > 0 {
+		t.markWaitingForMaterial(o, at, "stock short: "+strings.Join(short, "; "))
+	}
+	return short
+}
+
+// issueGoods withdraws the open reservations of an order from stock, all or
+// none. If the stock is short, the order is marked WMAT and an error wrapping
+// errStockShort is returned. The caller holds t.mu.
+func (t *Traits) issueGoods(o *Order, at time.Time) ([]GoodsMovement, error) {
+	if t.inv == nil {
+		return nil, nil
+	}
+	var open []*Reservation
+	need := make(map[string]float64)
+	for _, r := range t.inv.reservations {
+		if r.OrderID == o.ID && r.Status == reservationOpen {
+			open = append(open, r)
+			need[stockKey(r.Material, r.Plant, r.StorageLocation)] += r.Quantity
+		}
+	}
+	var short []string
+	for key, q := range need {
+		var have float64
+		if st, ok := t.inv.stock[key]; ok {
+			have = st.Unrestricted
+		}
+		if have < q {
+			short = append(short, fmt.Sprintf("%s needs %g, %g in stock", key, q, have))
+		}
+	}
+	if len(short) > 0 {
+		sort.Strings(short)
+		msg := strings.Join(short, "; ")
+		t.markWaitingForMaterial(o, at, "goods issue blocked: "+msg)
+		t.saveOrder(o)
+		return nil, fmt.Errorf("order %s: %w: %s", o.ID, errStockShort, msg)
+	}
+
+	var issued []GoodsMovement
+	for _, r := range open {
+		st := t.inv.stock[stockKey(r.Material, r.Plant, r.StorageLocation)]
+		st.Unrestricted -= r.Quantity
+		r.Status = reservationIssued
+		r.IssuedAt = &at
+		m := t.inv.post(GoodsMovement{MovementType: movementIssueToOrder, Material: r.Material, Plant: r.Plant,
+			StorageLocation: r.StorageLocation, Quantity: r.Quantity, Unit: r.Unit, OrderID: o.ID,
+			Reservation: r.Reservation, PostedAt: at})
+		t.saveInventory(st, r, &m)
+		issued = append(issued, m)
+	}
+	if i := slices.Index(o.UserStatuses, userStatusWaitMaterial); i >= 0 {
+		o.UserStatuses = slices.Delete(o.UserStatuses, i, i+1)
+		o.History = append(o.History, StatusChange{Status: "-" + userStatusWaitMaterial, At: at, Note: "goods issued"})
+	}
+	return issued, nil
+}
+
+// markWaitingForMaterial sets WMAT on an order, once.
+func (t *Traits) markWaitingForMaterial(o *Order, at time.Time, note string) {
+	log.Printf("order %s: %s\n", o.ID, note)
+	if !slices.Contains(o.UserStatuses, userStatusWaitMaterial) {
+		o.UserStatuses = append(o.UserStatuses, userStatusWaitMaterial)
+		o.History = append(o.History, StatusChange{Status: "+" + userStatusWaitMaterial, At: at, Note: note})
+	}
+}
+
+// retryBlocked fires again the overdue scheduled transitions of the orders
+// waiting for material, after stock came in. The caller holds t.mu.
+func (t *Traits) retryBlocked() {
+	now := time.Now()
+	for _, o := range t.orders {
+		if !slices.Contains(o.UserStatuses, userStatusWaitMaterial) {
+			continue
+		}
+		for _, st := range o.Scheduled {
+			if !st.Due.After(now) {
+				t.arm(o.ID, st)
+			}
+		}
+	}
+}
+
+// buildGoodsMovementSPARQL records a material document in the workorders
+// graph, linked to the order it was issued to.
+func (t *Traits) buildGoodsMovementSPARQL(m GoodsMovement) (string, error) {
+	params := sparqlParams{
+		"document":        "https://sinetiq.se/sap/MaterialDocument/" + m.Document,
+		"movementType":    m.MovementType,
+		"material":        m.Material,
+		"plant":           m.Plant,
+		"storageLocation": m.StorageLocation,
+		"quantity":        m.Quantity,
+		"unit":            m.Unit,
+		"created":         m.PostedAt,
+		"reservation":     m.Reservation,
+	}
+	if m.OrderID != "" {
+		params["workOrder"] = orderIRI(m.OrderID)
+	}
+	return t.renderSPARQL("goods-movement", nil, params)
+}
+
+// recordMovements writes material documents to GraphDB.
+func (t *Traits) recordMovements(movements []GoodsMovement) {
+	for _, m := range movements {
+		sparql, err := t.buildGoodsMovementSPARQL(m)
+		t.publishSPARQL("goods movement "+m.MovementType, m.OrderID, sparql, err)
+	}
+}
+
+//-------------------------------------Services
+
+// listStock returns the stock, with its reserved and available quantities,
+// filtered by material and plant.
+func (t *Traits) listStock(material, plant string) []Stock {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]Stock, 0)
+	if t.inv == nil {
+		return list
+	}
+	for key, st := range t.inv.stock {
+		if (material != "" && st.Material != material) || (plant != "" && st.Plant != plant) {
+			continue
+		}
+		s := *st
+		s.Reserved = t.inv.reserved(key)
+		s.Available = s.Unrestricted - s.Reserved
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return stockKey(list[i].Material, list[i].Plant, list[i].StorageLocation) < stockKey(list[j].Material, list[j].Plant, list[j].StorageLocation)
+	})
+	return list
+}
+
+// stockHandler lists the stock (GET ?material=&plant=) and posts goods
+// receipts (POST), after which the orders waiting for the material retry
+// their goods issue.
+func (t *Traits) stockHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.listStock(q.Get("material"), q.Get("plant")))
+	case http.MethodPost:
+		var gr GoodsReceipt
+		if err := json.NewDecoder(r.Body).Decode(&gr); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		t.mu.Lock()
+		if t.inv == nil {
+			t.mu.Unlock()
+			http.Error(w, "no inventory is simulated", http.StatusConflict)
+			return
+		}
+		m, err := t.receive(gr, movementReceipt, time.Now())
+		if err == nil {
+			t.retryBlocked()
+		}
+		t.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("goods receipt %s: %g %s %s\n", m.Document, m.Quantity, m.Unit, m.Material)
+		go t.recordMovements([]GoodsMovement{m})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(m)
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// reservationsHandler lists the reservations (GET), filtered by order,
+// material and status.
+func (t *Traits) reservationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	order, material, status := q.Get("order"), q.Get("material"), q.Get("status")
+	list := make([]Reservation, 0)
+	t.mu.Lock()
+	if t.inv != nil {
+		for _, res := range t.inv.reservations {
+			if (order == "" || res.OrderID == order) && (material == "" || res.Material == material) && (status == "" || res.Status == status) {
+				list = append(list, *res)
+			}
+		}
+	}
+	t.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,232 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ── helpers ───────────────────────────────────────────────────────────────────
+
+// stockedTraits returns quiet test traits with a simulated inventory holding
+// the given quantity of gaskets, persisted to path if it is not empty.
+func stockedTraits(t *testing.T, path string, gaskets float64) *Traits {
+	t.Helper()
+	tr := quietTraits()
+	tr.CompletionDelay = 3600
+	if path != "" {
+		store, err := openOrderStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(store.close)
+		tr.store = store
+	}
+	tr.Inventory = []GoodsReceipt{{Material: "GASKET", Description: "Valve gasket set", Plant: "1000", Quantity: gaskets, Unit: "EA"}}
+	if err := tr.restoreInventory(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.restoreOrders(); err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+// orderNeeding creates an order whose operation takes n gaskets and
+// releases it; its TECO is scheduled an hour out.
+func orderNeeding(t *testing.T, tr *Traits, n float64) *Order {
+	t.Helper()
+	o := tr.createOrder(OrderRequest{EquipmentID: "E1", Plant: "1000", Description: "leaking valve",
+		Operations: []OrderOperation{{OperationID: "0010", Text: "replace gaskets",
+			Components: []OrderComponent{{Material: "GASKET", Quantity: n, Unit: "EA"}}}}})
+	if _, err := tr.enrichAndRelease(o.ID, json.RawMessage(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func gaskets(tr *Traits) Stock {
+	return tr.listStock("GASKET", "1000")[0]
+}
+
+func postReceipt(tr *Traits, gr GoodsReceipt) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(gr)
+	w := httptest.NewRecorder()
+	tr.stockHandler(w, httptest.NewRequest(http.MethodPost, "/stock", bytes.NewReader(body)))
+	return w
+}
+
+// ── tests ─────────────────────────────────────────────────────────────────────
+
+func TestReservationAndGoodsIssue(t *testing.T) {
+	tr := stockedTraits(t, "", 20)
+	o := orderNeeding(t, tr, 2)
+
+	if s := gaskets(tr); s.Unrestricted != 20 || s.Reserved != 2 || s.Available != 18 {
+		t.Errorf("after release: %+v", s)
+	}
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, Status: "TECO"}); err != nil {
+		t.Fatal(err)
+	}
+	if s := gaskets(tr); s.Unrestricted != 18 || s.Reserved != 0 || s.Available != 18 {
+		t.Errorf("after TECO: %+v", s)
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	res := tr.inv.reservations[0]
+	last := tr.inv.movements[len(tr.inv.movements)-1]
+	if res.OrderID != o.ID || res.Status != "issued" || res.IssuedAt == nil || res.StorageLocation != "0001" {
+		t.Errorf("reservation = %+v", res)
+	}
+	if last.MovementType != "261" || last.OrderID != o.ID || last.Quantity != 2 || last.Reservation != res.Reservation {
+		t.Errorf("goods issue = %+v", last)
+	}
+	if tr.inv.movements[0].MovementType != "561" {
+		t.Errorf("initial stock = %+v", tr.inv.movements[0])
+	}
+}
+
+func TestShortStockBlocksTECO(t *testing.T) {
+	tr := stockedTraits(t, "", 1)
+	o := orderNeeding(t, tr, 2)
+
+	tr.mu.Lock()
+	if len(o.UserStatuses) != 1 || o.UserStatuses[0] != "WMAT" {
+		t.Errorf("released short: user statuses %v, want WMAT", o.UserStatuses)
+	}
+	tr.mu.Unlock()
+
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, Status: "TECO"}); !errors.Is(err, errStockShort) {
+		t.Errorf("TECO with short stock: %v", err)
+	}
+	at := time.Now().Add(100 * time.Millisecond)
+	tr.updateStatus(StatusRequest{OrderID: o.ID, Status: "TECO", At: &at})
+	time.Sleep(300 * time.Millisecond)
+	if s := statusOf(tr, o); s != "REL" {
+		t.Fatalf("blocked order is %s, want REL", s)
+	}
+
+	// The goods receipt unblocks the scheduled TECO
+	if w := postReceipt(tr, GoodsReceipt{Material: "GASKET", Plant: "1000", Quantity: 5}); w.Code != http.StatusCreated {
+		t.Fatalf("receipt: %d %s", w.Code, w.Body)
+	}
+	deadline := time.Now().Add(time.Second)
+	for statusOf(tr, o) != "TECO" && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if s := statusOf(tr, o); s != "TECO" {
+		t.Fatalf("after the receipt: %s, want TECO", s)
+	}
+	tr.mu.Lock()
+	userStatuses := o.UserStatuses
+	tr.mu.Unlock()
+	if len(userStatuses) != 0 {
+		t.Errorf("still %v after the goods issue", userStatuses)
+	}
+	if s := gaskets(tr); s.Unrestricted != 4 {
+		t.Errorf("stock after the issue: %+v", s)
+	}
+}
+
+func TestStockServices(t *testing.T) {
+	tr := stockedTraits(t, "", 10)
+	a := orderNeeding(t, tr, 3)
+	orderNeeding(t, tr, 1)
+
+	w := httptest.NewRecorder()
+	tr.reservationsHandler(w, httptest.NewRequest(http.MethodGet, "/reservations?order="+a.ID, nil))
+	var list []Reservation
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list) != 1 || list[0].Quantity != 3 || list[0].Status != "open" {
+		t.Errorf("reservations of %s = %+v", a.ID, list)
+	}
+
+	w = httptest.NewRecorder()
+	tr.stockHandler(w, httptest.NewRequest(http.MethodGet, "/stock?plant=1000", nil))
+	var stock []Stock
+	json.NewDecoder(w.Body).Decode(&stock)
+	if len(stock) != 1 || stock[0].Reserved != 4 || stock[0].Available != 6 || stock[0].Description != "Valve gasket set" {
+		t.Errorf("stock = %+v", stock)
+	}
+
+	for _, gr := range []GoodsReceipt{
+		{Material: "GASKET", Plant: "1000", Quantity: 0},
+		{Material: "GASKET", Plant: "1000", Quantity: 1, Unit: "KG"},
+		{Plant: "1000", Quantity: 1},
+	} {
+		if w := postReceipt(tr, gr); w.Code != http.StatusBadRequest {
+			t.Errorf("receipt %+v: %d", gr, w.Code)
+		}
+	}
+	if w := postReceipt(tr, GoodsReceipt{Material: "STEM", Plant: "1000", StorageLocation: "0002", Quantity: 2}); w.Code != http.StatusCreated {
+		t.Errorf("receipt of a new material: %d %s", w.Code, w.Body)
+	}
+	if got := tr.listStock("STEM", ""); len(got) != 1 || got[0].Unit != "EA" || got[0].StorageLocation != "0002" {
+		t.Errorf("new material stock = %+v", got)
+	}
+}
+
+func TestInventorySurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	tr := stockedTraits(t, path, 20)
+	o := orderNeeding(t, tr, 2)
+	tr.updateStatus(StatusRequest{OrderID: o.ID, Status: "TECO"})
+	orderNeeding(t, tr, 5)
+
+	// Restart: the initial stock is not put again
+	tr = stockedTraits(t, path, 20)
+	if s := gaskets(tr); s.Unrestricted != 18 || s.Reserved != 5 {
+		t.Errorf("stock after restart: %+v", s)
+	}
+	tr.mu.Lock()
+	n, last := len(tr.inv.movements), tr.inv.movements[len(tr.inv.movements)-1].Document
+	tr.mu.Unlock()
+	w := postReceipt(tr, GoodsReceipt{Material: "GASKET", Plant: "1000", Quantity: 1})
+	var m GoodsMovement
+	json.NewDecoder(w.Body).Decode(&m)
+	if n != 2 || m.Document <= last {
+		t.Errorf("%d documents, last %s, next %s", n, last, m.Document)
+	}
+}
+
+func TestGoodsMovementSPARQL(t *testing.T) {
+	sparql, err := quietTraits().buildGoodsMovementSPARQL(GoodsMovement{Document: "4900000007", MovementType: "261", Material: "GASKET",
+		Plant: "1000", StorageLocation: "0001", Quantity: 2, Unit: "EA", OrderID: "400000018", Reservation: "1000000003",
+		PostedAt: time.Date(2026, 5, 30, 14, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<https://sinetiq.se/sap/MaterialDocument/4900000007> a ex:GoodsMovement",
+		`ex:movementType "261"`,
+		`ex:quantity "2"^^xsd:decimal`,
+		"ex:forOrder <https://sinetiq.se/sap/MaintenanceOrder/400000018>",
+		`"2026-05-30T14:00:00Z"^^xsd:dateTime`,
+	} {
+		if !strings.Contains(sparql, want) {
+			t.Errorf("missing %s in\n%s", want, sparql)
+		}
+	}
+}
@@ -33,6 +33,7 @@ type OrderRequest struct {
 	PlannedStartTime      *time.Time       `json:"plannedStartTime,omitempty"`
 	PlannedEndTime        *time.Time       `json:"plannedEndTime,omitempty"`
 	Operations            []OrderOperation `json:"operations,omitempty"`
+	IdempotencyKey        string           `json:"idempotencyKey,omitempty"` // from the Idempotency-Key header: retries of a request get the same order
 }
 
 // OrderOperation is a single work step within an order.
@@ -65,21 +66,54 @@ type OrderResponse struct {
 	CreatedAt               time.Time `json:"createdAt"`
 }
 
-// Order is the in-memory representation of a maintenance order managed by the sapper.
+// Order is the representation of a maintenance order managed by the sapper,
+// kept in memory and persisted to the order store at every change.
 // An order is created in CRTD status by the nurse and stays there until a planner
-// enriches it via the firefighting UI; that submission flips it to REL and starts
-// the TECO countdown.
+// enriches it via the firefighting UI; that submission flips it to REL and
+// schedules its technical completion (TECO). Time confirmations move a released
+// order to PCNF or CNF; TECO orders are closed (CLSD) through the status service.
 type Order struct {
 	ID                  string
 	Notification        string
-	Status              string // CRTD → REL → TECO
+	Status              string   // system status: CRTD, REL, PCNF, CNF, TECO or CLSD
+	UserStatuses        []string // user statuses of the status profile, set by planners
 	CreatedAt           time.Time
 	ReleasedAt          time.Time // zero until enrichAndRelease is called
+	CompletedAt         time.Time // zero until TECO
+	ClosedAt            time.Time // zero until CLSD
 	Request             OrderRequest
 	CurrentPartIRI      string          // IRI of the part currently fitted at the FL, resolved from GraphDB at order-create time; empty if unresolved
 	SuggestedEnrichment string          // template the firefighting UI prefills when this order is selected
 	Enrichment          json.RawMessage // planner's actual submission; nil until released
 	ParsedEnrichment    *Enrichment     // structured view of Enrichment; nil if parse failed or not yet released
+	Confirmations       []Confirmation
+	History             []StatusChange        // every system and user status change, oldest first
+	Scheduled           []ScheduledTransition // transitions still to come, re-armed at restart
+}
+
+// StatusChange records a change of an order's system or user status.
+type StatusChange struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+	Note   string    `json:"note,omitempty"`
+}
+
+// ScheduledTransition is a system status an order moves to at a given time.
+type ScheduledTransition struct {
+	Status string    `json:"status"`
+	Due    time.Time `json:"due"`
+}
+
+// Confirmation is a time confirmation of the work done on an operation. A
+// final confirmation states that no more work is expected on the operation.
+type Confirmation struct {
+	Counter     int       `json:"counter"`
+	OperationID string    `json:"operationId"`
+	ActualWork  float64   `json:"actualWork"`
+	WorkUnit    string    `json:"workUnit"`
+	Final       bool      `json:"final"`
+	Text        string    `json:"text,omitempty"`
+	PostedAt    time.Time `json:"postedAt"`
 }
 
 // Enrichment is the structured form of the planner's firefighting submission.
@@ -103,3 +137,99 @@ type CompletionEvent struct {
 	ActualWorkHours float64    `json:"actualWorkHours,omitempty"`
 	Notes           string     `json:"notes,omitempty"`
 }
+
+// StatusRequest changes the statuses of an order: a system status, now or at
+// a given time, and user statuses to set or clear.
+type StatusRequest struct {
+	OrderID          string     `json:"orderId"`
+	Status           string     `json:"status,omitempty"`
+	At               *time.Time `json:"at,omitempty"` // schedule the transition; now if absent or past
+	Note             string     `json:"note,omitempty"`
+	AddUserStatus    []string   `json:"addUserStatus,omitempty"`
+	RemoveUserStatus []string   `json:"removeUserStatus,omitempty"`
+}
+
+// ConfirmationRequest posts a time confirmation on an operation of an order.
+type ConfirmationRequest struct {
+	OrderID     string  `json:"orderId"`
+	OperationID string  `json:"operationId"`
+	ActualWork  float64 `json:"actualWork"`
+	WorkUnit    string  `json:"workUnit,omitempty"` // H if empty
+	Final       bool    `json:"final"`
+	Text        string  `json:"text,omitempty"`
+}
+
+// OrderSummary is an order as listed by the order list service.
+type OrderSummary struct {
+	MaintenanceOrder        string                `json:"maintenanceOrder"`
+	MaintenanceNotification string                `json:"maintenanceNotification"`
+	Status                  string                `json:"status"`
+	UserStatus              []string              `json:"userStatus,omitempty"`
+	Plant                   string                `json:"plant"`
+	EquipmentID             string                `json:"equipmentId"`
+	FunctionalLocation      string                `json:"functionalLocation,omitempty"`
+	Description             string                `json:"description"`
+	Priority                string                `json:"priority,omitempty"`
+	OrderType               string                `json:"maintenanceOrderType,omitempty"`
+	CreatedAt               time.Time             `json:"createdAt"`
+	ReleasedAt              *time.Time            `json:"releasedAt,omitempty"`
+	CompletedAt             *time.Time            `json:"completedAt,omitempty"`
+	ActualWork              float64               `json:"actualWork,omitempty"` // hours confirmed
+	Scheduled               []ScheduledTransition `json:"scheduled,omitempty"`
+}
+
+// Stock is the unrestricted-use stock of a material at a storage location,
+// with the part of it reserved by released orders.
+type Stock struct {
+	Material        string  `json:"material"`
+	Description     string  `json:"description,omitempty"`
+	Plant           string  `json:"plant"`
+	StorageLocation string  `json:"storageLocation"`
+	Unit            string  `json:"unit"`
+	Unrestricted    float64 `json:"unrestricted"`
+	Reserved        float64 `json:"reserved"`  // open reservations, computed when listed
+	Available       float64 `json:"available"` // unrestricted less reserved, computed when listed
+}
+
+// Reservation is an item of the material reservation made for an order at
+// its release, withdrawn by the goods issue at TECO.
+type Reservation struct {
+	Reservation     string     `json:"reservation"` // one number per order
+	Item            int        `json:"item"`
+	OrderID         string     `json:"orderId"`
+	OperationID     string     `json:"operationId,omitempty"`
+	Material        string     `json:"material"`
+	Plant           string     `json:"plant"`
+	StorageLocation string     `json:"storageLocation"`
+	Quantity        float64    `json:"quantity"`
+	Unit            string     `json:"unit"`
+	Status          string     `json:"status"` // open or issued
+	CreatedAt       time.Time  `json:"createdAt"`
+	IssuedAt        *time.Time `json:"issuedAt,omitempty"`
+}
+
+// GoodsMovement is a posted material document: a goods receipt, or a goods
+// issue to an order.
+type GoodsMovement struct {
+	Document        string    `json:"materialDocument"`
+	MovementType    string    `json:"movementType"` // 561 initial entry, 101 receipt, 261 issue to order
+	Material        string    `json:"material"`
+	Plant           string    `json:"plant"`
+	StorageLocation string    `json:"storageLocation"`
+	Quantity        float64   `json:"quantity"`
+	Unit            string    `json:"unit"`
+	OrderID         string    `json:"orderId,omitempty"`
+	Reservation     string    `json:"reservation,omitempty"`
+	PostedAt        time.Time `json:"postedAt"`
+}
+
+// GoodsReceipt puts a quantity of a material into stock; the traits' initial
+// stock is given the same way.
+type GoodsReceipt struct {
+	Material        string  `json:"material"`
+	Description     string  `json:"description,omitempty"`
+	Plant           string  `json:"plant"`
+	StorageLocation string  `json:"storageLocation"`
+	Quantity        float64 `json:"quantity"`
+	Unit            string  `json:"unit,omitempty"` // the stock's unit if empty, EA for a new material
+}
@@ -0,0 +1,550 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The two S/4HANA OData (V2) services the adapter forwards to.
+const (
+	notificationService = "/sap/opu/odata/sap/API_MAINTNOTIFICATION"
+	orderService        = "/sap/opu/odata/sap/API_MAINTENANCEORDER"
+)
+
+const (
+	backendSimulation = "simulation"
+	backendSAP        = "sap"
+)
+
+// defaultPollInterval is how often, in seconds, the statuses of open orders
+// are read back from SAP.
+const defaultPollInterval = 60
+
+// SAPConfig locates and authenticates the SAP system of the adapter backend.
+// Basic authentication is used when a username is set, OAuth 2.0 client
+// credentials when a token URL is set.
+type SAPConfig struct {
+	URL          string `json:"url"`                    // scheme and host of the SAP gateway, e.g. https://s4.example.com
+	Client       string `json:"sapClient,omitempty"`    // sap-client, if not the system's default
+	Username     string `json:"username,omitempty"`     // basic authentication
+	Password     string `json:"password,omitempty"`     //
+	TokenURL     string `json:"tokenUrl,omitempty"`     // OAuth 2.0 client credentials
+	ClientID     string `json:"clientId,omitempty"`     //
+	ClientSecret string `json:"clientSecret,omitempty"` //
+	NotifType    string `json:"notificationType,omitempty"`
+	PollInterval int    `json:"pollInterval,omitempty"` // seconds; 60 if zero
+}
+
+// sapClient talks to the SAP gateway. It keeps the session cookies and the
+// CSRF token of each service, which SAP requires on every modifying request,
+// and the OAuth access token while it is valid.
+type sapClient struct {
+	cfg    SAPConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	csrf        map[string]string // service path → X-CSRF-Token
+	accessToken string
+	tokenExpiry time.Time
+}
+
+// newSAPClient checks the configuration and prepares a client for it.
+func newSAPClient(cfg SAPConfig) (*sapClient, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("the SAP backend needs a url")
+	}
+	if cfg.Username == "" && cfg.TokenURL == "" {
+		return nil, errors.New("the SAP backend needs a username or a tokenUrl")
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sapClient{
+		cfg:    cfg,
+		client: &http.Client{Jar: jar, Timeout: 30 * time.Second},
+		csrf:   make(map[string]string),
+	}, nil
+}
+
+// pollInterval returns the configured status polling period.
+func (c *sapClient) pollInterval() time.Duration {
+	if c.cfg.PollInterval <= 0 {
+		return defaultPollInterval * time.Second
+	}
+	return time.Duration(c.cfg.PollInterval) * time.Second
+}
+
+// authorize adds the credentials to a request, fetching an OAuth access
+// token first if there is none or it is about to expire.
+func (c *sapClient) authorize(ctx context.Context, req *http.Request) error {
+	if c.cfg.TokenURL == "" {
+		req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+		return nil
+	}
+	c.mu.Lock()
+	token, valid := c.accessToken, time.Now().Before(c.tokenExpiry)
+	c.mu.Unlock()
+	if !valid {
+		var err error
+		if token, err = c.fetchAccessToken(ctx); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// fetchAccessToken obtains an access token with the client credentials grant.
+func (c *sapClient) fetchAccessToken(ctx context.Context) (string, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.cfg.ClientID, c.cfg.ClientSecret)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("fetching access token: HTTP %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || tok.AccessToken == "" {
+		return "", fmt.Errorf("fetching access token: no token in the response (%v)", err)
+	}
+	c.mu.Lock()
+	c.accessToken = tok.AccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - 30*time.Second)
+	c.mu.Unlock()
+	return tok.AccessToken, nil
+}
+
+// endpoint builds the URL of a resource of an OData service.
+func (c *sapClient) endpoint(service, resource string, query url.Values) string {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.cfg.Client != "" {
+		query.Set("sap-client", c.cfg.Client)
+	}
+	u := strings.TrimRight(c.cfg.URL, "/") + service + "/" + resource
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// fetchCSRF asks the service for a CSRF token; the session cookie it comes
+// with lands in the client's jar.
+func (c *sapClient) fetchCSRF(ctx context.Context, service string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint(service, "", nil), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-CSRF-Token", "Fetch")
+	if err := c.authorize(ctx, req); err != nil {
+		return "", err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching CSRF token: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	token := resp.Header.Get("X-CSRF-Token")
+	if resp.StatusCode != http.StatusOK || token == "" {
+		return "", fmt.Errorf("fetching CSRF token: HTTP %s", resp.Status)
+	}
+	c.mu.Lock()
+	c.csrf[service] = token
+	c.mu.Unlock()
+	return token, nil
+}
+
+// do sends an OData request and decodes the "d" object of the response into
+// out. A modifying request carries the service's CSRF token; if SAP rejects
+// the token (it expires with the session), a fresh one is fetched and the
+// request is sent once more.
+func (c *sapClient) do(method, service, resource string, query url.Values, body, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.endpoint(service, resource, query), bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if err := c.authorize(ctx, req); err != nil {
+			return err
+		}
+		if method != http.MethodGet {
+			c.mu.Lock()
+			token := c.csrf[service]
+			c.mu.Unlock()
+			if token == "" {
+				if token, err = c.fetchCSRF(ctx, service); err != nil {
+					return err
+				}
+			}
+			req.Header.Set("X-CSRF-Token", token)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", method, resource, err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusForbidden && strings.EqualFold(resp.Header.Get("X-CSRF-Token"), "Required") && attempt == 0 {
+			c.mu.Lock()
+			delete(c.csrf, service)
+			c.mu.Unlock()
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("%s %s: HTTP %s: %s", method, resource, resp.Status, odataError(data))
+		}
+		if out == nil {
+			return nil
+		}
+		var envelope struct {
+			D json.RawMessage `json:"d"`
+		}
+		if err := json.Unmarshal(data, &envelope); err != nil || envelope.D == nil {
+			return fmt.Errorf("%s %s: not an OData response: %s", method, resource, strings.TrimSpace(string(data)))
+		}
+		return json.Unmarshal(envelope.D, out)
+	}
+}
+
+// odataError extracts the message of an OData error response, or returns
+// the body as is.
+func odataError(data []byte) string {
+	var e struct {
+		Error struct {
+			Message struct {
+				Value string `json:"value"`
+			} `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &e) == nil && e.Error.Message.Value != "" {
+		return e.Error.Message.Value
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// odataTime formats a time as an OData V2 Edm.DateTimeOffset literal.
+func odataTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return fmt.Sprintf("/Date(%d)/", t.UnixMilli())
+}
+
+//-------------------------------------Mapping
+
+// sapNotification is the part of an API_MAINTNOTIFICATION MaintenanceNotification the adapter writes.
+type sapNotification struct {
+	MaintenanceNotification   string `json:"MaintenanceNotification,omitempty"`
+	NotificationText          string `json:"NotificationText"`
+	NotificationType          string `json:"NotificationType"`
+	MaintPriority             string `json:"MaintPriority,omitempty"`
+	TechnicalObject           string `json:"TechnicalObject"`
+	TechObjIsEquipOrFuncnlLoc string `json:"TechObjIsEquipOrFuncnlLoc"`
+	MaintenancePlanningPlant  string `json:"MaintenancePlanningPlant"`
+}
+
+// sapOrder is the part of an API_MAINTENANCEORDER MaintenanceOrder the adapter writes and reads.
+type sapOrder struct {
+	MaintenanceOrder            string `json:"MaintenanceOrder,omitempty"`
+	MaintenanceOrderType        string `json:"MaintenanceOrderType,omitempty"`
+	MaintenanceOrderDesc        string `json:"MaintenanceOrderDesc,omitempty"`
+	MaintenanceNotification     string `json:"MaintenanceNotification,omitempty"`
+	Equipment                   string `json:"Equipment,omitempty"`
+	FunctionalLocation          string `json:"FunctionalLocation,omitempty"`
+	MaintenancePlanningPlant    string `json:"MaintenancePlanningPlant,omitempty"`
+	MaintPriority               string `json:"MaintPriority,omitempty"`
+	MainWorkCenter              string `json:"MainWorkCenter,omitempty"`
+	MaintOrdBasicStartDateTime  string `json:"MaintOrdBasicStartDateTime,omitempty"`
+	MaintOrdBasicEndDateTime    string `json:"MaintOrdBasicEndDateTime,omitempty"`
+	MaintOrdProcessPhaseCode    string `json:"MaintOrdProcessPhaseCode,omitempty"`
+	MaintOrdProcessSubPhaseCode string `json:"MaintOrdProcessSubPhaseCode,omitempty"`
+	Operations                  *struct {
+		Results []sapOperation `json:"results"`
+	} `json:"to_MaintenanceOrderOperation,omitempty"`
+}
+
+// sapOperation is an operation of a MaintenanceOrder, created with it.
+type sapOperation struct {
+	MaintenanceOrderOperation    string  `json:"MaintenanceOrderOperation"`
+	OperationDescription         string  `json:"OperationDescription"`
+	WorkCenter                   string  `json:"WorkCenter,omitempty"`
+	OperationPlannedWorkQuantity float64 `json:"OperationPlannedWorkQuantity,omitempty"`
+	OperationPlannedWorkUnit     string  `json:"OperationPlannedWorkUnit,omitempty"`
+}
+
+// notificationFor maps an order request to a malfunction notification on
+// its equipment.
+func (c *sapClient) notificationFor(req OrderRequest) sapNotification {
+	kind := c.cfg.NotifType
+	if kind == "" {
+		kind = "M2"
+	}
+	return sapNotification{
+		NotificationText:          req.Description,
+		NotificationType:          kind,
+		MaintPriority:             req.Priority,
+		TechnicalObject:           req.EquipmentID,
+		TechObjIsEquipOrFuncnlLoc: "EAMS_EQUI",
+		MaintenancePlanningPlant:  req.Plant,
+	}
+}
+
+// orderFor maps an order request to a maintenance order referring to the
+// notification, with its operations numbered 0010, 0020, … where the
+// request leaves them unnumbered.
+func orderFor(req OrderRequest, notification string) sapOrder {
+	kind := req.MaintenanceOrderType
+	if kind == "" {
+		kind = "PM01"
+	}
+	o := sapOrder{
+		MaintenanceOrderType:       kind,
+		MaintenanceOrderDesc:       req.Description,
+		MaintenanceNotification:    notification,
+		Equipment:                  req.EquipmentID,
+		FunctionalLocation:         req.FunctionalLocation,
+		MaintenancePlanningPlant:   req.Plant,
+		MaintPriority:              req.Priority,
+		MaintOrdBasicStartDateTime: odataTime(req.PlannedStartTime),
+		MaintOrdBasicEndDateTime:   odataTime(req.PlannedEndTime),
+	}
+	if len(req.Operations) == 0 {
+		return o
+	}
+	o.Operations = &struct {
+		Results []sapOperation `json:"results"`
+	}{}
+	for i, op := range req.Operations {
+		id := op.OperationID
+		if id == "" {
+			id = fmt.Sprintf("%04d", (i+1)*10)
+		}
+		if o.MainWorkCenter == "" {
+			o.MainWorkCenter = op.WorkCenter
+		}
+		o.Operations.Results = append(o.Operations.Results, sapOperation{
+			MaintenanceOrderOperation:    id,
+			OperationDescription:         op.Text,
+			WorkCenter:                   op.WorkCenter,
+			OperationPlannedWorkQuantity: op.Duration,
+			OperationPlannedWorkUnit:     op.DurationUnit,
+		})
+	}
+	return o
+}
+
+// phaseStatus maps the S/4HANA maintenance processing phase of an order to
+// the system status the sapper tracks: initiation to preparation (01–04) is
+// CRTD, scheduling and execution (05–06) REL, post-execution (07) TECO and
+// completion (08) CLSD. An unknown phase yields "".
+func phaseStatus(phase string) string {
+	switch phase {
+	case "01", "02", "03", "04":
+		return statusCreated
+	case "05", "06":
+		return statusReleased
+	case "07":
+		return statusTechDone
+	case "08":
+		return statusClosed
+	}
+	return ""
+}
+
+// createInSAP raises the notification and then the order in SAP and returns
+// their numbers.
+func (c *sapClient) createInSAP(req OrderRequest) (orderID, notification string, err error) {
+	var n sapNotification
+	if err := c.do(http.MethodPost, notificationService, "MaintenanceNotification", nil, c.notificationFor(req), &n); err != nil {
+		return "", "", fmt.Errorf("creating notification: %w", err)
+	}
+	var o sapOrder
+	if err := c.do(http.MethodPost, orderService, "MaintenanceOrder", nil, orderFor(req, n.MaintenanceNotification), &o); err != nil {
+		return "", "", fmt.Errorf("creating order for notification %s: %w", n.MaintenanceNotification, err)
+	}
+	if o.MaintenanceOrder == "" {
+		return "", "", errors.New("SAP returned no order number")
+	}
+	return o.MaintenanceOrder, n.MaintenanceNotification, nil
+}
+
+// statusInSAP reads the processing phase of an order and returns its status.
+func (c *sapClient) statusInSAP(orderID string) (string, error) {
+	var o sapOrder
+	query := url.Values{"$select": {"MaintenanceOrder,MaintOrdProcessPhaseCode,MaintOrdProcessSubPhaseCode"}}
+	if err := c.do(http.MethodGet, orderService, fmt.Sprintf("MaintenanceOrder('%s')", url.PathEscape(orderID)), query, nil, &o); err != nil {
+		return "", err
+	}
+	status := phaseStatus(o.MaintOrdProcessPhaseCode)
+	if status == "" {
+		return "", fmt.Errorf("order %s is in unknown phase %q", orderID, o.MaintOrdProcessPhaseCode)
+	}
+	return status, nil
+}
+
+//-------------------------------------Adapter backend
+
+// forwardOrder creates the order in SAP and records it locally under SAP's
+// numbers, in CRTD, so the planner and the status services see it as usual.
+func (t *Traits) forwardOrder(req OrderRequest) (*Order, error) {
+	orderID, notification, err := t.sap.createInSAP(req)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("sapper: order %s (notification %s) created in SAP\n", orderID, notification)
+	return t.recordOrder(req, orderID, notification), nil
+}
+
+// refreshFromSAP reads an order's status back from SAP and brings the local
+// order up to it.
+func (t *Traits) refreshFromSAP(orderID string) error {
+	status, err := t.sap.statusInSAP(orderID)
+	if err != nil {
+		return err
+	}
+	t.syncStatus(orderID, status)
+	return nil
+}
+
+// syncStatus moves a local order forward to the status SAP reports, through
+// the statuses in between, so that the release and completion effects run
+// as in simulation. SAP never moves an order back here: a local status ahead
+// of SAP's (a release by the planner, say) stands until SAP catches up.
+func (t *Traits) syncStatus(orderID, status string) {
+	t.mu.Lock()
+	o, ok := t.orders[orderID]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	var all []func()
+	for _, step := range statusPath(o.Status, status) {
+		effects, err := t.changeStatus(o, step, "(SAP)", time.Now())
+		if err != nil {
+			log.Printf("sapper: syncing order %s: %v\n", orderID, err)
+			break
+		}
+		all = append(all, effects)
+	}
+	if len(all) > 0 {
+		t.saveOrder(o)
+	}
+	t.mu.Unlock()
+	for _, effects := range all {
+		effects()
+	}
+}
+
+// statusPath returns the shortest chain of forward moves from one system
+// status to another, excluding from, or nil if to is not ahead of from.
+func statusPath(from, to string) []string {
+	rank := func(s string) int {
+		return slices.Index([]string{statusCreated, statusReleased, statusPartConf, statusConfirmed, statusTechDone, statusClosed}, s)
+	}
+	if rank(to) <= rank(from) {
+		return nil
+	}
+	prev := map[string]string{from: ""}
+	for queue := []string{from}; len(queue) > 0; queue = queue[1:] {
+		s := queue[0]
+		if s == to {
+			var path []string
+			for ; s != from; s = prev[s] {
+				path = append([]string{s}, path...)
+			}
+			return path
+		}
+		for _, next := range nextStatuses[s] {
+			if _, seen := prev[next]; !seen && rank(next) > rank(s) {
+				prev[next] = s
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// pollSAP reads the statuses of the open orders back from SAP until ctx is
+// done.
+func (t *Traits) pollSAP(ctx context.Context) {
+	ticker := time.NewTicker(t.sap.pollInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		t.mu.Lock()
+		var open []string
+		for id, o := range t.orders {
+			if o.Status != statusClosed {
+				open = append(open, id)
+			}
+		}
+		t.mu.Unlock()
+		for _, id := range open {
+			if err := t.refreshFromSAP(id); err != nil {
+				log.Printf("sapper: polling order %s: %v\n", id, err)
+			}
+		}
+	}
+}
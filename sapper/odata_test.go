@@ -0,0 +1,304 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeOData plays the SAP gateway: it hands out CSRF tokens bound to a
+// session cookie, checks the credentials, and keeps the orders created
+// through it with their processing phase.
+type fakeOData struct {
+	mu            sync.Mutex
+	bearer        string            // expected access token; basic sapper/secret if empty
+	csrf          map[string]string // service → token
+	session       string
+	fetches       int
+	tokens        int
+	notifications int
+	orders        map[string]*sapOrder
+	failOrders    bool
+}
+
+func (f *fakeOData) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/oauth/token" {
+		if id, secret, _ := r.BasicAuth(); id != "sapper" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		f.tokens++
+		fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","expires_in":3600}`, f.bearer)
+		return
+	}
+	if f.bearer != "" {
+		if r.Header.Get("Authorization") != "Bearer "+f.bearer {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	} else if user, pass, _ := r.BasicAuth(); user != "sapper" || pass != "secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Query().Get("sap-client") != "100" {
+		http.Error(w, "wrong client", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet && r.Header.Get("X-CSRF-Token") == "Fetch" {
+		f.fetches++
+		if cookie, err := r.Cookie("SAP_SESSIONID_S4H_100"); err != nil || cookie.Value != f.session {
+			f.session = fmt.Sprintf("session-%d", f.fetches)
+			http.SetCookie(w, &http.Cookie{Name: "SAP_SESSIONID_S4H_100", Value: f.session, Path: "/"})
+		}
+		token := fmt.Sprintf("token-%d", f.fetches)
+		f.csrf[strings.TrimSuffix(r.URL.Path, "/")] = token
+		w.Header().Set("X-CSRF-Token", token)
+		w.Write([]byte(`{"d":{"EntitySets":["MaintenanceOrder"]}}`))
+		return
+	}
+	if r.Method != http.MethodGet {
+		cookie, err := r.Cookie("SAP_SESSIONID_S4H_100")
+		service := r.URL.Path[:strings.LastIndex(r.URL.Path, "/")]
+		if token := f.csrf[service]; token == "" || r.Header.Get("X-CSRF-Token") != token || err != nil || cookie.Value != f.session {
+			w.Header().Set("X-CSRF-Token", "Required")
+			http.Error(w, "CSRF token validation failed", http.StatusForbidden)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == notificationService+"/MaintenanceNotification":
+		var n sapNotification
+		json.NewDecoder(r.Body).Decode(&n)
+		if n.TechnicalObject == "" {
+			http.Error(w, `{"error":{"message":{"value":"Technical object missing"}}}`, http.StatusBadRequest)
+			return
+		}
+		f.notifications++
+		n.MaintenanceNotification = fmt.Sprintf("1000%04d", f.notifications)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"d": n})
+	case r.Method == http.MethodPost && r.URL.Path == orderService+"/MaintenanceOrder":
+		if f.failOrders {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":"IWO_BAPI/121","message":{"lang":"en","value":"Equipment 999 does not exist"}}}`))
+			return
+		}
+		var o sapOrder
+		json.NewDecoder(r.Body).Decode(&o)
+		o.MaintenanceOrder = fmt.Sprintf("4%07d", len(f.orders)+1)
+		o.MaintOrdProcessPhaseCode = "03"
+		f.orders[o.MaintenanceOrder] = &o
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"d": o})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, orderService+"/MaintenanceOrder('"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, orderService+"/MaintenanceOrder('"), "')")
+		o, ok := f.orders[id]
+		if !ok {
+			http.Error(w, `{"error":{"message":{"value":"Resource not found"}}}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"d":{"MaintenanceOrder":%q,"MaintOrdProcessPhaseCode":%q}}`, id, o.MaintOrdProcessPhaseCode)
+	default:
+		http.Error(w, "no such resource", http.StatusNotFound)
+	}
+}
+
+func (f *fakeOData) setPhase(id, phase string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.orders[id].MaintOrdProcessPhaseCode = phase
+}
+
+// sapTraits returns quiet test traits forwarding to a fake SAP gateway.
+func sapTraits(t *testing.T, f *fakeOData, cfg SAPConfig) *Traits {
+	t.Helper()
+	f.orders = make(map[string]*sapOrder)
+	f.csrf = make(map[string]string)
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	cfg.URL = srv.URL
+	cfg.Client = "100"
+	if cfg.TokenURL != "" {
+		cfg.TokenURL = srv.URL + cfg.TokenURL
+	}
+	client, err := newSAPClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := quietTraits()
+	tr.sap = client
+	return tr
+}
+
+func postOrder(t *testing.T, tr *Traits) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(OrderRequest{EquipmentID: "10000045", Plant: "1000", Description: "Pressure exceeded threshold",
+		Operations: []OrderOperation{{Text: "inspect", WorkCenter: "MAINT-WC01", Duration: 4, DurationUnit: "H"}, {Text: "repair"}}})
+	w := httptest.NewRecorder()
+	tr.createOrderHandler(w, httptest.NewRequest(http.MethodPost, "/maintenanceorders", bytes.NewReader(body)))
+	return w
+}
+
+func queryStatus(t *testing.T, tr *Traits, id string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	tr.queryOrderHandler(w, httptest.NewRequest(http.MethodGet, "/maintenanceorders?id="+id, nil))
+	var out map[string]string
+	json.NewDecoder(w.Body).Decode(&out)
+	return out["status"]
+}
+
+// ── tests ─────────────────────────────────────────────────────────────────────
+
+func TestForwardOrder(t *testing.T) {
+	f := &fakeOData{}
+	tr := sapTraits(t, f, SAPConfig{Username: "sapper", Password: "secret"})
+
+	w := postOrder(t, tr)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp OrderResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.MaintenanceOrder != "40000001" || resp.MaintenanceNotification != "10000001" || resp.Status != "CRTD" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	f.mu.Lock()
+	sent := f.orders["40000001"]
+	f.mu.Unlock()
+	if sent.MaintenanceNotification != "10000001" || sent.Equipment == "" || sent.MaintenanceOrderType != "PM01" ||
+		sent.Operations == nil || sent.Operations.Results[1].MaintenanceOrderOperation != "0020" || sent.MainWorkCenter != "MAINT-WC01" {
+		t.Errorf("order sent to SAP = %+v", sent)
+	}
+	tr.mu.Lock()
+	local, ok := tr.orders["40000001"]
+	tr.mu.Unlock()
+	if !ok || local.Notification != "10000001" {
+		t.Fatalf("local order = %+v", local)
+	}
+
+	// One CSRF token per service, reused for the next order
+	postOrder(t, tr)
+	if f.fetches != 2 {
+		t.Errorf("%d CSRF fetches, want 2", f.fetches)
+	}
+}
+
+func TestExpiredCSRFTokenIsRefetched(t *testing.T) {
+	f := &fakeOData{}
+	tr := sapTraits(t, f, SAPConfig{Username: "sapper", Password: "secret"})
+	postOrder(t, tr)
+
+	f.mu.Lock()
+	f.csrf, f.session = map[string]string{}, "" // the session timed out
+	f.mu.Unlock()
+	if w := postOrder(t, tr); w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if f.notifications != 2 || len(f.orders) != 2 {
+		t.Errorf("%d notifications and %d orders in SAP, want 2 each", f.notifications, len(f.orders))
+	}
+}
+
+func TestOAuthClientCredentials(t *testing.T) {
+	f := &fakeOData{bearer: "abc123"}
+	tr := sapTraits(t, f, SAPConfig{TokenURL: "/oauth/token", ClientID: "sapper", ClientSecret: "s3cret"})
+
+	for range 2 {
+		if w := postOrder(t, tr); w.Code != http.StatusCreated {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	}
+	if f.tokens != 1 {
+		t.Errorf("%d access tokens fetched, want 1", f.tokens)
+	}
+}
+
+func TestSAPErrorIsBadGateway(t *testing.T) {
+	f := &fakeOData{failOrders: true}
+	tr := sapTraits(t, f, SAPConfig{Username: "sapper", Password: "secret"})
+
+	w := postOrder(t, tr)
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "Equipment 999 does not exist") {
+		t.Errorf("status = %d: %s", w.Code, w.Body)
+	}
+	if len(tr.orders) != 0 {
+		t.Errorf("a failed order was recorded locally: %v", tr.orders)
+	}
+
+	if _, err := newSAPClient(SAPConfig{URL: "https://s4.example.com"}); err == nil {
+		t.Error("expected an error without credentials")
+	}
+}
+
+func TestStatusFromSAP(t *testing.T) {
+	f := &fakeOData{}
+	tr := sapTraits(t, f, SAPConfig{Username: "sapper", Password: "secret"})
+	postOrder(t, tr)
+	id := "40000001"
+
+	for _, c := range []struct{ phase, want string }{
+		{"03", "CRTD"},
+		{"06", "REL"},
+		{"05", "REL"}, // SAP does not move the order back
+		{"08", "CLSD"},
+	} {
+		f.setPhase(id, c.phase)
+		if got := queryStatus(t, tr, id); got != c.want {
+			t.Errorf("phase %s: status %s, want %s", c.phase, got, c.want)
+		}
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	o := tr.orders[id]
+	var trail []string
+	for _, h := range o.History {
+		trail = append(trail, h.Status)
+	}
+	if strings.Join(trail, " ") != "CRTD REL TECO CLSD" || len(o.Scheduled) != 0 || o.CompletedAt.IsZero() {
+		t.Errorf("history %v, scheduled %v", trail, o.Scheduled)
+	}
+}
+
+func TestStatusPath(t *testing.T) {
+	for _, c := range []struct{ from, to, want string }{
+		{"CRTD", "REL", "REL"},
+		{"CRTD", "CLSD", "REL TECO CLSD"},
+		{"REL", "TECO", "TECO"},
+		{"PCNF", "CNF", "CNF"},
+		{"TECO", "REL", ""},
+		{"CLSD", "CLSD", ""},
+	} {
+		if got := strings.Join(statusPath(c.from, c.to), " "); got != c.want {
+			t.Errorf("%s → %s: %q, want %q", c.from, c.to, got, c.want)
+		}
+	}
+}
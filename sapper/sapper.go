@@ -106,6 +106,18 @@ func serving(t *Traits, w http.ResponseWriter, r *http.Request, servicePath stri
 		}
 	case "firefighting":
 		t.firefightingHandler(w, r)
+	case "orderlist":
+		t.listOrdersHandler(w, r)
+	case "orderstatus":
+		t.orderStatusHandler(w, r)
+	case "confirmations":
+		t.confirmationsHandler(w, r)
+	case "workbench":
+		t.workbenchHandler(w, r)
+	case "stock":
+		t.stockHandler(w, r)
+	case "reservations":
+		t.reservationsHandler(w, r)
 	default:
 		http.Error(w, "Invalid service path [do not modify subpath in configuration file]", http.StatusBadRequest)
 	}
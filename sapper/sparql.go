@@ -0,0 +1,286 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// The SPARQL updates the Sapper writes to GraphDB are text/template files,
+// one per update. A template starts with a comment block declaring its
+// parameters, one per line:
+//
+//	#@param workOrder   iri
+//	#@param note        string optional
+//
+// and refers to them as {{.workOrder}}. Parameters are typed: the value
+// passed in is checked and rendered as a complete SPARQL term (an IRI in
+// angle brackets, a quoted and escaped literal, a typed literal), so a
+// template never quotes or escapes anything itself. An optional parameter
+// that is not given renders as the empty string, for {{if}} blocks.
+//
+//go:embed sparql/*.rq
+var embeddedSPARQL embed.FS
+
+// sparqlParams are the parameters of a SPARQL template, by name.
+type sparqlParams map[string]any
+
+// sparqlParam is a parameter declared by a template.
+type sparqlParam struct {
+	name     string
+	kind     string // iri, string, dateTime or decimal
+	optional bool
+}
+
+// sparqlTemplate is a parsed SPARQL template.
+type sparqlTemplate struct {
+	name   string
+	params []sparqlParam
+	text   *template.Template
+}
+
+// sparqlTemplates finds the templates by name and order type. Templates in
+// dir, if set, replace the built-in ones of the same file name.
+type sparqlTemplates struct {
+	dir    string
+	mu     sync.Mutex
+	parsed map[string]*sparqlTemplate // by file name
+}
+
+// defaultSPARQL serves the built-in templates.
+var defaultSPARQL = newSPARQLTemplates("")
+
+// newSPARQLTemplates returns the templates, overridden by those in dir.
+func newSPARQLTemplates(dir string) *sparqlTemplates {
+	return &sparqlTemplates{dir: dir, parsed: make(map[string]*sparqlTemplate)}
+}
+
+// lookup returns the template name for an order type: name.<orderType>.rq
+// if there is one, name.rq otherwise.
+func (q *sparqlTemplates) lookup(name, orderType string) (*sparqlTemplate, error) {
+	files := []string{name + ".rq"}
+	if orderType != "" {
+		files = []string{name + "." + orderType + ".rq", name + ".rq"}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, file := range files {
+		if tp, ok := q.parsed[file]; ok {
+			return tp, nil
+		}
+		text, err := q.read(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading SPARQL template %s: %w", file, err)
+		}
+		tp, err := parseSPARQLTemplate(file, string(text))
+		if err != nil {
+			return nil, err
+		}
+		q.parsed[file] = tp
+		return tp, nil
+	}
+	return nil, fmt.Errorf("no SPARQL template %s", files[len(files)-1])
+}
+
+// read returns the text of a template file, from dir or built in.
+func (q *sparqlTemplates) read(file string) ([]byte, error) {
+	if q.dir != "" {
+		text, err := os.ReadFile(filepath.Join(q.dir, file))
+		if !errors.Is(err, fs.ErrNotExist) {
+			return text, err
+		}
+	}
+	return embeddedSPARQL.ReadFile("sparql/" + file)
+}
+
+// check parses every template, built in or in dir.
+func (q *sparqlTemplates) check() error {
+	files, err := fs.Glob(embeddedSPARQL, "sparql/*.rq")
+	if err != nil {
+		return err
+	}
+	if q.dir != "" {
+		local, err := filepath.Glob(filepath.Join(q.dir, "*.rq"))
+		if err != nil {
+			return err
+		}
+		files = append(files, local...)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".rq")
+		if _, err := q.lookup(name, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSPARQLTemplate reads the parameter declarations of a template and
+// parses its body, which starts at its first line that is not a comment.
+func parseSPARQLTemplate(name, text string) (*sparqlTemplate, error) {
+	tp := &sparqlTemplate{name: name}
+	lines := strings.SplitAfter(text, "\n")
+	body := len(lines)
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#") {
+			body = i
+			break
+		}
+		decl, ok := strings.CutPrefix(trimmed, "#@param")
+		if !ok {
+			continue
+		}
+		f := strings.Fields(decl)
+		if len(f) < 2 || len(f) > 3 || (len(f) == 3 && f[2] != "optional") {
+			return nil, fmt.Errorf("%s:%d: want #@param <name> <kind> [optional]", name, i+1)
+		}
+		switch f[1] {
+		case "iri", "string", "dateTime", "decimal":
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown parameter kind %q", name, i+1, f[1])
+		}
+		tp.params = append(tp.params, sparqlParam{name: f[0], kind: f[1], optional: len(f) == 3})
+	}
+	text = strings.TrimRight(strings.Join(lines[body:], ""), "\n")
+	parsed, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing SPARQL template: %w", err)
+	}
+	tp.text = parsed
+	return tp, nil
+}
+
+// render fills in the template. Every parameter given must be declared and
+// every required one given.
+func (tp *sparqlTemplate) render(params sparqlParams) (string, error) {
+	terms := make(map[string]string, len(tp.params))
+	for _, p := range tp.params {
+		v, ok := params[p.name]
+		if !ok || v == nil || (p.optional && reflect.ValueOf(v).IsZero()) {
+			if !p.optional {
+				return "", fmt.Errorf("%s: missing parameter %s", tp.name, p.name)
+			}
+			terms[p.name] = ""
+			continue
+		}
+		term, err := sparqlTerm(p.kind, v)
+		if err != nil {
+			return "", fmt.Errorf("%s: parameter %s: %w", tp.name, p.name, err)
+		}
+		terms[p.name] = term
+	}
+	for name := range params {
+		if _, declared := terms[name]; !declared {
+			return "", fmt.Errorf("%s: unknown parameter %s", tp.name, name)
+		}
+	}
+	var b strings.Builder
+	if err := tp.text.Execute(&b, terms); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// xsdDateTime matches the lexical form of an xsd:dateTime.
+var xsdDateTime = regexp.MustCompile(`^-?\d{4,}-\d\d-\d\dT\d\d:\d\d:\d\d(\.\d+)?(Z|[+-]\d\d:\d\d)?$`)
+
+// sparqlTerm renders a parameter value of the given kind as a SPARQL term.
+func sparqlTerm(kind string, v any) (string, error) {
+	switch kind {
+	case "iri":
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("want an IRI string, got %T", v)
+		}
+		if u, err := url.Parse(s); err != nil || !u.IsAbs() || strings.ContainsAny(s, "<>\"{}|^`\\ \t\r\n") {
+			return "", fmt.Errorf("%q is not an absolute IRI", s)
+		}
+		return "<" + s + ">", nil
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("want a string, got %T", v)
+		}
+		return `"` + sparqlString(s) + `"`, nil
+	case "dateTime":
+		switch d := v.(type) {
+		case time.Time:
+			return `"` + d.UTC().Format(time.RFC3339Nano) + `"^^xsd:dateTime`, nil
+		case string:
+			if !xsdDateTime.MatchString(d) {
+				return "", fmt.Errorf("%q is not an xsd:dateTime", d)
+			}
+			return `"` + d + `"^^xsd:dateTime`, nil
+		}
+		return "", fmt.Errorf("want a time, got %T", v)
+	case "decimal":
+		var f float64
+		switch n := v.(type) {
+		case float64:
+			f = n
+		case int:
+			f = float64(n)
+		default:
+			return "", fmt.Errorf("want a number, got %T", v)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("%g is not a decimal", f)
+		}
+		return `"` + strconv.FormatFloat(f, 'f', -1, 64) + `"^^xsd:decimal`, nil
+	}
+	return "", fmt.Errorf("unknown parameter kind %q", kind)
+}
+
+// sparqlString escapes s for a double-quoted SPARQL literal.
+func sparqlString(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+// renderSPARQL renders the template name, chosen for the type of order o
+// (which may be nil), with the given parameters.
+func (t *Traits) renderSPARQL(name string, o *Order, params sparqlParams) (string, error) {
+	q := t.queries
+	if q == nil {
+		q = defaultSPARQL
+	}
+	orderType := ""
+	if o != nil {
+		orderType = o.Request.MaintenanceOrderType
+	}
+	tp, err := q.lookup(name, orderType)
+	if err != nil {
+		return "", err
+	}
+	return tp.render(params)
+}
@@ -0,0 +1,237 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got with testdata/name, or rewrites the file with -update.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file:\n%s", name, got)
+	}
+}
+
+// ttlBinds matches the parameter lines of the WorkOrder_*_Dates.ttl examples.
+var ttlBinds = regexp.MustCompile(`BIND\((IRI\("[^"]+"\)|"[^"]+"\^\^xsd:dateTime) AS \?(\w+)\)`)
+
+// TestTECOTemplatesMirrorExamples renders the Repair and Replace templates
+// with the parameters of Triona's examples, and checks that they bind them
+// as the examples do.
+func TestTECOTemplatesMirrorExamples(t *testing.T) {
+	for _, c := range []struct{ example, template string }{
+		{"WorkOrder_Repair_135_Dates.ttl", "teco-repair"},
+		{"WorkOrder_Replace_134_Dates.ttl", "teco-replace"},
+	} {
+		ttl, err := os.ReadFile(c.example)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp, err := defaultSPARQL.lookup(c.template, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := sparqlParams{}
+		var binds []string
+		for _, m := range ttlBinds.FindAllStringSubmatch(string(ttl), -1) {
+			value := strings.TrimPrefix(m[1], `IRI(`)
+			value = value[1:strings.LastIndex(value, `"`)]
+			params[m[2]] = value
+			binds = append(binds, strings.NewReplacer(`IRI("`, "<", `")`, ">").Replace(m[0]))
+		}
+		for _, p := range tp.params {
+			if _, ok := params[p.name]; !ok {
+				t.Errorf("%s: %s does not bind %s", c.template, c.example, p.name)
+			}
+		}
+		sparql, err := tp.render(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, bind := range binds {
+			if !strings.Contains(sparql, bind) {
+				t.Errorf("%s: missing %s", c.template, bind)
+			}
+		}
+		golden(t, strings.TrimSuffix(c.example, ".ttl")+".rq", sparql)
+	}
+}
+
+// TestSPARQLGolden renders every update of an order's life.
+func TestSPARQLGolden(t *testing.T) {
+	tr := quietTraits()
+	tr.CompletionDelay = 1800
+	created := time.Date(2026, 5, 30, 9, 15, 0, 0, time.UTC)
+	o := &Order{ID: "400000018", Notification: "200000018", Status: statusCreated, CreatedAt: created,
+		ReleasedAt: created.Add(time.Hour),
+		Request: OrderRequest{Description: `Valve "V-50" leaking`,
+			FunctionalLocationIRI: "https://arrowheadweb.org/data/FL_827-PV2708-200"}}
+	bare := &Order{ID: "400000019", Notification: "200000019", Status: statusCreated, CreatedAt: created,
+		Request: OrderRequest{Description: "pump noise"}}
+	at := created.Add(3 * time.Hour)
+
+	for name, build := range map[string]func() (string, error){
+		"order-created.rq":      func() (string, error) { return tr.buildSPARQL(o) },
+		"order-created-bare.rq": func() (string, error) { return tr.buildSPARQL(bare) },
+		"release.rq":            func() (string, error) { return tr.buildRELInsertSPARQL(o) },
+		"status-closed.rq":      func() (string, error) { return tr.buildStatusSPARQL(o, statusClosed, at) },
+		"teco-revoke.rq":        func() (string, error) { return tr.buildStatusSPARQL(o, statusReleased, at) },
+		"teco.rq":               func() (string, error) { return tr.buildTECOFallbackSPARQL(o, at) },
+		"audit.rq":              func() (string, error) { return tr.buildAuditSPARQL(o.ID, "DEFER", "wait for the shutdown", at) },
+		"goods-issue.rq": func() (string, error) {
+			return tr.buildGoodsMovementSPARQL(GoodsMovement{Document: "4900000007", MovementType: "261", Material: "VALVE-GASKET-V50",
+				Plant: "1000", StorageLocation: "0001", Quantity: 2, Unit: "EA", OrderID: o.ID, Reservation: "1000000003", PostedAt: at})
+		},
+	} {
+		sparql, err := build()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		golden(t, name, sparql)
+	}
+}
+
+func TestSPARQLTerms(t *testing.T) {
+	for _, c := range []struct {
+		kind string
+		v    any
+		want string // empty if v is refused
+	}{
+		{"iri", "https://arrowheadweb.org/data/SK_000012", "<https://arrowheadweb.org/data/SK_000012>"},
+		{"iri", "https://x.org/a> . <https://x.org/b", ""},
+		{"iri", "SK_000012", ""},
+		{"iri", 12, ""},
+		{"string", "say \"hi\"\nback\\slash", `"say \"hi\"\nback\\slash"`},
+		{"dateTime", "2024-06-01T08:00:00", `"2024-06-01T08:00:00"^^xsd:dateTime`},
+		{"dateTime", "2024-06-01T08:00:00.5+02:00", `"2024-06-01T08:00:00.5+02:00"^^xsd:dateTime`},
+		{"dateTime", time.Date(2024, 6, 1, 10, 0, 0, 0, time.FixedZone("CEST", 7200)), `"2024-06-01T08:00:00Z"^^xsd:dateTime`},
+		{"dateTime", `2024-06-01" . <x> <y> "z`, ""},
+		{"decimal", 0.5, `"0.5"^^xsd:decimal`},
+		{"decimal", 1e21, `"1000000000000000000000"^^xsd:decimal`},
+		{"decimal", 3, `"3"^^xsd:decimal`},
+		{"decimal", "3", ""},
+	} {
+		got, err := sparqlTerm(c.kind, c.v)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%s %v: accepted as %s", c.kind, c.v, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%s %v = %s, %v; want %s", c.kind, c.v, got, err, c.want)
+		}
+	}
+}
+
+func TestSPARQLTemplateParameters(t *testing.T) {
+	tp, err := parseSPARQLTemplate("test.rq", `# A test template.
+#@param workOrder  iri
+#@param note       string optional
+INSERT DATA { {{.workOrder}} ex:note {{if .note}}{{.note}}{{else}}"none"{{end}} . }
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := tp.render(sparqlParams{"workOrder": "https://x.org/1"})
+	if want := `INSERT DATA { <https://x.org/1> ex:note "none" . }`; err != nil || got != want {
+		t.Errorf("render = %q, %v; want %q", got, err, want)
+	}
+	for _, params := range []sparqlParams{
+		{"note": "no order"},
+		{"workOrder": "https://x.org/1", "status": "REL"},
+	} {
+		if _, err := tp.render(params); err == nil {
+			t.Errorf("rendered with %v", params)
+		}
+	}
+	for _, bad := range []string{
+		"#@param workOrder\nINSERT DATA {}",
+		"#@param workOrder uri\nINSERT DATA {}",
+		"#@param workOrder iri maybe\nINSERT DATA {}",
+		"INSERT DATA { {{.workOrder }",
+	} {
+		if _, err := parseSPARQLTemplate("bad.rq", bad); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+// TestTemplatesPerOrderType overrides a built-in template and adds one for
+// an order type.
+func TestTemplatesPerOrderType(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "status.PM02.rq"), []byte("#@param workOrder iri\n#@param status string\n#@param modified dateTime\n#@param closedAt dateTime optional\n# PM02\nINSERT DATA { {{.workOrder}} ex:preventive {{.status}} }\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "audit.rq"), []byte("#@param auditEvent iri\n#@param workOrder iri\n#@param action string\n#@param created dateTime\n#@param note string optional\nINSERT DATA { {{.workOrder}} ex:audited {{.action}} }\n"), 0o644)
+	tr := quietTraits()
+	tr.queries = newSPARQLTemplates(dir)
+	if err := tr.queries.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Now()
+	pm02 := &Order{ID: "400000001", Request: OrderRequest{MaintenanceOrderType: "PM02"}}
+	pm01 := &Order{ID: "400000002", Request: OrderRequest{MaintenanceOrderType: "PM01"}}
+	if got, _ := tr.buildStatusSPARQL(pm02, statusClosed, at); got != `INSERT DATA { <https://sinetiq.se/sap/MaintenanceOrder/400000001> ex:preventive "CLSD" }` {
+		t.Errorf("PM02 status update:\n%s", got)
+	}
+	if got, _ := tr.buildStatusSPARQL(pm01, statusClosed, at); !strings.Contains(got, "ex:closedAt") {
+		t.Errorf("PM01 status update is not the built-in one:\n%s", got)
+	}
+	if got, _ := tr.buildAuditSPARQL("400000001", "EDIT", "", at); !strings.Contains(got, `ex:audited "EDIT"`) {
+		t.Errorf("audit update is not the override:\n%s", got)
+	}
+
+	os.WriteFile(filepath.Join(dir, "release.rq"), []byte("#@param workOrder iri\nINSERT DATA { {{.workOrder}\n"), 0o644)
+	if err := newSPARQLTemplates(dir).check(); err == nil {
+		t.Error("a broken template passed the check")
+	}
+}
+
+func TestTECOFallsBackOnBadEnrichment(t *testing.T) {
+	tr := quietTraits()
+	o := &Order{ID: "400000001", ParsedEnrichment: &Enrichment{Decision: "repair",
+		CurrentPart: "SK_000012", ActivityStart: "2024-06-01T08:00:00", ActivityEnd: "2024-06-01T12:00:00"}}
+	if stage, sparql, err := tr.chooseTECOTemplate(o, time.Now()); err != nil || stage != "TECO" || strings.Contains(sparql, "SK_000012") {
+		t.Errorf("stage %s, err %v:\n%s", stage, err, sparql)
+	}
+	o.ParsedEnrichment.CurrentPart = "https://arrowheadweb.org/data/SK_000012"
+	if stage, _, err := tr.chooseTECOTemplate(o, time.Now()); err != nil || stage != "TECO-repair" {
+		t.Errorf("stage %s, err %v", stage, err)
+	}
+}
@@ -0,0 +1,631 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// System statuses of a maintenance order, after SAP PM.
+const (
+	statusCreated   = "CRTD" // created, awaiting the planner
+	statusReleased  = "REL"  // released for execution
+	statusPartConf  = "PCNF" // partially confirmed
+	statusConfirmed = "CNF"  // every operation finally confirmed
+	statusTechDone  = "TECO" // technically completed
+	statusClosed    = "CLSD" // closed, no further change
+)
+
+// errOrderNotFound is returned for an order ID the sapper does not know.
+var errOrderNotFound = errors.New("order not found")
+
+// nextStatuses lists the system statuses an order may move to from each status.
+// PCNF and CNF follow from time confirmations; TECO may be revoked back to REL.
+var nextStatuses = map[string][]string{
+	statusCreated:   {statusReleased},
+	statusReleased:  {statusPartConf, statusConfirmed, statusTechDone},
+	statusPartConf:  {statusPartConf, statusConfirmed, statusTechDone},
+	statusConfirmed: {statusTechDone},
+	statusTechDone:  {statusClosed, statusReleased},
+	statusClosed:    {},
+}
+
+// defaultUserStatuses is the status profile used when the traits give none.
+var defaultUserStatuses = []string{"APRV", "WMAT", "WCAP", "INPR"}
+
+// userStatusProfile returns the user statuses planners may set.
+func (t *Traits) userStatusProfile() []string {
+	if len(t.UserStatuses) > 0 {
+		return t.UserStatuses
+	}
+	return defaultUserStatuses
+}
+
+// canMove reports whether an order in status from may move to status to.
+func canMove(from, to string) bool {
+	return slices.Contains(nextStatuses[from], to)
+}
+
+// completionDelay returns the time from release to technical completion.
+func (t *Traits) completionDelay() time.Duration {
+	delay := t.CompletionDelay * time.Second
+	if delay <= 0 {
+		delay = 30 * time.Second // safe default
+	}
+	return delay
+}
+
+// changeStatus moves an order to a system status, recording it in the order's
+// history, and returns the side effects to run once the lock is released.
+// The lock must be held; the caller saves the order.
+func (t *Traits) changeStatus(o *Order, status, note string, at time.Time) (func(), error) {
+	if _, known := nextStatuses[status]; !known {
+		return nil, fmt.Errorf("unknown system status %q", status)
+	}
+	if !canMove(o.Status, status) {
+		return nil, fmt.Errorf("order %s is %s and cannot move to %s", o.ID, o.Status, status)
+	}
+	revoked := o.Status == statusTechDone && status == statusReleased
+	var issued []GoodsMovement
+	if status == statusTechDone {
+		// The components are withdrawn at TECO; without them the order stays put
+		var err error
+		if issued, err = t.issueGoods(o, at); err != nil {
+			return nil, err
+		}
+	}
+	o.Status = status
+	o.History = append(o.History, StatusChange{Status: status, At: at, Note: note})
+	log.Printf("order %s → %s %s\n", o.ID, status, note)
+
+	switch {
+	case status == statusReleased && !revoked:
+		// The technical completion is measured from the planner's release
+		o.ReleasedAt = at
+		if t.sap == nil { // SAP reports its own technical completion
+			t.schedule(o, statusTechDone, at.Add(t.completionDelay()))
+		}
+		t.reserve(o, at)
+		sparql, err := t.buildRELInsertSPARQL(o)
+		return func() {
+			go t.publishSPARQL("REL", o.ID, sparql, err)
+			go t.notifyEnrichment(o)
+		}, nil
+	case status == statusTechDone:
+		o.CompletedAt = at
+		o.Scheduled = slices.DeleteFunc(o.Scheduled, func(st ScheduledTransition) bool { return st.Status == statusTechDone })
+		stage, sparql, err := t.chooseTECOTemplate(o, at)
+		return func() {
+			go t.publishSPARQL(stage, o.ID, sparql, err)
+			go t.recordMovements(issued)
+			t.notifyConsumer(o)
+		}, nil
+	case status == statusClosed:
+		o.ClosedAt = at
+	case revoked:
+		o.CompletedAt = time.Time{}
+	}
+	sparql, err := t.buildStatusSPARQL(o, status, at)
+	return func() { t.publishSPARQL(status, o.ID, sparql, err) }, nil
+}
+
+// saveOrder persists an order. The lock must be held. Persistence failures are
+// logged: the order lives on in memory and is saved again at its next change.
+func (t *Traits) saveOrder(o *Order) {
+	if err := t.store.save(o); err != nil {
+		log.Printf("sapper: %v\n", err)
+	}
+}
+
+//-------------------------------------Scheduled transitions
+
+// schedule records a transition of an order due at a given time and arms it.
+// The lock must be held; the caller saves the order.
+func (t *Traits) schedule(o *Order, status string, due time.Time) {
+	st := ScheduledTransition{Status: status, Due: due}
+	o.Scheduled = append(o.Scheduled, st)
+	t.arm(o.ID, st)
+}
+
+// arm starts the timer of a scheduled transition. Overdue transitions, such
+// as those found at restart, fire at once.
+func (t *Traits) arm(orderID string, st ScheduledTransition) {
+	time.AfterFunc(max(time.Until(st.Due), 0), func() { t.runScheduled(orderID, st) })
+}
+
+// runScheduled applies a due transition, unless it was cancelled or the order
+// has since moved to a status the transition cannot follow.
+func (t *Traits) runScheduled(orderID string, st ScheduledTransition) {
+	t.mu.Lock()
+	o, ok := t.orders[orderID]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	i := slices.IndexFunc(o.Scheduled, func(s ScheduledTransition) bool { return s.Status == st.Status && s.Due.Equal(st.Due) })
+	if i < 0 {
+		t.mu.Unlock()
+		return
+	}
+	o.Scheduled = slices.Delete(o.Scheduled, i, i+1)
+	effects, err := t.changeStatus(o, st.Status, "scheduled", time.Now())
+	switch {
+	case errors.Is(err, errStockShort):
+		// Kept for a retry when stock comes in, or at the next start
+		o.Scheduled = append(o.Scheduled, st)
+		log.Printf("sapper: scheduled transition waits for material: %v\n", err)
+	case err != nil:
+		log.Printf("sapper: scheduled transition dropped: %v\n", err)
+	}
+	t.saveOrder(o)
+	t.mu.Unlock()
+	if effects != nil {
+		effects()
+	}
+}
+
+// restoreOrders loads the stored orders, fast-forwards the order counter past
+// them, and re-arms their pending transitions.
+func (t *Traits) restoreOrders() error {
+	orders, err := t.store.load()
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, o := range orders {
+		t.orders[o.ID] = o
+		if n, err := strconv.ParseInt(strings.TrimPrefix(o.ID, "4"), 10, 64); err == nil && n > t.seq.Load() {
+			t.seq.Store(n)
+		}
+		for _, st := range o.Scheduled {
+			t.arm(o.ID, st)
+		}
+	}
+	if len(orders) > 0 {
+		log.Printf("sapper: %d order(s) restored, next order will be 4%08d", len(orders), t.seq.Load()+1)
+	}
+	return nil
+}
+
+//-------------------------------------Status and user status changes
+
+// updateStatus applies a status request: user statuses at once, and a system
+// status now or at the requested time.
+func (t *Traits) updateStatus(req StatusRequest) (*Order, error) {
+	if req.Status == statusPartConf || req.Status == statusConfirmed {
+		return nil, fmt.Errorf("%s follows from time confirmations", req.Status)
+	}
+
+	t.mu.Lock()
+	o, ok := t.orders[req.OrderID]
+	if !ok {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("order %s: %w", req.OrderID, errOrderNotFound)
+	}
+	now := time.Now()
+	for _, us := range req.AddUserStatus {
+		if !slices.Contains(t.userStatusProfile(), us) {
+			t.mu.Unlock()
+			return nil, fmt.Errorf("user status %q is not in the status profile %v", us, t.userStatusProfile())
+		}
+	}
+	if o.Status == statusClosed && (req.Status != "" || len(req.AddUserStatus)+len(req.RemoveUserStatus) > 0) {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("order %s is closed", o.ID)
+	}
+	var effects func()
+	if req.Status != "" {
+		if _, known := nextStatuses[req.Status]; !known {
+			t.mu.Unlock()
+			return nil, fmt.Errorf("unknown system status %q", req.Status)
+		}
+		if req.At != nil && req.At.After(now) {
+			t.schedule(o, req.Status, *req.At)
+			log.Printf("order %s: %s scheduled at %s\n", o.ID, req.Status, req.At.Format(time.RFC3339))
+		} else {
+			var err error
+			if effects, err = t.changeStatus(o, req.Status, req.Note, now); err != nil {
+				t.mu.Unlock()
+				return nil, err
+			}
+		}
+	}
+	for _, us := range req.AddUserStatus {
+		if !slices.Contains(o.UserStatuses, us) {
+			o.UserStatuses = append(o.UserStatuses, us)
+			o.History = append(o.History, StatusChange{Status: "+" + us, At: now, Note: req.Note})
+		}
+	}
+	for _, us := range req.RemoveUserStatus {
+		if i := slices.Index(o.UserStatuses, us); i >= 0 {
+			o.UserStatuses = slices.Delete(o.UserStatuses, i, i+1)
+			o.History = append(o.History, StatusChange{Status: "-" + us, At: now, Note: req.Note})
+		}
+	}
+	t.saveOrder(o)
+	t.mu.Unlock()
+	if effects != nil {
+		effects()
+	}
+	return o, nil
+}
+
+//-------------------------------------Time confirmations
+
+// confirm posts a time confirmation. A released order becomes CNF once every
+// operation has a final confirmation (or, for orders without operations, at
+// the first final one), PCNF otherwise.
+func (t *Traits) confirm(req ConfirmationRequest) (*Order, *Confirmation, error) {
+	if req.OperationID == "" || req.ActualWork < 0 {
+		return nil, nil, fmt.Errorf("operationId and a non-negative actualWork are required")
+	}
+	t.mu.Lock()
+	o, ok := t.orders[req.OrderID]
+	if !ok {
+		t.mu.Unlock()
+		return nil, nil, fmt.Errorf("order %s: %w", req.OrderID, errOrderNotFound)
+	}
+	if o.Status != statusReleased && o.Status != statusPartConf {
+		t.mu.Unlock()
+		return nil, nil, fmt.Errorf("order %s is %s; only released orders are confirmed", o.ID, o.Status)
+	}
+	ops := operationIDs(o)
+	if len(ops) > 0 && !slices.Contains(ops, req.OperationID) {
+		t.mu.Unlock()
+		return nil, nil, fmt.Errorf("order %s has no operation %s", o.ID, req.OperationID)
+	}
+	for _, c := range o.Confirmations {
+		if c.OperationID == req.OperationID && c.Final {
+			t.mu.Unlock()
+			return nil, nil, fmt.Errorf("operation %s of order %s is already finally confirmed", req.OperationID, o.ID)
+		}
+	}
+	unit := req.WorkUnit
+	if unit == "" {
+		unit = "H"
+	}
+	c := Confirmation{
+		Counter:     len(o.Confirmations) + 1,
+		OperationID: req.OperationID,
+		ActualWork:  req.ActualWork,
+		WorkUnit:    unit,
+		Final:       req.Final,
+		Text:        req.Text,
+		PostedAt:    time.Now(),
+	}
+	o.Confirmations = append(o.Confirmations, c)
+
+	status := statusPartConf
+	if allConfirmed(o, ops) {
+		status = statusConfirmed
+	}
+	var effects func()
+	if status != o.Status {
+		var err error
+		effects, err = t.changeStatus(o, status, fmt.Sprintf("confirmation %d", c.Counter), c.PostedAt)
+		if err != nil {
+			log.Printf("sapper: %v\n", err)
+		}
+	}
+	t.saveOrder(o)
+	t.mu.Unlock()
+	if effects != nil {
+		effects()
+	}
+	return o, &c, nil
+}
+
+// operationIDs returns the IDs of an order's operations.
+func operationIDs(o *Order) []string {
+	var ids []string
+	for _, op := range o.Request.Operations {
+		if op.OperationID != "" {
+			ids = append(ids, op.OperationID)
+		}
+	}
+	return ids
+}
+
+// allConfirmed reports whether every operation has a final confirmation.
+func allConfirmed(o *Order, ops []string) bool {
+	final := make(map[string]bool)
+	for _, c := range o.Confirmations {
+		if c.Final {
+			final[c.OperationID] = true
+		}
+	}
+	if len(ops) == 0 {
+		return len(final) > 0
+	}
+	for _, id := range ops {
+		if !final[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// actualWorkHours sums the confirmed work of an order in hours.
+func actualWorkHours(o *Order) float64 {
+	var h float64
+	for _, c := range o.Confirmations {
+		switch strings.ToUpper(c.WorkUnit) {
+		case "MIN":
+			h += c.ActualWork / 60
+		case "D", "DAY":
+			h += c.ActualWork * 24
+		default:
+			h += c.ActualWork
+		}
+	}
+	return h
+}
+
+//-------------------------------------Listing
+
+// orderFilter selects orders for the order list service.
+type orderFilter struct {
+	statuses []string // system or user statuses; any matches
+	plant    string
+	equip    string
+	location string // exact, or a prefix when it ends in *
+	priority string
+	from, to time.Time // creation window; zero for unbounded
+	limit    int
+}
+
+// parseOrderFilter reads a filter from the query parameters status, plant,
+// equipment, location, priority, from, to (RFC 3339 or dates) and limit.
+func parseOrderFilter(q map[string][]string) (orderFilter, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	f := orderFilter{plant: get("plant"), equip: get("equipment"), location: get("location"), priority: get("priority")}
+	for _, s := range strings.Split(get("status"), ",") {
+		if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+			f.statuses = append(f.statuses, s)
+		}
+	}
+	for k, dst := range map[string]*time.Time{"from": &f.from, "to": &f.to} {
+		v := get(k)
+		if v == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			day, derr := time.Parse(time.DateOnly, v)
+			if derr != nil {
+				return f, fmt.Errorf("%s: expected an RFC 3339 time or a date, got %q", k, v)
+			}
+			if k == "to" {
+				day = day.AddDate(0, 0, 1) // the whole day
+			}
+			at = day
+		}
+		*dst = at
+	}
+	if v := get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return f, fmt.Errorf("limit: expected a non-negative integer, got %q", v)
+		}
+		f.limit = n
+	}
+	return f, nil
+}
+
+// matches reports whether an order passes the filter.
+func (f orderFilter) matches(o *Order) bool {
+	if len(f.statuses) > 0 && !slices.Contains(f.statuses, o.Status) &&
+		!slices.ContainsFunc(o.UserStatuses, func(us string) bool { return slices.Contains(f.statuses, us) }) {
+		return false
+	}
+	switch {
+	case f.plant != "" && o.Request.Plant != f.plant,
+		f.equip != "" && o.Request.EquipmentID != f.equip,
+		f.location != "" && !matchLocation(o.Request.FunctionalLocation, f.location),
+		f.priority != "" && o.Request.Priority != f.priority,
+		!f.from.IsZero() && o.CreatedAt.Before(f.from),
+		!f.to.IsZero() && !o.CreatedAt.Before(f.to):
+		return false
+	}
+	return true
+}
+
+// matchLocation matches a functional location against an exact tag, or
+// against a prefix ending in * for a branch of the location hierarchy.
+func matchLocation(fl, pattern string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(fl, prefix)
+	}
+	return fl == pattern
+}
+
+// listOrders returns the summaries of the orders passing the filter, by order number.
+func (t *Traits) listOrders(f orderFilter) []OrderSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]OrderSummary, 0)
+	for _, o := range t.orders {
+		if f.matches(o) {
+			list = append(list, summarize(o))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].MaintenanceOrder < list[j].MaintenanceOrder })
+	if f.limit > 0 && len(list) > f.limit {
+		list = list[:f.limit]
+	}
+	return list
+}
+
+// summarize returns the listed view of an order. The lock must be held.
+func summarize(o *Order) OrderSummary {
+	s := OrderSummary{
+		MaintenanceOrder:        o.ID,
+		MaintenanceNotification: o.Notification,
+		Status:                  o.Status,
+		UserStatus:              slices.Clone(o.UserStatuses),
+		Plant:                   o.Request.Plant,
+		EquipmentID:             o.Request.EquipmentID,
+		FunctionalLocation:      o.Request.FunctionalLocation,
+		Description:             o.Request.Description,
+		Priority:                o.Request.Priority,
+		OrderType:               o.Request.MaintenanceOrderType,
+		CreatedAt:               o.CreatedAt,
+		ActualWork:              actualWorkHours(o),
+		Scheduled:               slices.Clone(o.Scheduled),
+	}
+	if !o.ReleasedAt.IsZero() {
+		s.ReleasedAt = &o.ReleasedAt
+	}
+	if !o.CompletedAt.IsZero() {
+		s.CompletedAt = &o.CompletedAt
+	}
+	return s
+}
+
+//-------------------------------------HTTP handlers
+
+// listOrdersHandler handles GET orderlist with optional filters.
+func (t *Traits) listOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+	f, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.listOrders(f))
+}
+
+// orderStatusHandler handles GET orderstatus?id=<orderID>, which returns the
+// order's statuses and history, and POST orderstatus with a StatusRequest.
+func (t *Traits) orderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var id string
+	switch r.Method {
+	case http.MethodGet:
+		if id = r.URL.Query().Get("id"); id == "" {
+			http.Error(w, "query parameter 'id' is required", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		var req StatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.OrderID == "" {
+			http.Error(w, "orderId is required", http.StatusBadRequest)
+			return
+		}
+		if _, err := t.updateStatus(req); err != nil {
+			code := http.StatusConflict
+			if errors.Is(err, errOrderNotFound) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		id = req.OrderID
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t.mu.Lock()
+	o, ok := t.orders[id]
+	if !ok {
+		t.mu.Unlock()
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+	out := struct {
+		OrderSummary
+		History []StatusChange `json:"history"`
+	}{summarize(o), slices.Clone(o.History)}
+	t.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// confirmationsHandler handles GET confirmations?id=<orderID> and POST
+// confirmations with a ConfirmationRequest.
+func (t *Traits) confirmationsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			http.Error(w, "query parameter 'id' is required", http.StatusBadRequest)
+			return
+		}
+		t.mu.Lock()
+		o, ok := t.orders[id]
+		var list []Confirmation
+		if ok {
+			list = append([]Confirmation{}, o.Confirmations...)
+		}
+		t.mu.Unlock()
+		if !ok {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	case http.MethodPost:
+		var req ConfirmationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		o, c, err := t.confirm(req)
+		if err != nil {
+			code := http.StatusConflict
+			if errors.Is(err, errOrderNotFound) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		t.mu.Lock()
+		status := o.Status
+		t.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			Confirmation
+			OrderID string `json:"maintenanceOrder"`
+			Status  string `json:"status"`
+		}{*c, o.ID, status})
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,331 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// ── helpers ───────────────────────────────────────────────────────────────────
+
+// quietTraits returns test traits without the nurse's callbacks, so that
+// status changes run their side effects without network calls.
+func quietTraits() *Traits {
+	tr := newTestTraits()
+	tr.monitor, tr.enrichment = nil, nil
+	return tr
+}
+
+// releasedOrder creates an order with two operations and releases it, with a
+// completion delay long enough not to interfere.
+func releasedOrder(t *testing.T, tr *Traits) *Order {
+	t.Helper()
+	tr.CompletionDelay = 3600
+	o := tr.createOrder(OrderRequest{EquipmentID: "10000045", Plant: "1000", Description: "test",
+		Operations: []OrderOperation{{OperationID: "0010", Text: "isolate"}, {OperationID: "0020", Text: "repair"}}})
+	if _, err := tr.enrichAndRelease(o.ID, json.RawMessage(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func statusOf(tr *Traits, o *Order) string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return o.Status
+}
+
+// ── status model ──────────────────────────────────────────────────────────────
+
+func TestStatusTransitions(t *testing.T) {
+	for _, c := range []struct {
+		from, to string
+		ok       bool
+	}{
+		{"CRTD", "REL", true},
+		{"CRTD", "TECO", false},
+		{"REL", "TECO", true},
+		{"PCNF", "CNF", true},
+		{"CNF", "PCNF", false},
+		{"TECO", "CLSD", true},
+		{"TECO", "REL", true},
+		{"CLSD", "REL", false},
+	} {
+		if got := canMove(c.from, c.to); got != c.ok {
+			t.Errorf("%s → %s allowed = %t, want %t", c.from, c.to, got, c.ok)
+		}
+	}
+}
+
+func TestConfirmations(t *testing.T) {
+	tr := quietTraits()
+	o := releasedOrder(t, tr)
+
+	if _, _, err := tr.confirm(ConfirmationRequest{OrderID: o.ID, OperationID: "0030", ActualWork: 1}); err == nil {
+		t.Error("expected an error confirming an unknown operation")
+	}
+	if _, _, err := tr.confirm(ConfirmationRequest{OrderID: o.ID, OperationID: "0010", ActualWork: 1.5, Final: true}); err != nil {
+		t.Fatal(err)
+	}
+	if s := statusOf(tr, o); s != "PCNF" {
+		t.Errorf("after one operation: %s, want PCNF", s)
+	}
+	if _, _, err := tr.confirm(ConfirmationRequest{OrderID: o.ID, OperationID: "0010", ActualWork: 1}); err == nil {
+		t.Error("expected an error confirming a finally confirmed operation")
+	}
+	if _, _, err := tr.confirm(ConfirmationRequest{OrderID: o.ID, OperationID: "0020", ActualWork: 30, WorkUnit: "MIN"}); err != nil {
+		t.Fatal(err)
+	}
+	if s := statusOf(tr, o); s != "PCNF" {
+		t.Errorf("after a partial confirmation: %s, want PCNF", s)
+	}
+	_, c, err := tr.confirm(ConfirmationRequest{OrderID: o.ID, OperationID: "0020", ActualWork: 1, Final: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Counter != 3 || c.WorkUnit != "H" {
+		t.Errorf("confirmation = %+v", c)
+	}
+	if s := statusOf(tr, o); s != "CNF" {
+		t.Errorf("after every operation: %s, want CNF", s)
+	}
+	tr.mu.Lock()
+	hours := actualWorkHours(o)
+	tr.mu.Unlock()
+	if hours != 3 {
+		t.Errorf("actual work = %g h, want 3", hours)
+	}
+	if _, _, err := tr.confirm(ConfirmationRequest{OrderID: o.ID, OperationID: "0020", ActualWork: 1}); err == nil {
+		t.Error("expected an error confirming a CNF order")
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	tr := quietTraits()
+	o := releasedOrder(t, tr)
+
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, AddUserStatus: []string{"WMAT", "INPR"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, AddUserStatus: []string{"NOPE"}}); err == nil {
+		t.Error("expected an error setting a user status outside the profile")
+	}
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, RemoveUserStatus: []string{"WMAT"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, Status: "CNF"}); err == nil {
+		t.Error("CNF must follow from confirmations")
+	}
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, Status: "CLSD"}); err == nil {
+		t.Error("a released order cannot be closed")
+	}
+	for _, s := range []string{"TECO", "REL", "TECO", "CLSD"} {
+		if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, Status: s}); err != nil {
+			t.Fatalf("→ %s: %v", s, err)
+		}
+	}
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, AddUserStatus: []string{"APRV"}}); err == nil {
+		t.Error("a closed order cannot change")
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	var trail []string
+	for _, h := range o.History {
+		trail = append(trail, h.Status)
+	}
+	want := []string{"CRTD", "REL", "+WMAT", "+INPR", "-WMAT", "TECO", "REL", "TECO", "CLSD"}
+	if len(trail) != len(want) {
+		t.Fatalf("history = %v, want %v", trail, want)
+	}
+	for i := range want {
+		if trail[i] != want[i] {
+			t.Fatalf("history = %v, want %v", trail, want)
+		}
+	}
+	if len(o.UserStatuses) != 1 || o.UserStatuses[0] != "INPR" || o.ClosedAt.IsZero() || len(o.Scheduled) != 0 {
+		t.Errorf("order = %+v", o)
+	}
+}
+
+func TestOrderNotFound(t *testing.T) {
+	tr := quietTraits()
+	if _, err := tr.updateStatus(StatusRequest{OrderID: "NOTEXIST", Status: "TECO"}); !errors.Is(err, errOrderNotFound) {
+		t.Errorf("updateStatus: %v", err)
+	}
+	if _, _, err := tr.confirm(ConfirmationRequest{OrderID: "NOTEXIST", OperationID: "0010", ActualWork: 1}); !errors.Is(err, errOrderNotFound) {
+		t.Errorf("confirm: %v", err)
+	}
+	if _, err := tr.enrichAndRelease("NOTEXIST", json.RawMessage(`{}`)); !errors.Is(err, errOrderNotFound) {
+		t.Errorf("enrichAndRelease: %v", err)
+	}
+
+	// Other failures on a known order are not mistaken for it
+	o := releasedOrder(t, tr)
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, Status: "CLSD"}); err == nil || errors.Is(err, errOrderNotFound) {
+		t.Errorf("invalid transition: %v", err)
+	}
+	if _, err := tr.enrichAndRelease(o.ID, json.RawMessage(`{}`)); err == nil || errors.Is(err, errOrderNotFound) {
+		t.Errorf("second release: %v", err)
+	}
+}
+
+func TestScheduledTransition(t *testing.T) {
+	tr := quietTraits()
+	o := releasedOrder(t, tr)
+
+	tr.mu.Lock()
+	if len(o.Scheduled) != 1 || o.Scheduled[0].Status != "TECO" || !o.Scheduled[0].Due.Equal(o.ReleasedAt.Add(time.Hour)) {
+		t.Errorf("scheduled at release = %+v", o.Scheduled)
+	}
+	tr.mu.Unlock()
+
+	at := time.Now().Add(150 * time.Millisecond)
+	if _, err := tr.updateStatus(StatusRequest{OrderID: o.ID, Status: "TECO", At: &at}); err != nil {
+		t.Fatal(err)
+	}
+	if s := statusOf(tr, o); s != "REL" {
+		t.Fatalf("before the due time: %s", s)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if s := statusOf(tr, o); s != "TECO" {
+		t.Fatalf("after the due time: %s, want TECO", s)
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if len(o.Scheduled) != 0 {
+		t.Errorf("the release's TECO is still scheduled: %+v", o.Scheduled)
+	}
+}
+
+// ── listing ───────────────────────────────────────────────────────────────────
+
+func TestListOrders(t *testing.T) {
+	tr := quietTraits()
+	a := tr.createOrder(OrderRequest{EquipmentID: "E1", Plant: "1000", Description: "a", FunctionalLocation: "FL-1"})
+	b := releasedOrder(t, tr)
+	c := tr.createOrder(OrderRequest{EquipmentID: "E2", Plant: "2000", Description: "c"})
+	tr.updateStatus(StatusRequest{OrderID: c.ID, AddUserStatus: []string{"WMAT"}})
+	tr.mu.Lock()
+	a.CreatedAt = time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+	tr.mu.Unlock()
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{a.ID, b.ID, c.ID}},
+		{"status=CRTD", []string{a.ID, c.ID}},
+		{"status=rel,wmat", []string{b.ID, c.ID}},
+		{"plant=2000", []string{c.ID}},
+		{"equipment=E1", []string{a.ID}},
+		{"location=FL-1", []string{a.ID}},
+		{"to=2026-01-10", []string{a.ID}},
+		{"from=2026-01-11T00:00:00Z", []string{b.ID, c.ID}},
+		{"limit=2", []string{a.ID, b.ID}},
+	} {
+		q, _ := url.ParseQuery(tc.query)
+		f, err := parseOrderFilter(q)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.query, err)
+		}
+		var got []string
+		for _, s := range tr.listOrders(f) {
+			got = append(got, s.MaintenanceOrder)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%q: %v, want %v", tc.query, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%q: %v, want %v", tc.query, got, tc.want)
+				break
+			}
+		}
+	}
+	for _, bad := range []string{"from=yesterday", "limit=-1"} {
+		q, _ := url.ParseQuery(bad)
+		if _, err := parseOrderFilter(q); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+// ── handlers ──────────────────────────────────────────────────────────────────
+
+func TestStatusServices(t *testing.T) {
+	tr := quietTraits()
+	o := releasedOrder(t, tr)
+
+	post := func(path string, body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		serving(tr, w, httptest.NewRequest(http.MethodPost, "/sapper/SAPSimulator/"+path, bytes.NewReader(b)), path)
+		return w
+	}
+
+	if w := post("confirmations", ConfirmationRequest{OrderID: o.ID, OperationID: "0010", ActualWork: 2, Final: true}); w.Code != http.StatusCreated {
+		t.Fatalf("confirmation: %d %s", w.Code, w.Body.String())
+	} else if !bytes.Contains(w.Body.Bytes(), []byte(`"status":"PCNF"`)) {
+		t.Errorf("confirmation response = %s", w.Body.String())
+	}
+	if w := post("confirmations", ConfirmationRequest{OrderID: "NOTEXIST", OperationID: "0010"}); w.Code != http.StatusNotFound {
+		t.Errorf("confirmation of an unknown order: %d", w.Code)
+	}
+	if w := post("orderstatus", StatusRequest{OrderID: "NOTEXIST", Status: "TECO"}); w.Code != http.StatusNotFound {
+		t.Errorf("status of an unknown order: %d", w.Code)
+	}
+	if w := post("orderstatus", StatusRequest{OrderID: o.ID, Status: "CLSD"}); w.Code != http.StatusConflict {
+		t.Errorf("invalid transition: %d", w.Code)
+	}
+	w := post("orderstatus", StatusRequest{OrderID: o.ID, Status: "TECO", Note: "done early"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("TECO: %d %s", w.Code, w.Body.String())
+	}
+	var got struct {
+		Status     string         `json:"status"`
+		ActualWork float64        `json:"actualWork"`
+		History    []StatusChange `json:"history"`
+	}
+	json.NewDecoder(w.Body).Decode(&got)
+	if got.Status != "TECO" || got.ActualWork != 2 || got.History[len(got.History)-1].Note != "done early" {
+		t.Errorf("status response = %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	serving(tr, w, httptest.NewRequest(http.MethodGet, "/sapper/SAPSimulator/orderlist?status=TECO", nil), "orderlist")
+	var list []OrderSummary
+	json.NewDecoder(w.Body).Decode(&list)
+	if w.Code != http.StatusOK || len(list) != 1 || list[0].MaintenanceOrder != o.ID {
+		t.Errorf("order list: %d %+v", w.Code, list)
+	}
+
+	w = httptest.NewRecorder()
+	serving(tr, w, httptest.NewRequest(http.MethodGet, "/sapper/SAPSimulator/confirmations?id="+o.ID, nil), "confirmations")
+	var confs []Confirmation
+	json.NewDecoder(w.Body).Decode(&confs)
+	if len(confs) != 1 || confs[0].OperationID != "0010" {
+		t.Errorf("confirmations = %+v", confs)
+	}
+}
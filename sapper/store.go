@@ -0,0 +1,296 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// orderStore persists the orders to SQLite. The searchable fields have their
+// own columns; the whole order is kept as JSON, so that confirmations,
+// history and scheduled transitions are saved together with the status.
+// The simulated inventory (stock, reservations and material documents) and
+// the SPARQL updates waiting for GraphDB are kept in the same database.
+type orderStore struct {
+	db *sql.DB
+}
+
+// openOrderStore opens (or creates) the order database at path.
+func openOrderStore(path string) (*orderStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+	db.SetMaxOpenConns(1) // one writer; also keeps a :memory: database alive
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS Orders (
+			ID                 TEXT PRIMARY KEY,
+			Notification       TEXT NOT NULL,
+			Status             TEXT NOT NULL,
+			UserStatus         TEXT NOT NULL DEFAULT '',
+			Plant              TEXT NOT NULL,
+			EquipmentID        TEXT NOT NULL,
+			FunctionalLocation TEXT NOT NULL DEFAULT '',
+			CreatedAt          DATETIME NOT NULL,
+			Data               TEXT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS OrdersByStatus ON Orders (Status);
+		CREATE TABLE IF NOT EXISTS Stock (
+			Material        TEXT NOT NULL,
+			Plant           TEXT NOT NULL,
+			StorageLocation TEXT NOT NULL,
+			Data            TEXT NOT NULL,
+			PRIMARY KEY (Material, Plant, StorageLocation)
+		);
+		CREATE TABLE IF NOT EXISTS Reservations (
+			Reservation TEXT NOT NULL,
+			Item        INTEGER NOT NULL,
+			OrderID     TEXT NOT NULL,
+			Data        TEXT NOT NULL,
+			PRIMARY KEY (Reservation, Item)
+		);
+		CREATE TABLE IF NOT EXISTS GoodsMovements (
+			Document TEXT PRIMARY KEY,
+			OrderID  TEXT NOT NULL DEFAULT '',
+			Data     TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS SPARQLUpdates (
+			ID   INTEGER PRIMARY KEY AUTOINCREMENT,
+			Data TEXT NOT NULL
+		);`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating table: %w", err)
+	}
+	return &orderStore{db: db}, nil
+}
+
+// save inserts or replaces an order.
+func (s *orderStore) save(o *Order) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("encoding order %s: %w", o.ID, err)
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO Orders (ID, Notification, Status, UserStatus, Plant, EquipmentID, FunctionalLocation, CreatedAt, Data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ID) DO UPDATE SET
+			Status = excluded.Status, UserStatus = excluded.UserStatus, Data = excluded.Data;`,
+		o.ID, o.Notification, o.Status, strings.Join(o.UserStatuses, " "),
+		o.Request.Plant, o.Request.EquipmentID, o.Request.FunctionalLocation, o.CreatedAt, string(data))
+	if err != nil {
+		return fmt.Errorf("saving order %s: %w", o.ID, err)
+	}
+	return nil
+}
+
+// load returns every stored order.
+func (s *orderStore) load() ([]*Order, error) {
+	if s == nil {
+		return nil, nil
+	}
+	rows, err := s.db.Query(`SELECT Data FROM Orders ORDER BY ID;`)
+	if err != nil {
+		return nil, fmt.Errorf("querying orders: %w", err)
+	}
+	defer rows.Close()
+	var orders []*Order
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("scanning order: %w", err)
+		}
+		o := new(Order)
+		if err := json.Unmarshal([]byte(data), o); err != nil {
+			return nil, fmt.Errorf("decoding order: %w", err)
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+// saveStock inserts or replaces the stock of a material at a storage location.
+func (s *orderStore) saveStock(st *Stock) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO Stock (Material, Plant, StorageLocation, Data) VALUES (?, ?, ?, ?);`,
+		st.Material, st.Plant, st.StorageLocation, string(data))
+	if err != nil {
+		return fmt.Errorf("saving stock of %s: %w", st.Material, err)
+	}
+	return nil
+}
+
+// saveReservation inserts or replaces a reservation item.
+func (s *orderStore) saveReservation(r *Reservation) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO Reservations (Reservation, Item, OrderID, Data) VALUES (?, ?, ?, ?);`,
+		r.Reservation, r.Item, r.OrderID, string(data))
+	if err != nil {
+		return fmt.Errorf("saving reservation %s/%d: %w", r.Reservation, r.Item, err)
+	}
+	return nil
+}
+
+// saveMovement inserts a material document; posted documents do not change.
+func (s *orderStore) saveMovement(m GoodsMovement) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`INSERT INTO GoodsMovements (Document, OrderID, Data) VALUES (?, ?, ?);`,
+		m.Document, m.OrderID, string(data)); err != nil {
+		return fmt.Errorf("saving material document %s: %w", m.Document, err)
+	}
+	return nil
+}
+
+// loadInventory returns the stored stock, reservations and material documents.
+func (s *orderStore) loadInventory() (stock []*Stock, reservations []*Reservation, movements []GoodsMovement, err error) {
+	if s == nil {
+		return nil, nil, nil, nil
+	}
+	if err := loadJSON(s.db, `SELECT Data FROM Stock ORDER BY Material, Plant, StorageLocation;`, &stock); err != nil {
+		return nil, nil, nil, fmt.Errorf("loading stock: %w", err)
+	}
+	if err := loadJSON(s.db, `SELECT Data FROM Reservations ORDER BY Reservation, Item;`, &reservations); err != nil {
+		return nil, nil, nil, fmt.Errorf("loading reservations: %w", err)
+	}
+	if err := loadJSON(s.db, `SELECT Data FROM GoodsMovements ORDER BY Document;`, &movements); err != nil {
+		return nil, nil, nil, fmt.Errorf("loading material documents: %w", err)
+	}
+	return stock, reservations, movements, nil
+}
+
+// queueUpdate stores a SPARQL update waiting for GraphDB and numbers it.
+func (s *orderStore) queueUpdate(u *sparqlUpdate) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec(`INSERT INTO SPARQLUpdates (Data) VALUES (?);`, string(data))
+	if err != nil {
+		return fmt.Errorf("queueing SPARQL update: %w", err)
+	}
+	u.ID, err = res.LastInsertId()
+	return err
+}
+
+// saveUpdate replaces a queued SPARQL update, after a failed attempt.
+func (s *orderStore) saveUpdate(u *sparqlUpdate) error {
+	if s == nil {
+		return nil
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`UPDATE SPARQLUpdates SET Data = ? WHERE ID = ?;`, string(data), u.ID); err != nil {
+		return fmt.Errorf("saving SPARQL update %d: %w", u.ID, err)
+	}
+	return nil
+}
+
+// dropUpdate removes a SPARQL update from the queue.
+func (s *orderStore) dropUpdate(id int64) error {
+	if s == nil {
+		return nil
+	}
+	if _, err := s.db.Exec(`DELETE FROM SPARQLUpdates WHERE ID = ?;`, id); err != nil {
+		return fmt.Errorf("removing SPARQL update %d: %w", id, err)
+	}
+	return nil
+}
+
+// loadUpdates returns the queued SPARQL updates, oldest first.
+func (s *orderStore) loadUpdates() ([]*sparqlUpdate, error) {
+	if s == nil {
+		return nil, nil
+	}
+	rows, err := s.db.Query(`SELECT ID, Data FROM SPARQLUpdates ORDER BY ID;`)
+	if err != nil {
+		return nil, fmt.Errorf("querying SPARQL updates: %w", err)
+	}
+	defer rows.Close()
+	var updates []*sparqlUpdate
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, fmt.Errorf("scanning SPARQL update: %w", err)
+		}
+		u := new(sparqlUpdate)
+		if err := json.Unmarshal([]byte(data), u); err != nil {
+			return nil, fmt.Errorf("decoding SPARQL update %d: %w", id, err)
+		}
+		u.ID = id
+		updates = append(updates, u)
+	}
+	return updates, rows.Err()
+}
+
+// loadJSON decodes the single JSON column of each row of query into an
+// element appended to *out.
+func loadJSON[T any](db *sql.DB, query string, out *[]T) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		var v T
+		if err := json.Unmarshal([]byte(data), &v); err != nil {
+			return err
+		}
+		*out = append(*out, v)
+	}
+	return rows.Err()
+}
+
+// close releases the database.
+func (s *orderStore) close() {
+	if s != nil {
+		s.db.Close()
+	}
+}
@@ -0,0 +1,92 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storedTraits returns test traits persisting to the database at path, with
+// the orders already stored there restored.
+func storedTraits(t *testing.T, path string) *Traits {
+	t.Helper()
+	store, err := openOrderStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.close)
+	tr := quietTraits()
+	tr.store = store
+	if err := tr.restoreOrders(); err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestOrdersSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+
+	tr := storedTraits(t, path)
+	o := releasedOrder(t, tr)
+	tr.confirm(ConfirmationRequest{OrderID: o.ID, OperationID: "0010", ActualWork: 1.5, Final: true})
+	tr.updateStatus(StatusRequest{OrderID: o.ID, AddUserStatus: []string{"INPR"}})
+	crtd := tr.createOrder(OrderRequest{EquipmentID: "E2", Plant: "1000", Description: "waiting"})
+
+	// Restart
+	tr = storedTraits(t, path)
+	tr.mu.Lock()
+	got, ok := tr.orders[o.ID]
+	_, crtdOK := tr.orders[crtd.ID]
+	tr.mu.Unlock()
+	if !ok || !crtdOK {
+		t.Fatalf("orders after restart: %v", tr.orders)
+	}
+	if got.Status != "PCNF" || len(got.Confirmations) != 1 || got.Confirmations[0].ActualWork != 1.5 ||
+		len(got.UserStatuses) != 1 || len(got.History) != 4 || got.Request.Operations[1].OperationID != "0020" {
+		t.Errorf("restored order = %+v", got)
+	}
+	if id := tr.nextOrderID(); id <= crtd.ID {
+		t.Errorf("next order ID %s does not follow the stored %s", id, crtd.ID)
+	}
+}
+
+func TestScheduledTransitionSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+
+	tr := storedTraits(t, path)
+	o := releasedOrder(t, tr) // TECO due in an hour
+	past := time.Now().Add(-time.Minute)
+	tr.mu.Lock()
+	o.Scheduled[0].Due = past // fell due while the Sapper was down
+	tr.saveOrder(o)
+	delete(tr.orders, o.ID) // the old timer finds nothing
+	tr.mu.Unlock()
+
+	tr = storedTraits(t, path)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		tr.mu.Lock()
+		status := tr.orders[o.ID].Status
+		tr.mu.Unlock()
+		if status == "TECO" {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Error("the overdue TECO did not fire after restart")
+}
@@ -25,6 +25,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -70,8 +71,8 @@ const firefightingHTML = `<!DOCTYPE html>
   <h1>Sapper · Firefighting</h1>
   <p>Work orders awaiting planner enrichment and release. Pick an order, edit
      the operations JSON to match the field decision, then submit. The order
-     transitions <code>CRTD&nbsp;→&nbsp;REL</code> and the 30 s TECO countdown
-     begins.</p>
+     transitions <code>CRTD&nbsp;→&nbsp;REL</code> and TECO is scheduled after
+     the completion delay.</p>
 
   {{if .Flash}}<div class="flash {{.FlashKind}}">{{.Flash}}</div>{{end}}
 
@@ -228,10 +229,24 @@ var firefightingTemplate = template.Must(template.New("firefighting").Parse(fire
 
 // Traits holds the configurable parameters for the sapper unit asset.
 type Traits struct {
-	CompletionDelay time.Duration `json:"completionDelay"` // stored as seconds; multiplied by time.Second at runtime
-	GraphDBURL      string        `json:"graphDbUrl"`      // SPARQL update endpoint; empty = disabled
+	CompletionDelay time.Duration  `json:"completionDelay"`           // stored as seconds; multiplied by time.Second at runtime
+	GraphDBURL      string         `json:"graphDbUrl"`                // SPARQL update endpoint; empty = disabled
+	Database        string         `json:"database"`                  // SQLite file holding the orders; empty = in memory only
+	UserStatuses    []string       `json:"userStatuses,omitempty"`    // status profile: user statuses planners may set
+	Backend         string         `json:"backend,omitempty"`         // "simulation" (default) or "sap"
+	SAP             *SAPConfig     `json:"sap,omitempty"`             // the SAP system of the sap backend
+	Inventory       []GoodsReceipt `json:"inventory,omitempty"`       // initial stock of the simulated MM
+	SPARQLTemplates string         `json:"sparqlTemplates,omitempty"` // directory of .rq templates replacing the built-in ones
+	SPARQLDryRun    string         `json:"sparqlDryRun,omitempty"`    // file the SPARQL updates are appended to instead of posted
 	orders          map[string]*Order
+	store           *orderStore      // persists orders; nil = not persisted
+	sap             *sapClient       // forwards orders to SAP; nil = simulation
+	inv             *inventory       // simulated MM; nil = no stock kept
+	queries         *sparqlTemplates // nil = the built-in templates
+	updates         *updateQueue     // updates GraphDB could not take; nil = not retried
+	dryRunMu        sync.Mutex
 	mu              sync.Mutex
+	createMu        sync.Mutex   // serializes the creation of orders with an idempotency key
 	seq             atomic.Int64 // monotonic counter for order IDs
 	primeOnce       sync.Once    // guards a single graph-peek before the first order is allocated
 	monitor         *components.Cervice
@@ -258,6 +273,50 @@ func initTemplate() *components.UnitAsset {
 		RegPeriod:   30,
 		Description: "planner UI (GET) to enrich and release CRTD work orders (POST)",
 	}
+	listService := components.Service{
+		Definition:  "MaintenanceOrderList",
+		SubPath:     "orderlist",
+		Details:     map[string][]string{"Forms": {"application/json"}},
+		RegPeriod:   30,
+		Description: "lists maintenance orders (GET), filtered by status, plant, equipment, location and creation date",
+	}
+	statusService := components.Service{
+		Definition:  "MaintenanceOrderStatus",
+		SubPath:     "orderstatus",
+		Details:     map[string][]string{"Forms": {"application/json"}},
+		RegPeriod:   30,
+		Description: "reports (GET ?id=<orderID>) and changes or schedules (POST) the system and user statuses of an order",
+	}
+	confirmationService := components.Service{
+		Definition:  "OrderConfirmation",
+		SubPath:     "confirmations",
+		Details:     map[string][]string{"Forms": {"application/json"}},
+		RegPeriod:   30,
+		Description: "lists (GET ?id=<orderID>) and posts (POST) time confirmations of an order's operations",
+	}
+
+	workbenchService := components.Service{
+		Definition:  "PlannerWorkbench",
+		SubPath:     "workbench",
+		Details:     map[string][]string{"Forms": {"text/html"}},
+		RegPeriod:   30,
+		Description: "planner UI: order queue with filters and bulk release, and per order operations editing, release, deferral, rejection and timeline",
+	}
+
+	stockService := components.Service{
+		Definition:  "MaterialStock",
+		SubPath:     "stock",
+		Details:     map[string][]string{"Forms": {"application/json"}},
+		RegPeriod:   30,
+		Description: "lists the simulated stock with its reserved and available quantities (GET) and posts goods receipts (POST)",
+	}
+	reservationService := components.Service{
+		Definition:  "MaterialReservation",
+		SubPath:     "reservations",
+		Details:     map[string][]string{"Forms": {"application/json"}},
+		RegPeriod:   30,
+		Description: "lists the material reservations of released orders (GET ?order=&material=&status=)",
+	}
 
 	return &components.UnitAsset{
 		Name:    "SAPSimulator",
@@ -265,9 +324,21 @@ func initTemplate() *components.UnitAsset {
 		ServicesMap: components.Services{
 			ordersService.SubPath:       &ordersService,
 			firefightingService.SubPath: &firefightingService,
+			listService.SubPath:         &listService,
+			statusService.SubPath:       &statusService,
+			confirmationService.SubPath: &confirmationService,
+			workbenchService.SubPath:    &workbenchService,
+			stockService.SubPath:        &stockService,
+			reservationService.SubPath:  &reservationService,
 		},
 		Traits: &Traits{
 			CompletionDelay: 30, // 30 × time.Second = 30 s
+			Database:        "orders.db",
+			UserStatuses:    defaultUserStatuses,
+			Backend:         backendSimulation,
+			Inventory: []GoodsReceipt{
+				{Material: "VALVE-GASKET-V50", Description: "Valve gasket set", Plant: "1000", StorageLocation: "0001", Quantity: 20, Unit: "EA"},
+			},
 		},
 	}
 }
@@ -287,6 +358,56 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 		}
 	}
 
+	// Orders survive restarts in the database; their scheduled transitions
+	// are re-armed, and those that fell due while the Sapper was down fire now.
+	// The simulated inventory is restored first, as those may issue goods.
+	if t.Database != "" {
+		store, err := openOrderStore(t.Database)
+		if err != nil {
+			log.Fatalf("sapper: %v", err)
+		}
+		t.store = store
+	}
+	if t.Backend == "" || t.Backend == backendSimulation {
+		if err := t.restoreInventory(); err != nil {
+			log.Fatalf("sapper: restoring inventory: %v", err)
+		}
+	}
+	if err := t.restoreOrders(); err != nil {
+		log.Fatalf("sapper: restoring orders: %v", err)
+	}
+
+	// The graph updates are rendered from templates, checked now rather
+	// than at the first order. Those GraphDB could not take are retried.
+	t.queries = newSPARQLTemplates(t.SPARQLTemplates)
+	if err := t.queries.check(); err != nil {
+		log.Fatalf("sapper: %v", err)
+	}
+	if err := t.restoreUpdates(); err != nil {
+		log.Fatalf("sapper: restoring SPARQL updates: %v", err)
+	}
+	if t.updateEndpoint() != "" && t.SPARQLDryRun == "" {
+		go t.retrySPARQL(sys.Ctx)
+	}
+
+	// In adapter mode the orders live in SAP: they are created there and
+	// their statuses read back periodically.
+	switch t.Backend {
+	case "", backendSimulation:
+	case backendSAP:
+		if t.SAP == nil {
+			log.Fatalf("sapper: the sap backend needs a \"sap\" configuration")
+		}
+		client, err := newSAPClient(*t.SAP)
+		if err != nil {
+			log.Fatalf("sapper: %v", err)
+		}
+		t.sap = client
+		go t.pollSAP(sys.Ctx)
+	default:
+		log.Fatalf("sapper: unknown backend %q", t.Backend)
+	}
+
 	// Build cervices so the sapper can discover, at runtime, the nurse's
 	// callback endpoints: SignalMonitoring (TECO completion) and
 	// EnrichmentNotification (planner's release with enrichment payload).
@@ -326,6 +447,7 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 
 	return ua, func() {
 		log.Printf("disconnecting from %s\n", ua.Name)
+		t.store.close()
 	}
 }
 
@@ -513,10 +635,16 @@ func (t *Traits) nextNotifID() string {
 	return fmt.Sprintf("2%08d", n)
 }
 
-// createOrder stores a new order in CRTD status. It does NOT start the
-// lifecycle countdown — the order waits in CRTD until a planner enriches and
+// createOrder stores a new order in CRTD status. It does NOT schedule
+// any transition — the order waits in CRTD until a planner enriches and
 // releases it through the firefighting UI.
 func (t *Traits) createOrder(req OrderRequest) *Order {
+	return t.recordOrder(req, t.nextOrderID(), t.nextNotifID())
+}
+
+// recordOrder stores a new CRTD order under the given order and notification
+// numbers, allocated by the simulator or by SAP.
+func (t *Traits) recordOrder(req OrderRequest, orderID, notification string) *Order {
 	now := time.Now()
 	currentPart := ""
 	if req.FunctionalLocationIRI != "" {
@@ -527,40 +655,40 @@ func (t *Traits) createOrder(req OrderRequest) *Order {
 			log.Printf("sapper: FL %s currently has part %s fitted", req.FunctionalLocationIRI, currentPart)
 		}
 	}
-	orderID := t.nextOrderID()
 	o := &Order{
 		ID:                  orderID,
-		Notification:        t.nextNotifID(),
-		Status:              "CRTD",
+		Notification:        notification,
+		Status:              statusCreated,
 		CreatedAt:           now,
 		Request:             req,
 		CurrentPartIRI:      currentPart,
 		SuggestedEnrichment: buildOrderEnrichmentTemplate(currentPart, orderID, now),
+		History:             []StatusChange{{Status: statusCreated, At: now}},
 	}
 	t.mu.Lock()
 	t.orders[o.ID] = o
+	t.saveOrder(o)
 	t.mu.Unlock()
 	return o
 }
 
-// enrichAndRelease attaches the planner's enrichment to the order, transitions
-// it to REL, records the release in GraphDB, notifies the nurse with the
-// enrichment payload, and starts the TECO countdown. Returns false if the
-// order does not exist or is not in CRTD status.
+// enrichAndRelease attaches the planner's enrichment to the order and
+// transitions it to REL, which records the release in GraphDB, notifies the
+// nurse with the enrichment payload, and schedules the technical completion.
+// It returns a copy of the released order, or an error if the order does not exist or is not in CRTD status.
 func (t *Traits) enrichAndRelease(orderID string, enrichment json.RawMessage) (*Order, error) {
 	t.mu.Lock()
 	o, ok := t.orders[orderID]
 	if !ok {
 		t.mu.Unlock()
-		return nil, fmt.Errorf("order %s not found", orderID)
+		return nil, fmt.Errorf("order %s: %w", orderID, errOrderNotFound)
 	}
-	if o.Status != "CRTD" {
+	if o.Status != statusCreated {
 		t.mu.Unlock()
 		return nil, fmt.Errorf("order %s is %s, only CRTD orders can be released", orderID, o.Status)
 	}
-	o.Status = "REL"
-	o.ReleasedAt = time.Now()
 	o.Enrichment = enrichment
+	o.UserStatuses = slices.DeleteFunc(o.UserStatuses, func(us string) bool { return us == userStatusDeferred })
 	// Parse the structured fields. A parse failure isn't fatal — the TECO
 	// path falls back to the simpler status-only update when ParsedEnrichment
 	// is nil or doesn't carry the fields a Repair/Replace template needs.
@@ -570,32 +698,17 @@ func (t *Traits) enrichAndRelease(orderID string, enrichment json.RawMessage) (*
 	} else {
 		log.Printf("enrichAndRelease: order %s enrichment is not structured (%v); TECO will use fallback template", o.ID, err)
 	}
-	t.mu.Unlock()
-	log.Printf("order %s → REL (planner authorised)\n", o.ID)
-
-	go t.insertReleaseToGraphDB(o)
-	go t.notifyEnrichment(o)
-	go t.runTECOCountdown(o)
-	return o, nil
-}
-
-// runTECOCountdown sleeps the configured completion delay and then transitions
-// the order to TECO. Called from enrichAndRelease — the countdown is measured
-// from the planner's release, not from order creation.
-func (t *Traits) runTECOCountdown(o *Order) {
-	delay := t.CompletionDelay * time.Second
-	if delay <= 0 {
-		delay = 30 * time.Second // safe default
+	effects, err := t.changeStatus(o, statusReleased, "(planner authorised)", time.Now())
+	if err != nil {
+		t.mu.Unlock()
+		return nil, err
 	}
-	time.Sleep(delay)
-
-	t.mu.Lock()
-	o.Status = "TECO"
+	t.saveOrder(o)
+	released := *o // as released, while the lifecycle moves on
 	t.mu.Unlock()
-	log.Printf("order %s → TECO\n", o.ID)
 
-	go t.insertCompletionToGraphDB(o)
-	t.notifyConsumer(o)
+	effects()
+	return &released, nil
 }
 
 // discoverMonitor is a variable so tests can substitute a fake implementation
@@ -614,6 +727,16 @@ var discoverEnrichment = func(c *components.Cervice, sys *components.System) err
 // notifyConsumer discovers the SignalMonitoring endpoint via Arrowhead and POSTs
 // the completion event.
 func (t *Traits) notifyConsumer(o *Order) {
+	t.notifyConsumerOf(o, statusTechDone, "Completed by SAP simulator")
+}
+
+// notifyConsumerOf POSTs an end of the order to the consumer: its technical
+// completion, or its closure when a planner rejects it.
+func (t *Traits) notifyConsumerOf(o *Order, status, notes string) {
+	if t.monitor == nil {
+		log.Printf("notifyConsumer: no monitor cervice for order %s\n", o.ID)
+		return
+	}
 	if err := discoverMonitor(t.monitor, t.owner); err != nil {
 		log.Printf("notifyConsumer: discovery failed for order %s: %v\n", o.ID, err)
 		return
@@ -633,12 +756,18 @@ func (t *Traits) notifyConsumer(o *Order) {
 	}
 
 	now := time.Now()
+	t.mu.Lock()
+	hours := actualWorkHours(o)
+	t.mu.Unlock()
+	if hours == 0 && status == statusTechDone {
+		hours = float64(t.CompletionDelay) / 3600 // nothing confirmed: the simulated work time
+	}
 	event := CompletionEvent{
 		OrderID:         o.ID,
-		Status:          "TECO",
+		Status:          status,
 		CompletedAt:     &now,
-		ActualWorkHours: float64(t.CompletionDelay) / 3600,
-		Notes:           "Completed by SAP simulator",
+		ActualWorkHours: hours,
+		Notes:           notes,
 	}
 	body, err := json.Marshal(event)
 	if err != nil {
@@ -659,7 +788,12 @@ func (t *Traits) notifyConsumer(o *Order) {
 
 //-------------------------------------GraphDB SPARQL insert
 
-// buildSPARQL constructs the SPARQL UPDATE statement for a newly created order.
+// orderIRI returns the IRI of an order in the workorders graph.
+func orderIRI(orderID string) string {
+	return "https://sinetiq.se/sap/MaintenanceOrder/" + orderID
+}
+
+// buildSPARQL renders the SPARQL UPDATE for a newly created order.
 // URI shapes follow Triona's convention `<base>/<Type>_<ID>` so tools that
 // expect `WorkRequestAssignment_<notifID>`, `ID_<orderID>`, etc. find our
 // data through the same patterns. The WorkRequestAssignment block — which
@@ -667,89 +801,23 @@ func (t *Traits) notifyConsumer(o *Order) {
 // emitted when the consumer supplied an IRI; orders raised by consumers that
 // don't resolve an FL IRI still get a WorkOrder and WorkRequest, just no
 // graph-side asset linkage.
-func (t *Traits) buildSPARQL(o *Order) string {
-	const woBase = "https://sinetiq.se/sap/MaintenanceOrder/"
+func (t *Traits) buildSPARQL(o *Order) (string, error) {
 	const wrBase = "https://sinetiq.se/sap/MaintenanceNotification/"
-	orderURI := woBase + o.ID
-	orderIDURI := woBase + "ID_" + o.ID
-	orderDescURI := woBase + "Description_" + o.ID
-	notifURI := wrBase + o.Notification
-	notifIDURI := wrBase + "ID_" + o.Notification
-	notifDescURI := wrBase + "Description_" + o.Notification
-	wraURI := wrBase + "WorkRequestAssignment_" + o.Notification
-	createdAt := o.CreatedAt.UTC().Format(time.RFC3339Nano)
-	desc := strings.ReplaceAll(o.Request.Description, `"`, `\"`) // escape any quotes
-
-	wraBlock := ""
-	if o.Request.FunctionalLocationIRI != "" {
-		wraBlock = fmt.Sprintf(`
-        # step:WorkRequestAssignment
-        <%s> a step:WorkRequestAssignment ;
-            workrequestassignment:AssignedTo <%s> ;
-            workrequestassignment:AssignedWorkRequest <%s> .`,
-			wraURI, o.Request.FunctionalLocationIRI, notifURI)
-	}
-
-	return fmt.Sprintf(`PREFIX rdfs: <http://www.w3.org/2000/01/rdf-schema#>
-PREFIX ex: <https://sinetiq.se/sap/>
-PREFIX schema: <http://schema.org/>
-PREFIX dcterms: <http://purl.org/dc/terms/>
-PREFIX xsd: <http://www.w3.org/2001/XMLSchema#>
-PREFIX step: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/>
-PREFIX workorder: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/WorkOrder#>
-PREFIX workrequest: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/WorkRequest#>
-PREFIX workrequestassignment: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/WorkRequestAssignment#>
-PREFIX identifier: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/Identifier#>
-INSERT
-{
-    GRAPH<https://arrowheadweb.org/graph/sap/workorders>
-    {
-        # step:WorkOrder
-        <%s> a step:WorkOrder ;
-            workorder:Id <%s> ;
-            workorder:Description <%s> ;
-            ex:status "%s" ;
-            dcterms:created "%s"^^xsd:dateTime ;
-            workorder:InResponseTo <%s> .
-
-        <%s> a step:Identifier ;
-            identifier:Id "%s" .
-
-        <%s> a step:LocalizedString ;
-            rdfs:label "Maintenance order created successfully"@en .
-
-        # step:WorkRequest
-        <%s> a step:WorkRequest ;
-            workrequest:Id <%s> ;
-            workrequest:Description <%s> ;
-            dcterms:isPartOf <%s> .
-
-        <%s> a step:Identifier ;
-            identifier:Id "%s" .
-
-        <%s> a step:LocalizedString ;
-            rdfs:label "%s"@en .%s
-    }
-}
-where
-{
-}`,
-		// WorkOrder
-		orderURI, orderIDURI, orderDescURI,
-		o.Status, createdAt, notifURI,
-		// WorkOrder Identifier
-		orderIDURI, o.ID,
-		// WorkOrder Description
-		orderDescURI,
-		// WorkRequest
-		notifURI, notifIDURI, notifDescURI, orderURI,
-		// WorkRequest Identifier
-		notifIDURI, o.Notification,
-		// WorkRequest Description
-		notifDescURI, desc,
-		// Optional WorkRequestAssignment
-		wraBlock,
-	)
+	return t.renderSPARQL("order-created", o, sparqlParams{
+		"workOrder":              orderIRI(o.ID),
+		"workOrderId":            orderIRI("ID_" + o.ID),
+		"workOrderDescription":   orderIRI("Description_" + o.ID),
+		"orderNumber":            o.ID,
+		"status":                 o.Status,
+		"created":                o.CreatedAt,
+		"workRequest":            wrBase + o.Notification,
+		"workRequestId":          wrBase + "ID_" + o.Notification,
+		"workRequestDescription": wrBase + "Description_" + o.Notification,
+		"notificationNumber":     o.Notification,
+		"description":            o.Request.Description,
+		"workRequestAssignment":  wrBase + "WorkRequestAssignment_" + o.Notification,
+		"functionalLocation":     o.Request.FunctionalLocationIRI,
+	})
 }
 
 // buildRELInsertSPARQL records the planner's release of an order. The status
@@ -757,29 +825,22 @@ where
 // shows the "current" status by reading a single ex:status triple, so an
 // additive update would leave the order looking still-CRTD even after release.
 // dcterms:modified is set so the UI can show a last-modified timestamp.
-func (t *Traits) buildRELInsertSPARQL(o *Order) string {
-	orderURI := "https://sinetiq.se/sap/MaintenanceOrder/" + o.ID
-	releasedAt := o.ReleasedAt.UTC().Format(time.RFC3339Nano)
-	return fmt.Sprintf(`PREFIX ex: <https://sinetiq.se/sap/>
-PREFIX xsd: <http://www.w3.org/2001/XMLSchema#>
-PREFIX dcterms: <http://purl.org/dc/terms/>
-
-DELETE { GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-    <%s> ex:status ?old .
-}} WHERE { GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-    <%s> ex:status ?old .
-}};
-
-INSERT DATA { GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-    <%s> ex:status "REL" ;
-        ex:releasedAt "%s"^^xsd:dateTime ;
-        dcterms:modified "%s"^^xsd:dateTime .
-}}`, orderURI, orderURI, orderURI, releasedAt, releasedAt)
+func (t *Traits) buildRELInsertSPARQL(o *Order) (string, error) {
+	return t.renderSPARQL("release", o, sparqlParams{"workOrder": orderIRI(o.ID), "releasedAt": o.ReleasedAt})
 }
 
-// insertReleaseToGraphDB pushes the REL transition to GraphDB.
-func (t *Traits) insertReleaseToGraphDB(o *Order) {
-	t.postSPARQL("REL", o.ID, t.buildRELInsertSPARQL(o))
+// buildStatusSPARQL records any other status change of an order, replacing
+// the previous status triple as buildRELInsertSPARQL does. Closing stamps
+// ex:closedAt; a revoked TECO (back to REL) drops the completion dates.
+func (t *Traits) buildStatusSPARQL(o *Order, status string, at time.Time) (string, error) {
+	if status == statusReleased {
+		return t.renderSPARQL("teco-revoke", o, sparqlParams{"workOrder": orderIRI(o.ID), "modified": at})
+	}
+	params := sparqlParams{"workOrder": orderIRI(o.ID), "status": status, "modified": at}
+	if status == statusClosed {
+		params["closedAt"] = at
+	}
+	return t.renderSPARQL("status", o, params)
 }
 
 // notifyEnrichment posts the planner's enrichment payload to the nurse's
@@ -836,34 +897,28 @@ func (t *Traits) notifyEnrichment(o *Order) {
 // update, or when the order's FunctionalLocationIRI couldn't be resolved.
 // Keeps the demo's loop intact even without Triona's part graphs being
 // populated for the FL we targeted.
-func (t *Traits) buildTECOFallbackSPARQL(o *Order) string {
-	orderURI := "https://sinetiq.se/sap/MaintenanceOrder/" + o.ID
-	descURI := "https://sinetiq.se/sap/MaintenanceOrder/Description_" + o.ID
-	completedAt := time.Now().UTC().Format(time.RFC3339Nano)
-	actualHours := float64(t.CompletionDelay) / 3600.0
-	return fmt.Sprintf(`PREFIX ex: <https://sinetiq.se/sap/>
-PREFIX xsd: <http://www.w3.org/2001/XMLSchema#>
-PREFIX dcterms: <http://purl.org/dc/terms/>
-PREFIX rdfs: <http://www.w3.org/2000/01/rdf-schema#>
-
-DELETE { GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-    <%s> ex:status ?oldStatus .
-    <%s> rdfs:label ?oldLabel .
-}} WHERE { GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-    OPTIONAL { <%s> ex:status ?oldStatus }
-    OPTIONAL { <%s> rdfs:label ?oldLabel }
-}};
-
-INSERT DATA { GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-    <%s> ex:status "TECO" ;
-        ex:completedAt "%s"^^xsd:dateTime ;
-        dcterms:modified "%s"^^xsd:dateTime ;
-        ex:actualWorkHours "%g"^^xsd:decimal .
-    <%s> rdfs:label "Maintenance order completed"@en .
-}}`,
-		orderURI, descURI,
-		orderURI, descURI,
-		orderURI, completedAt, completedAt, actualHours, descURI)
+func (t *Traits) buildTECOFallbackSPARQL(o *Order, at time.Time) (string, error) {
+	return t.renderSPARQL("teco", o, sparqlParams{
+		"workOrder":            orderIRI(o.ID),
+		"workOrderDescription": orderIRI("Description_" + o.ID),
+		"completedAt":          at,
+		"actualWorkHours":      float64(t.CompletionDelay) / 3600.0,
+	})
+}
+
+// tecoActivityParams are the parameters shared by the Repair and Replace
+// TECO templates, named after the variables of Triona's
+// WorkOrder_*_Dates.ttl examples they mirror.
+func tecoActivityParams(o *Order, e *Enrichment, at time.Time) sparqlParams {
+	return sparqlParams{
+		"workOrder":                   orderIRI(o.ID),
+		"workOrderAssignment":         orderIRI("WorkOrderAssignment_" + o.ID),
+		"individualPart":              e.CurrentPart,
+		"technicalCompletionDateTime": at,
+		"modifiedDateTime":            at,
+		"activityStartDateTime":       e.ActivityStart,
+		"activityEndDateTime":         e.ActivityEnd,
+	}
 }
 
 // buildTECORepairSPARQL produces the Repair-variant TECO update — mirror of
@@ -878,164 +933,8 @@ INSERT DATA { GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
 // is in fact currently fitted at the same breakdown item the work request
 // points at — a graph-side integrity check that the planner picked a
 // sensible part.
-func (t *Traits) buildTECORepairSPARQL(o *Order, e *Enrichment) string {
-	orderURI := "https://sinetiq.se/sap/MaintenanceOrder/" + o.ID
-	woAssignURI := "https://sinetiq.se/sap/MaintenanceOrder/WorkOrderAssignment_" + o.ID
-	completedAt := time.Now().UTC().Format(time.RFC3339Nano)
-
-	return fmt.Sprintf(`PREFIX : <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/Core#>
-PREFIX ex: <https://sinetiq.se/sap/>
-PREFIX dcterms: <http://purl.org/dc/terms/>
-PREFIX rdfs: <http://www.w3.org/2000/01/rdf-schema#>
-PREFIX step: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/>
-PREFIX workorder: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/WorkOrder#>
-PREFIX wra: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/WorkRequestAssignment#>
-PREFIX dpa: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/DirectedPlannedActivity#>
-PREFIX ahr: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/ActivityHappeningRelationship#>
-PREFIX aa: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/ActivityAssignment#>
-PREFIX actualactivity: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/ActualActivity#>
-PREFIX eff: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/EffectivityAssignment#>
-PREFIX de: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/DatedEffectivity#>
-PREFIX ber: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/BreakdownElementRealization#>
-PREFIX pva: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/PartViewToIndividualPartViewAssociation#>
-PREFIX woa: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/WorkOrderAssignment#>
-PREFIX xsd: <http://www.w3.org/2001/XMLSchema#>
-
-DELETE {
-    GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-        ?workOrder ex:status ?oldStatus .
-        ?workOrder ex:technicalCompletionDate ?oldTechnicalCompletionDate .
-        ?workOrder dcterms:modified ?oldModified .
-        ?workOrderDescription rdfs:label ?oldDescriptionLabel .
-    }
-}
-INSERT {
-    GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-        ?workOrder
-            ex:status ?newStatus ;
-            ex:technicalCompletionDate ?technicalCompletionDateTime ;
-            dcterms:modified ?modifiedDateTime .
-
-        ?workOrderDescription rdfs:label "Maintenance order completed (repair)"@en .
-
-        ?directedPlannedActivity
-            a step:DirectedPlannedActivity ;
-            dpa:Directive ?workOrder .
-
-        ?actualActivity
-            a step:ActualActivity ;
-            actualactivity:ActualStartDate [
-                a step:DateTimeString, step:String ;
-                :value ?activityStartDateTime
-            ] ;
-            actualactivity:ActualEndDate [
-                a step:DateTimeString, step:String ;
-                :value ?activityEndDateTime
-            ] .
-
-        ?activityHappeningRelationship
-            a step:ActivityHappeningRelationship ;
-            ahr:Relating ?directedPlannedActivity ;
-            ahr:Related ?actualActivity .
-
-        ?activityAssignmentRepairedPart
-            a step:ActivityAssignment ;
-            aa:AssignedTo ?individualPart ;
-            aa:AssignedActivity ?actualActivity .
-
-        ?activityAssignmentRepairActivityEffectivity
-            a step:ActivityAssignment ;
-            aa:AssignedTo ?repairActivityEffectivity ;
-            aa:AssignedActivity ?actualActivity .
-
-        ?workOrderAssignment
-            a step:WorkOrderAssignment ;
-            woa:AssignedTo ?breakdownItem ;
-            woa:AssignedWorkOrder ?workOrder .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/effectivity> {
-        ?repairActivityEffectivity
-            a step:EffectivityAssignment ;
-            eff:AssignedTo ?actualActivity ;
-            eff:AssignedEffectivity ?repairActivityDatedEffectivity ;
-            eff:EffectivityIndication step:True .
-
-        ?repairActivityDatedEffectivity
-            a step:DatedEffectivity ;
-            de:StartDefinition ?repairActivityStartDateTimeString ;
-            de:EndDefinition ?repairActivityEndDateTimeString .
-
-        ?repairActivityStartDateTimeString
-            a step:DateTimeString, step:String ;
-            :value ?activityStartDateTime .
-
-        ?repairActivityEndDateTimeString
-            a step:DateTimeString, step:String ;
-            :value ?activityEndDateTime .
-    }
-}
-WHERE {
-    BIND(IRI("%s") AS ?workOrder)
-    BIND(IRI("%s") AS ?workOrderAssignment)
-    BIND(IRI("%s") AS ?individualPart)
-
-    BIND("TECO" AS ?newStatus)
-    BIND("%s"^^xsd:dateTime AS ?technicalCompletionDateTime)
-    BIND("%s"^^xsd:dateTime AS ?modifiedDateTime)
-    BIND("%s"^^xsd:dateTime AS ?activityStartDateTime)
-    BIND("%s"^^xsd:dateTime AS ?activityEndDateTime)
-
-    BIND(REPLACE(STR(?workOrder), "^.*/", "") AS ?workOrderId)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/Description_", ?workOrderId)) AS ?workOrderDescription)
-
-    OPTIONAL { GRAPH <https://arrowheadweb.org/graph/sap/workorders> { ?workOrder ex:status ?oldStatus } }
-    OPTIONAL { GRAPH <https://arrowheadweb.org/graph/sap/workorders> { ?workOrder ex:technicalCompletionDate ?oldTechnicalCompletionDate } }
-    OPTIONAL { GRAPH <https://arrowheadweb.org/graph/sap/workorders> { ?workOrder dcterms:modified ?oldModified } }
-    OPTIONAL { GRAPH <https://arrowheadweb.org/graph/sap/workorders> { ?workOrderDescription rdfs:label ?oldDescriptionLabel } }
-
-    GRAPH <https://arrowheadweb.org/graph/parts> {
-        ?individualPart a step:IndividualPartView .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/associations> {
-        ?partRealization
-            pva:AssociatedIndividualPart ?individualPart ;
-            pva:AssociatedPart ?part .
-        ?breakdownRealization
-            ber:RealizedAs ?individualPart ;
-            ber:BreakdownItem ?breakdownItem .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-        ?workOrder a step:WorkOrder ;
-            workorder:InResponseTo ?workRequest .
-        ?workRequestAssignment
-            a step:WorkRequestAssignment ;
-            wra:AssignedWorkRequest ?workRequest ;
-            wra:AssignedTo ?breakdownItem .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/effectivity> {
-        ?effCurrent
-            eff:AssignedTo ?breakdownRealization ;
-            eff:AssignedEffectivity ?currentDatedEffectivity .
-        FILTER NOT EXISTS { ?currentDatedEffectivity de:EndDefinition ?_existingEndDefinition }
-    }
-
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/DirectedPlannedActivity_", ?workOrderId)) AS ?directedPlannedActivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/ActivityHappeningRelationship_", ?workOrderId)) AS ?activityHappeningRelationship)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/ActualActivity_", ?workOrderId)) AS ?actualActivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/ActivityAssignment_", ?workOrderId, "_RepairedPart")) AS ?activityAssignmentRepairedPart)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/ActivityAssignment_", ?workOrderId, "_RepairActivityEffectivity")) AS ?activityAssignmentRepairActivityEffectivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/EffectivityAssignment_", ?workOrderId, "_RepairActivity")) AS ?repairActivityEffectivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/DatedEffectivity_", ?workOrderId, "_RepairActivity")) AS ?repairActivityDatedEffectivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/DateTimeString_Start_", ?workOrderId, "_RepairActivity")) AS ?repairActivityStartDateTimeString)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/DateTimeString_End_", ?workOrderId, "_RepairActivity")) AS ?repairActivityEndDateTimeString)
-}`,
-		orderURI, woAssignURI, e.CurrentPart,
-		completedAt, completedAt,
-		e.ActivityStart, e.ActivityEnd)
+func (t *Traits) buildTECORepairSPARQL(o *Order, e *Enrichment, at time.Time) (string, error) {
+	return t.renderSPARQL("teco-repair", o, tecoActivityParams(o, e, at))
 }
 
 // buildTECOReplaceSPARQL produces the Replace-variant TECO update — mirror
@@ -1046,301 +945,61 @@ WHERE {
 // breakdown item and PartView. Significant graph mutation; depends on
 // Triona's part/association/effectivity graphs being correctly populated for
 // the FL in question.
-func (t *Traits) buildTECOReplaceSPARQL(o *Order, e *Enrichment) string {
-	orderURI := "https://sinetiq.se/sap/MaintenanceOrder/" + o.ID
-	woAssignURI := "https://sinetiq.se/sap/MaintenanceOrder/WorkOrderAssignment_" + o.ID
-	completedAt := time.Now().UTC().Format(time.RFC3339Nano)
-
-	return fmt.Sprintf(`PREFIX : <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/Core#>
-PREFIX ex: <https://sinetiq.se/sap/>
-PREFIX dcterms: <http://purl.org/dc/terms/>
-PREFIX rdfs: <http://www.w3.org/2000/01/rdf-schema#>
-PREFIX ido-ext: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/ido-ext#>
-PREFIX step: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/>
-PREFIX workorder: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/WorkOrder#>
-PREFIX wra: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/WorkRequestAssignment#>
-PREFIX dpa: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/DirectedPlannedActivity#>
-PREFIX ahr: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/ActivityHappeningRelationship#>
-PREFIX aa: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/ActivityAssignment#>
-PREFIX actualactivity: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/ActualActivity#>
-PREFIX eff: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/EffectivityAssignment#>
-PREFIX de: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/DatedEffectivity#>
-PREFIX ber: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/BreakdownElementRealization#>
-PREFIX pva: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/PartViewToIndividualPartViewAssociation#>
-PREFIX woa: <http://www.semanticweb.org/ARROWHEADfPVN/ontologies/STEP_AP4K/WorkOrderAssignment#>
-PREFIX xsd: <http://www.w3.org/2001/XMLSchema#>
-
-DELETE {
-    GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-        ?workOrder ex:status ?oldStatus .
-        ?workOrder ex:technicalCompletionDate ?oldTechnicalCompletionDate .
-        ?workOrder dcterms:modified ?oldModified .
-        ?workOrderDescription rdfs:label ?oldDescriptionLabel .
-    }
-}
-INSERT {
-    GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-        ?workOrder
-            ex:status ?newStatus ;
-            ex:technicalCompletionDate ?technicalCompletionDateTime ;
-            dcterms:modified ?modifiedDateTime .
-
-        ?workOrderDescription rdfs:label "Maintenance order completed (replacement)"@en .
-
-        ?directedPlannedActivity
-            a step:DirectedPlannedActivity ;
-            dpa:Directive ?workOrder .
-
-        ?actualActivity
-            a step:ActualActivity ;
-            actualactivity:ActualStartDate [
-                a step:DateTimeString, step:String ;
-                :value ?activityStartDateTime
-            ] ;
-            actualactivity:ActualEndDate [
-                a step:DateTimeString, step:String ;
-                :value ?activityEndDateTime
-            ] .
-
-        ?activityHappeningRelationship
-            a step:ActivityHappeningRelationship ;
-            ahr:Relating ?directedPlannedActivity ;
-            ahr:Related ?actualActivity .
-
-        ?activityAssignmentOutgoingEffectivity
-            a step:ActivityAssignment ;
-            aa:AssignedTo ?effOld ;
-            aa:AssignedActivity ?actualActivity .
-
-        ?activityAssignmentIncomingEffectivity
-            a step:ActivityAssignment ;
-            aa:AssignedTo ?effNew ;
-            aa:AssignedActivity ?actualActivity .
-
-        ?activityAssignmentReplacementActivityEffectivity
-            a step:ActivityAssignment ;
-            aa:AssignedTo ?replacementActivityEffectivity ;
-            aa:AssignedActivity ?actualActivity .
-
-        ?workOrderAssignment
-            a step:WorkOrderAssignment ;
-            woa:AssignedTo ?breakdownItem ;
-            woa:AssignedWorkOrder ?workOrder .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/parts> {
-        ?newIndividualPart a step:IndividualPartView, ido-ext:IndividualPart .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/associations> {
-        ?newRealization
-            a :AssociationObject, step:BreakdownElementRealization ;
-            ber:RealizedAs ?newIndividualPart ;
-            ber:BreakdownItem ?breakdownItem .
-        ?newPvIpvAssociation
-            a :AssociationObject, step:PartViewToIndividualPartViewAssociation ;
-            pva:AssociatedIndividualPart ?newIndividualPart ;
-            pva:AssociatedPart ?part .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/effectivity> {
-        ?effOldEffectivity de:EndDefinition ?oldEndDateTimeString .
-        ?oldEndDateTimeString
-            a step:DateTimeString, step:String ;
-            :value ?activityEndDateTime .
-
-        ?effNew
-            a step:EffectivityAssignment ;
-            eff:AssignedTo ?newRealization ;
-            eff:AssignedEffectivity ?newDatedEffectivity ;
-            eff:EffectivityIndication step:True .
-
-        ?newDatedEffectivity
-            a step:DatedEffectivity ;
-            de:StartDefinition ?newStartDateTimeString .
-
-        ?newStartDateTimeString
-            a step:DateTimeString, step:String ;
-            :value ?activityEndDateTime .
-
-        ?replacementActivityEffectivity
-            a step:EffectivityAssignment ;
-            eff:AssignedTo ?actualActivity ;
-            eff:AssignedEffectivity ?replacementActivityDatedEffectivity ;
-            eff:EffectivityIndication step:True .
-
-        ?replacementActivityDatedEffectivity
-            a step:DatedEffectivity ;
-            de:StartDefinition ?replacementActivityStartDateTimeString ;
-            de:EndDefinition ?replacementActivityEndDateTimeString .
-
-        ?replacementActivityStartDateTimeString
-            a step:DateTimeString, step:String ;
-            :value ?activityStartDateTime .
-
-        ?replacementActivityEndDateTimeString
-            a step:DateTimeString, step:String ;
-            :value ?activityEndDateTime .
-    }
-
-    GRAPH <http://www.arrowhead.org/step-ido-alignments> {
-        ?newIndividualPart ido-ext:implements ?breakdownItem .
-        ?newIndividualPart ido-ext:implements ?part .
-    }
-}
-WHERE {
-    BIND(IRI("%s") AS ?workOrder)
-    BIND(IRI("%s") AS ?workOrderAssignment)
-    BIND(IRI("%s") AS ?individualPart)
-    BIND(IRI("%s") AS ?newIndividualPart)
-
-    BIND("TECO" AS ?newStatus)
-    BIND("%s"^^xsd:dateTime AS ?technicalCompletionDateTime)
-    BIND("%s"^^xsd:dateTime AS ?modifiedDateTime)
-    BIND("%s"^^xsd:dateTime AS ?activityStartDateTime)
-    BIND("%s"^^xsd:dateTime AS ?activityEndDateTime)
-
-    BIND(REPLACE(STR(?workOrder), "^.*/", "") AS ?workOrderId)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/Description_", ?workOrderId)) AS ?workOrderDescription)
-
-    OPTIONAL { GRAPH <https://arrowheadweb.org/graph/sap/workorders> { ?workOrder ex:status ?oldStatus } }
-    OPTIONAL { GRAPH <https://arrowheadweb.org/graph/sap/workorders> { ?workOrder ex:technicalCompletionDate ?oldTechnicalCompletionDate } }
-    OPTIONAL { GRAPH <https://arrowheadweb.org/graph/sap/workorders> { ?workOrder dcterms:modified ?oldModified } }
-    OPTIONAL { GRAPH <https://arrowheadweb.org/graph/sap/workorders> { ?workOrderDescription rdfs:label ?oldDescriptionLabel } }
-
-    GRAPH <https://arrowheadweb.org/graph/parts> {
-        ?individualPart a step:IndividualPartView .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/associations> {
-        ?partRealization
-            pva:AssociatedIndividualPart ?individualPart ;
-            pva:AssociatedPart ?part .
-        ?breakdownRealization
-            ber:RealizedAs ?individualPart ;
-            ber:BreakdownItem ?breakdownItem .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
-        ?workOrder a step:WorkOrder ;
-            workorder:InResponseTo ?workRequest .
-        ?workRequestAssignment
-            a step:WorkRequestAssignment ;
-            wra:AssignedWorkRequest ?workRequest ;
-            wra:AssignedTo ?breakdownItem .
-    }
-
-    GRAPH <https://arrowheadweb.org/graph/effectivity> {
-        ?effOld
-            eff:AssignedTo ?breakdownRealization ;
-            eff:AssignedEffectivity ?effOldEffectivity .
-        FILTER NOT EXISTS { ?effOldEffectivity de:EndDefinition ?_existingEndDefinition }
-    }
-
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/DirectedPlannedActivity_", ?workOrderId)) AS ?directedPlannedActivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/ActivityHappeningRelationship_", ?workOrderId)) AS ?activityHappeningRelationship)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/ActualActivity_", ?workOrderId)) AS ?actualActivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/ActivityAssignment_", ?workOrderId, "_OutgoingEffectivity")) AS ?activityAssignmentOutgoingEffectivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/ActivityAssignment_", ?workOrderId, "_IncomingEffectivity")) AS ?activityAssignmentIncomingEffectivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/ActivityAssignment_", ?workOrderId, "_ReplacementActivityEffectivity")) AS ?activityAssignmentReplacementActivityEffectivity)
-
-    BIND(IRI(CONCAT(STR(?breakdownRealization), "-replacement")) AS ?newRealization)
-    BIND(IRI(CONCAT(STR(?partRealization), "-replacement")) AS ?newPvIpvAssociation)
-
-    BIND(REPLACE(STR(?breakdownRealization), "^.*[/#]", "") AS ?oldRealizationId)
-    BIND(REPLACE(STR(?newRealization), "^.*[/#]", "") AS ?newRealizationId)
-
-    BIND(IRI(CONCAT("https://arrowheadweb.org/data/DateTimeString_End_", ENCODE_FOR_URI(?oldRealizationId))) AS ?oldEndDateTimeString)
-    BIND(IRI(CONCAT("https://arrowheadweb.org/data/EffectivityAssignment_", ENCODE_FOR_URI(?newRealizationId))) AS ?effNew)
-    BIND(IRI(CONCAT("https://arrowheadweb.org/data/DatedEffectivity_", ENCODE_FOR_URI(?newRealizationId))) AS ?newDatedEffectivity)
-    BIND(IRI(CONCAT("https://arrowheadweb.org/data/DateTimeString_", ENCODE_FOR_URI(?newRealizationId))) AS ?newStartDateTimeString)
-
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/EffectivityAssignment_", ?workOrderId, "_ReplacementActivity")) AS ?replacementActivityEffectivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/DatedEffectivity_", ?workOrderId, "_ReplacementActivity")) AS ?replacementActivityDatedEffectivity)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/DateTimeString_Start_", ?workOrderId, "_ReplacementActivity")) AS ?replacementActivityStartDateTimeString)
-    BIND(IRI(CONCAT("https://sinetiq.se/sap/MaintenanceOrder/DateTimeString_End_", ?workOrderId, "_ReplacementActivity")) AS ?replacementActivityEndDateTimeString)
-}`,
-		orderURI, woAssignURI, e.CurrentPart, e.NewPart,
-		completedAt, completedAt,
-		e.ActivityStart, e.ActivityEnd)
-}
-
-// updateEndpoint returns the SPARQL update URL by appending /statements to the
-// repository base URL the operator configured. Empty in, empty out.
-func (t *Traits) updateEndpoint() string {
-	if t.GraphDBURL == "" {
-		return ""
-	}
-	return strings.TrimRight(t.GraphDBURL, "/") + "/statements"
+func (t *Traits) buildTECOReplaceSPARQL(o *Order, e *Enrichment, at time.Time) (string, error) {
+	params := tecoActivityParams(o, e, at)
+	params["newIndividualPart"] = e.NewPart
+	return t.renderSPARQL("teco-replace", o, params)
 }
 
 // insertToGraphDB prints the SPARQL UPDATE to the terminal and, when GraphDBURL
 // is configured, POSTs it to GraphDB.
 func (t *Traits) insertToGraphDB(o *Order) {
-	t.postSPARQL("CRTD", o.ID, t.buildSPARQL(o))
+	sparql, err := t.buildSPARQL(o)
+	t.publishSPARQL("CRTD", o.ID, sparql, err)
 }
 
-// insertCompletionToGraphDB records the order's TECO transition in the graph.
-// Called from runTECOCountdown once the in-memory status flips to TECO. The
-// SPARQL template chosen depends on what the planner specified in the
-// enrichment JSON:
+// chooseTECOTemplate renders the order's TECO transition at time at for the
+// graph, with the most specific template the available data supports, and
+// returns it along with a tag for the log line. The template depends on what
+// the planner specified in the enrichment JSON:
 //
 //   - decision="replace" with both currentPart and newPart populated → Replace template
 //     (closes old part's effectivity, creates new IndividualPartView, etc.)
 //   - decision="repair"  with currentPart populated → Repair template
 //     (records ActualActivity against the same part; effectivity unchanged)
-//   - anything else (no parsed enrichment, missing parts, missing dates) → fallback
-//     template (status flip + completion timestamp only)
+//   - anything else (no parsed enrichment, missing parts, missing dates, or
+//     values that are not IRIs or dates) → fallback template (status flip +
+//     completion timestamp only)
 //
 // Falling back is intentional: it keeps the demo loop alive even when
 // Triona-side data dependencies (parts / associations / effectivity graphs)
 // aren't yet in place for the target FL.
-func (t *Traits) insertCompletionToGraphDB(o *Order) {
-	stage, sparql := t.chooseTECOTemplate(o)
-	t.postSPARQL(stage, o.ID, sparql)
-}
-
-// chooseTECOTemplate picks the most specific TECO SPARQL template the
-// available data supports, and returns it along with a tag for the log line.
-func (t *Traits) chooseTECOTemplate(o *Order) (stage, sparql string) {
+func (t *Traits) chooseTECOTemplate(o *Order, at time.Time) (stage, sparql string, err error) {
 	e := o.ParsedEnrichment
 	if e == nil || e.CurrentPart == "" || e.ActivityStart == "" || e.ActivityEnd == "" {
-		return "TECO", t.buildTECOFallbackSPARQL(o)
+		sparql, err = t.buildTECOFallbackSPARQL(o, at)
+		return "TECO", sparql, err
 	}
 	switch strings.ToLower(e.Decision) {
 	case "replace":
 		if e.NewPart == "" {
 			log.Printf("sapper: order %s decision=replace but newPart is empty; using fallback TECO", o.ID)
-			return "TECO", t.buildTECOFallbackSPARQL(o)
+			break
+		}
+		if sparql, err = t.buildTECOReplaceSPARQL(o, e, at); err == nil {
+			return "TECO-replace", sparql, nil
 		}
-		return "TECO-replace", t.buildTECOReplaceSPARQL(o, e)
+		log.Printf("sapper: order %s: %v; using fallback TECO", o.ID, err)
 	case "repair", "":
-		return "TECO-repair", t.buildTECORepairSPARQL(o, e)
+		if sparql, err = t.buildTECORepairSPARQL(o, e, at); err == nil {
+			return "TECO-repair", sparql, nil
+		}
+		log.Printf("sapper: order %s: %v; using fallback TECO", o.ID, err)
 	default:
 		log.Printf("sapper: order %s unknown decision %q; using fallback TECO", o.ID, e.Decision)
-		return "TECO", t.buildTECOFallbackSPARQL(o)
-	}
-}
-
-// postSPARQL is the common path for both lifecycle pushes. Graph publication is
-// a side effect of the SAP lifecycle, not load-bearing for it, so failures here
-// are logged and dropped — the maintenance loop continues either way.
-func (t *Traits) postSPARQL(stage, orderID, sparql string) {
-	log.Printf("→ GraphDB INSERT (%s) order=%s\n%s\n", stage, orderID, sparql)
-
-	endpoint := t.updateEndpoint()
-	if endpoint == "" {
-		return
-	}
-
-	resp, err := http.Post(endpoint, "application/sparql-update", strings.NewReader(sparql))
-	if err != nil {
-		log.Printf("postSPARQL (%s): POST failed: %v\n", stage, err)
-		return
 	}
-	defer resp.Body.Close()
-	msg, _ := io.ReadAll(resp.Body)
-	log.Printf("← GraphDB %s  body=%s\n", resp.Status, string(msg))
+	sparql, err = t.buildTECOFallbackSPARQL(o, at)
+	return "TECO", sparql, err
 }
 
 //-------------------------------------HTTP handlers
@@ -1366,7 +1025,7 @@ func (t *Traits) renderFirefighting(w http.ResponseWriter, flash, kind string) {
 	t.mu.Lock()
 	crtd := make([]*Order, 0, len(t.orders))
 	for _, o := range t.orders {
-		if o.Status == "CRTD" {
+		if o.Status == statusCreated {
 			crtd = append(crtd, o)
 		}
 	}
@@ -1425,7 +1084,42 @@ func (t *Traits) createOrderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	o := t.createOrder(req)
+	// A retry of a request whose answer was lost gets the order the first
+	// attempt created, as long as that order is open.
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		req.IdempotencyKey = key
+	}
+	if req.IdempotencyKey != "" {
+		t.createMu.Lock()
+		defer t.createMu.Unlock()
+		if o := t.openOrderByKey(req.IdempotencyKey); o != nil {
+			log.Printf("order %s already created for key %s\n", o.ID, req.IdempotencyKey)
+			t.mu.Lock()
+			resp := OrderResponse{
+				MaintenanceOrder:        o.ID,
+				MaintenanceNotification: o.Notification,
+				Status:                  o.Status,
+				Message:                 "Maintenance order already created",
+				CreatedAt:               o.CreatedAt,
+			}
+			t.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	var o *Order
+	if t.sap != nil {
+		var err error
+		if o, err = t.forwardOrder(req); err != nil {
+			log.Printf("sapper: forwarding order to SAP: %v\n", err)
+			http.Error(w, "SAP: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	} else {
+		o = t.createOrder(req)
+	}
 	log.Printf("order created: id=%s equipment=%s\n", o.ID, req.EquipmentID)
 	go t.insertToGraphDB(o)
 
@@ -1441,13 +1135,40 @@ func (t *Traits) createOrderHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// queryOrderHandler handles GET /orders?id=<orderID>.
+// openOrderByKey returns the order created for an idempotency key that is not
+// yet technically completed, or nil.
+func (t *Traits) openOrderByKey(key string) *Order {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, o := range t.orders {
+		if o.Request.IdempotencyKey == key && o.Status != statusTechDone && o.Status != statusClosed {
+			return o
+		}
+	}
+	return nil
+}
+
+// queryOrderHandler handles GET /orders?id=<orderID>, or ?key=<idempotency key>
+// for the open order created under a key.
 func (t *Traits) queryOrderHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
+	if key := r.URL.Query().Get("key"); id == "" && key != "" {
+		o := t.openOrderByKey(key)
+		if o == nil {
+			http.Error(w, "order not found", http.StatusNotFound)
+			return
+		}
+		id = o.ID
+	}
 	if id == "" {
-		http.Error(w, "query parameter 'id' is required", http.StatusBadRequest)
+		http.Error(w, "query parameter 'id' or 'key' is required", http.StatusBadRequest)
 		return
 	}
+	if t.sap != nil {
+		if err := t.refreshFromSAP(id); err != nil {
+			log.Printf("sapper: reading order %s from SAP: %v\n", id, err)
+		}
+	}
 	t.mu.Lock()
 	o, ok := t.orders[id]
 	t.mu.Unlock()
@@ -1455,10 +1176,14 @@ func (t *Traits) queryOrderHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "order not found", http.StatusNotFound)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	t.mu.Lock()
+	out := map[string]string{
 		"maintenanceOrder": o.ID,
 		"status":           o.Status,
+		"userStatus":       strings.Join(o.UserStatuses, " "),
 		"createdAt":        o.CreatedAt.Format(time.RFC3339),
-	})
+	}
+	t.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
 }
@@ -402,6 +402,58 @@ func TestCreateOrderHandler(t *testing.T) {
 	})
 }
 
+func TestCreateOrderHandlerIdempotencyKey(t *testing.T) {
+	tr := newTestTraits()
+	tr.CompletionDelay = 9999
+	post := func(key string) (int, OrderResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/orders", validOrderBody(t))
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		tr.createOrderHandler(w, req)
+		var resp OrderResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, first := post("nurse/pressure/node1@2026-05-30T13:58:21Z")
+	if code != http.StatusCreated {
+		t.Fatalf("first request: status = %d, want 201", code)
+	}
+	code, retry := post("nurse/pressure/node1@2026-05-30T13:58:21Z")
+	if code != http.StatusOK || retry.MaintenanceOrder != first.MaintenanceOrder {
+		t.Errorf("retry: status = %d, order %s, want 200 and order %s", code, retry.MaintenanceOrder, first.MaintenanceOrder)
+	}
+	if _, other := post("nurse/pressure/node2@2026-05-30T13:58:21Z"); other.MaintenanceOrder == first.MaintenanceOrder {
+		t.Error("another key got the same order")
+	}
+	if len(tr.orders) != 2 {
+		t.Errorf("%d orders created, want 2", len(tr.orders))
+	}
+
+	w := httptest.NewRecorder()
+	tr.queryOrderHandler(w, httptest.NewRequest(http.MethodGet, "/orders?key=nurse/pressure/node1@2026-05-30T13:58:21Z", nil))
+	var found map[string]string
+	json.NewDecoder(w.Body).Decode(&found)
+	if w.Code != http.StatusOK || found["maintenanceOrder"] != first.MaintenanceOrder {
+		t.Errorf("query by key: status = %d, %v", w.Code, found)
+	}
+
+	// Once the order is completed, the key raises a new one
+	tr.mu.Lock()
+	done := *tr.orders[first.MaintenanceOrder] // the GraphDB insert may still read the order
+	done.Status = statusTechDone
+	tr.orders[done.ID] = &done
+	tr.mu.Unlock()
+	if code, again := post("nurse/pressure/node1@2026-05-30T13:58:21Z"); code != http.StatusCreated || again.MaintenanceOrder == first.MaintenanceOrder {
+		t.Errorf("after TECO: status = %d, order %s", code, again.MaintenanceOrder)
+	}
+	w = httptest.NewRecorder()
+	tr.queryOrderHandler(w, httptest.NewRequest(http.MethodGet, "/orders?key=unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("query by unknown key: status = %d, want 404", w.Code)
+	}
+}
+
 // ── queryOrderHandler ─────────────────────────────────────────────────────────
 
 func TestQueryOrderHandler(t *testing.T) {
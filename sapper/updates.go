@@ -0,0 +1,258 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sparqlUpdate is a SPARQL update waiting to be written to GraphDB.
+type sparqlUpdate struct {
+	ID        int64     `json:"-"`
+	Stage     string    `json:"stage"`
+	OrderID   string    `json:"orderId,omitempty"`
+	Body      string    `json:"body"`
+	Attempts  int       `json:"attempts"`
+	QueuedAt  time.Time `json:"queuedAt"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// updateQueue holds the updates GraphDB could not take, oldest first. While
+// it is not empty new updates join it rather than overtake it, so the graph
+// sees the updates of an order in the order they were made.
+type updateQueue struct {
+	mu      sync.Mutex
+	pending []*sparqlUpdate
+}
+
+// The retry delay doubles with every failed attempt of the oldest update,
+// from sparqlRetryInterval up to sparqlRetryMax.
+var (
+	sparqlRetryInterval = 5 * time.Second
+	sparqlRetryMax      = 5 * time.Minute
+)
+
+// graphDBError is a failed SPARQL update request; 5xx, 408 and 429 are worth
+// retrying, other statuses mean GraphDB refused the update itself.
+type graphDBError struct {
+	status int
+	body   string
+}
+
+func (e *graphDBError) Error() string {
+	return fmt.Sprintf("GraphDB answered %d %s: %s", e.status, http.StatusText(e.status), e.body)
+}
+
+// retryable tells if a failed update may succeed when sent again: GraphDB
+// could not be reached, or was not able to take it at that time.
+func retryable(err error) bool {
+	var ge *graphDBError
+	if !errors.As(err, &ge) {
+		return true
+	}
+	return ge.status >= 500 || ge.status == http.StatusRequestTimeout || ge.status == http.StatusTooManyRequests
+}
+
+// updateEndpoint returns the SPARQL update URL by appending /statements to the
+// repository base URL the operator configured. Empty in, empty out.
+func (t *Traits) updateEndpoint() string {
+	if t.GraphDBURL == "" {
+		return ""
+	}
+	return strings.TrimRight(t.GraphDBURL, "/") + "/statements"
+}
+
+// publishSPARQL posts an update rendered for an order, or logs why it could
+// not be rendered.
+func (t *Traits) publishSPARQL(stage, orderID, sparql string, err error) {
+	if err != nil {
+		log.Printf("sapper: %s update of order %s: %v\n", stage, orderID, err)
+		return
+	}
+	t.postSPARQL(stage, orderID, sparql)
+}
+
+// postSPARQL is the common path for all graph updates. Graph publication is a
+// side effect of the SAP lifecycle, not load-bearing for it: an update GraphDB
+// cannot take is queued and retried in the background (when the asset has a
+// queue), one it refuses is logged and dropped. In dry-run mode the updates
+// are appended to a file instead.
+func (t *Traits) postSPARQL(stage, orderID, sparql string) {
+	log.Printf("→ GraphDB INSERT (%s) order=%s\n%s\n", stage, orderID, sparql)
+
+	if t.SPARQLDryRun != "" {
+		if err := t.dryRun(stage, orderID, sparql); err != nil {
+			log.Printf("postSPARQL (%s): dry run: %v\n", stage, err)
+		}
+		return
+	}
+	endpoint := t.updateEndpoint()
+	if endpoint == "" {
+		return
+	}
+
+	u := &sparqlUpdate{Stage: stage, OrderID: orderID, Body: sparql, QueuedAt: time.Now()}
+	if t.updates.waiting() {
+		t.enqueue(u)
+		return
+	}
+	err := sendSPARQL(endpoint, sparql)
+	if err == nil {
+		return
+	}
+	log.Printf("postSPARQL (%s): %v\n", stage, err)
+	if retryable(err) && t.updates != nil {
+		u.Attempts, u.LastError = 1, err.Error()
+		t.enqueue(u)
+	}
+}
+
+// sendSPARQL posts an update to GraphDB.
+func sendSPARQL(endpoint, sparql string) error {
+	resp, err := http.Post(endpoint, "application/sparql-update", strings.NewReader(sparql))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	log.Printf("← GraphDB %s  body=%s\n", resp.Status, string(msg))
+	if resp.StatusCode/100 != 2 {
+		return &graphDBError{status: resp.StatusCode, body: string(msg)}
+	}
+	return nil
+}
+
+// dryRun appends an update to the dry-run file, headed by a comment naming
+// when and why it was made.
+func (t *Traits) dryRun(stage, orderID, sparql string) error {
+	t.dryRunMu.Lock()
+	defer t.dryRunMu.Unlock()
+	f, err := os.OpenFile(t.SPARQLDryRun, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "# %s %s order=%s\n%s\n\n", time.Now().UTC().Format(time.RFC3339), stage, orderID, sparql)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// waiting tells if updates are queued; a nil queue never holds any.
+func (q *updateQueue) waiting() bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) > 0
+}
+
+// enqueue appends an update to the retry queue and stores it.
+func (t *Traits) enqueue(u *sparqlUpdate) {
+	if err := t.store.queueUpdate(u); err != nil {
+		log.Printf("sapper: %v\n", err)
+	}
+	t.updates.mu.Lock()
+	t.updates.pending = append(t.updates.pending, u)
+	n := len(t.updates.pending)
+	t.updates.mu.Unlock()
+	log.Printf("sapper: %s update of order %s queued for retry (%d waiting)\n", u.Stage, u.OrderID, n)
+}
+
+// restoreUpdates loads the updates still queued when the Sapper stopped.
+func (t *Traits) restoreUpdates() error {
+	pending, err := t.store.loadUpdates()
+	if err != nil {
+		return err
+	}
+	t.updates = &updateQueue{pending: pending}
+	if len(pending) > 0 {
+		log.Printf("sapper: %d SPARQL updates waiting for GraphDB\n", len(pending))
+	}
+	return nil
+}
+
+// retryDelay is the time to wait before the next attempt of the oldest
+// update.
+func (q *updateQueue) retryDelay() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d := sparqlRetryInterval
+	if len(q.pending) > 0 {
+		for i := 1; i < q.pending[0].Attempts && d < sparqlRetryMax; i++ {
+			d *= 2
+		}
+	}
+	return min(d, sparqlRetryMax)
+}
+
+// retrySPARQL sends the queued updates to GraphDB until ctx is done.
+func (t *Traits) retrySPARQL(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t.updates.retryDelay()):
+			t.flushUpdates()
+		}
+	}
+}
+
+// flushUpdates sends the queued updates in order until GraphDB fails to take
+// one. The update being sent stays at the head of the queue, so that updates
+// made meanwhile queue up behind it.
+func (t *Traits) flushUpdates() {
+	endpoint := t.updateEndpoint()
+	for {
+		t.updates.mu.Lock()
+		if len(t.updates.pending) == 0 {
+			t.updates.mu.Unlock()
+			return
+		}
+		u := t.updates.pending[0]
+		t.updates.mu.Unlock()
+
+		err := sendSPARQL(endpoint, u.Body)
+		if err != nil && retryable(err) {
+			u.Attempts++
+			u.LastError = err.Error()
+			log.Printf("postSPARQL (%s): attempt %d for order %s: %v\n", u.Stage, u.Attempts, u.OrderID, err)
+			if err := t.store.saveUpdate(u); err != nil {
+				log.Printf("sapper: %v\n", err)
+			}
+			return
+		}
+		if err != nil {
+			log.Printf("postSPARQL (%s): dropping the update of order %s: %v\n", u.Stage, u.OrderID, err)
+		}
+		if err := t.store.dropUpdate(u.ID); err != nil {
+			log.Printf("sapper: %v\n", err)
+		}
+		t.updates.mu.Lock()
+		t.updates.pending = t.updates.pending[1:]
+		t.updates.mu.Unlock()
+	}
+}
@@ -0,0 +1,179 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyGraphDB answers SPARQL updates with status while it is not 0, and
+// keeps those it takes. It knows no data to query.
+type flakyGraphDB struct {
+	mu       sync.Mutex
+	status   int
+	attempts int
+	updates  []string
+}
+
+func (g *flakyGraphDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/statements") {
+		http.NotFound(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.attempts++
+	if g.status != 0 {
+		http.Error(w, "not now", g.status)
+		return
+	}
+	g.updates = append(g.updates, string(body))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *flakyGraphDB) answer(status int) {
+	g.mu.Lock()
+	g.status = status
+	g.mu.Unlock()
+}
+
+func (g *flakyGraphDB) taken() (attempts int, updates []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.attempts, append([]string(nil), g.updates...)
+}
+
+// queuedTraits returns quiet test traits posting to g, with a retry queue
+// persisted to path.
+func queuedTraits(t *testing.T, g *flakyGraphDB, path string) *Traits {
+	t.Helper()
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	tr := quietTraits()
+	tr.GraphDBURL = srv.URL
+	store, err := openOrderStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.close)
+	tr.store = store
+	if err := tr.restoreUpdates(); err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestFailedUpdatesAreRetriedInOrder(t *testing.T) {
+	g := &flakyGraphDB{status: http.StatusServiceUnavailable}
+	path := filepath.Join(t.TempDir(), "orders.db")
+	tr := queuedTraits(t, g, path)
+
+	tr.postSPARQL("CRTD", "400000001", "INSERT DATA { <a> <b> 1 }")
+	tr.postSPARQL("REL", "400000001", "INSERT DATA { <a> <b> 2 }")
+	if attempts, _ := g.taken(); attempts != 1 {
+		t.Errorf("%d attempts; the second update should wait behind the first", attempts)
+	}
+	tr.flushUpdates()
+	if tr.updates.pending[0].Attempts != 2 || len(tr.updates.pending) != 2 {
+		t.Fatalf("queue after a failed retry: %+v", tr.updates.pending)
+	}
+
+	// The queue survives a restart
+	tr = queuedTraits(t, g, path)
+	if len(tr.updates.pending) != 2 || tr.updates.pending[0].Attempts != 2 || tr.updates.pending[1].Stage != "REL" {
+		t.Fatalf("queue after restart: %+v", tr.updates.pending)
+	}
+
+	g.answer(0)
+	tr.flushUpdates()
+	tr.postSPARQL("TECO", "400000001", "INSERT DATA { <a> <b> 3 }")
+	if _, updates := g.taken(); strings.Join(updates, "|") != "INSERT DATA { <a> <b> 1 }|INSERT DATA { <a> <b> 2 }|INSERT DATA { <a> <b> 3 }" {
+		t.Errorf("GraphDB took %q", updates)
+	}
+	if left, _ := tr.store.loadUpdates(); len(left) != 0 || tr.updates.waiting() {
+		t.Errorf("%d updates left in the store", len(left))
+	}
+}
+
+func TestRefusedUpdatesAreDropped(t *testing.T) {
+	g := &flakyGraphDB{status: http.StatusBadRequest}
+	tr := queuedTraits(t, g, filepath.Join(t.TempDir(), "orders.db"))
+	tr.postSPARQL("CRTD", "400000001", "INSERT DATA { broken")
+	if tr.updates.waiting() {
+		t.Error("a refused update was queued")
+	}
+
+	// A queued update that GraphDB then refuses does not block the queue
+	g.answer(http.StatusBadGateway)
+	tr.postSPARQL("CRTD", "400000002", "INSERT DATA { broken")
+	tr.postSPARQL("REL", "400000002", "INSERT DATA { <a> <b> 2 }")
+	g.answer(http.StatusBadRequest)
+	tr.updates.pending[1].Body = "INSERT DATA { also broken"
+	tr.flushUpdates()
+	if tr.updates.waiting() {
+		t.Errorf("queue: %+v", tr.updates.pending)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	q := &updateQueue{}
+	if d := q.retryDelay(); d != sparqlRetryInterval {
+		t.Errorf("empty queue: %v", d)
+	}
+	for attempts, want := range map[int]time.Duration{1: 5 * time.Second, 3: 20 * time.Second, 20: 5 * time.Minute} {
+		q.pending = []*sparqlUpdate{{Attempts: attempts}}
+		if d := q.retryDelay(); d != want {
+			t.Errorf("after %d attempts: %v, want %v", attempts, d, want)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	g := &flakyGraphDB{}
+	tr := queuedTraits(t, g, filepath.Join(t.TempDir(), "orders.db"))
+	tr.SPARQLDryRun = filepath.Join(t.TempDir(), "updates.rq")
+
+	o := releasedOrder(t, tr)
+	tr.audit(o.ID, "EDIT", "")
+	time.Sleep(100 * time.Millisecond) // the release is written in the background
+
+	if attempts, _ := g.taken(); attempts != 0 {
+		t.Errorf("%d updates sent to GraphDB in a dry run", attempts)
+	}
+	data, err := os.ReadFile(tr.SPARQLDryRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		" REL order=" + o.ID + "\n",
+		" audit EDIT order=" + o.ID + "\n",
+		`ex:status "REL"`,
+		`ex:action "EDIT"`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %q in\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,734 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// workbenchHTML is the planner workbench served at /sapper/SAPSimulator/workbench:
+// the order queue with its filters and bulk release, or, with ?id=, one
+// order with its operations editor, the release/defer/reject actions and
+// its timeline.
+const workbenchHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Sapper — Planner workbench</title>
+  <style>
+    body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif;
+           margin: 1.5em; color: #222; }
+    h1 { margin-top: 0; }
+    h2 { margin-top: 1.5em; border-bottom: 1px solid #ccc; padding-bottom: 0.2em; }
+    table { border-collapse: collapse; width: 100%; }
+    th, td { padding: 0.4em 0.6em; text-align: left; border-bottom: 1px solid #eee; vertical-align: top; }
+    th { background: #f3f3f3; }
+    tr:hover { background: #fafafa; }
+    tr.component td { background: #fbfbf6; font-size: 0.9em; }
+    input[type=text], input[type=number], select { padding: 0.25em; }
+    textarea { width: 100%; font-family: ui-monospace, "SF Mono", Menlo, monospace;
+               font-size: 0.9em; padding: 0.5em; }
+    button { padding: 0.5em 1.2em; font-size: 1em; cursor: pointer;
+             background: #2d6cdf; color: white; border: 0; border-radius: 4px; }
+    button:hover { background: #1a4fb8; }
+    button.warn { background: #c0392b; }
+    button.quiet { background: #888; }
+    .filters { display: flex; gap: 1em; align-items: end; margin-bottom: 1em; }
+    .filters label { display: flex; flex-direction: column; font-size: 0.85em; }
+    .actions { display: flex; gap: 2em; flex-wrap: wrap; }
+    .actions form { flex: 1; min-width: 18em; }
+    .badge { display: inline-block; padding: 0 0.4em; margin-left: 0.3em; border-radius: 3px;
+             background: #eee; font-size: 0.8em; }
+    .empty { color: #888; font-style: italic; }
+    .flash { padding: 0.6em 0.9em; margin-bottom: 1em; border-radius: 4px; }
+    .flash.ok { background: #e3f7e0; border: 1px solid #8bce85; }
+    .flash.err { background: #fce6e6; border: 1px solid #d97070; }
+    .timeline { list-style: none; padding-left: 0; }
+    .timeline li { padding: 0.3em 0; border-left: 3px solid #2d6cdf; padding-left: 0.8em; margin-left: 0.3em; }
+    .timeline time { color: #666; font-size: 0.9em; margin-right: 0.6em; }
+  </style>
+</head>
+<body>
+  <h1>Sapper · Planner workbench</h1>
+
+  {{if .Flash}}<div class="flash {{.FlashKind}}">{{.Flash}}</div>{{end}}
+  {{if .Errors}}<div class="flash err"><ul>{{range .Errors}}<li>{{.}}</li>{{end}}</ul></div>{{end}}
+
+  {{with .Order}}
+  <p><a href="workbench">← queue</a></p>
+  <h2>Order <code>{{.ID}}</code> <span class="badge">{{.Status}}</span>{{range .UserStatuses}}<span class="badge">{{.}}</span>{{end}}</h2>
+  <table>
+    <tr><th>Description</th><td>{{.Request.Description}}</td><th>Priority</th><td>{{.Request.Priority}}</td></tr>
+    <tr><th>Equipment</th><td>{{.Request.EquipmentID}}</td><th>Functional location</th><td>{{.Request.FunctionalLocation}}</td></tr>
+    <tr><th>Notification</th><td>{{.Notification}}</td><th>Created</th><td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td></tr>
+  </table>
+
+  <h2>Operations</h2>
+  <form method="POST" action="workbench">
+    <input type="hidden" name="action" value="save">
+    <input type="hidden" name="orderId" value="{{.ID}}">
+    <table>
+      <thead>
+        <tr><th>Operation</th><th>Text</th><th>Work center</th><th>Duration</th><th>Unit</th></tr>
+      </thead>
+      <tbody>
+        {{range $i, $op := $.Operations}}
+        <tr>
+          <td><input type="text" name="op{{$i}}.operationId" value="{{$op.OperationID}}" size="4" placeholder="{{opNumber $i}}" {{if not $.Editable}}disabled{{end}}></td>
+          <td><input type="text" name="op{{$i}}.text" value="{{$op.Text}}" size="40" {{if not $.Editable}}disabled{{end}}></td>
+          <td><input type="text" name="op{{$i}}.workCenter" value="{{$op.WorkCenter}}" size="12" {{if not $.Editable}}disabled{{end}}></td>
+          <td><input type="number" step="any" min="0" name="op{{$i}}.duration" value="{{if $op.Duration}}{{$op.Duration}}{{end}}" {{if not $.Editable}}disabled{{end}}></td>
+          <td><select name="op{{$i}}.durationUnit" {{if not $.Editable}}disabled{{end}}>
+            {{range $.DurationUnits}}<option {{if eq . $op.DurationUnit}}selected{{end}}>{{.}}</option>{{end}}
+          </select></td>
+        </tr>
+        {{range $j, $c := $op.Components}}
+        <tr class="component">
+          <td></td>
+          <td>component <input type="text" name="op{{$i}}.c{{$j}}.material" value="{{$c.Material}}" size="16" placeholder="material" {{if not $.Editable}}disabled{{end}}>
+              <input type="text" name="op{{$i}}.c{{$j}}.description" value="{{$c.Description}}" size="20" placeholder="description" {{if not $.Editable}}disabled{{end}}></td>
+          <td><input type="text" name="op{{$i}}.c{{$j}}.plant" value="{{$c.Plant}}" size="5" placeholder="plant" {{if not $.Editable}}disabled{{end}}>
+              <input type="text" name="op{{$i}}.c{{$j}}.storageLocation" value="{{$c.StorageLocation}}" size="5" placeholder="sloc" {{if not $.Editable}}disabled{{end}}></td>
+          <td><input type="number" step="any" min="0" name="op{{$i}}.c{{$j}}.quantity" value="{{if $c.Quantity}}{{$c.Quantity}}{{end}}" placeholder="quantity" {{if not $.Editable}}disabled{{end}}></td>
+          <td><input type="text" name="op{{$i}}.c{{$j}}.unit" value="{{$c.Unit}}" size="4" placeholder="EA" {{if not $.Editable}}disabled{{end}}></td>
+        </tr>
+        {{end}}
+        {{end}}
+      </tbody>
+    </table>
+    {{if $.Editable}}<p class="empty">Fill the blank rows to add an operation or a component; clear a row to drop it.</p>
+    <p><button type="submit">Save operations</button></p>{{end}}
+  </form>
+
+  {{if $.Editable}}
+  <h2>Decision</h2>
+  <div class="actions">
+    <form method="POST" action="workbench">
+      <input type="hidden" name="action" value="release">
+      <input type="hidden" name="orderId" value="{{.ID}}">
+      <textarea name="enrichment" rows="14" spellcheck="false">{{.SuggestedEnrichment}}</textarea>
+      <p><button type="submit">Release</button></p>
+    </form>
+    {{if $.InSAP}}<p class="empty">Orders are deferred and rejected in SAP.</p>
+    {{else}}<form method="POST" action="workbench">
+      <input type="hidden" name="orderId" value="{{.ID}}">
+      <p><input type="text" name="reason" size="40" placeholder="reason" required></p>
+      <p><button type="submit" name="action" value="defer" class="quiet">Defer</button>
+         <button type="submit" name="action" value="reject" class="warn">Reject</button></p>
+    </form>{{end}}
+  </div>
+  {{end}}
+
+  <h2>Timeline</h2>
+  <p class="empty">{{$.TimelineSource}}</p>
+  <ul class="timeline">
+    {{range $.Timeline}}<li><time>{{.At.Format "2006-01-02 15:04:05"}}</time><strong>{{.Kind}}</strong>{{if .Note}} — {{.Note}}{{end}}</li>
+    {{else}}<li class="empty">No events.</li>{{end}}
+  </ul>
+
+  {{else}}
+  <form method="GET" action="workbench" class="filters">
+    <label>Status
+      <select name="status">
+        <option value="" {{if eq $.Status ""}}selected{{end}}>any</option>
+        {{range $.Statuses}}<option {{if eq . $.Status}}selected{{end}}>{{.}}</option>{{end}}
+      </select></label>
+    <label>Priority
+      <select name="priority">
+        <option value="" {{if eq $.Priority ""}}selected{{end}}>any</option>
+        {{range $.Priorities}}<option {{if eq . $.Priority}}selected{{end}}>{{.}}</option>{{end}}
+      </select></label>
+    <label>Functional location
+      <input type="text" name="location" value="{{$.Location}}" placeholder="827-PV2708-200 or 827-*"></label>
+    <button type="submit">Filter</button>
+  </form>
+
+  <form method="POST" action="workbench">
+    <input type="hidden" name="action" value="bulkrelease">
+    <table>
+      <thead>
+        <tr><th></th><th>Order</th><th>Status</th><th>Priority</th><th>Functional location</th>
+            <th>Equipment</th><th>Description</th><th>Created</th></tr>
+      </thead>
+      <tbody>
+        {{range .Orders}}
+        <tr>
+          <td>{{if eq .Status "CRTD"}}<input type="checkbox" name="orderId" value="{{.MaintenanceOrder}}">{{end}}</td>
+          <td><a href="workbench?id={{.MaintenanceOrder}}"><code>{{.MaintenanceOrder}}</code></a></td>
+          <td>{{.Status}}{{range .UserStatus}}<span class="badge">{{.}}</span>{{end}}</td>
+          <td>{{.Priority}}</td>
+          <td>{{.FunctionalLocation}}</td>
+          <td>{{.EquipmentID}}</td>
+          <td>{{.Description}}</td>
+          <td>{{.CreatedAt.Format "2006-01-02 15:04"}}</td>
+        </tr>
+        {{else}}
+        <tr><td colspan="8" class="empty">No orders match.</td></tr>
+        {{end}}
+      </tbody>
+    </table>
+    <p><button type="submit">Release selected</button></p>
+  </form>
+  {{end}}
+</body>
+</html>
+`
+
+var workbenchTemplate = template.Must(template.New("workbench").Funcs(template.FuncMap{
+	"opNumber": func(i int) string { return fmt.Sprintf("%04d", (i+1)*10) },
+}).Parse(workbenchHTML))
+
+// User statuses set by the planner's decisions, outside the status profile.
+const (
+	userStatusDeferred = "DEFR" // set aside, still awaiting release
+	userStatusRejected = "RJCT" // closed without execution
+)
+
+// durationUnits are the work units an operation may be planned in; the same
+// units are converted when confirmations are summed.
+var durationUnits = []string{"H", "MIN", "D"}
+
+// operationNumber is the SAP form of an operation number: four digits.
+var operationNumber = regexp.MustCompile(`^[0-9]{4}$`)
+
+//-------------------------------------Structured editing
+
+// parseOperations reads the operations editor, in which the fields of
+// operation i are named op<i>.<field> and those of its component j
+// op<i>.c<j>.<field>. Rows left blank are dropped; a number that does not
+// parse reads as -1, so that validation reports it.
+func parseOperations(form url.Values) []OrderOperation {
+	number := func(key string) float64 {
+		v := strings.TrimSpace(form.Get(key))
+		if v == "" {
+			return 0
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return -1
+		}
+		return f
+	}
+	present := func(prefix string) bool {
+		for k := range form {
+			if strings.HasPrefix(k, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	var ops []OrderOperation
+	for i := 0; present(fmt.Sprintf("op%d.", i)); i++ {
+		p := fmt.Sprintf("op%d.", i)
+		op := OrderOperation{
+			OperationID:  strings.TrimSpace(form.Get(p + "operationId")),
+			Text:         strings.TrimSpace(form.Get(p + "text")),
+			WorkCenter:   strings.TrimSpace(form.Get(p + "workCenter")),
+			Duration:     number(p + "duration"),
+			DurationUnit: strings.TrimSpace(form.Get(p + "durationUnit")),
+		}
+		for j := 0; present(fmt.Sprintf("%sc%d.", p, j)); j++ {
+			cp := fmt.Sprintf("%sc%d.", p, j)
+			c := OrderComponent{
+				Material:        strings.TrimSpace(form.Get(cp + "material")),
+				Description:     strings.TrimSpace(form.Get(cp + "description")),
+				Quantity:        number(cp + "quantity"),
+				Unit:            strings.TrimSpace(form.Get(cp + "unit")),
+				Plant:           strings.TrimSpace(form.Get(cp + "plant")),
+				StorageLocation: strings.TrimSpace(form.Get(cp + "storageLocation")),
+			}
+			if c != (OrderComponent{}) {
+				op.Components = append(op.Components, c)
+			}
+		}
+		// The unit select always has a value, so it alone does not make a row
+		if op.OperationID == "" && op.Text == "" && op.WorkCenter == "" && op.Duration == 0 && len(op.Components) == 0 {
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// validateOperations numbers the unnumbered operations 0010, 0020, … after
+// their position and checks them against what SAP accepts for an
+// OrderOperation and its OrderComponents. It returns one message per problem.
+func validateOperations(ops []OrderOperation) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	for i := range ops {
+		op := &ops[i]
+		if op.OperationID == "" {
+			op.OperationID = fmt.Sprintf("%04d", (i+1)*10)
+		}
+		label := "operation " + op.OperationID
+		switch {
+		case !operationNumber.MatchString(op.OperationID):
+			problems = append(problems, fmt.Sprintf("operation %d: the number %q is not four digits", i+1, op.OperationID))
+		case seen[op.OperationID]:
+			problems = append(problems, label+": the number is used twice")
+		}
+		seen[op.OperationID] = true
+		if op.Text == "" {
+			problems = append(problems, label+": the text is required")
+		}
+		if op.Duration < 0 {
+			problems = append(problems, label+": the duration must be a non-negative number")
+		}
+		if op.Duration > 0 && !slices.Contains(durationUnits, op.DurationUnit) {
+			problems = append(problems, fmt.Sprintf("%s: the duration unit must be one of %v", label, durationUnits))
+		}
+		for j, c := range op.Components {
+			clabel := fmt.Sprintf("%s, component %d", label, j+1)
+			if c.Material == "" {
+				problems = append(problems, clabel+": the material is required")
+			}
+			if c.Quantity <= 0 {
+				problems = append(problems, clabel+": the quantity must be a positive number")
+			}
+			if c.Unit == "" {
+				problems = append(problems, clabel+": the unit is required")
+			}
+		}
+	}
+	return problems
+}
+
+// editOperations replaces the operations of an order awaiting release.
+func (t *Traits) editOperations(orderID string, ops []OrderOperation) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o, err := t.awaitingRelease(orderID)
+	if err != nil {
+		return err
+	}
+	o.Request.Operations = ops
+	t.saveOrder(o)
+	return nil
+}
+
+// awaitingRelease returns an order the planner may still decide on. The
+// caller holds t.mu.
+func (t *Traits) awaitingRelease(orderID string) (*Order, error) {
+	o, ok := t.orders[orderID]
+	if !ok {
+		return nil, fmt.Errorf("order %s: %w", orderID, errOrderNotFound)
+	}
+	if o.Status != statusCreated {
+		return nil, fmt.Errorf("order %s is %s, only CRTD orders await the planner", orderID, o.Status)
+	}
+	return o, nil
+}
+
+//-------------------------------------Planner decisions
+
+// errDecidedInSAP refuses a deferral or a rejection with the SAP backend: it
+// would only change the local copy, which SAP's statuses never move back.
+var errDecidedInSAP = errors.New("with the SAP backend, defer or reject the order in SAP")
+
+// deferOrder sets an order aside: it stays in CRTD, marked DEFR, until it is
+// released.
+func (t *Traits) deferOrder(orderID, reason string) error {
+	if reason == "" {
+		return errors.New("a deferral needs a reason")
+	}
+	if t.sap != nil {
+		return fmt.Errorf("order %s: %w", orderID, errDecidedInSAP)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	o, err := t.awaitingRelease(orderID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(o.UserStatuses, userStatusDeferred) {
+		return fmt.Errorf("order %s is already deferred", orderID)
+	}
+	o.UserStatuses = append(o.UserStatuses, userStatusDeferred)
+	o.History = append(o.History, StatusChange{Status: "+" + userStatusDeferred, At: time.Now(), Note: reason})
+	t.saveOrder(o)
+	return nil
+}
+
+// rejectOrder closes an order without executing it, marked RJCT, and tells
+// the consumer so it stops waiting for the work.
+func (t *Traits) rejectOrder(orderID, reason string) error {
+	if reason == "" {
+		return errors.New("a rejection needs a reason")
+	}
+	if t.sap != nil {
+		return fmt.Errorf("order %s: %w", orderID, errDecidedInSAP)
+	}
+	t.mu.Lock()
+	o, err := t.awaitingRelease(orderID)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	now := time.Now()
+	o.Status = statusClosed
+	o.ClosedAt = now
+	o.UserStatuses = append(slices.DeleteFunc(o.UserStatuses, func(us string) bool { return us == userStatusDeferred }), userStatusRejected)
+	o.History = append(o.History,
+		StatusChange{Status: statusClosed, At: now, Note: "rejected: " + reason},
+		StatusChange{Status: "+" + userStatusRejected, At: now, Note: reason})
+	t.saveOrder(o)
+	sparql, err := t.buildStatusSPARQL(o, statusClosed, now)
+	t.mu.Unlock()
+
+	log.Printf("order %s rejected: %s\n", orderID, reason)
+	t.publishSPARQL(statusClosed, orderID, sparql, err)
+	go t.notifyConsumerOf(o, statusClosed, "Rejected by planner: "+reason)
+	return nil
+}
+
+// releaseOrders releases each order with its suggested enrichment and
+// returns those released and the reasons the others were not.
+func (t *Traits) releaseOrders(ids []string) (released []string, failed []string) {
+	for _, id := range ids {
+		t.mu.Lock()
+		var enrichment string
+		if o, ok := t.orders[id]; ok {
+			enrichment = o.SuggestedEnrichment
+		}
+		t.mu.Unlock()
+		if !json.Valid([]byte(enrichment)) {
+			enrichment = "{}"
+		}
+		if _, err := t.enrichAndRelease(id, json.RawMessage(enrichment)); err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+		released = append(released, id)
+	}
+	return released, failed
+}
+
+//-------------------------------------Audit trail
+
+// auditEvent is an entry of an order's timeline.
+type auditEvent struct {
+	At   time.Time
+	Kind string
+	Note string
+}
+
+// auditLabels names the dated properties of an order in the timeline.
+var auditLabels = map[string]string{
+	"created":     "Created",
+	"releasedAt":  "Released",
+	"completedAt": "Technically completed",
+	"closedAt":    "Closed",
+}
+
+// buildAuditSPARQL records a planner action on an order as an ex:AuditEvent.
+func (t *Traits) buildAuditSPARQL(orderID, action, note string, at time.Time) (string, error) {
+	return t.renderSPARQL("audit", nil, sparqlParams{
+		"auditEvent": fmt.Sprintf("%s/audit/%d", orderIRI(orderID), at.UnixNano()),
+		"workOrder":  orderIRI(orderID),
+		"action":     action,
+		"created":    at,
+		"note":       note,
+	})
+}
+
+// audit records a planner action in GraphDB.
+func (t *Traits) audit(orderID, action, note string) {
+	sparql, err := t.buildAuditSPARQL(orderID, action, note, time.Now())
+	t.publishSPARQL("audit "+action, orderID, sparql, err)
+}
+
+// timeline reads the audit events of an order from GraphDB: its dated
+// lifecycle properties and the planner's actions, oldest first.
+func (t *Traits) timeline(orderID string) ([]auditEvent, error) {
+	orderURI := "https://sinetiq.se/sap/MaintenanceOrder/" + orderID
+	query := fmt.Sprintf(`PREFIX ex: <https://sinetiq.se/sap/>
+PREFIX dcterms: <http://purl.org/dc/terms/>
+
+SELECT ?at ?kind ?note WHERE {
+    GRAPH <https://arrowheadweb.org/graph/sap/workorders> {
+        {
+            <%s> ?p ?at .
+            VALUES ?p { dcterms:created ex:releasedAt ex:completedAt ex:closedAt }
+            BIND(REPLACE(STR(?p), "^.*[/#]", "") AS ?kind)
+        } UNION {
+            ?event a ex:AuditEvent ;
+                ex:auditOf <%s> ;
+                ex:action ?kind ;
+                dcterms:created ?at .
+            OPTIONAL { ?event ex:note ?note }
+        }
+    }
+}
+ORDER BY ?at`, orderURI, orderURI)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.GraphDBURL, strings.NewReader(query))
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/sparql-query")
+	req.Header.Set("Accept", "application/sparql-results+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("HTTP %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		Results struct {
+			Bindings []map[string]struct {
+				Value string `json:"value"`
+			} `json:"bindings"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	var events []auditEvent
+	for _, b := range result.Results.Bindings {
+		at, err := time.Parse(time.RFC3339Nano, b["at"].Value)
+		if err != nil {
+			continue
+		}
+		kind := b["kind"].Value
+		if label, ok := auditLabels[kind]; ok {
+			kind = label
+		}
+		events = append(events, auditEvent{At: at, Kind: kind, Note: b["note"].Value})
+	}
+	return events, nil
+}
+
+//-------------------------------------Handlers
+
+// workbenchView is what the workbench template renders: the queue, or one
+// order when Order is set.
+type workbenchView struct {
+	Flash, FlashKind string
+	Errors           []string
+
+	Status, Priority, Location string // queue filters
+	Statuses, Priorities       []string
+	Orders                     []OrderSummary
+
+	Order          *Order
+	Editable       bool
+	InSAP          bool             // deferrals and rejections are made in SAP
+	Operations     []OrderOperation // with blank rows to fill in
+	DurationUnits  []string
+	Timeline       []auditEvent
+	TimelineSource string
+}
+
+// workbenchHandler serves the queue (GET), one order (GET ?id=) and the
+// planner's actions (POST).
+func (t *Traits) workbenchHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		view := workbenchView{Flash: q.Get("msg"), FlashKind: q.Get("kind")}
+		if id := q.Get("id"); id != "" {
+			t.renderOrder(w, id, view, nil)
+			return
+		}
+		t.renderQueue(w, q, view)
+	case http.MethodPost:
+		t.workbenchAction(w, r)
+	default:
+		http.Error(w, "Method not supported", http.StatusMethodNotAllowed)
+	}
+}
+
+// renderQueue lists the orders passing the filters; it shows the orders
+// awaiting the planner by default.
+func (t *Traits) renderQueue(w http.ResponseWriter, q url.Values, view workbenchView) {
+	if _, set := q["status"]; !set {
+		q.Set("status", statusCreated)
+	}
+	f, err := parseOrderFilter(q)
+	if err != nil {
+		view.Errors = append(view.Errors, err.Error())
+	}
+	view.Status, view.Priority, view.Location = q.Get("status"), q.Get("priority"), q.Get("location")
+	view.Statuses = []string{statusCreated, userStatusDeferred, statusReleased, statusPartConf, statusConfirmed, statusTechDone, statusClosed, userStatusRejected}
+	view.Priorities = []string{"1", "2", "3", "4"}
+	view.Orders = t.listOrders(f)
+	t.renderWorkbench(w, http.StatusOK, view)
+}
+
+// renderOrder shows one order. Operations, if not nil, are the planner's
+// rejected edit, shown again with the problems found.
+func (t *Traits) renderOrder(w http.ResponseWriter, id string, view workbenchView, operations []OrderOperation) {
+	t.mu.Lock()
+	o, ok := t.orders[id]
+	var order Order
+	if ok {
+		order = *o
+		order.UserStatuses = slices.Clone(o.UserStatuses)
+		order.History = slices.Clone(o.History)
+		order.Request.Operations = slices.Clone(o.Request.Operations)
+	}
+	t.mu.Unlock()
+	if !ok {
+		http.Error(w, "order not found", http.StatusNotFound)
+		return
+	}
+	view.Order = &order
+	view.Editable = order.Status == statusCreated
+	view.InSAP = t.sap != nil
+	view.DurationUnits = durationUnits
+
+	if operations == nil {
+		operations = order.Request.Operations
+	}
+	for _, op := range operations {
+		op.Components = append(slices.Clone(op.Components), OrderComponent{})
+		view.Operations = append(view.Operations, op)
+	}
+	if view.Editable {
+		view.Operations = append(view.Operations, OrderOperation{DurationUnit: "H", Components: []OrderComponent{{}}})
+	}
+
+	status := http.StatusOK
+	if len(view.Errors) > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	if t.GraphDBURL == "" {
+		view.TimelineSource = "From the order's status history; GraphDB is not configured."
+		view.Timeline = historyTimeline(order.History)
+	} else if events, err := t.timeline(id); err != nil {
+		log.Printf("workbench: timeline of order %s: %v\n", id, err)
+		view.TimelineSource = "GraphDB could not be read (" + err.Error() + "); showing the order's status history."
+		view.Timeline = historyTimeline(order.History)
+	} else {
+		view.TimelineSource = "Audit events recorded in GraphDB."
+		view.Timeline = events
+	}
+	t.renderWorkbench(w, status, view)
+}
+
+// historyTimeline presents an order's status history as a timeline.
+func historyTimeline(history []StatusChange) []auditEvent {
+	events := make([]auditEvent, len(history))
+	for i, h := range history {
+		events[i] = auditEvent{At: h.At, Kind: h.Status, Note: h.Note}
+	}
+	return events
+}
+
+func (t *Traits) renderWorkbench(w http.ResponseWriter, status int, view workbenchView) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := workbenchTemplate.Execute(w, view); err != nil {
+		log.Printf("workbench: template error: %v\n", err)
+	}
+}
+
+// workbenchAction carries out a planner action and redirects (303) to the
+// order, or to the queue after a bulk release. An edit that does not
+// validate is shown again instead, with its problems.
+func (t *Traits) workbenchAction(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "could not parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := strings.TrimSpace(r.PostForm.Get("orderId"))
+	reason := strings.TrimSpace(r.PostForm.Get("reason"))
+	back := func(target, msg string, err error) {
+		q := url.Values{"msg": {msg}, "kind": {"ok"}}
+		if err != nil {
+			q = url.Values{"msg": {err.Error()}, "kind": {"err"}}
+		}
+		if target != "" {
+			q.Set("id", target)
+		}
+		http.Redirect(w, r, "workbench?"+q.Encode(), http.StatusSeeOther)
+	}
+
+	switch action := r.PostForm.Get("action"); action {
+	case "save":
+		ops := parseOperations(r.PostForm)
+		if problems := validateOperations(ops); len(problems) > 0 {
+			t.renderOrder(w, id, workbenchView{Errors: problems}, ops)
+			return
+		}
+		err := t.editOperations(id, ops)
+		if err == nil {
+			t.audit(id, "EDIT", fmt.Sprintf("%d operations", len(ops)))
+		}
+		back(id, "Operations saved.", err)
+	case "release":
+		enrichment := strings.TrimSpace(r.PostForm.Get("enrichment"))
+		if !json.Valid([]byte(enrichment)) {
+			back(id, "", errors.New("the enrichment is not valid JSON"))
+			return
+		}
+		_, err := t.enrichAndRelease(id, json.RawMessage(enrichment))
+		if err == nil {
+			t.audit(id, "RELEASE", "")
+		}
+		back(id, "Order released.", err)
+	case "defer":
+		err := t.deferOrder(id, reason)
+		if err == nil {
+			t.audit(id, "DEFER", reason)
+		}
+		back(id, "Order deferred.", err)
+	case "reject":
+		err := t.rejectOrder(id, reason)
+		if err == nil {
+			t.audit(id, "REJECT", reason)
+		}
+		back(id, "Order rejected and closed.", err)
+	case "bulkrelease":
+		ids := r.PostForm["orderId"]
+		if len(ids) == 0 {
+			back("", "", errors.New("select the orders to release"))
+			return
+		}
+		released, failed := t.releaseOrders(ids)
+		for _, id := range released {
+			t.audit(id, "RELEASE", "bulk release")
+		}
+		msg := fmt.Sprintf("Released %d of %d orders.", len(released), len(ids))
+		if len(failed) > 0 {
+			back("", "", fmt.Errorf("%s %s", msg, strings.Join(failed, "; ")))
+			return
+		}
+		back("", msg, nil)
+	default:
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+	}
+}
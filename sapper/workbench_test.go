@@ -0,0 +1,330 @@
+/*******************************************************************************
+ * Copyright (c) 2025 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+)
+
+// ── helpers ───────────────────────────────────────────────────────────────────
+
+func workbenchGet(t *testing.T, tr *Traits, query string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	tr.workbenchHandler(w, httptest.NewRequest(http.MethodGet, "/workbench?"+query, nil))
+	return w.Code, w.Body.String()
+}
+
+// workbenchPost posts a form and returns the status and, after a redirect,
+// the flash message, or else the page.
+func workbenchPost(t *testing.T, tr *Traits, form url.Values) (int, string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/workbench", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	tr.workbenchHandler(w, r)
+	if w.Code == http.StatusSeeOther {
+		loc, _ := url.Parse(w.Header().Get("Location"))
+		return w.Code, loc.Query().Get("kind") + ": " + loc.Query().Get("msg")
+	}
+	return w.Code, w.Body.String()
+}
+
+// fakeTripleStore keeps the SPARQL updates posted to it and answers every
+// query with the audit events found in them.
+type fakeTripleStore struct {
+	mu      sync.Mutex
+	updates []string
+}
+
+func (g *fakeTripleStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if strings.HasSuffix(r.URL.Path, "/statements") {
+		g.updates = append(g.updates, string(body))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var bindings []string
+	for _, u := range g.updates {
+		if !strings.Contains(u, "ex:AuditEvent") {
+			continue
+		}
+		action := between(u, `ex:action "`, `"`)
+		at := between(u, `dcterms:created "`, `"`)
+		bindings = append(bindings, fmt.Sprintf(`{"at":{"value":%q},"kind":{"value":%q},"note":{"value":%q}}`,
+			at, action, between(u, `ex:note "`, `"`+"\n")))
+	}
+	bindings = append(bindings, `{"at":{"value":"2026-05-30T13:58:21Z"},"kind":{"value":"created"}}`)
+	w.Header().Set("Content-Type", "application/sparql-results+json")
+	fmt.Fprintf(w, `{"results":{"bindings":[%s]}}`, strings.Join(bindings, ","))
+}
+
+func between(s, from, to string) string {
+	_, after, ok := strings.Cut(s, from)
+	if !ok {
+		return ""
+	}
+	v, _, _ := strings.Cut(after, to)
+	return v
+}
+
+// ── structured editing ────────────────────────────────────────────────────────
+
+func TestParseOperations(t *testing.T) {
+	form := url.Values{
+		"op0.text": {"Isolate valve"}, "op0.workCenter": {"VALVE-WC01"}, "op0.duration": {"1.5"}, "op0.durationUnit": {"H"},
+		"op0.c0.material": {"VALVE-GASKET-V50"}, "op0.c0.quantity": {"2"}, "op0.c0.unit": {"EA"},
+		"op0.c1.material": {""}, "op0.c1.quantity": {""}, // blank component row
+		"op1.operationId": {""}, "op1.text": {""}, "op1.durationUnit": {"H"}, // blank operation row
+		"op2.operationId": {"0050"}, "op2.text": {"Pressure test"}, "op2.durationUnit": {"H"},
+	}
+	ops := parseOperations(form)
+	if problems := validateOperations(ops); len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	if len(ops) != 2 || ops[0].OperationID != "0010" || ops[1].OperationID != "0050" ||
+		ops[0].Duration != 1.5 || len(ops[0].Components) != 1 || ops[0].Components[0].Quantity != 2 {
+		t.Errorf("operations = %+v", ops)
+	}
+}
+
+func TestValidateOperations(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		op   OrderOperation
+		want string
+	}{
+		{"number", OrderOperation{OperationID: "10", Text: "x"}, "not four digits"},
+		{"text", OrderOperation{}, "text is required"},
+		{"duration", OrderOperation{Text: "x", Duration: -1}, "non-negative"},
+		{"unit", OrderOperation{Text: "x", Duration: 2, DurationUnit: "WK"}, "duration unit"},
+		{"material", OrderOperation{Text: "x", Components: []OrderComponent{{Quantity: 1, Unit: "EA"}}}, "material is required"},
+		{"quantity", OrderOperation{Text: "x", Components: []OrderComponent{{Material: "M", Unit: "EA"}}}, "quantity must be"},
+		{"component unit", OrderOperation{Text: "x", Components: []OrderComponent{{Material: "M", Quantity: 1}}}, "unit is required"},
+	} {
+		problems := validateOperations([]OrderOperation{c.op})
+		if len(problems) != 1 || !strings.Contains(problems[0], c.want) {
+			t.Errorf("%s: %v, want %q", c.name, problems, c.want)
+		}
+	}
+	twice := []OrderOperation{{OperationID: "0010", Text: "a"}, {Text: "b"}, {OperationID: "0010", Text: "c"}}
+	if problems := validateOperations(twice); len(problems) != 1 || !strings.Contains(problems[0], "used twice") {
+		t.Errorf("duplicate numbers: %v", problems)
+	}
+}
+
+func TestWorkbenchEdit(t *testing.T) {
+	tr := quietTraits()
+	o := tr.createOrder(OrderRequest{EquipmentID: "E1", Plant: "1000", Description: "leak"})
+
+	code, page := workbenchPost(t, tr, url.Values{"action": {"save"}, "orderId": {o.ID},
+		"op0.text": {"Replace seal"}, "op0.duration": {"two"}, "op0.durationUnit": {"H"}})
+	if code != http.StatusUnprocessableEntity || !strings.Contains(page, "non-negative") || !strings.Contains(page, `value="Replace seal"`) {
+		t.Fatalf("invalid edit: %d %s", code, page)
+	}
+	if len(o.Request.Operations) != 0 {
+		t.Error("an invalid edit was saved")
+	}
+
+	code, flash := workbenchPost(t, tr, url.Values{"action": {"save"}, "orderId": {o.ID},
+		"op0.text": {"Replace seal"}, "op0.duration": {"2"}, "op0.durationUnit": {"H"},
+		"op0.c0.material": {"SEAL-50"}, "op0.c0.quantity": {"1"}, "op0.c0.unit": {"EA"}})
+	if code != http.StatusSeeOther || !strings.HasPrefix(flash, "ok") {
+		t.Fatalf("edit: %d %s", code, flash)
+	}
+	tr.mu.Lock()
+	ops := o.Request.Operations
+	tr.mu.Unlock()
+	if len(ops) != 1 || ops[0].OperationID != "0010" || ops[0].Components[0].Material != "SEAL-50" {
+		t.Errorf("operations = %+v", ops)
+	}
+
+	// The saved operations are shown for editing, with blank rows to add more
+	_, page = workbenchGet(t, tr, "id="+o.ID)
+	if !strings.Contains(page, `value="SEAL-50"`) || !strings.Contains(page, `name="op1.text"`) {
+		t.Error("the editor does not show the saved operations")
+	}
+
+	tr.enrichAndRelease(o.ID, json.RawMessage(`{}`))
+	if _, flash = workbenchPost(t, tr, url.Values{"action": {"save"}, "orderId": {o.ID}, "op0.text": {"late"}}); !strings.HasPrefix(flash, "err") {
+		t.Errorf("edited a released order: %s", flash)
+	}
+}
+
+// ── decisions ─────────────────────────────────────────────────────────────────
+
+func TestDeferAndReject(t *testing.T) {
+	events := make(chan CompletionEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e CompletionEvent
+		json.NewDecoder(r.Body).Decode(&e)
+		events <- e
+	}))
+	defer srv.Close()
+	withDiscoverMonitor(t, func(c *components.Cervice, _ *components.System) error {
+		c.Nodes = map[string][]components.NodeInfo{"n": {{URL: srv.URL}}}
+		return nil
+	})
+	tr := quietTraits()
+	tr.monitor = &components.Cervice{Definition: "SignalMonitoring"}
+	deferred := tr.createOrder(OrderRequest{EquipmentID: "E1", Plant: "1000", Description: "drift"})
+	rejected := tr.createOrder(OrderRequest{EquipmentID: "E2", Plant: "1000", Description: "false alarm"})
+
+	if _, flash := workbenchPost(t, tr, url.Values{"action": {"defer"}, "orderId": {deferred.ID}}); !strings.Contains(flash, "needs a reason") {
+		t.Errorf("deferred without a reason: %s", flash)
+	}
+	workbenchPost(t, tr, url.Values{"action": {"defer"}, "orderId": {deferred.ID}, "reason": {"wait for the shutdown"}})
+	if _, page := workbenchGet(t, tr, "status=DEFR"); !strings.Contains(page, deferred.ID) || strings.Contains(page, rejected.ID) {
+		t.Error("the deferred order is not in the DEFR queue")
+	}
+	workbenchPost(t, tr, url.Values{"action": {"release"}, "orderId": {deferred.ID}, "enrichment": {"{}"}})
+	tr.mu.Lock()
+	if deferred.Status != "REL" || len(deferred.UserStatuses) != 0 {
+		t.Errorf("released deferred order: %s %v", deferred.Status, deferred.UserStatuses)
+	}
+	tr.mu.Unlock()
+
+	workbenchPost(t, tr, url.Values{"action": {"reject"}, "orderId": {rejected.ID}, "reason": {"sensor fault, not the valve"}})
+	select {
+	case e := <-events:
+		if e.OrderID != rejected.ID || e.Status != "CLSD" || !strings.Contains(e.Notes, "sensor fault") {
+			t.Errorf("consumer told %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the consumer was not told of the rejection")
+	}
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if rejected.Status != "CLSD" || rejected.ClosedAt.IsZero() || len(rejected.UserStatuses) != 1 || rejected.UserStatuses[0] != "RJCT" {
+		t.Errorf("rejected order: %s %v", rejected.Status, rejected.UserStatuses)
+	}
+}
+
+// TestDeferAndRejectInSAP checks that the planner's decisions that SAP would
+// not follow are refused with the SAP backend.
+func TestDeferAndRejectInSAP(t *testing.T) {
+	tr := sapTraits(t, &fakeOData{}, SAPConfig{Username: "sapper", Password: "secret"})
+	postOrder(t, tr)
+
+	for _, action := range []string{"defer", "reject"} {
+		_, flash := workbenchPost(t, tr, url.Values{"action": {action}, "orderId": {"40000001"}, "reason": {"false alarm"}})
+		if !strings.HasPrefix(flash, "err: ") || !strings.Contains(flash, "in SAP") {
+			t.Errorf("%s with the SAP backend: %s", action, flash)
+		}
+	}
+	tr.mu.Lock()
+	if o := tr.orders["40000001"]; o.Status != "CRTD" || len(o.UserStatuses) != 0 {
+		t.Errorf("order after the refused decisions: %s %v", o.Status, o.UserStatuses)
+	}
+	tr.mu.Unlock()
+	if _, page := workbenchGet(t, tr, "id=40000001"); strings.Contains(page, `value="reject"`) {
+		t.Error("the order page offers to reject the order")
+	}
+}
+
+func TestBulkRelease(t *testing.T) {
+	tr := quietTraits()
+	tr.CompletionDelay = 3600
+	a := tr.createOrder(OrderRequest{EquipmentID: "E1", Plant: "1000", Description: "a"})
+	b := tr.createOrder(OrderRequest{EquipmentID: "E2", Plant: "1000", Description: "b"})
+	c := releasedOrder(t, tr)
+
+	_, flash := workbenchPost(t, tr, url.Values{"action": {"bulkrelease"}, "orderId": {a.ID, b.ID, c.ID}})
+	if !strings.HasPrefix(flash, "err: Released 2 of 3 orders.") || !strings.Contains(flash, c.ID) {
+		t.Errorf("flash = %q", flash)
+	}
+	for _, o := range []*Order{a, b} {
+		if s := statusOf(tr, o); s != "REL" {
+			t.Errorf("order %s is %s, want REL", o.ID, s)
+		}
+	}
+}
+
+// ── queue and timeline ────────────────────────────────────────────────────────
+
+func TestWorkbenchQueue(t *testing.T) {
+	tr := quietTraits()
+	urgent := tr.createOrder(OrderRequest{EquipmentID: "E1", Plant: "1000", Description: "a", Priority: "1", FunctionalLocation: "827-PV2708-200"})
+	other := tr.createOrder(OrderRequest{EquipmentID: "E2", Plant: "1000", Description: "b", Priority: "3", FunctionalLocation: "828-PV0001-100"})
+	released := releasedOrder(t, tr)
+
+	for _, c := range []struct {
+		query string
+		shown []*Order
+	}{
+		{"", []*Order{urgent, other}}, // awaiting the planner by default
+		{"status=", []*Order{urgent, other, released}},
+		{"priority=1", []*Order{urgent}},
+		{"location=827-*", []*Order{urgent}},
+		{"status=REL", []*Order{released}},
+	} {
+		code, page := workbenchGet(t, tr, c.query)
+		if code != http.StatusOK {
+			t.Fatalf("%q: status %d", c.query, code)
+		}
+		for _, o := range []*Order{urgent, other, released} {
+			want := false
+			for _, s := range c.shown {
+				want = want || s == o
+			}
+			if got := strings.Contains(page, "id="+o.ID); got != want {
+				t.Errorf("%q: order %s shown = %t, want %t", c.query, o.ID, got, want)
+			}
+		}
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	g := &fakeTripleStore{}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	tr := quietTraits()
+	o := tr.createOrder(OrderRequest{EquipmentID: "E1", Plant: "1000", Description: "leak"})
+
+	// Without GraphDB, the order's own history
+	_, page := workbenchGet(t, tr, "id="+o.ID)
+	if !strings.Contains(page, "GraphDB is not configured") || !strings.Contains(page, "<strong>CRTD</strong>") {
+		t.Error("no local timeline without GraphDB")
+	}
+
+	tr.GraphDBURL = srv.URL
+	workbenchPost(t, tr, url.Values{"action": {"defer"}, "orderId": {o.ID}, "reason": {`parts on "backorder"`}})
+	g.mu.Lock()
+	update := g.updates[len(g.updates)-1]
+	g.mu.Unlock()
+	if !strings.Contains(update, `ex:action "DEFER"`) || !strings.Contains(update, `ex:note "parts on \"backorder\""`) {
+		t.Errorf("audit update:\n%s", update)
+	}
+
+	_, page = workbenchGet(t, tr, "id="+o.ID)
+	if !strings.Contains(page, "Audit events recorded in GraphDB") || !strings.Contains(page, "<strong>Created</strong>") ||
+		!strings.Contains(page, "<strong>DEFER</strong>") {
+		t.Errorf("timeline from GraphDB missing:\n%s", page[strings.Index(page, "Timeline"):])
+	}
+}
@@ -0,0 +1,233 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ *   Thomas Hedeler, Hamburg - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// Operating modes of the controller
+const (
+	modeAuto    = "auto"    // the controller drives the valve towards the local setpoint
+	modeManual  = "manual"  // the operator sets the valve position through the output service
+	modeCascade = "cascade" // as auto, with the setpoint taken from an upstream controller
+	modeOff     = "off"     // the valve is closed and the controller idle
+)
+
+// errNotManual is returned when the output is written outside the manual mode
+var errNotManual = errors.New("the output can only be set in manual mode")
+
+// ControlMode_v1 is the exchanged form for the operating mode of a controller
+type ControlMode_v1 struct {
+	Mode      string    `json:"mode"`
+	Timestamp time.Time `json:"timestamp"`
+	Version   string    `json:"version"`
+}
+
+func (f *ControlMode_v1) NewForm() forms.Form {
+	f.Version = "ControlMode_v1"
+	return f
+}
+
+func (f *ControlMode_v1) FormVersion() string {
+	return f.Version
+}
+
+func init() {
+	forms.FormTypeMap["ControlMode_v1"] = reflect.TypeOf(ControlMode_v1{})
+}
+
+// checkMode verifies that a mode is one of the operating modes
+func checkMode(mode string) error {
+	switch mode {
+	case modeAuto, modeManual, modeCascade, modeOff:
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q (use %q, %q, %q or %q)", mode, modeAuto, modeManual, modeCascade, modeOff)
+}
+
+//-------------------------------------Service handlers
+
+// operatingMode handles the get and set requests for the controller's operating mode
+func (t *Traits) operatingMode(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		modeForm := t.getMode()
+		usecases.HTTPProcessGetRequest(w, r, &modeForm)
+	case "PUT":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Error reading the request body", http.StatusBadRequest)
+			return
+		}
+		f, err := usecases.Unpack(body, r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, "Error unpacking the mode form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		mf, ok := f.(*ControlMode_v1)
+		if !ok {
+			http.Error(w, "The mode is expected as a ControlMode_v1 form", http.StatusBadRequest)
+			return
+		}
+		previous, err := t.setMode(mf.Mode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if previous != mf.Mode {
+			usecases.LogInfo(t.owner, "controller %s switched from %s to %s mode", t.name, previous, mf.Mode)
+		}
+		confirmed := t.getMode()
+		usecases.HTTPProcessGetRequest(w, r, &confirmed)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+// valveOutput handles the get requests for the controller output and, in manual mode, the set requests
+func (t *Traits) valveOutput(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		outputForm := t.getOutput()
+		usecases.HTTPProcessGetRequest(w, r, &outputForm)
+	case "PUT":
+		sig, err := usecases.HTTPProcessSetRequest(w, r)
+		if err != nil {
+			http.Error(w, "Error with the setting request of the output: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := t.setOutput(sig.Value); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errNotManual) {
+				status = http.StatusConflict
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		confirmed := t.getOutput()
+		usecases.HTTPProcessGetRequest(w, r, &confirmed)
+	default:
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+	}
+}
+
+//-------------------------------------Thing's resource methods
+
+// getMode fills out a mode form with the current operating mode
+func (t *Traits) getMode() (f ControlMode_v1) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f.NewForm()
+	f.Mode = t.Mode
+	f.Timestamp = time.Now()
+	return f
+}
+
+// setMode switches the operating mode and returns the previous one. Leaving the automatic modes, the valve stays
+// where the controller left it (manual) or closes (off); coming back, the controller carries on from there.
+func (t *Traits) setMode(mode string) (previous string, err error) {
+	if err := checkMode(mode); err != nil {
+		return "", err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous = t.Mode
+	c := &t.ctl
+	if !c.started && !c.resume {
+		c.output = outputBias // the valve position is unknown until the controller has set it
+	}
+	switch mode {
+	case modeManual:
+		c.resume = true
+	case modeOff:
+		c.output = outputMin
+		c.resume = true
+	}
+	t.Mode = mode
+	return previous, nil
+}
+
+// getOutput fills out a signal form with the current valve position
+func (t *Traits) getOutput() (f forms.SignalA_v1a) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f.NewForm()
+	f.Value = t.ctl.output
+	f.Unit = "Percent"
+	f.Timestamp = time.Now()
+	return f
+}
+
+// setOutput sets the valve position in manual mode, sent to the valve at the next sample
+func (t *Traits) setOutput(position float64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Mode != modeManual {
+		return errNotManual
+	}
+	if position < outputMin || position > outputMax {
+		return fmt.Errorf("valve position %.1f%% is out of range", position)
+	}
+	t.ctl.output = position
+	log.Printf("valve set by hand at %.2f%%\n", position)
+	return nil
+}
+
+// control computes the valve position in the current mode, with or without a temperature reading
+func (t *Traits) control(temperature float64, read bool) float64 {
+	if read {
+		t.deviation = t.SetPt - temperature
+	}
+	switch t.Mode {
+	case modeManual, modeOff:
+		return t.ctl.output
+	}
+	if !read {
+		return t.signalLost()
+	}
+	return t.calculateOutput(temperature)
+}
+
+// followSetPoint takes the setpoint from the upstream controller in cascade mode, keeping the last one without it
+func (t *Traits) followSetPoint() {
+	f, err := usecases.GetState(t.cervices["setPoint"], t.owner)
+	if err != nil {
+		log.Printf("unable to obtain the upstream setpoint, keeping %.1f °C: %s\n", t.getSetPoint().Value, err)
+		return
+	}
+	sp, ok := f.(*forms.SignalA_v1a)
+	if !ok {
+		log.Println("problem unpacking the upstream setpoint signal form")
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Mode == modeCascade && sp.Value != t.SetPt {
+		t.SetPt = sp.Value
+		log.Printf("new set point from upstream: %.1f", sp.Value)
+	}
+}
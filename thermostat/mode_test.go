@@ -0,0 +1,223 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ *   Thomas Hedeler, Hamburg - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sdoque/mbaigo/components"
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+// newModeController returns a controller in automatic mode, owned by a
+// system without messengers so that mode changes can be logged.
+func newModeController() *Traits {
+	tr := newTestController()
+	tr.Mode, tr.name = modeAuto, "controller_1"
+	tr.owner = &components.System{
+		Name:  "thermostat",
+		Mutex: &sync.Mutex{},
+		Husk:  &components.Husk{Messengers: make(map[string]int)},
+	}
+	return tr
+}
+
+// put sends a form to a handler and returns the recorded reply.
+func put(t *testing.T, handler http.HandlerFunc, f forms.Form) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := usecases.Pack(f, "application/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// modeForm returns a mode form for a mode.
+func modeForm(mode string) *ControlMode_v1 {
+	f := &ControlMode_v1{Mode: mode, Timestamp: time.Now()}
+	f.NewForm()
+	return f
+}
+
+// percent returns a signal form for a valve position.
+func percent(value float64) *forms.SignalA_v1a {
+	f := &forms.SignalA_v1a{Value: value, Unit: "Percent", Timestamp: time.Now()}
+	f.NewForm()
+	return f
+}
+
+// TestModeService switches modes through the service, logging each change to
+// the messenger, and refuses unknown modes.
+func TestModeService(t *testing.T) {
+	var logged []string
+	messenger := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m forms.SystemMessage_v1
+		if r.URL.Path == "/log/message" && json.NewDecoder(r.Body).Decode(&m) == nil {
+			logged = append(logged, m.Body)
+		}
+	}))
+	defer messenger.Close()
+	tr := newModeController()
+	tr.owner.Husk.Messengers[messenger.URL] = 0
+
+	w := httptest.NewRecorder()
+	tr.operatingMode(w, httptest.NewRequest(http.MethodGet, "/mode", nil))
+	var got ControlMode_v1
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Mode != modeAuto || got.Version != "ControlMode_v1" {
+		t.Fatalf("GET mode: %d %s", w.Code, w.Body)
+	}
+
+	for _, mode := range []string{modeManual, modeCascade, modeOff, modeAuto} {
+		w = put(t, tr.operatingMode, modeForm(mode))
+		if err := json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || err != nil || got.Mode != mode {
+			t.Errorf("PUT %s: %d %s", mode, w.Code, w.Body)
+		}
+	}
+	if w = put(t, tr.operatingMode, modeForm("boost")); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown mode: %d", w.Code)
+	}
+	if w = put(t, tr.operatingMode, percent(50)); w.Code != http.StatusBadRequest {
+		t.Errorf("mode as a signal form: %d", w.Code)
+	}
+	if tr.Mode != modeAuto {
+		t.Errorf("mode %s after refused requests", tr.Mode)
+	}
+	put(t, tr.operatingMode, modeForm(modeAuto))
+	if len(logged) != 4 || logged[0] != "controller controller_1 switched from auto to manual mode" {
+		t.Errorf("mode changes logged: %q", logged)
+	}
+	w = httptest.NewRecorder()
+	tr.operatingMode(w, httptest.NewRequest(http.MethodDelete, "/mode", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("DELETE mode: %d", w.Code)
+	}
+}
+
+// TestOutputService reads the valve position and sets it in manual mode only.
+func TestOutputService(t *testing.T) {
+	tr := newModeController()
+	tr.SetPt = 17
+	tr.control(15, true)
+	if w := put(t, tr.valveOutput, percent(30)); w.Code != http.StatusConflict {
+		t.Errorf("output set in auto mode: %d", w.Code)
+	}
+
+	tr.setMode(modeManual) //nolint:errcheck
+	if w := put(t, tr.valveOutput, percent(30)); w.Code != http.StatusOK {
+		t.Fatalf("output set in manual mode: %d %s", w.Code, w.Body)
+	}
+	if w := put(t, tr.valveOutput, percent(120)); w.Code != http.StatusBadRequest {
+		t.Errorf("output out of range: %d", w.Code)
+	}
+	w := httptest.NewRecorder()
+	tr.valveOutput(w, httptest.NewRequest(http.MethodGet, "/output", nil))
+	var got forms.SignalA_v1a
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Value != 30 || got.Unit != "Percent" {
+		t.Errorf("GET output: %s", w.Body)
+	}
+	if u := tr.control(10, true); u != 30 {
+		t.Errorf("valve at %.2f%% in manual mode, want 30", u)
+	}
+}
+
+// TestBumplessModeChanges verifies that the valve stays where it is when the
+// controller is taken into manual, and that the controller carries on from
+// where manual or off left it.
+func TestBumplessModeChanges(t *testing.T) {
+	tr := newModeController()
+	tr.SetPt = 17
+	auto := tr.control(15, true)
+
+	tr.setMode(modeManual) //nolint:errcheck
+	if u := tr.control(16, true); u != auto {
+		t.Errorf("valve moved from %.2f%% to %.2f%% going to manual", auto, u)
+	}
+	if u := tr.control(0, false); u != auto {
+		t.Errorf("signal loss action applied in manual mode: %.2f%%", u)
+	}
+	tr.setOutput(70)     //nolint:errcheck
+	tr.setMode(modeAuto) //nolint:errcheck
+	if u := tr.control(16, true); u != 70 {
+		t.Errorf("back in auto the valve jumped from 70%% to %.2f%%", u)
+	}
+
+	tr.setMode(modeOff) //nolint:errcheck
+	if u := tr.control(16, true); u != outputMin {
+		t.Errorf("valve at %.2f%% when off", u)
+	}
+	if err := tr.setOutput(40); err == nil {
+		t.Error("output set when off")
+	}
+	tr.setMode(modeCascade) //nolint:errcheck
+	if u := tr.control(16, true); u != outputMin {
+		t.Errorf("in cascade the valve jumped from 0%% to %.2f%%", u)
+	}
+	if tr.deviation != 1 {
+		t.Errorf("thermal error %.2f, want 1", tr.deviation)
+	}
+}
+
+// TestCascadeSetPoint takes the setpoint from an upstream setpoint service
+// in cascade mode, and refuses local setpoints meanwhile.
+func TestCascadeSetPoint(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := &forms.SignalA_v1a{Value: 21.5, Unit: "Celsius", Timestamp: time.Now()}
+		f.NewForm()
+		usecases.HTTPProcessGetRequest(w, r, f)
+	}))
+	defer upstream.Close()
+
+	tr := newModeController()
+	tr.SetPt = 20
+	tr.cervices = components.Cervices{"setPoint": &components.Cervice{
+		Definition: "setPoint",
+		Nodes:      map[string][]components.NodeInfo{"flattener": {{URL: upstream.URL}}},
+	}}
+
+	tr.followSetPoint()
+	if tr.SetPt != 20 {
+		t.Errorf("setpoint %.1f taken from upstream in auto mode", tr.SetPt)
+	}
+	tr.setMode(modeCascade) //nolint:errcheck
+	tr.followSetPoint()
+	if tr.SetPt != 21.5 {
+		t.Errorf("setpoint %.1f in cascade mode, want 21.5", tr.SetPt)
+	}
+
+	sp := &forms.SignalA_v1a{Value: 18, Unit: "Celsius", Timestamp: time.Now()}
+	sp.NewForm()
+	if w := put(t, tr.setpt, sp); w.Code != http.StatusConflict || tr.SetPt != 21.5 {
+		t.Errorf("local setpoint in cascade mode: %d, setpoint %.1f", w.Code, tr.SetPt)
+	}
+
+	upstream.Close()
+	tr.followSetPoint()
+	if tr.SetPt != 21.5 {
+		t.Errorf("setpoint %.1f without the upstream controller, want the last one", tr.SetPt)
+	}
+}
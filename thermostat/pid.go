@@ -0,0 +1,179 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ *   Thomas Hedeler, Hamburg - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Valve positions the controller can ask for, and where it starts from
+const (
+	outputMin  = 0.0
+	outputMax  = 100.0
+	outputBias = 50.0
+)
+
+// Anti-windup methods
+const (
+	clamping        = "clamping"        // stop integrating while the output is saturated
+	backCalculation = "backCalculation" // bleed the saturation off the integral
+)
+
+// Actions on loss of the temperature signal
+const (
+	lossHold = "hold" // keep the last valve position
+	lossSafe = "safe" // go to the safe position at once
+	lossRamp = "ramp" // move towards the safe position at the ramp rate
+)
+
+// LossAction is what the controller does with the valve while it has no temperature reading
+type LossAction struct {
+	Action   string  `json:"action"`   // hold, safe or ramp
+	Position float64 `json:"position"` // safe valve position in percent
+	Rate     float64 `json:"rate"`     // ramp rate in percent per minute
+}
+
+// pid is the state of the discrete PID controller between samples
+type pid struct {
+	started     bool    // false until the first step
+	resume      bool    // the loss action set the output, from which the controller carries on
+	setpoint    float64 // working setpoint, following the configured one at the ramp rate
+	measurement float64 // temperature of the last step
+	integral    float64 // integral term, which also holds the output bias and the unweighted share of the setpoint
+	derivative  float64 // filtered derivative term
+	output      float64 // last valve position
+	kp, weight  float64 // proportional gain and setpoint weight of the last step
+}
+
+// checkTuning verifies the controller configuration
+func (t *Traits) checkTuning() error {
+	switch t.AntiWindup {
+	case clamping, backCalculation:
+	default:
+		return fmt.Errorf("unknown anti-windup method %q (use %q or %q)", t.AntiWindup, clamping, backCalculation)
+	}
+	switch t.SignalLoss.Action {
+	case lossHold, lossSafe, lossRamp:
+	default:
+		return fmt.Errorf("unknown signal loss action %q (use %q, %q or %q)", t.SignalLoss.Action, lossHold, lossSafe, lossRamp)
+	}
+	if t.SignalLoss.Position < outputMin || t.SignalLoss.Position > outputMax {
+		return fmt.Errorf("safe valve position %.1f%% is out of range", t.SignalLoss.Position)
+	}
+	if t.Kp < 0 || t.Ki < 0 || t.Kd < 0 || t.Lambda < 0 || t.TrackingTime < 0 || t.SetPtRamp < 0 || t.SignalLoss.Rate < 0 {
+		return fmt.Errorf("gains, time constants and rates cannot be negative")
+	}
+	return nil
+}
+
+// sampleSeconds is the sampling period in seconds
+func (t *Traits) sampleSeconds() float64 {
+	if h := (t.Period * time.Second).Seconds(); h > 0 {
+		return h
+	}
+	return 1
+}
+
+// calculateOutput is the actual PID controller, which computes the valve position for a temperature.
+// The proportional term weights the setpoint, the derivative term acts on the filtered temperature only so that
+// setpoint changes do not kick the valve, and the integral term is kept from winding up while the valve is saturated.
+func (t *Traits) calculateOutput(temperature float64) float64 {
+	c := &t.ctl
+	h := t.sampleSeconds()
+
+	switch {
+	case !c.started:
+		c.setpoint = t.SetPt
+		c.measurement = temperature
+		c.integral = outputBias
+		c.kp, c.weight = t.Kp, t.SetPtWeight
+		c.started = true
+	case c.kp != t.Kp || c.weight != t.SetPtWeight:
+		// bumpless retuning: the integral takes up the change of the proportional term
+		c.integral += c.kp*(c.weight*c.setpoint-temperature) - t.Kp*(t.SetPtWeight*c.setpoint-temperature)
+		c.kp, c.weight = t.Kp, t.SetPtWeight
+	}
+	c.setpoint = approach(c.setpoint, t.SetPt, t.SetPtRamp*h/60)
+
+	// derivative on measurement through a first order filter with time constant Lambda
+	c.derivative = (t.Lambda*c.derivative - t.Kd*(temperature-c.measurement)) / (t.Lambda + h)
+	c.measurement = temperature
+
+	p := t.Kp * (t.SetPtWeight*c.setpoint - temperature)
+	if c.resume {
+		// bumpless return from signal loss: carry on from the valve position the loss action left
+		c.derivative = 0
+		c.integral = c.output - p
+		c.resume = false
+	}
+	v := p + c.integral + c.derivative
+	u := clamp(v)
+
+	deviation := c.setpoint - temperature
+	if t.Ki > 0 {
+		switch t.AntiWindup {
+		case backCalculation:
+			tt := t.TrackingTime
+			if tt == 0 {
+				tt = t.Kp / t.Ki // the integral time
+			}
+			c.integral += t.Ki*h*deviation + math.Min(h/tt, 1)*(u-v)
+		default:
+			if !(v > outputMax && deviation > 0) && !(v < outputMin && deviation < 0) {
+				c.integral += t.Ki * h * deviation
+			}
+		}
+	}
+
+	c.output = u
+	return u
+}
+
+// signalLost applies the configured loss of signal action and returns the valve position
+func (t *Traits) signalLost() float64 {
+	c := &t.ctl
+	if !c.started && !c.resume {
+		c.output = outputBias // the valve position is unknown until the controller has set it
+	}
+	switch t.SignalLoss.Action {
+	case lossHold:
+	case lossRamp:
+		c.output = approach(c.output, t.SignalLoss.Position, t.SignalLoss.Rate*t.sampleSeconds()/60)
+	default:
+		c.output = t.SignalLoss.Position
+	}
+	c.resume = true
+	return c.output
+}
+
+// approach moves value towards target by at most step, or all the way if step is zero
+func approach(value, target, step float64) float64 {
+	if step <= 0 || math.Abs(target-value) <= step {
+		return target
+	}
+	if target > value {
+		return value + step
+	}
+	return value - step
+}
+
+// clamp limits a valve position to the range of the valve
+func clamp(position float64) float64 {
+	return math.Max(outputMin, math.Min(outputMax, position))
+}
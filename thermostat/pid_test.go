@@ -0,0 +1,233 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Jan A. van Deventer, Luleå - initial implementation
+ *   Thomas Hedeler, Hamburg - initial implementation
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// room is the simulated plant: a first order room heated through the valve,
+// reaching ambient + gain × valve position with the time constant tau.
+type room struct {
+	temperature float64 // °C
+	ambient     float64 // °C
+	gain        float64 // °C per percent of valve opening
+	tau         float64 // seconds
+}
+
+// newRoom returns a room at rest at the given temperature.
+func newRoom(temperature float64) *room {
+	return &room{temperature: temperature, ambient: 5, gain: 0.3, tau: 600}
+}
+
+// step advances the room by h seconds with the valve at position u.
+func (r *room) step(u, h float64) {
+	r.temperature += (1 - math.Exp(-h/r.tau)) * (r.ambient + r.gain*u - r.temperature)
+}
+
+// newTestController returns a PI controller tuned for the room, sampling
+// every 10 seconds.
+func newTestController() *Traits {
+	return &Traits{
+		SetPt: 15, SetPtWeight: 1, Period: 10,
+		Kp: 6, Ki: 0.01,
+		AntiWindup: clamping,
+		SignalLoss: LossAction{Action: lossSafe, Position: 50},
+	}
+}
+
+// run closes the loop over the room for a number of samples and returns the
+// highest temperature reached.
+func run(tr *Traits, r *room, samples int) (peak float64) {
+	peak = r.temperature
+	for i := 0; i < samples; i++ {
+		r.step(tr.calculateOutput(r.temperature), tr.sampleSeconds())
+		peak = math.Max(peak, r.temperature)
+	}
+	return peak
+}
+
+// TestStepResponse verifies that a setpoint step settles without offset,
+// which the proportional controller could not do, and without much overshoot.
+func TestStepResponse(t *testing.T) {
+	tr, r := newTestController(), newRoom(15)
+	run(tr, r, 720)
+	if math.Abs(r.temperature-15) > 0.01 {
+		t.Fatalf("did not settle at 15 °C: %.3f", r.temperature)
+	}
+
+	tr.SetPt = 20
+	peak := run(tr, r, 360)
+	if math.Abs(r.temperature-20) > 0.02 {
+		t.Errorf("settled at %.3f °C, want 20", r.temperature)
+	}
+	if peak > 20.5 {
+		t.Errorf("overshoot to %.2f °C", peak)
+	}
+	if u := tr.ctl.output; math.Abs(u-50) > 0.5 {
+		t.Errorf("valve at %.2f%%, the room needs 50%% at 20 °C", u)
+	}
+}
+
+// TestAntiWindup drives the valve into saturation with a setpoint the room
+// only just reaches, and checks that the integral does not wind up with either
+// method while the valve is wide open.
+func TestAntiWindup(t *testing.T) {
+	for _, method := range []string{clamping, backCalculation} {
+		t.Run(method, func(t *testing.T) {
+			tr, r := newTestController(), newRoom(15)
+			tr.AntiWindup = method
+			run(tr, r, 360)
+
+			tr.SetPt = 33
+			tr.calculateOutput(r.temperature)
+			if tr.ctl.output != outputMax {
+				t.Fatalf("valve at %.2f%%, want it saturated", tr.ctl.output)
+			}
+			peak := run(tr, r, 720)
+			if peak > 33.3 {
+				t.Errorf("overshoot to %.2f °C", peak)
+			}
+			if math.Abs(r.temperature-33) > 0.02 {
+				t.Errorf("settled at %.3f °C, want 33", r.temperature)
+			}
+		})
+	}
+}
+
+// TestSetpointWeighting verifies that a setpoint step moves the valve by the
+// weighted proportional term only: the derivative acts on the temperature.
+func TestSetpointWeighting(t *testing.T) {
+	tr := newTestController()
+	tr.Kd, tr.Lambda, tr.SetPtWeight = 300, 20, 0.5
+	for i := 0; i < 3; i++ {
+		tr.calculateOutput(15)
+	}
+	before := tr.ctl.output
+	tr.SetPt = 17
+	if jump := tr.calculateOutput(15) - before; math.Abs(jump-6*0.5*2) > 1e-9 {
+		t.Errorf("the valve moved by %.3f%%, want 6", jump)
+	}
+
+	// a change of temperature moves it through the filtered derivative too
+	tr = newTestController()
+	tr.Ki, tr.Kd, tr.Lambda = 0, 300, 20
+	tr.calculateOutput(15)
+	if got, want := tr.calculateOutput(14), 50+6*1+300*1/(20+10.0); math.Abs(got-want) > 1e-9 {
+		t.Errorf("valve at %.3f%%, want %.3f%%", got, want)
+	}
+}
+
+// TestBumplessRetuning changes the gains and the setpoint weight at steady
+// state and checks that the valve does not move.
+func TestBumplessRetuning(t *testing.T) {
+	tr, r := newTestController(), newRoom(15)
+	tr.SetPt = 18
+	run(tr, r, 60) // not yet settled, so the proportional term is not zero
+	before := tr.calculateOutput(r.temperature)
+
+	tr.Kp, tr.Ki, tr.SetPtWeight = 12, 0.02, 0.3
+	integration := 0.01 * 10 * (18 - r.temperature) // of the sample before the change
+	if after := tr.calculateOutput(r.temperature); math.Abs(after-before-integration) > 1e-9 {
+		t.Errorf("retuning moved the valve from %.3f%% to %.3f%%", before, after)
+	}
+	run(tr, r, 720)
+	if math.Abs(r.temperature-18) > 0.02 {
+		t.Errorf("settled at %.3f °C after retuning, want 18", r.temperature)
+	}
+}
+
+// TestSetpointRamp verifies that the working setpoint follows a new setpoint
+// at the ramp rate, so that the valve moves in small steps.
+func TestSetpointRamp(t *testing.T) {
+	tr := newTestController()
+	tr.Ki, tr.SetPtRamp = 0, 0.5 // °C per minute, 1/12 °C per sample
+	previous := tr.calculateOutput(15)
+	tr.SetPt = 17
+	for i := 1; i <= 24; i++ {
+		u := tr.calculateOutput(15)
+		if math.Abs(u-previous-0.5) > 1e-9 {
+			t.Fatalf("sample %d: the valve moved by %.3f%%, want 0.5", i, u-previous)
+		}
+		previous = u
+	}
+	if math.Abs(tr.ctl.setpoint-17) > 1e-9 {
+		t.Errorf("working setpoint %.3f after 4 minutes, want 17", tr.ctl.setpoint)
+	}
+}
+
+// TestSignalLoss checks each loss of signal action and that the controller
+// carries on from where the action left the valve once the signal is back.
+func TestSignalLoss(t *testing.T) {
+	for _, c := range []struct {
+		action string
+		want   []float64
+	}{
+		{lossHold, []float64{62, 62, 62}},
+		{lossSafe, []float64{20, 20, 20}},
+		{lossRamp, []float64{61, 60, 59}},
+	} {
+		t.Run(c.action, func(t *testing.T) {
+			tr := newTestController()
+			tr.Ki = 0
+			tr.SignalLoss = LossAction{Action: c.action, Position: 20, Rate: 6}
+			tr.SetPt = 17
+			if u := tr.calculateOutput(15); u != 62 {
+				t.Fatalf("valve at %.2f%%, want 62", u)
+			}
+			for i, want := range c.want {
+				if got := tr.signalLost(); got != want {
+					t.Errorf("sample %d without signal: valve at %.2f%%, want %.2f%%", i+1, got, want)
+				}
+			}
+			last := c.want[len(c.want)-1]
+			if got := tr.calculateOutput(15); got != last {
+				t.Errorf("signal back: valve jumped from %.2f%% to %.2f%%", last, got)
+			}
+			if got := tr.calculateOutput(14.5); math.Abs(got-(last+3)) > 1e-9 {
+				t.Errorf("valve at %.2f%% once controlling again, want %.2f%%", got, last+3)
+			}
+		})
+	}
+
+	// without a reading yet, the valve position is taken to be the bias
+	tr := newTestController()
+	tr.SignalLoss = LossAction{Action: lossHold}
+	if got := tr.signalLost(); got != outputBias {
+		t.Errorf("valve held at %.2f%% before the first reading", got)
+	}
+}
+
+// TestCheckTuning verifies that configuration mistakes are reported.
+func TestCheckTuning(t *testing.T) {
+	if err := newTestController().checkTuning(); err != nil {
+		t.Fatalf("test configuration refused: %v", err)
+	}
+	for name, spoil := range map[string]func(*Traits){
+		"anti-windup":   func(tr *Traits) { tr.AntiWindup = "none" },
+		"loss action":   func(tr *Traits) { tr.SignalLoss.Action = "close" },
+		"safe position": func(tr *Traits) { tr.SignalLoss.Position = 120 },
+		"negative gain": func(tr *Traits) { tr.Ki = -0.01 },
+	} {
+		tr := newTestController()
+		spoil(tr)
+		if err := tr.checkTuning(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
@@ -103,6 +103,10 @@ func serving(t *Traits, w http.ResponseWriter, r *http.Request, servicePath stri
 		t.diff(w, r)
 	case "jitter":
 		t.variations(w, r)
+	case "mode":
+		t.operatingMode(w, r)
+	case "output":
+		t.valveOutput(w, r)
 	default:
 		http.Error(w, "Invalid service request [Do not modify the services subpath in the configuration file]", http.StatusBadRequest)
 	}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/sdoque/mbaigo/components"
@@ -32,16 +33,26 @@ import (
 
 // Traits are Asset-specific configurable parameters
 type Traits struct {
-	SetPt     float64             `json:"setPoint"`
-	Period    time.Duration       `json:"samplingPeriod"`
-	Kp        float64             `json:"kp"`
-	Lambda    float64             `json:"lambda"`
-	Ki        float64             `json:"ki"`
-	jitter    time.Duration       `json:"-"`
-	deviation float64             `json:"-"`
-	previousT float64             `json:"-"`
-	owner     *components.System  `json:"-"`
-	cervices  components.Cervices `json:"-"`
+	SetPt        float64             `json:"setPoint"`
+	SetPtWeight  float64             `json:"setPointWeight"` // share of the setpoint in the proportional term (0 to 1)
+	SetPtRamp    float64             `json:"setPointRamp"`   // °C per minute the working setpoint follows a new one at, 0 = at once
+	Period       time.Duration       `json:"samplingPeriod"`
+	Kp           float64             `json:"kp"`           // proportional gain in percent per °C
+	Ki           float64             `json:"ki"`           // integral gain in percent per °C and second
+	Kd           float64             `json:"kd"`           // derivative gain in percent per °C/s
+	Lambda       float64             `json:"lambda"`       // time constant of the derivative filter in seconds
+	AntiWindup   string              `json:"antiWindup"`   // clamping or backCalculation
+	TrackingTime float64             `json:"trackingTime"` // back-calculation time constant in seconds, 0 = the integral time
+	SignalLoss   LossAction          `json:"signalLoss"`
+	Mode         string              `json:"mode"` // auto, manual, cascade or off
+	name         string              `json:"-"`
+	mu           sync.Mutex          `json:"-"`
+	ctl          pid                 `json:"-"`
+	jitter       time.Duration       `json:"-"`
+	deviation    float64             `json:"-"`
+	previousT    float64             `json:"-"`
+	owner        *components.System  `json:"-"`
+	cervices     components.Cervices `json:"-"`
 }
 
 //-------------------------------------Instantiate a unit asset template
@@ -70,6 +81,20 @@ func initTemplate() *components.UnitAsset {
 		RegPeriod:   120,
 		Description: "provides the current jitter or control algorithm execution calculated every period (GET)",
 	}
+	modeService := components.Service{
+		Definition:  "mode",
+		SubPath:     "mode",
+		Details:     map[string][]string{"Forms": {"ControlMode_v1"}},
+		RegPeriod:   120,
+		Description: "provides the operating mode, auto, manual, cascade or off (GET) or sets it (PUT)",
+	}
+	outputService := components.Service{
+		Definition:  "output",
+		SubPath:     "output",
+		Details:     map[string][]string{"Unit": {"Percent"}, "Forms": {"SignalA_v1a"}},
+		RegPeriod:   120,
+		Description: "provides the current valve position (GET) or sets it in manual mode (PUT)",
+	}
 
 	return &components.UnitAsset{
 		Name:    "controller_1",
@@ -79,13 +104,21 @@ func initTemplate() *components.UnitAsset {
 			setPointService.SubPath:     &setPointService,
 			thermalErrorService.SubPath: &thermalErrorService,
 			jitterService.SubPath:       &jitterService,
+			modeService.SubPath:         &modeService,
+			outputService.SubPath:       &outputService,
 		},
 		Traits: &Traits{
-			SetPt:  20,
-			Period: 10,
-			Kp:     5,
-			Lambda: 0.5,
-			Ki:     0,
+			SetPt:       20,
+			SetPtWeight: 1,
+			SetPtRamp:   0.5,
+			Period:      10,
+			Kp:          5,
+			Ki:          0.01,
+			Kd:          0,
+			Lambda:      60,
+			AntiWindup:  backCalculation,
+			SignalLoss:  LossAction{Action: lossRamp, Position: 50, Rate: 5},
+			Mode:        modeAuto,
 		},
 	}
 }
@@ -107,14 +140,26 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 		Nodes:      make(map[string][]components.NodeInfo),
 		Mode:       "set",
 	}
+	upstreamCervice := &components.Cervice{
+		Definition: "setPoint",
+		Protos:     sProtocols,
+		Nodes:      make(map[string][]components.NodeInfo),
+		Mode:       "get",
+	}
 	cervMap := components.Cervices{
-		tempCervice.Definition: tempCervice,
-		rotCervice.Definition:  rotCervice,
+		tempCervice.Definition:     tempCervice,
+		rotCervice.Definition:      rotCervice,
+		upstreamCervice.Definition: upstreamCervice,
 	}
 
 	t := &Traits{
-		owner:    sys,
-		cervices: cervMap,
+		SetPtWeight: 1,
+		AntiWindup:  clamping,
+		SignalLoss:  LossAction{Action: lossSafe, Position: outputBias},
+		Mode:        modeAuto,
+		name:        configuredAsset.Name,
+		owner:       sys,
+		cervices:    cervMap,
 	}
 
 	if len(configuredAsset.Traits) > 0 {
@@ -122,6 +167,14 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 			log.Println("Warning: could not unmarshal traits:", err)
 		}
 	}
+	if err := t.checkTuning(); err != nil {
+		log.Fatalf("controller %s: %v\n", configuredAsset.Name, err)
+	}
+	mode := t.Mode
+	t.Mode = modeAuto
+	if _, err := t.setMode(mode); err != nil {
+		log.Fatalf("controller %s: %v\n", configuredAsset.Name, err)
+	}
 
 	ua := &components.UnitAsset{
 		Name:        configuredAsset.Name,
@@ -138,6 +191,8 @@ func newResource(configuredAsset usecases.ConfigurableAsset, sys *components.Sys
 
 	ua.CervicesMap["temperature"].Details = components.MergeDetails(ua.Details, map[string][]string{"Unit": {"Celsius"}, "Forms": {"SignalA_v1a"}})
 	ua.CervicesMap["rotation"].Details = components.MergeDetails(ua.Details, map[string][]string{"Unit": {"Percent"}, "Forms": {"SignalA_v1a"}})
+	// the upstream controller's setpoint in cascade mode (e.g., the flattener's), not the thermostat's own "setpoint"
+	ua.CervicesMap["setPoint"].Details = components.MergeDetails(ua.Details, map[string][]string{"Unit": {"Celsius"}, "Forms": {"SignalA_v1a"}})
 
 	go t.feedbackLoop(sys.Ctx)
 
@@ -155,6 +210,10 @@ func (t *Traits) setpt(w http.ResponseWriter, r *http.Request) {
 		setPointForm := t.getSetPoint()
 		usecases.HTTPProcessGetRequest(w, r, &setPointForm)
 	case "PUT":
+		if t.getMode().Mode == modeCascade {
+			http.Error(w, "The setpoint comes from the upstream controller in cascade mode", http.StatusConflict)
+			return
+		}
 		sig, err := usecases.HTTPProcessSetRequest(w, r)
 		if err != nil {
 			log.Println("Error with the setting request of the position ", err)
@@ -193,6 +252,8 @@ func (t *Traits) variations(w http.ResponseWriter, r *http.Request) {
 
 // getSetPoint fills out a signal form with the current thermal setpoint
 func (t *Traits) getSetPoint() (f forms.SignalA_v1a) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	f.NewForm()
 	f.Value = t.SetPt
 	f.Unit = "Celsius"
@@ -202,12 +263,16 @@ func (t *Traits) getSetPoint() (f forms.SignalA_v1a) {
 
 // setSetPoint updates the thermal setpoint
 func (t *Traits) setSetPoint(f forms.SignalA_v1a) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.SetPt = f.Value
 	log.Printf("new set point: %.1f", f.Value)
 }
 
 // getError fills out a signal form with the current thermal setpoint and temperature
 func (t *Traits) getError() (f forms.SignalA_v1a) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	f.NewForm()
 	f.Value = t.deviation
 	f.Unit = "Celsius"
@@ -217,6 +282,8 @@ func (t *Traits) getError() (f forms.SignalA_v1a) {
 
 // getJitter fills out a signal form with the current jitter
 func (t *Traits) getJitter() (f forms.SignalA_v1a) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	f.NewForm()
 	f.Value = float64(t.jitter.Milliseconds())
 	f.Unit = "millisecond"
@@ -243,41 +310,39 @@ func (t *Traits) feedbackLoop(ctx context.Context) {
 func (t *Traits) processFeedbackLoop() {
 	jitterStart := time.Now()
 
+	if t.getMode().Mode == modeCascade {
+		t.followSetPoint()
+	}
+
 	tf, err := usecases.GetState(t.cervices["temperature"], t.owner)
+	tup, read := tf.(*forms.SignalA_v1a)
 	if err != nil {
 		log.Printf("\n unable to obtain a temperature reading error: %s\n", err)
-		t.updateValvePosition(50)
-		return
-	}
-	tup, ok := tf.(*forms.SignalA_v1a)
-	if !ok {
+	} else if !read {
 		log.Println("problem unpacking the temperature signal form")
-		t.updateValvePosition(50)
-		return
+	}
+	var temperature float64
+	if read {
+		temperature = tup.Value
 	}
 
-	t.deviation = t.SetPt - tup.Value
-	output := t.calculateOutput(t.deviation)
-
-	if tup.Value != t.previousT {
-		log.Printf("the temperature is %.2f °C with an error %.2f°C and valve set at %.2f%%\n", tup.Value, t.deviation, output)
-		t.previousT = tup.Value
+	t.mu.Lock()
+	output := t.control(temperature, read)
+	deviation, mode := t.deviation, t.Mode
+	t.mu.Unlock()
+
+	switch {
+	case !read && (mode == modeAuto || mode == modeCascade):
+		log.Printf("no temperature signal (%s): valve set at %.2f%%\n", t.SignalLoss.Action, output)
+	case read && temperature != t.previousT:
+		log.Printf("the temperature is %.2f °C with an error %.2f°C and valve set at %.2f%% (%s)\n", temperature, deviation, output, mode)
+		t.previousT = temperature
 	}
 
 	t.updateValvePosition(output)
+	t.mu.Lock()
 	t.jitter = time.Since(jitterStart)
-}
-
-// calculateOutput is the actual P controller
-func (t *Traits) calculateOutput(thermDiff float64) float64 {
-	vPosition := t.Kp*thermDiff + 50
-
-	if vPosition < 0 {
-		vPosition = 0
-	} else if vPosition > 100 {
-		vPosition = 100
-	}
-	return vPosition
+	t.mu.Unlock()
 }
 
 func (t *Traits) updateValvePosition(position float64) {
@@ -291,6 +356,7 @@ func (t *Traits) updateValvePosition(position float64) {
 	if err != nil {
 		return
 	}
-	_, err = usecases.SetState(t.cervices["rotation"], t.owner, op)
-	_ = err
+	if _, err = usecases.SetState(t.cervices["rotation"], t.owner, op); err != nil {
+		log.Printf("cannot update the valve position: %s\n", err)
+	}
 }
@@ -84,8 +84,8 @@ func TestGetJitter(t *testing.T) {
 	}
 }
 
-// TestCalculateOutput is a table-driven test for the P-controller output
-// clamped to [0, 100].
+// TestCalculateOutput is a table-driven test for the first output of the
+// controller without integral action, clamped to [0, 100].
 func TestCalculateOutput(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -102,8 +102,8 @@ func TestCalculateOutput(t *testing.T) {
 	for _, tc := range cases {
 		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			tr := &Traits{Kp: tc.kp}
-			got := tr.calculateOutput(tc.diff)
+			tr := &Traits{SetPt: 20, SetPtWeight: 1, Kp: tc.kp}
+			got := tr.calculateOutput(20 - tc.diff)
 			if got != tc.expected {
 				t.Errorf("calculateOutput(%f) with Kp=%f: expected %f, got %f",
 					tc.diff, tc.kp, tc.expected, got)
@@ -0,0 +1,414 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//-------------------------------------Invoicing configuration
+
+// Invoicing holds what a PEPPOL invoice needs beyond the order itself: the
+// seller, and the price of a pen holder.
+type Invoicing struct {
+	SellerName      string  `json:"sellerName"`
+	SellerPeppolID  string  `json:"sellerPeppolId"`  // <scheme>:<identifier>, e.g. 0007:5567321707
+	SellerVATNumber string  `json:"sellerVatNumber"` // e.g. SE556732170701
+	SellerStreet    string  `json:"sellerStreet"`
+	SellerCity      string  `json:"sellerCity"`
+	SellerPostcode  string  `json:"sellerPostcode"`
+	SellerCountry   string  `json:"sellerCountry"`  // ISO 3166-1 alpha-2
+	IBAN            string  `json:"iban,omitempty"` // account the buyer pays to; empty = no payment means
+	Currency        string  `json:"currency"`       // ISO 4217
+	UnitPrice       float64 `json:"unitPrice"`      // VAT excluded, for orders placed without a price
+	VATPercent      float64 `json:"vatPercent"`
+	PaymentDays     int     `json:"paymentDays"`  // payment terms; 0 = no due date
+	NumberPrefix    string  `json:"numberPrefix"` // invoice number = prefix + order number
+}
+
+// check tells what the configuration lacks for an invoice.
+func (c *Invoicing) check() error {
+	var missing []string
+	for name, v := range map[string]string{
+		"sellerName": c.SellerName, "sellerPeppolId": c.SellerPeppolID, "sellerVatNumber": c.SellerVATNumber,
+		"sellerCity": c.SellerCity, "sellerCountry": c.SellerCountry, "currency": c.Currency,
+	} {
+		if v == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("invoicing is not configured: missing %s", strings.Join(missing, ", "))
+	}
+	if c.UnitPrice < 0 || c.VATPercent < 0 {
+		return fmt.Errorf("invoicing is not configured: unitPrice and vatPercent must not be negative")
+	}
+	return nil
+}
+
+//-------------------------------------UBL invoice
+
+// The identifiers of a PEPPOL BIS Billing 3.0 invoice.
+const (
+	peppolCustomizationID = "urn:cen.eu:en16931:2017#compliant#urn:fdc:peppol.eu:2017:poacc:billing:3.0"
+	peppolProfileID       = "urn:fdc:peppol.eu:2017:poacc:billing:01:1.0"
+	commercialInvoice     = "380"
+	creditTransfer        = "30"
+	unitPiece             = "H87"
+)
+
+// peppolCountries gives the country of the PEPPOL identifier schemes (EAS)
+// that belong to one; the buyer's country is taken from its scheme.
+var peppolCountries = map[string]string{
+	"0007": "SE", "0037": "FI", "0096": "DK", "0184": "DK", "0192": "NO", "0106": "NL",
+	"0190": "NL", "0208": "BE", "0204": "DE", "9930": "DE", "0009": "FR", "0211": "IT", "9906": "IT",
+}
+
+// splitPeppolID splits a participant identifier such as 0007:5567321707 into
+// its scheme and identifier.
+func splitPeppolID(id string) (scheme, value string, err error) {
+	scheme, value, ok := strings.Cut(strings.TrimSpace(id), ":")
+	if !ok || len(scheme) != 4 || value == "" {
+		return "", "", fmt.Errorf("%q is not a PEPPOL participant ID (<scheme>:<identifier>)", id)
+	}
+	if _, err := strconv.Atoi(scheme); err != nil {
+		return "", "", fmt.Errorf("%q is not a PEPPOL participant ID (<scheme>:<identifier>)", id)
+	}
+	return scheme, value, nil
+}
+
+// UBL elements are named with their namespace prefix, declared on the root.
+type ublInvoice struct {
+	XMLName                 xml.Name     `xml:"Invoice"`
+	Xmlns                   string       `xml:"xmlns,attr"`
+	XmlnsCac                string       `xml:"xmlns:cac,attr"`
+	XmlnsCbc                string       `xml:"xmlns:cbc,attr"`
+	CustomizationID         string       `xml:"cbc:CustomizationID"`
+	ProfileID               string       `xml:"cbc:ProfileID"`
+	ID                      string       `xml:"cbc:ID"`
+	IssueDate               string       `xml:"cbc:IssueDate"`
+	DueDate                 string       `xml:"cbc:DueDate,omitempty"`
+	InvoiceTypeCode         string       `xml:"cbc:InvoiceTypeCode"`
+	DocumentCurrencyCode    string       `xml:"cbc:DocumentCurrencyCode"`
+	BuyerReference          string       `xml:"cbc:BuyerReference"`
+	OrderReference          ublReference `xml:"cac:OrderReference"`
+	AccountingSupplierParty ublPartyOf   `xml:"cac:AccountingSupplierParty"`
+	AccountingCustomerParty ublPartyOf   `xml:"cac:AccountingCustomerParty"`
+	Delivery                ublDelivery  `xml:"cac:Delivery"`
+	PaymentMeans            *ublPayment  `xml:"cac:PaymentMeans,omitempty"`
+	PaymentTerms            *ublTerms    `xml:"cac:PaymentTerms,omitempty"`
+	TaxTotal                ublTaxTotal  `xml:"cac:TaxTotal"`
+	LegalMonetaryTotal      ublTotals    `xml:"cac:LegalMonetaryTotal"`
+	InvoiceLines            []ublLine    `xml:"cac:InvoiceLine"`
+}
+
+type ublReference struct {
+	ID string `xml:"cbc:ID"`
+}
+
+type ublSchemeID struct {
+	SchemeID string `xml:"schemeID,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type ublAmount struct {
+	CurrencyID string `xml:"currencyID,attr"`
+	Value      string `xml:",chardata"`
+}
+
+type ublPartyOf struct {
+	Party ublParty `xml:"cac:Party"`
+}
+
+type ublParty struct {
+	EndpointID       ublSchemeID    `xml:"cbc:EndpointID"`
+	PostalAddress    ublAddress     `xml:"cac:PostalAddress"`
+	PartyTaxScheme   *ublPartyTax   `xml:"cac:PartyTaxScheme,omitempty"`
+	PartyLegalEntity ublLegalEntity `xml:"cac:PartyLegalEntity"`
+	Contact          *ublContact    `xml:"cac:Contact,omitempty"`
+}
+
+type ublAddress struct {
+	StreetName string     `xml:"cbc:StreetName,omitempty"`
+	CityName   string     `xml:"cbc:CityName,omitempty"`
+	PostalZone string     `xml:"cbc:PostalZone,omitempty"`
+	Country    ublCountry `xml:"cac:Country"`
+}
+
+type ublCountry struct {
+	IdentificationCode string `xml:"cbc:IdentificationCode"`
+}
+
+type ublPartyTax struct {
+	CompanyID string       `xml:"cbc:CompanyID"`
+	TaxScheme ublReference `xml:"cac:TaxScheme"`
+}
+
+type ublLegalEntity struct {
+	RegistrationName string `xml:"cbc:RegistrationName"`
+}
+
+type ublContact struct {
+	ElectronicMail string `xml:"cbc:ElectronicMail"`
+}
+
+type ublDelivery struct {
+	ActualDeliveryDate string `xml:"cbc:ActualDeliveryDate"`
+}
+
+type ublPayment struct {
+	PaymentMeansCode      string       `xml:"cbc:PaymentMeansCode"`
+	PaymentID             string       `xml:"cbc:PaymentID"`
+	PayeeFinancialAccount ublReference `xml:"cac:PayeeFinancialAccount"`
+}
+
+type ublTerms struct {
+	Note string `xml:"cbc:Note"`
+}
+
+type ublTaxCategory struct {
+	ID        string       `xml:"cbc:ID"`
+	Percent   string       `xml:"cbc:Percent"`
+	TaxScheme ublReference `xml:"cac:TaxScheme"`
+}
+
+type ublTaxTotal struct {
+	TaxAmount   ublAmount      `xml:"cbc:TaxAmount"`
+	TaxSubtotal ublTaxSubtotal `xml:"cac:TaxSubtotal"`
+}
+
+type ublTaxSubtotal struct {
+	TaxableAmount ublAmount      `xml:"cbc:TaxableAmount"`
+	TaxAmount     ublAmount      `xml:"cbc:TaxAmount"`
+	TaxCategory   ublTaxCategory `xml:"cac:TaxCategory"`
+}
+
+type ublTotals struct {
+	LineExtensionAmount ublAmount `xml:"cbc:LineExtensionAmount"`
+	TaxExclusiveAmount  ublAmount `xml:"cbc:TaxExclusiveAmount"`
+	TaxInclusiveAmount  ublAmount `xml:"cbc:TaxInclusiveAmount"`
+	PayableAmount       ublAmount `xml:"cbc:PayableAmount"`
+}
+
+type ublLine struct {
+	ID                  string    `xml:"cbc:ID"`
+	InvoicedQuantity    ublQty    `xml:"cbc:InvoicedQuantity"`
+	LineExtensionAmount ublAmount `xml:"cbc:LineExtensionAmount"`
+	Item                ublItem   `xml:"cac:Item"`
+	Price               ublPrice  `xml:"cac:Price"`
+}
+
+type ublQty struct {
+	UnitCode string `xml:"unitCode,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type ublItem struct {
+	Description              string         `xml:"cbc:Description"`
+	Name                     string         `xml:"cbc:Name"`
+	ClassifiedTaxCategory    ublTaxCategory `xml:"cac:ClassifiedTaxCategory"`
+	AdditionalItemProperties []ublProperty  `xml:"cac:AdditionalItemProperty"`
+}
+
+type ublProperty struct {
+	Name  string `xml:"cbc:Name"`
+	Value string `xml:"cbc:Value"`
+}
+
+type ublPrice struct {
+	PriceAmount ublAmount `xml:"cbc:PriceAmount"`
+}
+
+// ErrNotInvoiceable is returned for orders that cannot be invoiced yet.
+var ErrNotInvoiceable = errors.New("order cannot be invoiced")
+
+// BuildInvoice writes the PEPPOL BIS Billing 3.0 invoice of a shipped order
+// whose customer has a PEPPOL ID, issued on the given day. The order is
+// invoiced at the price it was placed at or, for orders placed without one,
+// at the configured unit price. Amounts are kept in cents, so that the totals
+// add up exactly.
+func BuildInvoice(o *ProductOrder_v1, c *Invoicing, issued time.Time) ([]byte, error) {
+	if o.Status != StatusShipped {
+		return nil, fmt.Errorf("order %d is %s, only shipped orders are invoiced: %w", o.OrderNumber, o.Status, ErrNotInvoiceable)
+	}
+	if o.PeppolID == "" {
+		return nil, fmt.Errorf("order %d has no PEPPOL ID: %w", o.OrderNumber, ErrNotInvoiceable)
+	}
+	buyerScheme, buyerID, err := splitPeppolID(o.PeppolID)
+	if err != nil {
+		return nil, fmt.Errorf("order %d: %v: %w", o.OrderNumber, err, ErrNotInvoiceable)
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	sellerScheme, sellerID, err := splitPeppolID(c.SellerPeppolID)
+	if err != nil {
+		return nil, fmt.Errorf("invoicing: sellerPeppolId: %w", err)
+	}
+	buyerCountry, ok := peppolCountries[buyerScheme]
+	if !ok {
+		buyerCountry = c.SellerCountry
+	}
+
+	amount := func(cents int64) ublAmount {
+		return ublAmount{CurrencyID: c.Currency, Value: fmt.Sprintf("%d.%02d", cents/100, cents%100)}
+	}
+	price := c.UnitPrice
+	if o.Price > 0 {
+		if o.Currency != "" && o.Currency != c.Currency {
+			return nil, fmt.Errorf("order %d is priced in %s, invoices are in %s: %w", o.OrderNumber, o.Currency, c.Currency, ErrNotInvoiceable)
+		}
+		price = o.Price
+	}
+	if price <= 0 {
+		return nil, fmt.Errorf("order %d has no price and no unitPrice is configured: %w", o.OrderNumber, ErrNotInvoiceable)
+	}
+	net := int64(math.Round(price * 100))
+	vat := int64(math.Round(float64(net) * c.VATPercent / 100))
+	category := ublTaxCategory{ID: "S", Percent: strconv.FormatFloat(c.VATPercent, 'f', -1, 64), TaxScheme: ublReference{ID: "VAT"}}
+	if c.VATPercent == 0 {
+		category.ID = "Z"
+	}
+	number := c.NumberPrefix + strconv.Itoa(o.OrderNumber)
+
+	inv := ublInvoice{
+		Xmlns:                "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2",
+		XmlnsCac:             "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2",
+		XmlnsCbc:             "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2",
+		CustomizationID:      peppolCustomizationID,
+		ProfileID:            peppolProfileID,
+		ID:                   number,
+		IssueDate:            issued.Format(time.DateOnly),
+		InvoiceTypeCode:      commercialInvoice,
+		DocumentCurrencyCode: c.Currency,
+		BuyerReference:       strconv.Itoa(o.OrderNumber),
+		OrderReference:       ublReference{ID: strconv.Itoa(o.OrderNumber)},
+		AccountingSupplierParty: ublPartyOf{Party: ublParty{
+			EndpointID: ublSchemeID{SchemeID: sellerScheme, Value: sellerID},
+			PostalAddress: ublAddress{StreetName: c.SellerStreet, CityName: c.SellerCity, PostalZone: c.SellerPostcode,
+				Country: ublCountry{IdentificationCode: c.SellerCountry}},
+			PartyTaxScheme:   &ublPartyTax{CompanyID: c.SellerVATNumber, TaxScheme: ublReference{ID: "VAT"}},
+			PartyLegalEntity: ublLegalEntity{RegistrationName: c.SellerName},
+		}},
+		AccountingCustomerParty: ublPartyOf{Party: ublParty{
+			EndpointID:       ublSchemeID{SchemeID: buyerScheme, Value: buyerID},
+			PostalAddress:    ublAddress{Country: ublCountry{IdentificationCode: buyerCountry}},
+			PartyLegalEntity: ublLegalEntity{RegistrationName: o.Name},
+			Contact:          &ublContact{ElectronicMail: o.Email},
+		}},
+		Delivery: ublDelivery{ActualDeliveryDate: o.CompletedTimestamp.Format(time.DateOnly)},
+		TaxTotal: ublTaxTotal{TaxAmount: amount(vat), TaxSubtotal: ublTaxSubtotal{
+			TaxableAmount: amount(net), TaxAmount: amount(vat), TaxCategory: category,
+		}},
+		LegalMonetaryTotal: ublTotals{
+			LineExtensionAmount: amount(net), TaxExclusiveAmount: amount(net),
+			TaxInclusiveAmount: amount(net + vat), PayableAmount: amount(net + vat),
+		},
+		InvoiceLines: []ublLine{{
+			ID:                  "1",
+			InvoicedQuantity:    ublQty{UnitCode: unitPiece, Value: "1"},
+			LineExtensionAmount: amount(net),
+			Item:                invoiceItem(o, category),
+			Price:               ublPrice{PriceAmount: amount(net)},
+		}},
+	}
+	if c.PaymentDays > 0 {
+		inv.DueDate = issued.AddDate(0, 0, c.PaymentDays).Format(time.DateOnly)
+		inv.PaymentTerms = &ublTerms{Note: fmt.Sprintf("Net %d days", c.PaymentDays)}
+	}
+	if c.IBAN != "" {
+		inv.PaymentMeans = &ublPayment{PaymentMeansCode: creditTransfer, PaymentID: number,
+			PayeeFinancialAccount: ublReference{ID: strings.ReplaceAll(c.IBAN, " ", "")}}
+	}
+
+	out, err := xml.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshalling invoice: %w", err)
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// invoiceItem describes the product of an order on its invoice line, with the
+// order's parameters as item properties.
+func invoiceItem(o *ProductOrder_v1, category ublTaxCategory) ublItem {
+	item := ublItem{Description: o.describe(), Name: o.ProductID, ClassifiedTaxCategory: category}
+	if ph, ok := o.penHolderOrder(); ok {
+		item.Name = "Pen holder"
+		item.AdditionalItemProperties = []ublProperty{
+			{Name: "Height (mm)", Value: strconv.FormatFloat(ph.Height, 'f', -1, 64)},
+			{Name: "Depth (mm)", Value: strconv.FormatFloat(ph.Depth, 'f', -1, 64)},
+			{Name: "Roughness", Value: strconv.Itoa(ph.Roughness)},
+		}
+		return item
+	}
+	for name, v := range o.Parameters {
+		item.AdditionalItemProperties = append(item.AdditionalItemProperties, ublProperty{Name: name, Value: fmt.Sprint(v)})
+	}
+	sort.Slice(item.AdditionalItemProperties, func(i, j int) bool {
+		return item.AdditionalItemProperties[i].Name < item.AdditionalItemProperties[j].Name
+	})
+	return item
+}
+
+//-------------------------------------Service handler
+
+// invoiceHandler answers the UBL invoice of shipped order ?id=N to operators
+// (GET).
+func (t *Traits) invoiceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method is not supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	if !t.authorized(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "query parameter id must be an order number", http.StatusBadRequest)
+		return
+	}
+	order, err := GetProductOrder(t.db, id)
+	if errors.Is(err, ErrOrderNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	invoice, err := BuildInvoice(order, &t.Invoicing, time.Now())
+	if errors.Is(err, ErrNotInvoiceable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="invoice-%s%d.xml"`, t.Invoicing.NumberPrefix, id))
+	w.WriteHeader(http.StatusOK)
+	w.Write(invoice) //nolint:errcheck
+}
@@ -0,0 +1,169 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testInvoicing is a complete invoicing configuration.
+var testInvoicing = Invoicing{
+	SellerName: "Pen Holders AB", SellerPeppolID: "0007:5567321707", SellerVATNumber: "SE556732170701",
+	SellerStreet: "Storgatan 1", SellerCity: "Luleå", SellerPostcode: "97231", SellerCountry: "SE",
+	IBAN: "SE45 5000 0000 0583 9825 7466", Currency: "SEK", UnitPrice: 249.99, VATPercent: 25,
+	PaymentDays: 30, NumberPrefix: "PH-",
+}
+
+// shippedOrder files an order with a PEPPOL ID and ships it.
+func shippedOrder(t *testing.T, tr *Traits, peppolID string) int {
+	t.Helper()
+	order := &PenHolderOrder_v1{Name: "Ivar AB", Email: "ap@ivar.example", Height: 15, Depth: 5, Roughness: 3,
+		OrderedTimestamp: time.Now(), ProductionLine: "LineA", PeppolID: peppolID}
+	order.NewForm()
+	id, err := InsertOrder(tr.db, order)
+	if err != nil {
+		t.Fatalf("InsertOrder: %v", err)
+	}
+	moveTo(t, tr, id, StatusScheduled, StatusInProduction, StatusQualityCheck)
+	if err := ChangeStatus(tr.db, &OrderStatus_v1{OrderNumber: id, Status: StatusShipped,
+		Timestamp: time.Date(2026, 4, 15, 16, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatalf("ChangeStatus: %v", err)
+	}
+	return id
+}
+
+// TestBuildInvoice checks the BIS Billing 3.0 header, the parties, the totals
+// and the order of the top-level elements, which the UBL schema fixes.
+func TestBuildInvoice(t *testing.T) {
+	tr := openTestDB(t)
+	id := shippedOrder(t, tr, "0192:987654321")
+	order, _ := GetProductOrder(tr.db, id)
+	data, err := BuildInvoice(order, &testInvoicing, time.Date(2026, 4, 16, 8, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("BuildInvoice: %v", err)
+	}
+	invoice := string(data)
+	for _, want := range []string{
+		`<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"`,
+		`<cbc:CustomizationID>urn:cen.eu:en16931:2017#compliant#urn:fdc:peppol.eu:2017:poacc:billing:3.0</cbc:CustomizationID>`,
+		`<cbc:ID>PH-` + intStr(id) + `</cbc:ID>`,
+		`<cbc:IssueDate>2026-04-16</cbc:IssueDate>`,
+		`<cbc:DueDate>2026-05-16</cbc:DueDate>`,
+		`<cbc:EndpointID schemeID="0007">5567321707</cbc:EndpointID>`,
+		`<cbc:EndpointID schemeID="0192">987654321</cbc:EndpointID>`,
+		`<cbc:IdentificationCode>NO</cbc:IdentificationCode>`,
+		`<cbc:ActualDeliveryDate>2026-04-15</cbc:ActualDeliveryDate>`,
+		`<cbc:ID>SE4550000000058398257466</cbc:ID>`,
+		`<cbc:TaxAmount currencyID="SEK">62.50</cbc:TaxAmount>`,
+		`<cbc:TaxExclusiveAmount currencyID="SEK">249.99</cbc:TaxExclusiveAmount>`,
+		`<cbc:PayableAmount currencyID="SEK">312.49</cbc:PayableAmount>`,
+		`<cbc:InvoicedQuantity unitCode="H87">1</cbc:InvoicedQuantity>`,
+		`<cbc:Percent>25</cbc:Percent>`,
+	} {
+		if !strings.Contains(invoice, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	var elements []string
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch e := tok.(type) {
+		case xml.StartElement:
+			if depth == 1 {
+				elements = append(elements, e.Name.Local)
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	want := "CustomizationID ProfileID ID IssueDate DueDate InvoiceTypeCode DocumentCurrencyCode BuyerReference OrderReference " +
+		"AccountingSupplierParty AccountingCustomerParty Delivery PaymentMeans PaymentTerms TaxTotal LegalMonetaryTotal InvoiceLine"
+	if got := strings.Join(elements, " "); got != want {
+		t.Errorf("element order\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestInvoiceRefused only invoices shipped orders with a usable PEPPOL ID,
+// under a complete configuration.
+func TestInvoiceRefused(t *testing.T) {
+	tr := openTestDB(t)
+	open := newTestOrder(t, tr)
+	order, _ := GetProductOrder(tr.db, open)
+	order.PeppolID = "0007:5567321707"
+	if _, err := BuildInvoice(order, &testInvoicing, time.Now()); !errors.Is(err, ErrNotInvoiceable) {
+		t.Errorf("open order: %v", err)
+	}
+	for _, peppolID := range []string{"", "5567321707", "SE:5567321707"} {
+		order, _ := GetProductOrder(tr.db, shippedOrder(t, tr, peppolID))
+		if _, err := BuildInvoice(order, &testInvoicing, time.Now()); !errors.Is(err, ErrNotInvoiceable) {
+			t.Errorf("PEPPOL ID %q: %v", peppolID, err)
+		}
+	}
+	order, _ = GetProductOrder(tr.db, shippedOrder(t, tr, "0007:5567321707"))
+	if _, err := BuildInvoice(order, &Invoicing{Currency: "SEK"}, time.Now()); err == nil || errors.Is(err, ErrNotInvoiceable) {
+		t.Errorf("unconfigured invoicing: %v", err)
+	}
+}
+
+// TestInvoiceHandler serves invoices to operators only.
+func TestInvoiceHandler(t *testing.T) {
+	tr := openTestDB(t)
+	tr.Operators = []Operator{{Name: "Hanna", Token: testToken}}
+	tr.Invoicing = testInvoicing
+	shipped := shippedOrder(t, tr, "0007:5567321707")
+	open := newTestOrder(t, tr)
+
+	get := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/invoice?"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		serving(tr, w, req, "invoice")
+		return w
+	}
+	if w := get("id="+intStr(shipped), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", w.Code)
+	}
+	w := get("id="+intStr(shipped), testToken)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/xml" {
+		t.Errorf("expected 200 application/xml, got %d %s: %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	for query, code := range map[string]int{
+		"id=" + intStr(open): http.StatusConflict,
+		"id=9999":            http.StatusNotFound,
+		"id=x":               http.StatusBadRequest,
+	} {
+		if w := get(query, testToken); w.Code != code {
+			t.Errorf("%s: expected %d, got %d", query, code, w.Code)
+		}
+	}
+}
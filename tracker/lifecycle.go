@@ -0,0 +1,333 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+//-------------------------------------Order lifecycle
+
+// The states of an order, from the tracker filing it to the parcel leaving.
+const (
+	StatusReceived     = "received"
+	StatusScheduled    = "scheduled"
+	StatusInProduction = "in_production"
+	StatusQualityCheck = "quality_check"
+	StatusShipped      = "shipped"
+	StatusCancelled    = "cancelled"
+)
+
+// transitions lists the states an order may move to from each state. A part
+// that fails its quality check goes back into production; shipped and
+// cancelled orders are final.
+var transitions = map[string][]string{
+	StatusReceived:     {StatusScheduled, StatusCancelled},
+	StatusScheduled:    {StatusInProduction, StatusCancelled},
+	StatusInProduction: {StatusQualityCheck, StatusCancelled},
+	StatusQualityCheck: {StatusShipped, StatusInProduction, StatusCancelled},
+	StatusShipped:      {},
+	StatusCancelled:    {},
+}
+
+// ErrOrderNotFound is returned for an order number the tracker does not know.
+var ErrOrderNotFound = errors.New("order not found")
+
+// TransitionError is a status change the lifecycle does not allow.
+type TransitionError struct {
+	From, To string
+}
+
+func (e *TransitionError) Error() string {
+	if _, known := transitions[e.To]; !known {
+		return fmt.Sprintf("unknown order status %q", e.To)
+	}
+	return fmt.Sprintf("an order cannot go from %s to %s", e.From, e.To)
+}
+
+// allowed tells if an order in state from may move to state to.
+func allowed(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// specFrozen tells if an order in state status is too far along for its
+// dimensions to change.
+func specFrozen(status string) bool {
+	return status != StatusReceived && status != StatusScheduled
+}
+
+// final tells if an order in state status can no longer change at all.
+func final(status string) bool {
+	return len(transitions[status]) == 0
+}
+
+//-------------------------------------Status form
+
+// StatusEntry is one line of an order's status history.
+type StatusEntry struct {
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	Source    string    `json:"source,omitempty"`
+	Note      string    `json:"note,omitempty"`
+}
+
+// OrderStatus_v1 is the exchanged form for an order's production status. A
+// production line pushes a change with it (the history is ignored), and the
+// status service answers with the order's current status and its history.
+type OrderStatus_v1 struct {
+	OrderNumber    int           `json:"order_number"`
+	Status         string        `json:"status"`
+	ProductionLine string        `json:"production_line,omitempty"`
+	Source         string        `json:"source,omitempty"`
+	Note           string        `json:"note,omitempty"`
+	Timestamp      time.Time     `json:"timestamp"`
+	History        []StatusEntry `json:"history,omitempty"`
+	Version        string        `json:"version"`
+}
+
+func (f *OrderStatus_v1) NewForm() forms.Form {
+	f.Version = "OrderStatus_v1"
+	return f
+}
+
+func (f *OrderStatus_v1) FormVersion() string {
+	return f.Version
+}
+
+func init() {
+	forms.FormTypeMap["OrderStatus_v1"] = reflect.TypeOf(OrderStatus_v1{})
+}
+
+//-------------------------------------Service handler
+
+// statusHandler handles GET (status and history of ?id=N, for a production
+// line or an operator with a token, or for the customer with &email=E) and
+// POST (a status change pushed by a production line or an operator, who must
+// carry a token and is recorded as its source).
+func (t *Traits) statusHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+
+	case http.MethodGet:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "query parameter id must be an order number", http.StatusBadRequest)
+			return
+		}
+		if _, ok := t.reporter(r); !ok {
+			email := r.URL.Query().Get("email")
+			if email == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="tracker"`)
+				http.Error(w, "a production line or operator token, or the customer's email, is required", http.StatusUnauthorized)
+				return
+			}
+			if _, err := GetProductOrderByIDAndEmail(t.db, id, email); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		}
+		status, err := GetOrderStatus(t.db, id)
+		if errors.Is(err, ErrOrderNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		usecases.HTTPProcessGetRequest(w, r, status)
+
+	case http.MethodPost:
+		reporter, ok := t.reporter(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tracker"`)
+			http.Error(w, "a production line or operator token is required", http.StatusUnauthorized)
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, "could not parse Content-Type: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "reading request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		unpacked, err := usecases.Unpack(bodyBytes, mediaType)
+		if err != nil {
+			http.Error(w, "unpacking request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		change, ok := unpacked.(*OrderStatus_v1)
+		if !ok {
+			http.Error(w, "expected OrderStatus_v1 body", http.StatusBadRequest)
+			return
+		}
+
+		// The token holder is the source; a source the client gives is kept in the note
+		if change.Source != "" && change.Source != reporter {
+			change.Note = strings.TrimSuffix("reported as "+change.Source+"; "+change.Note, "; ")
+		}
+		change.Source = reporter
+		err = ChangeStatus(t.db, change)
+		var te *TransitionError
+		switch {
+		case errors.Is(err, ErrOrderNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.As(err, &te):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, "changing status: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("tracker: order %d is %s (%s)\n", change.OrderNumber, change.Status, change.Source)
+
+		status, err := GetOrderStatus(t.db, change.OrderNumber)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		confirmed, err := usecases.Pack(status, mediaType)
+		if err != nil {
+			http.Error(w, "marshalling response: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", mediaType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(confirmed) //nolint:errcheck
+
+	default:
+		http.Error(w, "Method is not supported.", http.StatusMethodNotAllowed)
+	}
+}
+
+//-------------------------------------Database
+
+// ChangeStatus moves an order to change.Status if the lifecycle allows it,
+// and appends the change to its history. A zero change.Timestamp means now;
+// a production line, if given, becomes the order's. Shipping an order sets
+// its completion time.
+func ChangeStatus(db *sql.DB, change *OrderStatus_v1) error {
+	if change.Timestamp.IsZero() {
+		change.Timestamp = time.Now()
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var current string
+	err = tx.QueryRow(`SELECT Status FROM PenHolderOrders WHERE OrderNumber=?;`, change.OrderNumber).Scan(&current)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("order %d: %w", change.OrderNumber, ErrOrderNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("querying order: %w", err)
+	}
+	if !allowed(current, change.Status) {
+		return &TransitionError{From: current, To: change.Status}
+	}
+
+	if _, err := tx.Exec(`UPDATE PenHolderOrders SET Status=? WHERE OrderNumber=?;`, change.Status, change.OrderNumber); err != nil {
+		return fmt.Errorf("updating status: %w", err)
+	}
+	if change.ProductionLine != "" {
+		if _, err := tx.Exec(`UPDATE PenHolderOrders SET ProductionLine=? WHERE OrderNumber=?;`, change.ProductionLine, change.OrderNumber); err != nil {
+			return fmt.Errorf("updating production line: %w", err)
+		}
+	}
+	if change.Status == StatusShipped {
+		if _, err := tx.Exec(`UPDATE PenHolderOrders SET CompletedTimestamp=? WHERE OrderNumber=?;`, change.Timestamp, change.OrderNumber); err != nil {
+			return fmt.Errorf("updating completion time: %w", err)
+		}
+	}
+	if err := appendStatus(tx, change.OrderNumber, change.Status, change.Timestamp, change.Source, change.Note); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appendStatus adds a line to an order's status history.
+func appendStatus(tx *sql.Tx, orderNumber int, status string, at time.Time, source, note string) error {
+	_, err := tx.Exec(`
+		INSERT INTO OrderStatusHistory (OrderNumber, Status, Timestamp, Source, Note)
+		VALUES (?, ?, ?, ?, ?);`, orderNumber, status, at, source, note)
+	if err != nil {
+		return fmt.Errorf("appending status history: %w", err)
+	}
+	return nil
+}
+
+// GetOrderStatus returns an order's current status and its history, oldest
+// first.
+func GetOrderStatus(db *sql.DB, orderNumber int) (*OrderStatus_v1, error) {
+	s := &OrderStatus_v1{OrderNumber: orderNumber}
+	s.NewForm()
+	err := db.QueryRow(`SELECT Status, ProductionLine FROM PenHolderOrders WHERE OrderNumber=?;`, orderNumber).
+		Scan(&s.Status, &s.ProductionLine)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("order %d: %w", orderNumber, ErrOrderNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying order: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT Status, Timestamp, Source, Note FROM OrderStatusHistory
+		WHERE OrderNumber=? ORDER BY ID;`, orderNumber)
+	if err != nil {
+		return nil, fmt.Errorf("querying status history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e StatusEntry
+		if err := rows.Scan(&e.Status, &e.Timestamp, &e.Source, &e.Note); err != nil {
+			return nil, fmt.Errorf("reading status history: %w", err)
+		}
+		s.History = append(s.History, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading status history: %w", err)
+	}
+	if n := len(s.History); n > 0 {
+		last := s.History[n-1]
+		s.Timestamp, s.Source, s.Note = last.Timestamp, last.Source, last.Note
+	}
+	return s, nil
+}
@@ -0,0 +1,241 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestOrder files a received order and returns its number.
+func newTestOrder(t *testing.T, tr *Traits) int {
+	t.Helper()
+	order := &PenHolderOrder_v1{
+		Name: "Frank", Email: "frank@example.com",
+		Height: 11, Depth: 4, Roughness: 2,
+		OrderedTimestamp: time.Now(), ProductionLine: "LineA",
+	}
+	order.NewForm()
+	id, err := InsertOrder(tr.db, order)
+	if err != nil {
+		t.Fatalf("InsertOrder: %v", err)
+	}
+	return id
+}
+
+// moveTo changes the status of an order, failing the test if it is refused.
+func moveTo(t *testing.T, tr *Traits, id int, statuses ...string) {
+	t.Helper()
+	for _, s := range statuses {
+		if err := ChangeStatus(tr.db, &OrderStatus_v1{OrderNumber: id, Status: s, Source: "LineA"}); err != nil {
+			t.Fatalf("ChangeStatus to %s: %v", s, err)
+		}
+	}
+}
+
+// TestOrderLifecycle walks an order from received to shipped, through a
+// failed quality check.
+func TestOrderLifecycle(t *testing.T) {
+	tr := openTestDB(t)
+	id := newTestOrder(t, tr)
+	moveTo(t, tr, id, StatusScheduled, StatusInProduction, StatusQualityCheck, StatusInProduction, StatusQualityCheck)
+	shipped := time.Date(2026, 6, 2, 14, 0, 0, 0, time.UTC)
+	if err := ChangeStatus(tr.db, &OrderStatus_v1{OrderNumber: id, Status: StatusShipped, Timestamp: shipped, Note: "DHL 00340434"}); err != nil {
+		t.Fatalf("ChangeStatus to shipped: %v", err)
+	}
+
+	status, err := GetOrderStatus(tr.db, id)
+	if err != nil {
+		t.Fatalf("GetOrderStatus: %v", err)
+	}
+	var got []string
+	for _, e := range status.History {
+		got = append(got, e.Status)
+	}
+	want := []string{StatusReceived, StatusScheduled, StatusInProduction, StatusQualityCheck, StatusInProduction, StatusQualityCheck, StatusShipped}
+	if len(got) != len(want) {
+		t.Fatalf("history %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("history %v, want %v", got, want)
+		}
+	}
+	if status.Status != StatusShipped || status.Note != "DHL 00340434" || !status.Timestamp.Equal(shipped) {
+		t.Errorf("current status %+v", status)
+	}
+
+	order, err := GetOrder(tr.db, id)
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if order.Status != StatusShipped || !order.CompletedTimestamp.Equal(shipped) {
+		t.Errorf("shipped order is %s, completed %v", order.Status, order.CompletedTimestamp)
+	}
+}
+
+// TestTransitionsEnforced refuses changes the lifecycle does not allow.
+func TestTransitionsEnforced(t *testing.T) {
+	tr := openTestDB(t)
+	id := newTestOrder(t, tr)
+
+	for _, to := range []string{StatusShipped, StatusQualityCheck, StatusReceived, "painted"} {
+		var te *TransitionError
+		if err := ChangeStatus(tr.db, &OrderStatus_v1{OrderNumber: id, Status: to}); !errors.As(err, &te) {
+			t.Errorf("received to %s: %v, want a TransitionError", to, err)
+		}
+	}
+	moveTo(t, tr, id, StatusCancelled)
+	if err := ChangeStatus(tr.db, &OrderStatus_v1{OrderNumber: id, Status: StatusScheduled}); err == nil {
+		t.Error("a cancelled order was scheduled")
+	}
+	if err := ChangeStatus(tr.db, &OrderStatus_v1{OrderNumber: 9999, Status: StatusScheduled}); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("unknown order: %v", err)
+	}
+
+	// Refused changes leave no trace
+	status, _ := GetOrderStatus(tr.db, id)
+	if len(status.History) != 2 {
+		t.Errorf("history %+v", status.History)
+	}
+}
+
+// TestHistoryIsAppendOnly checks that the database refuses to rewrite history.
+func TestHistoryIsAppendOnly(t *testing.T) {
+	tr := openTestDB(t)
+	newTestOrder(t, tr)
+	if _, err := tr.db.Exec(`UPDATE OrderStatusHistory SET Status='shipped';`); err == nil {
+		t.Error("status history updated")
+	}
+	if _, err := tr.db.Exec(`DELETE FROM OrderStatusHistory;`); err == nil {
+		t.Error("status history deleted")
+	}
+}
+
+// TestUpdateOrderRespectsLifecycle fixes the dimensions once production
+// starts, and the whole order once it is shipped.
+func TestUpdateOrderRespectsLifecycle(t *testing.T) {
+	tr := openTestDB(t)
+	id := newTestOrder(t, tr)
+	moveTo(t, tr, id, StatusScheduled, StatusInProduction)
+
+	order, _ := GetOrder(tr.db, id)
+	order.Status = StatusShipped // ignored: status only changes through the lifecycle
+	order.CompletedTimestamp = time.Now()
+	order.PeppolID = "0007:5567321707"
+	if err := UpdateOrder(tr.db, order); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+	got, _ := GetOrder(tr.db, id)
+	if got.PeppolID != "0007:5567321707" || got.Status != StatusInProduction || !got.CompletedTimestamp.IsZero() {
+		t.Errorf("updated order %+v", got)
+	}
+
+	order.Height = 20
+	if err := UpdateOrder(tr.db, order); !errors.Is(err, ErrOrderLocked) {
+		t.Errorf("resizing an order in production: %v", err)
+	}
+
+	order.Height = got.Height
+	moveTo(t, tr, id, StatusQualityCheck, StatusShipped)
+	order.Name = "Frank Jr."
+	if err := UpdateOrder(tr.db, order); !errors.Is(err, ErrOrderLocked) {
+		t.Errorf("renaming a shipped order: %v", err)
+	}
+}
+
+// TestStatusHandler pushes status changes over HTTP and reads the history back.
+func TestStatusHandler(t *testing.T) {
+	tr := openTestDB(t)
+	tr.Lines = []Operator{{Name: "LineB", Token: "s3cret-lineB-token"}}
+	tr.Operators = []Operator{{Name: "Hanna", Token: testToken}}
+	id := newTestOrder(t, tr)
+
+	post := func(change OrderStatus_v1, token string) *httptest.ResponseRecorder {
+		change.NewForm()
+		body, _ := json.Marshal(change)
+		req := httptest.NewRequest(http.MethodPost, "/status", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		serving(tr, w, req, "status")
+		return w
+	}
+	for _, token := range []string{"", "wrong-but-long-enough-token"} {
+		if w := post(OrderStatus_v1{OrderNumber: id, Status: StatusCancelled}, token); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("token %q: expected 401 with a challenge, got %d", token, w.Code)
+		}
+	}
+	if w := post(OrderStatus_v1{OrderNumber: id, Status: StatusScheduled, ProductionLine: "LineB", Source: "planner"}, "s3cret-lineB-token"); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := post(OrderStatus_v1{OrderNumber: id, Status: StatusShipped}, "s3cret-lineB-token"); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for scheduled to shipped, got %d", w.Code)
+	}
+	if w := post(OrderStatus_v1{OrderNumber: 9999, Status: StatusScheduled}, testToken); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown order, got %d", w.Code)
+	}
+	// An operator may push a change too; the token always names the reporter
+	if w := post(OrderStatus_v1{OrderNumber: id, Status: StatusInProduction}, testToken); w.Code != http.StatusOK {
+		t.Fatalf("operator: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	get := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/status?"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		serving(tr, w, req, "status")
+		return w
+	}
+	if w := get("id="+intStr(id), ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("GET without a token or email: expected 401 with a challenge, got %d", w.Code)
+	}
+	for _, query := range []string{"id=" + intStr(id) + "&email=eve@example.com", "id=9999&email=frank@example.com"} {
+		if w := get(query, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: expected 404, got %d", query, w.Code)
+		}
+	}
+	if w := get("id="+intStr(id)+"&email=frank@example.com", ""); w.Code != http.StatusOK {
+		t.Errorf("GET with the customer's email: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w := get("id="+intStr(id), "s3cret-lineB-token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var status OrderStatus_v1
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decoding status: %v", err)
+	}
+	if status.Status != StatusInProduction || status.ProductionLine != "LineB" || len(status.History) != 3 ||
+		status.History[1].Source != "LineB" || status.History[1].Note != "reported as planner" || status.History[2].Source != "Hanna" {
+		t.Errorf("status %+v", status)
+	}
+
+	if w := get("id=x", testToken); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a bad id, got %d", w.Code)
+	}
+}
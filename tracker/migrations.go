@@ -0,0 +1,251 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+//-------------------------------------Schema migrations
+
+// migration is one versioned step of the database schema. Steps run in order,
+// each in its own transaction, and are recorded in SchemaMigrations so that
+// each runs exactly once per database.
+type migration struct {
+	version     int
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations is the history of the schema. Append new steps; never edit or
+// reorder the ones that have shipped.
+var migrations = []migration{
+	{1, "create PenHolderOrders", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS PenHolderOrders (
+				OrderNumber        INTEGER PRIMARY KEY AUTOINCREMENT,
+				Name               TEXT    NOT NULL,
+				Email              TEXT    NOT NULL,
+				Height             REAL    NOT NULL,
+				Depth              REAL    NOT NULL,
+				Roughness          INTEGER NOT NULL,
+				OrderedTimestamp   DATETIME NOT NULL,
+				CompletedTimestamp DATETIME,
+				ProductionLine     TEXT    NOT NULL,
+				Version            TEXT    NOT NULL
+			);`)
+		return err
+	}},
+	{2, "add PeppolID to PenHolderOrders", func(tx *sql.Tx) error {
+		// Databases made before versioned migrations may have the column already.
+		return addColumn(tx, "PenHolderOrders", "PeppolID", `TEXT NOT NULL DEFAULT ''`)
+	}},
+	{3, "add order status and OrderStatusHistory", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "PenHolderOrders", "Status", `TEXT NOT NULL DEFAULT 'received'`); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			CREATE TABLE OrderStatusHistory (
+				ID          INTEGER PRIMARY KEY AUTOINCREMENT,
+				OrderNumber INTEGER NOT NULL REFERENCES PenHolderOrders (OrderNumber),
+				Status      TEXT    NOT NULL,
+				Timestamp   DATETIME NOT NULL,
+				Source      TEXT    NOT NULL DEFAULT '',
+				Note        TEXT    NOT NULL DEFAULT ''
+			);
+			CREATE INDEX OrderStatusHistoryByOrder ON OrderStatusHistory (OrderNumber, ID);
+			CREATE TRIGGER OrderStatusHistoryNoUpdate BEFORE UPDATE ON OrderStatusHistory
+			BEGIN SELECT RAISE(ABORT, 'the order status history is append-only'); END;
+			CREATE TRIGGER OrderStatusHistoryNoDelete BEFORE DELETE ON OrderStatusHistory
+			BEGIN SELECT RAISE(ABORT, 'the order status history is append-only'); END;`); err != nil {
+			return err
+		}
+		return backfillStatus(tx)
+	}},
+	{4, "generic product orders", func(tx *sql.Tx) error {
+		// The table keeps its name; its Height, Depth and Roughness columns are
+		// kept for pen holders, the product all earlier orders are for.
+		_, err := tx.Exec(`
+			ALTER TABLE PenHolderOrders ADD COLUMN ProductID   TEXT NOT NULL DEFAULT 'penholder';
+			ALTER TABLE PenHolderOrders ADD COLUMN Parameters  TEXT NOT NULL DEFAULT '{}';
+			ALTER TABLE PenHolderOrders ADD COLUMN Description TEXT NOT NULL DEFAULT '';
+			ALTER TABLE PenHolderOrders ADD COLUMN Price       REAL NOT NULL DEFAULT 0;
+			ALTER TABLE PenHolderOrders ADD COLUMN Currency    TEXT NOT NULL DEFAULT '';
+			UPDATE PenHolderOrders
+			SET Parameters = json_object('height', Height, 'depth', Depth, 'roughness', Roughness);`)
+		return err
+	}},
+	{5, "index orders by their UTC order time", func(tx *sql.Tx) error {
+		// OrderedTimestamp keeps the zone the ordering system sent; the
+		// operators' search compares, sorts and pages on OrderedUTC instead.
+		if err := addColumn(tx, "PenHolderOrders", "OrderedUTC", `TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+		if err := backfillOrderedUTC(tx); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`CREATE INDEX PenHolderOrdersByOrderedUTC ON PenHolderOrders (OrderedUTC, OrderNumber);`)
+		return err
+	}},
+}
+
+// migrate brings the database schema up to the latest version.
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS SchemaMigrations (
+			Version     INTEGER PRIMARY KEY,
+			Description TEXT     NOT NULL,
+			AppliedAt   DATETIME NOT NULL
+		);`); err != nil {
+		return fmt.Errorf("creating SchemaMigrations: %w", err)
+	}
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.apply(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO SchemaMigrations (Version, Description, AppliedAt) VALUES (?, ?, ?);`,
+			m.version, m.description, time.Now()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("recording migration %d: %w", m.version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		log.Printf("tracker: database migrated to version %d (%s)\n", m.version, m.description)
+	}
+	return nil
+}
+
+// schemaVersion returns the version of the last migration applied, 0 if none.
+func schemaVersion(db *sql.DB) (int, error) {
+	var v sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(Version) FROM SchemaMigrations;`).Scan(&v); err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return int(v.Int64), nil
+}
+
+// addColumn adds a column to a table unless the table has it already.
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?);`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s;`, table, column, definition))
+	return err
+}
+
+// backfillStatus gives the orders made before the lifecycle existed their
+// history: received when ordered and, for those with a completion time,
+// shipped when completed.
+func backfillStatus(tx *sql.Tx) error {
+	type legacy struct {
+		number             int
+		ordered, completed sql.NullTime
+	}
+	rows, err := tx.Query(`SELECT OrderNumber, OrderedTimestamp, CompletedTimestamp FROM PenHolderOrders ORDER BY OrderNumber;`)
+	if err != nil {
+		return err
+	}
+	var orders []legacy
+	for rows.Next() {
+		var o legacy
+		if err := rows.Scan(&o.number, &o.ordered, &o.completed); err != nil {
+			rows.Close()
+			return err
+		}
+		orders = append(orders, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, o := range orders {
+		if err := appendStatus(tx, o.number, StatusReceived, o.ordered.Time, "migration", ""); err != nil {
+			return err
+		}
+		if !o.completed.Valid || o.completed.Time.IsZero() {
+			continue
+		}
+		if err := appendStatus(tx, o.number, StatusShipped, o.completed.Time, "migration", "completed before status tracking"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE PenHolderOrders SET Status=? WHERE OrderNumber=?;`, StatusShipped, o.number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillOrderedUTC fills OrderedUTC for the orders made before it existed.
+func backfillOrderedUTC(tx *sql.Tx) error {
+	type stamp struct {
+		number  int
+		ordered sql.NullTime
+	}
+	rows, err := tx.Query(`SELECT OrderNumber, OrderedTimestamp FROM PenHolderOrders;`)
+	if err != nil {
+		return err
+	}
+	var stamps []stamp
+	for rows.Next() {
+		var s stamp
+		if err := rows.Scan(&s.number, &s.ordered); err != nil {
+			rows.Close()
+			return err
+		}
+		stamps = append(stamps, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, s := range stamps {
+		if _, err := tx.Exec(`UPDATE PenHolderOrders SET OrderedUTC=? WHERE OrderNumber=?;`, utcKey(s.ordered.Time), s.number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
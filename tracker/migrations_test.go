@@ -0,0 +1,115 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// legacyDB writes an orders database as the tracker made it before versioned
+// migrations, with one open and one completed order.
+func legacyDB(t *testing.T, withPeppol bool) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "orders.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	peppol := ""
+	if withPeppol {
+		peppol = "PeppolID TEXT NOT NULL DEFAULT '',"
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE PenHolderOrders (
+			OrderNumber        INTEGER PRIMARY KEY AUTOINCREMENT,
+			Name               TEXT    NOT NULL,
+			Email              TEXT    NOT NULL,
+			Height             REAL    NOT NULL,
+			Depth              REAL    NOT NULL,
+			Roughness          INTEGER NOT NULL,
+			OrderedTimestamp   DATETIME NOT NULL,
+			CompletedTimestamp DATETIME,
+			ProductionLine     TEXT    NOT NULL,
+			` + peppol + `
+			Version            TEXT    NOT NULL
+		);`); err != nil {
+		t.Fatal(err)
+	}
+	ordered := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, completed := range []time.Time{{}, ordered.Add(48 * time.Hour)} {
+		if _, err := db.Exec(`
+			INSERT INTO PenHolderOrders (Name, Email, Height, Depth, Roughness, OrderedTimestamp, CompletedTimestamp, ProductionLine, Version)
+			VALUES ('Gina', 'gina@example.com', 10, 4, 2, ?, ?, 'LineA', 'PenHolderOrder_v1');`, ordered, completed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// TestMigrateLegacyDatabase upgrades databases made before versioned
+// migrations, with and without the PeppolID column, and reopens them.
+func TestMigrateLegacyDatabase(t *testing.T) {
+	for _, withPeppol := range []bool{false, true} {
+		path := legacyDB(t, withPeppol)
+		for range 2 { // the second opening has nothing left to migrate
+			db, closeDB, err := openDBAt(path)
+			if err != nil {
+				t.Fatalf("PeppolID %v: %v", withPeppol, err)
+			}
+			if v, _ := schemaVersion(db); v != len(migrations) {
+				t.Errorf("schema version %d, want %d", v, len(migrations))
+			}
+			open, err := GetOrderStatus(db, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done, err := GetOrderStatus(db, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if open.Status != StatusReceived || len(open.History) != 1 {
+				t.Errorf("open order %+v", open)
+			}
+			if done.Status != StatusShipped || len(done.History) != 2 || done.History[1].Source != "migration" {
+				t.Errorf("completed order %+v", done)
+			}
+			if o, err := GetProductOrder(db, 1); err != nil || o.ProductID != penHolderProduct ||
+				o.Parameters["height"] != 10.0 || o.Parameters["roughness"] != 2.0 {
+				t.Errorf("pen holder parameters of a legacy order: %+v, %v", o, err)
+			}
+			if orders, total, err := SearchOrders(db, OrderFilter{From: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+				To: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)}); err != nil || total != 2 || len(orders) != 2 {
+				t.Errorf("legacy orders by date: %d of %d, %v", len(orders), total, err)
+			}
+			closeDB()
+		}
+	}
+}
+
+// TestMigrationsAreNumberedInOrder guards the migration list against
+// reordering and gaps.
+func TestMigrationsAreNumberedInOrder(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("migration %d (%s) is at position %d", m.version, m.description, i+1)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+	"github.com/sdoque/mbaigo/usecases"
+)
+
+//-------------------------------------Operators
+
+// Operator is a person or system allowed on the operator services (orders
+// and invoice), identified by a bearer token.
+type Operator struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+}
+
+// minTokenLength is the shortest token accepted; shorter ones are ignored so
+// that a placeholder left in the configuration opens nothing.
+const minTokenLength = 16
+
+// bearer returns the name of the holder whose token the request carries in
+// its Authorization header, or false.
+func bearer(r *http.Request, holders []Operator) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || len(token) < minTokenLength {
+		return "", false
+	}
+	for _, h := range holders {
+		if len(h.Token) >= minTokenLength && subtle.ConstantTimeCompare([]byte(h.Token), []byte(token)) == 1 {
+			return h.Name, true
+		}
+	}
+	return "", false
+}
+
+// hasTokens tells if any holder has a token long enough to be accepted.
+func hasTokens(holders []Operator) bool {
+	for _, h := range holders {
+		if len(h.Token) >= minTokenLength {
+			return true
+		}
+	}
+	return false
+}
+
+// operator returns the name of the operator whose token the request carries
+// in its Authorization header, or false.
+func (t *Traits) operator(r *http.Request) (string, bool) {
+	return bearer(r, t.Operators)
+}
+
+// reporter returns the name of the production line or operator whose token
+// the request carries, or false. Only they may push status changes.
+func (t *Traits) reporter(r *http.Request) (string, bool) {
+	if name, ok := bearer(r, t.Lines); ok {
+		return name, true
+	}
+	return t.operator(r)
+}
+
+// authorized answers 401 to requests that carry no operator token, and tells
+// the handler whether to go on.
+func (t *Traits) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if _, ok := t.operator(r); !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tracker"`)
+		http.Error(w, "an operator token is required", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+//-------------------------------------Order search
+
+// OrderFilter selects orders for the operators. Zero fields select all.
+type OrderFilter struct {
+	From, To       time.Time // ordered in [From, To)
+	ProductionLine string
+	Statuses       []string
+	Customer       string // part of the customer's name or email, any case
+	Page, PerPage  int    // 1-based page; PerPage 0 = all
+}
+
+// Page sizes of the orders service.
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// parseOrderFilter reads a filter from the query of an orders request:
+// from, to (dates or RFC 3339 times; a date in to includes that day), line,
+// status (comma separated), customer, page and per_page.
+func parseOrderFilter(q map[string][]string) (OrderFilter, error) {
+	get := func(key string) string {
+		if v := q[key]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	f := OrderFilter{ProductionLine: get("line"), Customer: get("customer"), Page: 1, PerPage: defaultPerPage}
+	var err error
+	if f.From, err = parseQueryTime(get("from"), false); err != nil {
+		return f, fmt.Errorf("from: %w", err)
+	}
+	if f.To, err = parseQueryTime(get("to"), true); err != nil {
+		return f, fmt.Errorf("to: %w", err)
+	}
+	if s := get("status"); s != "" {
+		for _, status := range strings.Split(s, ",") {
+			status = strings.TrimSpace(status)
+			if _, known := transitions[status]; !known {
+				return f, fmt.Errorf("unknown order status %q", status)
+			}
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+	if s := get("page"); s != "" {
+		if f.Page, err = strconv.Atoi(s); err != nil || f.Page < 1 {
+			return f, fmt.Errorf("page must be a positive integer")
+		}
+	}
+	if s := get("per_page"); s != "" {
+		if f.PerPage, err = strconv.Atoi(s); err != nil || f.PerPage < 1 || f.PerPage > maxPerPage {
+			return f, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+	}
+	return f, nil
+}
+
+// parseQueryTime reads a date (2006-01-02, local time) or an RFC 3339 time.
+// A date ending a range stands for the end of that day.
+func parseQueryTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		if end {
+			d = d.AddDate(0, 0, 1)
+		}
+		return d, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// SearchOrders returns the page of orders the filter selects, oldest first,
+// and how many it selects in all. Times are compared on OrderedUTC, as the
+// orders keep the zone the ordering system sent them in.
+func SearchOrders(db *sql.DB, f OrderFilter) ([]*ProductOrder_v1, int, error) {
+	where := ` WHERE 1=1`
+	var args []any
+	if !f.From.IsZero() {
+		where += ` AND OrderedUTC>=?`
+		args = append(args, utcKey(f.From))
+	}
+	if !f.To.IsZero() {
+		where += ` AND OrderedUTC<?`
+		args = append(args, utcKey(f.To))
+	}
+	if f.ProductionLine != "" {
+		where += ` AND ProductionLine=?`
+		args = append(args, f.ProductionLine)
+	}
+	if len(f.Statuses) > 0 {
+		where += ` AND Status IN (?` + strings.Repeat(`, ?`, len(f.Statuses)-1) + `)`
+		for _, s := range f.Statuses {
+			args = append(args, s)
+		}
+	}
+	if f.Customer != "" {
+		like := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(f.Customer) + "%"
+		where += ` AND (Name LIKE ? ESCAPE '\' OR Email LIKE ? ESCAPE '\')`
+		args = append(args, like, like)
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM PenHolderOrders`+where+`;`, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("counting orders: %w", err)
+	}
+	query := `SELECT ` + orderColumns + ` FROM PenHolderOrders` + where + ` ORDER BY OrderedUTC, OrderNumber`
+	if f.PerPage > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, f.PerPage, (f.Page-1)*f.PerPage)
+	}
+	rows, err := db.Query(query+`;`, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("searching orders: %w", err)
+	}
+	defer rows.Close()
+	var orders []*ProductOrder_v1
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("reading orders: %w", err)
+		}
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("reading orders: %w", err)
+	}
+	return orders, total, nil
+}
+
+//-------------------------------------Order list form
+
+// OrderList_v1 is the exchanged form for a page of orders found by the
+// operators' search.
+type OrderList_v1 struct {
+	Orders  []*ProductOrder_v1 `json:"orders"`
+	Total   int                `json:"total"`
+	Page    int                `json:"page"`
+	PerPage int                `json:"per_page"`
+	Version string             `json:"version"`
+}
+
+func (f *OrderList_v1) NewForm() forms.Form {
+	f.Version = "OrderList_v1"
+	return f
+}
+
+func (f *OrderList_v1) FormVersion() string {
+	return f.Version
+}
+
+func init() {
+	forms.FormTypeMap["OrderList_v1"] = reflect.TypeOf(OrderList_v1{})
+}
+
+//-------------------------------------Service handler
+
+// ordersHandler lists and searches the orders for operators (GET), as a page
+// of JSON or, with ?format=csv or Accept: text/csv, as a CSV file of every
+// order found.
+func (t *Traits) ordersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method is not supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	if !t.authorized(w, r) {
+		return
+	}
+	f, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	asCSV := r.URL.Query().Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv")
+	if asCSV {
+		f.PerPage = 0
+	}
+	orders, total, err := SearchOrders(t.db, f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if asCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="orders.csv"`)
+		w.WriteHeader(http.StatusOK)
+		writeOrdersCSV(w, orders) //nolint:errcheck
+		return
+	}
+	list := &OrderList_v1{Orders: orders, Total: total, Page: f.Page, PerPage: f.PerPage}
+	if list.Orders == nil {
+		list.Orders = []*ProductOrder_v1{}
+	}
+	list.NewForm()
+	usecases.HTTPProcessGetRequest(w, r, list)
+}
+
+// writeOrdersCSV writes orders as CSV, one order per row under a header row.
+// The parameters of each order are in one column, as a JSON object.
+func writeOrdersCSV(w io.Writer, orders []*ProductOrder_v1) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"order_number", "product_id", "parameters", "price", "currency", "name", "email", //nolint:errcheck
+		"ordered", "completed", "production_line", "status", "peppol_id"})
+	for _, o := range orders {
+		completed := ""
+		if !o.CompletedTimestamp.IsZero() {
+			completed = o.CompletedTimestamp.Format(time.RFC3339)
+		}
+		params, _ := json.Marshal(o.Parameters)
+		price := ""
+		if o.Price > 0 {
+			price = strconv.FormatFloat(o.Price, 'f', 2, 64)
+		}
+		cw.Write([]string{ //nolint:errcheck
+			strconv.Itoa(o.OrderNumber), csvText(o.ProductID), csvText(string(params)), price, o.Currency,
+			csvText(o.Name), csvText(o.Email), o.OrderedTimestamp.Format(time.RFC3339), completed,
+			csvText(o.ProductionLine), o.Status, csvText(o.PeppolID),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvText guards a customer-supplied cell against being read as a formula by
+// spreadsheet programs.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,194 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testToken = "s3cret-operator-token"
+
+// operatorDB returns test traits with one operator and five orders on two
+// lines over three days, the first of them in production.
+func operatorDB(t *testing.T) *Traits {
+	t.Helper()
+	tr := openTestDB(t)
+	tr.Operators = []Operator{{Name: "Hanna", Token: testToken}, {Name: "placeholder", Token: "short"}}
+	day := time.Date(2026, 4, 13, 9, 0, 0, 0, time.Local)
+	for i, c := range []struct{ name, email, line string }{
+		{"Alice", "alice@example.com", "LineA"},
+		{"Bob", "bob@example.com", "LineB"},
+		{"=cmd|' /C calc'!A0", "mallory@example.com", "LineA"},
+		{"Alice", "alice@example.com", "LineA"},
+		{"Carol", "carol@example.com", "LineB"},
+	} {
+		order := &PenHolderOrder_v1{Name: c.name, Email: c.email, Height: 10, Depth: 4, Roughness: 2,
+			OrderedTimestamp: day.Add(time.Duration(i/2) * 24 * time.Hour).Add(time.Duration(i) * time.Minute), ProductionLine: c.line}
+		order.NewForm()
+		if _, err := InsertOrder(tr.db, order); err != nil {
+			t.Fatalf("InsertOrder: %v", err)
+		}
+	}
+	moveTo(t, tr, 1, StatusScheduled, StatusInProduction)
+	return tr
+}
+
+// search runs an operator request and returns the response.
+func search(tr *Traits, query, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/orders?"+query, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	serving(tr, w, req, "orders")
+	return w
+}
+
+// TestOperatorAuthentication refuses requests without a valid token, and
+// tokens too short to be real.
+func TestOperatorAuthentication(t *testing.T) {
+	tr := operatorDB(t)
+	for _, token := range []string{"", "wrong-but-long-enough-token", "short"} {
+		w := search(tr, "", token)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("token %q: expected 401 with a challenge, got %d", token, w.Code)
+		}
+	}
+	if w := search(tr, "", testToken); w.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// TestSearchOrders filters by date, line, status and customer, and pages.
+func TestSearchOrders(t *testing.T) {
+	tr := operatorDB(t)
+	for _, c := range []struct {
+		query string
+		want  []int
+		total int
+	}{
+		{"", []int{1, 2, 3, 4, 5}, 5},
+		{"from=2026-04-14&to=2026-04-14", []int{3, 4}, 2},
+		{"to=2026-04-13", []int{1, 2}, 2},
+		{"line=LineA", []int{1, 3, 4}, 3},
+		{"status=received,scheduled", []int{2, 3, 4, 5}, 4},
+		{"status=in_production&line=LineA", []int{1}, 1},
+		{"customer=ALICE", []int{1, 4}, 2},
+		{"customer=%25", []int{}, 0},
+		{"per_page=2&page=2", []int{3, 4}, 5},
+		{"per_page=2&page=9", []int{}, 5},
+	} {
+		w := search(tr, c.query, testToken)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: %d %s", c.query, w.Code, w.Body.String())
+			continue
+		}
+		var list OrderList_v1
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("%s: %v", c.query, err)
+		}
+		var got []int
+		for _, o := range list.Orders {
+			got = append(got, o.OrderNumber)
+		}
+		if intsStr(got) != intsStr(c.want) || list.Total != c.total {
+			t.Errorf("%s: orders %v of %d, want %v of %d", c.query, got, list.Total, c.want, c.total)
+		}
+	}
+
+	for _, query := range []string{"from=yesterday", "status=painted", "page=0", "per_page=501"} {
+		if w := search(tr, query, testToken); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, w.Code)
+		}
+	}
+}
+
+// TestSearchOrdersAcrossZones compares, sorts and pages orders sent in
+// different time zones by the instant they were placed.
+func TestSearchOrdersAcrossZones(t *testing.T) {
+	tr := openTestDB(t)
+	tokyo, newYork := time.FixedZone("JST", 9*3600), time.FixedZone("EST", -5*3600)
+	for _, at := range []time.Time{
+		time.Date(2026, 4, 13, 20, 0, 0, 0, newYork), // 01:00 UTC on the 14th
+		time.Date(2026, 4, 14, 8, 0, 0, 0, tokyo),    // 23:00 UTC on the 13th
+		time.Date(2026, 4, 14, 0, 30, 0, 0, time.UTC),
+	} {
+		order := &PenHolderOrder_v1{Name: "Dora", Email: "dora@example.com", Height: 10, Depth: 4, Roughness: 2,
+			OrderedTimestamp: at, ProductionLine: "LineA"}
+		order.NewForm()
+		if _, err := InsertOrder(tr.db, order); err != nil {
+			t.Fatalf("InsertOrder: %v", err)
+		}
+	}
+	for _, c := range []struct {
+		filter OrderFilter
+		want   []int
+		total  int
+	}{
+		{OrderFilter{}, []int{2, 3, 1}, 3},
+		{OrderFilter{From: time.Date(2026, 4, 14, 0, 0, 0, 0, time.UTC)}, []int{3, 1}, 2},
+		{OrderFilter{To: time.Date(2026, 4, 14, 1, 0, 0, 0, time.UTC)}, []int{2, 3}, 2},
+		{OrderFilter{Page: 2, PerPage: 2}, []int{1}, 3},
+	} {
+		orders, total, err := SearchOrders(tr.db, c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, o := range orders {
+			got = append(got, o.OrderNumber)
+		}
+		if intsStr(got) != intsStr(c.want) || total != c.total {
+			t.Errorf("%+v: orders %v of %d, want %v of %d", c.filter, got, total, c.want, c.total)
+		}
+	}
+}
+
+// TestOrdersCSV exports every order found, unpaged, with customer text that
+// spreadsheets would take for a formula defused.
+func TestOrdersCSV(t *testing.T) {
+	tr := operatorDB(t)
+	w := search(tr, "format=csv&line=LineA&per_page=1", testToken)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("expected 200 text/csv, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || records[0][0] != "order_number" {
+		t.Fatalf("CSV %v", records)
+	}
+	if records[1][10] != StatusInProduction || records[2][5] != "'=cmd|' /C calc'!A0" {
+		t.Errorf("CSV rows %v", records[1:])
+	}
+}
+
+// intsStr renders a list of numbers for comparison.
+func intsStr(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = intStr(n)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,326 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sdoque/mbaigo/forms"
+)
+
+//-------------------------------------Generic order form
+
+// ProductOrder_v1 is the exchanged form for an order of any product of the
+// clerk's catalogue. The tracker stores the product's parameters as they
+// come; checking them against the catalogue is the clerk's business.
+type ProductOrder_v1 struct {
+	OrderNumber        int            `json:"order_number"`
+	ProductID          string         `json:"product_id"`
+	Parameters         map[string]any `json:"parameters"`
+	Description        string         `json:"description,omitempty"` // the product and its parameters, in words
+	Price              float64        `json:"price,omitempty"`       // quoted when ordered, VAT excluded
+	Currency           string         `json:"currency,omitempty"`
+	Name               string         `json:"name"`
+	Email              string         `json:"email"`
+	OrderedTimestamp   time.Time      `json:"timestamp"`
+	CompletedTimestamp time.Time      `json:"completed_timestamp"`
+	ProductionLine     string         `json:"production_line"`
+	PeppolID           string         `json:"peppol_id"`
+	Status             string         `json:"status,omitempty"`
+	Version            string         `json:"version"`
+}
+
+func (f *ProductOrder_v1) NewForm() forms.Form {
+	f.Version = "ProductOrder_v1"
+	return f
+}
+
+func (f *ProductOrder_v1) FormVersion() string {
+	return f.Version
+}
+
+func init() {
+	forms.FormTypeMap["ProductOrder_v1"] = reflect.TypeOf(ProductOrder_v1{})
+}
+
+//-------------------------------------Pen holder compatibility
+
+// penHolderProduct is the catalogue ID of the pen holder, the product that
+// PenHolderOrder_v1 describes.
+const penHolderProduct = "penholder"
+
+// productOrder maps a pen holder order onto the generic form.
+func (f *PenHolderOrder_v1) productOrder() *ProductOrder_v1 {
+	p := &ProductOrder_v1{
+		OrderNumber: f.OrderNumber,
+		ProductID:   penHolderProduct,
+		Parameters: map[string]any{
+			"height":    f.Height,
+			"depth":     f.Depth,
+			"roughness": float64(f.Roughness),
+		},
+		Name: f.Name, Email: f.Email,
+		OrderedTimestamp: f.OrderedTimestamp, CompletedTimestamp: f.CompletedTimestamp,
+		ProductionLine: f.ProductionLine, PeppolID: f.PeppolID, Status: f.Status,
+	}
+	p.NewForm()
+	return p
+}
+
+// penHolderOrder maps a generic order back onto PenHolderOrder_v1, if it is
+// an order for a pen holder.
+func (f *ProductOrder_v1) penHolderOrder() (*PenHolderOrder_v1, bool) {
+	if f.ProductID != penHolderProduct {
+		return nil, false
+	}
+	o := &PenHolderOrder_v1{
+		OrderNumber: f.OrderNumber,
+		Height:      number(f.Parameters["height"]),
+		Depth:       number(f.Parameters["depth"]),
+		Roughness:   int(math.Round(number(f.Parameters["roughness"]))),
+		Name:        f.Name, Email: f.Email,
+		OrderedTimestamp: f.OrderedTimestamp, CompletedTimestamp: f.CompletedTimestamp,
+		ProductionLine: f.ProductionLine, PeppolID: f.PeppolID, Status: f.Status,
+	}
+	o.NewForm()
+	return o, true
+}
+
+// number reads a numeric parameter; anything else reads as 0.
+func number(v any) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case json.Number:
+		f, _ := n.Float64()
+		return f
+	}
+	return 0
+}
+
+// describe puts an order's product and parameters into words, for orders
+// that came without a description.
+func (f *ProductOrder_v1) describe() string {
+	if f.Description != "" {
+		return f.Description
+	}
+	if o, ok := f.penHolderOrder(); ok {
+		return fmt.Sprintf("Pen holder, height %g mm, depth %g mm, roughness %d", o.Height, o.Depth, o.Roughness)
+	}
+	names := make([]string, 0, len(f.Parameters))
+	for name := range f.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s %v", name, f.Parameters[name])
+	}
+	return strings.TrimSuffix(f.ProductID+", "+strings.Join(names, ", "), ", ")
+}
+
+//-------------------------------------Database
+
+// orderColumns are the columns scanOrder reads, in its order.
+const orderColumns = `OrderNumber, ProductID, Parameters, Description, Price, Currency, Name, Email,
+	OrderedTimestamp, CompletedTimestamp, ProductionLine, PeppolID, Status`
+
+// scanOrder reads a row of orderColumns.
+func scanOrder(row interface{ Scan(...any) error }) (*ProductOrder_v1, error) {
+	var o ProductOrder_v1
+	var params string
+	if err := row.Scan(&o.OrderNumber, &o.ProductID, &params, &o.Description, &o.Price, &o.Currency,
+		&o.Name, &o.Email, &o.OrderedTimestamp, &o.CompletedTimestamp, &o.ProductionLine, &o.PeppolID, &o.Status); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(params), &o.Parameters); err != nil {
+		return nil, fmt.Errorf("order %d: parameters: %w", o.OrderNumber, err)
+	}
+	o.NewForm()
+	return &o, nil
+}
+
+// penHolderColumns are the Height, Depth and Roughness columns of an order,
+// kept for pen holders so that the table still reads as it did before the
+// catalogue; other products have zeros there.
+func (f *ProductOrder_v1) penHolderColumns() (height, depth float64, roughness int) {
+	if o, ok := f.penHolderOrder(); ok {
+		return o.Height, o.Depth, o.Roughness
+	}
+	return 0, 0, 0
+}
+
+// checkProductOrder refuses orders without a product.
+func checkProductOrder(order *ProductOrder_v1) error {
+	if strings.TrimSpace(order.ProductID) == "" {
+		return fmt.Errorf("an order needs a product_id")
+	}
+	if order.Parameters == nil {
+		order.Parameters = map[string]any{}
+	}
+	return nil
+}
+
+// InsertProductOrder files a new order as received and returns the assigned
+// order number. The order's status and completion time are the lifecycle's,
+// not the caller's.
+func InsertProductOrder(db *sql.DB, order *ProductOrder_v1) (int, error) {
+	if err := checkProductOrder(order); err != nil {
+		return 0, err
+	}
+	params, err := json.Marshal(order.Parameters)
+	if err != nil {
+		return 0, fmt.Errorf("encoding parameters: %w", err)
+	}
+	order.Status = StatusReceived
+	order.CompletedTimestamp = time.Time{}
+	height, depth, roughness := order.penHolderColumns()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	result, err := tx.Exec(`
+		INSERT INTO PenHolderOrders
+			(ProductID, Parameters, Description, Price, Currency, Name, Email, Height, Depth, Roughness,
+			 OrderedTimestamp, OrderedUTC, CompletedTimestamp, ProductionLine, PeppolID, Status, Version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
+		order.ProductID, string(params), order.Description, order.Price, order.Currency,
+		order.Name, order.Email, height, depth, roughness,
+		order.OrderedTimestamp, utcKey(order.OrderedTimestamp), order.CompletedTimestamp,
+		order.ProductionLine, order.PeppolID, order.Status, order.Version,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("inserting order: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("retrieving insert ID: %w", err)
+	}
+	if err := appendStatus(tx, int(id), StatusReceived, time.Now(), "tracker", ""); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("inserting order: %w", err)
+	}
+	order.OrderNumber = int(id)
+	return int(id), nil
+}
+
+// utcLayout writes times in UTC with a fixed width, so that they sort as text.
+const utcLayout = "2006-01-02T15:04:05.000000000Z"
+
+// utcKey is the sortable form of an order time, kept in OrderedUTC.
+func utcKey(t time.Time) string {
+	return t.UTC().Format(utcLayout)
+}
+
+// UpdateProductOrder updates the customer's details of an existing order.
+// The product and its parameters may change until production starts, the
+// rest until the order is shipped or cancelled. Status and timestamps only
+// change through the lifecycle (see ChangeStatus), and are left as they are.
+func UpdateProductOrder(db *sql.DB, order *ProductOrder_v1) error {
+	if err := checkProductOrder(order); err != nil {
+		return err
+	}
+	params, err := json.Marshal(order.Parameters)
+	if err != nil {
+		return fmt.Errorf("encoding parameters: %w", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stored, err := scanOrder(tx.QueryRow(`SELECT `+orderColumns+` FROM PenHolderOrders WHERE OrderNumber=?;`, order.OrderNumber))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no order found with OrderNumber %d: %w", order.OrderNumber, ErrOrderNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("querying order: %w", err)
+	}
+	if final(stored.Status) {
+		return fmt.Errorf("order %d is %s: %w", order.OrderNumber, stored.Status, ErrOrderLocked)
+	}
+	if specFrozen(stored.Status) && !sameSpec(stored, order) {
+		return fmt.Errorf("order %d is %s, its product and parameters are fixed: %w", order.OrderNumber, stored.Status, ErrOrderLocked)
+	}
+
+	height, depth, roughness := order.penHolderColumns()
+	if _, err := tx.Exec(`
+		UPDATE PenHolderOrders
+		SET ProductID=?, Parameters=?, Description=?, Price=?, Currency=?, Name=?, Email=?,
+		    Height=?, Depth=?, Roughness=?, ProductionLine=?, PeppolID=?
+		WHERE OrderNumber=?;`,
+		order.ProductID, string(params), order.Description, order.Price, order.Currency, order.Name, order.Email,
+		height, depth, roughness, order.ProductionLine, order.PeppolID,
+		order.OrderNumber,
+	); err != nil {
+		return fmt.Errorf("updating order: %w", err)
+	}
+	return tx.Commit()
+}
+
+// sameSpec tells if two orders are for the same product with the same
+// parameters, whatever the types the parameters were decoded into.
+func sameSpec(a, b *ProductOrder_v1) bool {
+	canonical := func(params map[string]any) string {
+		data, _ := json.Marshal(params)
+		var decoded any
+		json.Unmarshal(data, &decoded) //nolint:errcheck
+		data, _ = json.Marshal(decoded)
+		return string(data)
+	}
+	return a.ProductID == b.ProductID && canonical(a.Parameters) == canonical(b.Parameters)
+}
+
+// GetProductOrder retrieves a single order by its order number.
+func GetProductOrder(db *sql.DB, orderNumber int) (*ProductOrder_v1, error) {
+	o, err := scanOrder(db.QueryRow(`SELECT `+orderColumns+` FROM PenHolderOrders WHERE OrderNumber=?;`, orderNumber))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("order %d: %w", orderNumber, ErrOrderNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying order: %w", err)
+	}
+	return o, nil
+}
+
+// GetProductOrderByIDAndEmail retrieves a single order matching both order
+// number and email address. A deliberately vague error is returned when no
+// record matches, to prevent enumeration attacks.
+func GetProductOrderByIDAndEmail(db *sql.DB, orderNumber int, email string) (*ProductOrder_v1, error) {
+	o, err := scanOrder(db.QueryRow(`SELECT `+orderColumns+` FROM PenHolderOrders WHERE OrderNumber=? AND Email=?;`, orderNumber, email))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no order found with that number and email")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying order: %w", err)
+	}
+	return o, nil
+}
@@ -0,0 +1,165 @@
+/*******************************************************************************
+ * Copyright (c) 2026 Synecdoque
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, subject to the following conditions:
+ *
+ * The software is licensed under the MIT License. See the LICENSE file in this repository for details.
+ *
+ * Contributors:
+ *   Franziska Sievert - initial implementation
+ *   Jan A. van Deventer, Luleå - modernized for current mbaigo
+ ***************************************************************************SDG*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestProductOrder files an order for a coaster, a product PenHolderOrder_v1
+// cannot describe, and returns its number.
+func newTestProductOrder(t *testing.T, tr *Traits) int {
+	t.Helper()
+	order := &ProductOrder_v1{
+		ProductID:   "coaster",
+		Parameters:  map[string]any{"diameter": 90.0, "engraving": "KTH"},
+		Description: "Coaster, 90 mm, engraved KTH",
+		Price:       59, Currency: "SEK",
+		Name: "Hugo", Email: "hugo@example.com",
+		OrderedTimestamp: time.Now(), ProductionLine: "LineB",
+	}
+	order.NewForm()
+	id, err := InsertProductOrder(tr.db, order)
+	if err != nil {
+		t.Fatalf("InsertProductOrder: %v", err)
+	}
+	return id
+}
+
+// TestPenHolderMapping maps a pen holder order onto the generic form and back.
+func TestPenHolderMapping(t *testing.T) {
+	legacy := &PenHolderOrder_v1{OrderNumber: 7, Name: "Ivy", Email: "ivy@example.com",
+		Height: 12.5, Depth: 4, Roughness: 63, ProductionLine: "LineA", PeppolID: "0007:5567321707"}
+	generic := legacy.productOrder()
+	if generic.ProductID != penHolderProduct || generic.Parameters["height"] != 12.5 || generic.Version != "ProductOrder_v1" {
+		t.Fatalf("generic form %+v", generic)
+	}
+	if got := generic.describe(); got != "Pen holder, height 12.5 mm, depth 4 mm, roughness 63" {
+		t.Errorf("description %q", got)
+	}
+	back, ok := generic.penHolderOrder()
+	if !ok {
+		t.Fatal("a pen holder order does not map back")
+	}
+	legacy.NewForm()
+	if *back != *legacy {
+		t.Errorf("mapped back to %+v, want %+v", back, legacy)
+	}
+	if _, ok := (&ProductOrder_v1{ProductID: "coaster"}).penHolderOrder(); ok {
+		t.Error("a coaster order maps onto PenHolderOrder_v1")
+	}
+}
+
+// TestProductOrderRoundTrip files an order for another product and reads it
+// back, parameters, quoted price and all.
+func TestProductOrderRoundTrip(t *testing.T) {
+	tr := openTestDB(t)
+	id := newTestProductOrder(t, tr)
+	got, err := GetProductOrder(tr.db, id)
+	if err != nil {
+		t.Fatalf("GetProductOrder: %v", err)
+	}
+	if got.ProductID != "coaster" || got.Parameters["diameter"] != 90.0 || got.Parameters["engraving"] != "KTH" {
+		t.Errorf("product %q parameters %v", got.ProductID, got.Parameters)
+	}
+	if got.Price != 59 || got.Currency != "SEK" || got.Status != StatusReceived || got.Version != "ProductOrder_v1" {
+		t.Errorf("order %+v", got)
+	}
+	if _, err := GetOrder(tr.db, id); err == nil {
+		t.Error("GetOrder read a coaster order as a pen holder")
+	}
+	if _, err := InsertProductOrder(tr.db, &ProductOrder_v1{Name: "Nobody"}); err == nil {
+		t.Error("an order without a product was filed")
+	}
+}
+
+// TestOrderHandlerForms retrieves pen holder orders in the form asked for and
+// takes orders for other products as ProductOrder_v1.
+func TestOrderHandlerForms(t *testing.T) {
+	tr := openTestDB(t)
+	id, err := InsertOrder(tr.db, &PenHolderOrder_v1{Name: "Ivy", Email: "ivy@example.com",
+		Height: 12, Depth: 4, Roughness: 63, OrderedTimestamp: time.Now(), ProductionLine: "LineA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(query string) map[string]any {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/order?id="+strconv.Itoa(id)+"&email=ivy@example.com"+query, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		tr.orderHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET%s: %d %s", query, w.Code, w.Body)
+		}
+		var reply map[string]any
+		json.Unmarshal(w.Body.Bytes(), &reply) //nolint:errcheck
+		return reply
+	}
+	if reply := get(""); reply["version"] != "PenHolderOrder_v1" || reply["height"] != 12.0 {
+		t.Errorf("default form %v", reply)
+	}
+	if reply := get("&form=ProductOrder_v1"); reply["version"] != "ProductOrder_v1" || reply["product_id"] != penHolderProduct {
+		t.Errorf("generic form %v", reply)
+	}
+
+	order := &ProductOrder_v1{ProductID: "coaster", Parameters: map[string]any{"diameter": 90},
+		Name: "Hugo", Email: "hugo@example.com", OrderedTimestamp: time.Now()}
+	order.NewForm()
+	body, _ := json.Marshal(order)
+	req := httptest.NewRequest(http.MethodPost, "/order", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	tr.orderHandler(w, req)
+	var reply ProductOrder_v1
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &reply) != nil || reply.ProductID != "coaster" || reply.OrderNumber <= id {
+		t.Fatalf("POST ProductOrder_v1: %d %s", w.Code, w.Body)
+	}
+}
+
+// TestProductParametersLockedInProduction lets the customer change the
+// parameters of an order until production starts, and their name after.
+func TestProductParametersLockedInProduction(t *testing.T) {
+	tr := openTestDB(t)
+	id := newTestProductOrder(t, tr)
+	order, _ := GetProductOrder(tr.db, id)
+	order.Parameters["diameter"] = 100.0
+	if err := UpdateProductOrder(tr.db, order); err != nil {
+		t.Fatalf("changing a received order: %v", err)
+	}
+
+	moveTo(t, tr, id, StatusScheduled, StatusInProduction)
+	order, _ = GetProductOrder(tr.db, id)
+	order.Name = "Hugo Holm"
+	if err := UpdateProductOrder(tr.db, order); err != nil {
+		t.Errorf("renaming an order in production: %v", err)
+	}
+	order.Parameters["engraving"] = "LTU"
+	if err := UpdateProductOrder(tr.db, order); !errors.Is(err, ErrOrderLocked) {
+		t.Errorf("changing parameters in production: %v, want ErrOrderLocked", err)
+	}
+	got, _ := GetProductOrder(tr.db, id)
+	if got.Name != "Hugo Holm" || got.Parameters["diameter"] != 100.0 || got.Parameters["engraving"] != "KTH" {
+		t.Errorf("stored order %+v", got)
+	}
+}
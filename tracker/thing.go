@@ -18,6 +18,8 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -38,9 +40,12 @@ import (
 
 // Traits holds the runtime state for the order tracker unit asset.
 type Traits struct {
-	owner    *components.System  `json:"-"`
-	cervices components.Cervices `json:"-"`
-	db       *sql.DB             `json:"-"`
+	Operators []Operator          `json:"operators"` // bearer tokens allowed on the orders and invoice services
+	Lines     []Operator          `json:"lines"`     // bearer tokens of the production lines allowed to push status changes
+	Invoicing Invoicing           `json:"invoicing"` // seller and price on the PEPPOL invoices
+	owner     *components.System  `json:"-"`
+	cervices  components.Cervices `json:"-"`
+	db        *sql.DB             `json:"-"`
 }
 
 //-------------------------------------Instantiate a unit asset template
@@ -54,15 +59,54 @@ func initTemplate() *components.UnitAsset {
 		RegPeriod:   60,
 		Description: "create an order record (POST), update it (PUT), or retrieve it (GET ?id=N)",
 	}
+	statusService := components.Service{
+		Definition:  "status",
+		SubPath:     "status",
+		Details:     map[string][]string{"Forms": {"OrderStatus_v1"}},
+		RegPeriod:   60,
+		Description: "push an order's production status (POST, production lines and operators), or retrieve its status history (GET ?id=N)",
+	}
+	ordersService := components.Service{
+		Definition:  "orders",
+		SubPath:     "orders",
+		Details:     map[string][]string{"Forms": {"OrderList_v1", "text/csv"}},
+		RegPeriod:   60,
+		Description: "list and search orders for operators (GET ?from=&to=&line=&status=&customer=&page=&per_page=, format=csv)",
+	}
+	invoiceService := components.Service{
+		Definition:  "invoice",
+		SubPath:     "invoice",
+		Details:     map[string][]string{"Forms": {"application/xml"}},
+		RegPeriod:   60,
+		Description: "PEPPOL BIS Billing 3.0 invoice of a shipped order, for operators (GET ?id=N)",
+	}
 
 	return &components.UnitAsset{
 		Name:    "product",
 		Mission: "track_orders",
 		Details: map[string][]string{"Status": {"Evaluation"}},
 		ServicesMap: components.Services{
-			orderService.SubPath: &orderService,
+			orderService.SubPath:   &orderService,
+			statusService.SubPath:  &statusService,
+			ordersService.SubPath:  &ordersService,
+			invoiceService.SubPath: &invoiceService,
+		},
+		Traits: &Traits{
+			Operators: []Operator{{Name: "operator", Token: ""}},
+			Lines:     []Operator{{Name: "LineA", Token: ""}},
+			Invoicing: Invoicing{
+				SellerName:      "Pen Holders AB",
+				SellerPeppolID:  "0007:5567321707",
+				SellerVATNumber: "SE556732170701",
+				SellerCity:      "Luleå",
+				SellerCountry:   "SE",
+				Currency:        "SEK",
+				UnitPrice:       250,
+				VATPercent:      25,
+				PaymentDays:     30,
+				NumberPrefix:    "PH-",
+			},
 		},
-		Traits: &Traits{},
 	}
 }
 
@@ -92,6 +136,17 @@ func newResource(uac usecases.ConfigurableAsset, sys *components.System) (*compo
 			addorderCer.Definition: addorderCer,
 		},
 	}
+	if len(uac.Traits) > 0 {
+		if err := json.Unmarshal(uac.Traits[0], t); err != nil {
+			log.Println("Warning: could not unmarshal traits:", err)
+		}
+	}
+	if !hasTokens(t.Operators) {
+		log.Printf("tracker: no operator token of %d characters or more is configured; the orders and invoice services refuse every request\n", minTokenLength)
+		if !hasTokens(t.Lines) {
+			log.Println("tracker: no production line token is configured either; the status service refuses every change")
+		}
+	}
 
 	ua := &components.UnitAsset{
 		Name:        uac.Name,
@@ -114,7 +169,9 @@ func newResource(uac usecases.ConfigurableAsset, sys *components.System) (*compo
 
 //-------------------------------------Order form
 
-// PenHolderOrder_v1 is the exchanged form for pen holder orders.
+// PenHolderOrder_v1 is the exchanged form for pen holder orders. It predates
+// the product catalogue and is kept as a mapping onto ProductOrder_v1 (see
+// product.go) for the systems that use it.
 type PenHolderOrder_v1 struct {
 	OrderNumber        int       `json:"order_number"`
 	Name               string    `json:"name"`
@@ -126,6 +183,7 @@ type PenHolderOrder_v1 struct {
 	CompletedTimestamp time.Time `json:"completed_timestamp"`
 	ProductionLine     string    `json:"production_line"`
 	PeppolID           string    `json:"peppol_id"`
+	Status             string    `json:"status,omitempty"`
 	Version            string    `json:"version"`
 }
 
@@ -144,7 +202,11 @@ func init() {
 
 //-------------------------------------Service handlers
 
-// orderHandler handles GET (retrieve by ?id=N), POST (new order), and PUT (update order).
+// orderHandler handles GET (retrieve by ?id=N&email=E), POST (new order), and
+// PUT (update order). Orders come as ProductOrder_v1 or, for pen holders, as
+// PenHolderOrder_v1, and are answered in the form they came in. A pen holder
+// order is retrieved as PenHolderOrder_v1 unless ?form=ProductOrder_v1 asks
+// for the generic form.
 func (t *Traits) orderHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 
@@ -160,12 +222,17 @@ func (t *Traits) orderHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "id must be an integer", http.StatusBadRequest)
 			return
 		}
-		order, err := GetOrderByIDAndEmail(t.db, id, email)
+		order, err := GetProductOrderByIDAndEmail(t.db, id, email)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
-		order.NewForm()
+		if r.URL.Query().Get("form") != "ProductOrder_v1" {
+			if legacy, ok := order.penHolderOrder(); ok {
+				usecases.HTTPProcessGetRequest(w, r, legacy)
+				return
+			}
+		}
 		usecases.HTTPProcessGetRequest(w, r, order)
 
 	case http.MethodPost, http.MethodPut:
@@ -187,41 +254,58 @@ func (t *Traits) orderHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "unpacking request: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		record, ok := unpacked.(*PenHolderOrder_v1)
-		if !ok {
-			http.Error(w, "expected PenHolderOrder_v1 body", http.StatusBadRequest)
+		var record *ProductOrder_v1
+		legacy, isLegacy := unpacked.(*PenHolderOrder_v1)
+		switch f := unpacked.(type) {
+		case *PenHolderOrder_v1:
+			record = f.productOrder()
+		case *ProductOrder_v1:
+			record = f
+		default:
+			http.Error(w, "expected ProductOrder_v1 or PenHolderOrder_v1 body", http.StatusBadRequest)
 			return
 		}
 
 		if record.OrderNumber <= 0 {
 			// New order: insert and forward to downstream system.
-			oN, err := InsertOrder(t.db, record)
+			oN, err := InsertProductOrder(t.db, record)
 			if err != nil {
-				http.Error(w, "inserting order: "+err.Error(), http.StatusInternalServerError)
+				http.Error(w, "inserting order: "+err.Error(), http.StatusBadRequest)
 				return
 			}
-			record.OrderNumber = oN
-			log.Printf("tracker: new order %d filed\n", oN)
-
-			// Forward the new order to the addorder cervice (e.g. a production TSP).
-			if t.owner != nil {
-				packed, err := usecases.Pack(record, "application/json")
-				if err == nil {
-					if _, err := usecases.SetState(t.cervices["addorder"], t.owner, packed); err != nil {
-						log.Printf("tracker: could not forward order %d to addorder service: %v\n", oN, err)
-					}
-				}
-			}
+			log.Printf("tracker: new %s order %d filed\n", record.ProductID, oN)
+			t.forward(record)
 		} else {
-			// Existing order: update in place.
-			if err := UpdateOrder(t.db, record); err != nil {
+			// Existing order: update what the customer may still change.
+			if isLegacy {
+				err = UpdateOrder(t.db, legacy)
+			} else {
+				err = UpdateProductOrder(t.db, record)
+			}
+			switch {
+			case errors.Is(err, ErrOrderNotFound):
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			case errors.Is(err, ErrOrderLocked):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case err != nil:
 				http.Error(w, "updating order: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
 			log.Printf("tracker: order %d updated\n", record.OrderNumber)
 		}
 
-		confirmed, err := usecases.Pack(record, mediaType)
+		var reply forms.Form = record
+		if stored, err := GetProductOrder(t.db, record.OrderNumber); err == nil {
+			reply = stored
+		}
+		if isLegacy {
+			if o, ok := reply.(*ProductOrder_v1).penHolderOrder(); ok {
+				reply = o
+			}
+		}
+		confirmed, err := usecases.Pack(reply, mediaType)
 		if err != nil {
 			http.Error(w, "marshalling response: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -235,6 +319,26 @@ func (t *Traits) orderHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// forward sends a new order to the addorder cervice (e.g. a production TSP):
+// pen holders as PenHolderOrder_v1, which such systems know, other products
+// as ProductOrder_v1.
+func (t *Traits) forward(order *ProductOrder_v1) {
+	if t.owner == nil {
+		return
+	}
+	var f forms.Form = order
+	if legacy, ok := order.penHolderOrder(); ok {
+		f = legacy
+	}
+	packed, err := usecases.Pack(f, "application/json")
+	if err != nil {
+		return
+	}
+	if _, err := usecases.SetState(t.cervices["addorder"], t.owner, packed); err != nil {
+		log.Printf("tracker: could not forward order %d to addorder service: %v\n", order.OrderNumber, err)
+	}
+}
+
 //-------------------------------------Database
 
 // openDB opens (or creates) the SQLite orders database at orders.db.
@@ -258,127 +362,79 @@ func openDBAt(path string) (*sql.DB, func(), error) {
 	if err != nil {
 		return nil, nil, fmt.Errorf("opening database: %w", err)
 	}
+	if path == ":memory:" {
+		// Every connection to :memory: is a database of its own.
+		db.SetMaxOpenConns(1)
+	}
 
-	if err := createTableIfNotExists(db); err != nil {
+	if err := migrate(db); err != nil {
 		db.Close()
-		return nil, nil, fmt.Errorf("creating table: %w", err)
+		return nil, nil, fmt.Errorf("migrating schema: %w", err)
 	}
 
 	fmt.Println("tracker: database ready")
 	return db, func() { db.Close() }, nil
 }
 
-// createTableIfNotExists creates the PenHolderOrders table if it does not already exist,
-// and adds the PeppolID column to pre-existing databases via a migration.
-func createTableIfNotExists(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS PenHolderOrders (
-			OrderNumber        INTEGER PRIMARY KEY AUTOINCREMENT,
-			Name               TEXT    NOT NULL,
-			Email              TEXT    NOT NULL,
-			Height             REAL    NOT NULL,
-			Depth              REAL    NOT NULL,
-			Roughness          INTEGER NOT NULL,
-			OrderedTimestamp   DATETIME NOT NULL,
-			CompletedTimestamp DATETIME,
-			ProductionLine     TEXT    NOT NULL,
-			PeppolID           TEXT    NOT NULL DEFAULT '',
-			Version            TEXT    NOT NULL
-		);`)
-	if err != nil {
-		return fmt.Errorf("creating table: %w", err)
-	}
-	// Migration: add PeppolID to databases created before this column existed.
-	// The error is intentionally ignored — it fails harmlessly if the column is already present.
-	db.Exec(`ALTER TABLE PenHolderOrders ADD COLUMN PeppolID TEXT NOT NULL DEFAULT '';`) //nolint:errcheck
-	return nil
-}
+// ErrOrderLocked is returned for changes to an order that is too far along
+// its lifecycle to take them.
+var ErrOrderLocked = errors.New("order can no longer be changed")
+
+// The functions below keep the pen holder API of the tracker, on top of the
+// generic orders.
 
-// InsertOrder inserts a new order and returns the assigned order number.
+// InsertOrder files a new pen holder order as received and returns the
+// assigned order number.
 func InsertOrder(db *sql.DB, order *PenHolderOrder_v1) (int, error) {
-	result, err := db.Exec(`
-		INSERT INTO PenHolderOrders
-			(Name, Email, Height, Depth, Roughness,
-			 OrderedTimestamp, CompletedTimestamp, ProductionLine, PeppolID, Version)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`,
-		order.Name, order.Email, order.Height, order.Depth, order.Roughness,
-		order.OrderedTimestamp, order.CompletedTimestamp,
-		order.ProductionLine, order.PeppolID, order.Version,
-	)
+	p := order.productOrder()
+	p.Version = order.Version
+	id, err := InsertProductOrder(db, p)
 	if err != nil {
-		return 0, fmt.Errorf("inserting order: %w", err)
+		return 0, err
 	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("retrieving insert ID: %w", err)
-	}
-	return int(id), nil
+	order.Status, order.CompletedTimestamp = p.Status, p.CompletedTimestamp
+	return id, nil
 }
 
-// UpdateOrder updates an existing order record identified by its order number.
+// UpdateOrder updates the customer's details of an existing order from a pen
+// holder form. The price quoted when the order was placed, and its
+// description, are kept unless the dimensions change; then the order falls
+// back on the configured price.
 func UpdateOrder(db *sql.DB, order *PenHolderOrder_v1) error {
-	result, err := db.Exec(`
-		UPDATE PenHolderOrders
-		SET Name=?, Email=?, Height=?, Depth=?, Roughness=?,
-		    OrderedTimestamp=?, CompletedTimestamp=?, ProductionLine=?, PeppolID=?, Version=?
-		WHERE OrderNumber=?;`,
-		order.Name, order.Email, order.Height, order.Depth, order.Roughness,
-		order.OrderedTimestamp, order.CompletedTimestamp,
-		order.ProductionLine, order.PeppolID, order.Version,
-		order.OrderNumber,
-	)
+	p := order.productOrder()
+	stored, err := GetProductOrder(db, order.OrderNumber)
 	if err != nil {
-		return fmt.Errorf("updating order: %w", err)
+		return fmt.Errorf("no order found with OrderNumber %d: %w", order.OrderNumber, err)
 	}
-	n, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("checking rows affected: %w", err)
+	if sameSpec(stored, p) {
+		p.Description, p.Price, p.Currency = stored.Description, stored.Price, stored.Currency
 	}
-	if n == 0 {
-		return fmt.Errorf("no order found with OrderNumber %d", order.OrderNumber)
-	}
-	return nil
+	return UpdateProductOrder(db, p)
 }
 
-// GetOrder retrieves a single order by its order number.
+// GetOrder retrieves a single pen holder order by its order number.
 func GetOrder(db *sql.DB, orderNumber int) (*PenHolderOrder_v1, error) {
-	row := db.QueryRow(`
-		SELECT OrderNumber, Name, Email, Height, Depth, Roughness,
-		       OrderedTimestamp, CompletedTimestamp, ProductionLine, PeppolID, Version
-		FROM PenHolderOrders WHERE OrderNumber=?;`, orderNumber)
-
-	var o PenHolderOrder_v1
-	err := row.Scan(
-		&o.OrderNumber, &o.Name, &o.Email, &o.Height, &o.Depth, &o.Roughness,
-		&o.OrderedTimestamp, &o.CompletedTimestamp, &o.ProductionLine, &o.PeppolID, &o.Version,
-	)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("order %d not found", orderNumber)
-	}
+	p, err := GetProductOrder(db, orderNumber)
 	if err != nil {
-		return nil, fmt.Errorf("querying order: %w", err)
+		return nil, err
 	}
-	return &o, nil
+	o, ok := p.penHolderOrder()
+	if !ok {
+		return nil, fmt.Errorf("order %d is for a %s, not a pen holder", orderNumber, p.ProductID)
+	}
+	return o, nil
 }
 
-// GetOrderByIDAndEmail retrieves a single order matching both order number and email address.
-// A deliberately vague error is returned when no record matches, to prevent enumeration attacks.
+// GetOrderByIDAndEmail retrieves a single pen holder order matching both
+// order number and email address.
 func GetOrderByIDAndEmail(db *sql.DB, orderNumber int, email string) (*PenHolderOrder_v1, error) {
-	row := db.QueryRow(`
-		SELECT OrderNumber, Name, Email, Height, Depth, Roughness,
-		       OrderedTimestamp, CompletedTimestamp, ProductionLine, PeppolID, Version
-		FROM PenHolderOrders WHERE OrderNumber=? AND Email=?;`, orderNumber, email)
-
-	var o PenHolderOrder_v1
-	err := row.Scan(
-		&o.OrderNumber, &o.Name, &o.Email, &o.Height, &o.Depth, &o.Roughness,
-		&o.OrderedTimestamp, &o.CompletedTimestamp, &o.ProductionLine, &o.PeppolID, &o.Version,
-	)
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("no order found with that number and email")
-	}
+	p, err := GetProductOrderByIDAndEmail(db, orderNumber, email)
 	if err != nil {
-		return nil, fmt.Errorf("querying order: %w", err)
+		return nil, err
+	}
+	o, ok := p.penHolderOrder()
+	if !ok {
+		return nil, fmt.Errorf("no order found with that number and email")
 	}
-	return &o, nil
+	return o, nil
 }
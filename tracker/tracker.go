@@ -87,6 +87,12 @@ func serving(t *Traits, w http.ResponseWriter, r *http.Request, servicePath stri
 	switch servicePath {
 	case "order":
 		t.orderHandler(w, r)
+	case "status":
+		t.statusHandler(w, r)
+	case "orders":
+		t.ordersHandler(w, r)
+	case "invoice":
+		t.invoiceHandler(w, r)
 	default:
 		http.Error(w, "Invalid service request [Do not modify the services subpath in the configuration file]", http.StatusBadRequest)
 	}